@@ -1,19 +1,25 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/LeonLow97/toolkit"
 )
 
 func main() {
+	var t toolkit.Tools
+
 	mux := routes()
 
 	log.Println("Starting server on port 8080!")
 
-	if err := http.ListenAndServe(":8080", mux); err != nil {
+	// allow plenty of time for large uploads to be read, and drain them on shutdown
+	if err := t.Serve(context.Background(), ":8080", mux, toolkit.WithReadTimeout(10*time.Minute)); err != nil {
 		log.Fatalln(err)
 	}
 }
@@ -21,55 +27,113 @@ func main() {
 func routes() http.Handler {
 	mux := http.NewServeMux()
 
+	var t toolkit.Tools
+
 	mux.Handle("/", http.StripPrefix("/", http.FileServer(http.Dir("."))))
-	mux.HandleFunc("/upload", uploadFiles)
-	mux.HandleFunc("/upload-one", uploadOneFile)
+	mux.HandleFunc("/upload", t.AllowMethods(uploadFiles, http.MethodPost))
+	mux.HandleFunc("/upload-one", t.AllowMethods(uploadOneFile, http.MethodPost))
+	mux.HandleFunc("/chunks/start", t.AllowMethods(startChunkedUpload, http.MethodPost))
+	mux.HandleFunc("/chunks/append", t.AllowMethods(appendChunk, http.MethodGet, http.MethodPatch))
+	mux.HandleFunc("/chunks/complete", t.AllowMethods(completeChunkedUpload, http.MethodPost))
 
 	return mux
 }
 
 func uploadFiles(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	t := toolkit.Tools{
+		MaxFileSize:       1024 * 1024 * 1024,
+		AllowedFileTypes:  []string{"image/jpeg", "image/png", "image/gif"},
+		CheckFreeSpace:    true,
+		FreeSpaceHeadroom: 0.1,
 	}
 
+	files, err := t.UploadFiles(r, "./uploads")
+	_ = t.WriteUploadResponse(w, files, err)
+}
+
+func uploadOneFile(w http.ResponseWriter, r *http.Request) {
 	t := toolkit.Tools{
-		MaxFileSize:      1024 * 1024 * 1024,
-		AllowedFileTypes: []string{"image/jpeg", "image/png", "image/gif"},
+		MaxFileSize:       1024 * 1024 * 1024,
+		AllowedFileTypes:  []string{"image/jpeg", "image/png", "image/gif"},
+		CheckFreeSpace:    true,
+		FreeSpaceHeadroom: 0.1,
 	}
 
-	files, err := t.UploadFiles(r, "./uploads")
+	f, err := t.UploadOneFile(r, "./uploads")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		_ = t.WriteUploadResponse(w, nil, err)
 		return
 	}
 
-	out := ""
-	fmt.Println(len(files))
-	for _, item := range files {
-		out += fmt.Sprintf("Uploaded %s to the uploads folder, renamed to %s\n", item.OriginalFileName, item.NewFileName)
+	_ = t.WriteUploadResponse(w, []*toolkit.UploadedFile{f}, nil)
+}
+
+// chunkDir holds the chunks of the uploads sent in pieces until they are completed
+const chunkDir = "./chunks"
+
+// chunkedTools is shared by the chunked upload handlers, so that each chunk is checked against the same limits as
+// the file it completes
+var chunkedTools = toolkit.Tools{
+	MaxFileSize:       1024 * 1024 * 1024,
+	AllowedFileTypes:  []string{"image/jpeg", "image/png", "image/gif"},
+	CheckFreeSpace:    true,
+	FreeSpaceHeadroom: 0.1,
+}
+
+// startChunkedUpload begins an upload of the file named by the filename query parameter, and responds with its ID
+func startChunkedUpload(w http.ResponseWriter, r *http.Request) {
+	id, err := chunkedTools.StartChunkedUpload(chunkDir, r.URL.Query().Get("filename"))
+	if err != nil {
+		_ = chunkedTools.WriteUploadResponse(w, nil, err)
+		return
 	}
 
-	_, _ = w.Write([]byte(out))
+	_ = chunkedTools.WriteJSON(w, http.StatusCreated, toolkit.JSONResponse{Message: "upload started", Data: map[string]string{"id": id}})
 }
 
-func uploadOneFile(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "POST" {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// appendChunk adds the body of a PATCH request to the upload id at its Upload-Offset header. A GET or HEAD reports
+// the offset to resume from
+func appendChunk(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+
+	if r.Method != http.MethodPatch {
+		offset, err := chunkedTools.ChunkedUploadOffset(chunkDir, id)
+		if err != nil {
+			_ = chunkedTools.WriteUploadResponse(w, nil, err)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
 
-	t := toolkit.Tools{
-		MaxFileSize:      1024 * 1024 * 1024,
-		AllowedFileTypes: []string{"image/jpeg", "image/png", "image/gif"},
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		_ = chunkedTools.ErrorJSON(w, errors.New("the Upload-Offset header must be the offset of the chunk"))
+		return
 	}
 
-	f, err := t.UploadOneFile(r, "./uploads")
+	size, err := chunkedTools.AppendChunk(chunkDir, id, offset, r.Body)
+	var offsetErr *toolkit.ChunkOffsetError
+	switch {
+	case errors.As(err, &offsetErr):
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offsetErr.Expected, 10))
+		_ = chunkedTools.WriteJSON(w, http.StatusConflict, toolkit.JSONResponse{Error: true, Message: err.Error(), Data: map[string]int64{"offset": offsetErr.Expected}})
+	case err != nil:
+		_ = chunkedTools.WriteUploadResponse(w, nil, err)
+	default:
+		w.Header().Set("Upload-Offset", strconv.FormatInt(size, 10))
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// completeChunkedUpload saves the file sent in chunks to the upload given by the id query parameter
+func completeChunkedUpload(w http.ResponseWriter, r *http.Request) {
+	f, err := chunkedTools.CompleteChunkedUpload(r.Context(), chunkDir, r.URL.Query().Get("id"), "./uploads")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		_ = chunkedTools.WriteUploadResponse(w, nil, err)
 		return
 	}
 
-	_, _ = w.Write([]byte(fmt.Sprintf("Uploaded 1 file, %s, to the uploads folder", f.OriginalFileName)))
+	_ = chunkedTools.WriteUploadResponse(w, []*toolkit.UploadedFile{f}, nil)
 }
@@ -0,0 +1,82 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrInvalidBase64 is returned by UploadBase64File when the data is not valid base64, or is a data: URI that isn't
+// base64 encoded
+var ErrInvalidBase64 error = newMessageError("upload.invalid_base64")
+
+// UploadBase64File saves a base64 encoded file, or data: URI, to uploadDir, with the same checks as UploadFiles.
+// Data that can't be decoded gets ErrInvalidBase64
+func (t *Tools) UploadBase64File(data string, filename string, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	return t.UploadBase64FileContext(context.Background(), data, filename, uploadDir, rename...)
+}
+
+// UploadBase64FileContext is like UploadBase64File, but stops the save and scan when ctx is done, such as when the
+// client of the request the data came in has gone, and returns ctx.Err()
+func (t *Tools) UploadBase64FileContext(ctx context.Context, data string, filename string, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	payload, err := base64Payload(data)
+	if err != nil {
+		return nil, err
+	}
+	enc := base64.RawStdEncoding
+	if strings.ContainsAny(payload, "-_") {
+		enc = base64.RawURLEncoding
+	}
+
+	maxFileSize := defaultMaxFileSize
+	if t.MaxFileSize != 0 {
+		maxFileSize = t.MaxFileSize
+	}
+
+	src := &maxSizeReader{r: base64Reader{base64.NewDecoder(enc, strings.NewReader(payload))}, max: int64(maxFileSize)}
+	return t.uploadStreamToDir(ctx, uploadDir, int64(enc.DecodedLen(len(payload))), filename, src, renameFile, base64ReadError(filename))
+}
+
+// base64Payload returns the base64 text of data, without the header of a data: URI and without padding
+func base64Payload(data string) (string, error) {
+	if len(data) >= 5 && strings.EqualFold(data[:5], "data:") {
+		i := strings.IndexByte(data, ',')
+		if i < 0 || !strings.HasSuffix(strings.ToLower(data[:i]), ";base64") {
+			return "", ErrInvalidBase64
+		}
+		data = data[i+1:]
+	}
+	return strings.TrimRight(strings.TrimSpace(data), "="), nil
+}
+
+// base64Reader reads a file from a base64 decoder, reporting corrupt input as ErrInvalidBase64
+type base64Reader struct {
+	r io.Reader
+}
+
+func (b base64Reader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	var corrupt base64.CorruptInputError
+	if errors.As(err, &corrupt) {
+		return n, ErrInvalidBase64
+	}
+	return n, err
+}
+
+// base64ReadError returns the readError for streamUploadedFile that keeps the errors of base64Reader as they are
+func base64ReadError(filename string) func(error) error {
+	return func(err error) error {
+		if errors.Is(err, ErrInvalidBase64) || errors.Is(err, ErrFileTooBig) {
+			return err
+		}
+		return fmt.Errorf("could not read uploaded file %q: %w", filename, err)
+	}
+}
@@ -0,0 +1,108 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTools_UploadBase64File(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	encoded := base64.StdEncoding.EncodeToString(img)
+
+	// wrapped at 76 characters, as MIME encoders write it
+	var wrapped strings.Builder
+	for s := encoded; len(s) > 0; {
+		n := 76
+		if len(s) < n {
+			n = len(s)
+		}
+		wrapped.WriteString(s[:n] + "\r\n")
+		s = s[n:]
+	}
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		data     string
+		expected error
+	}{
+		{name: "plain", data: encoded},
+		{name: "data uri", data: "data:image/png;base64," + encoded},
+		{name: "data uri with the wrong type", data: "data:text/plain;base64," + encoded},
+		{name: "no padding", data: base64.RawStdEncoding.EncodeToString(img)},
+		{name: "url-safe", data: base64.RawURLEncoding.EncodeToString(img)},
+		{name: "wrapped", data: wrapped.String()},
+		{name: "data uri not base64", data: "data:text/plain,hello", expected: ErrInvalidBase64},
+		{name: "invalid characters", data: "!!!not base64!!!", expected: ErrInvalidBase64},
+		{name: "invalid after a while", data: encoded[:4000] + "*" + encoded[4000:], expected: ErrInvalidBase64},
+		{name: "over MaxFileSize", tools: Tools{MaxFileSize: 1000}, data: encoded, expected: ErrFileTooBig},
+		{name: "over MaxFilePerSize", tools: Tools{MaxFilePerSize: 1000}, data: encoded, expected: ErrFileTooBig},
+		{name: "type not allowed", tools: Tools{AllowedFileTypes: []string{"image/jpeg"}}, data: encoded, expected: ErrFileTypeNotPermitted},
+		{name: "empty", data: "", expected: ErrFileTooSmall},
+	}
+
+	for _, e := range tests {
+		dir := t.TempDir()
+		uploadedFile, err := e.tools.UploadBase64File(e.data, "avatar.png", dir)
+
+		if e.expected != nil {
+			if !errors.Is(err, e.expected) {
+				t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+			}
+			if names := remainingFiles(t, dir); names != nil {
+				t.Errorf("%s: expected nothing to be saved, got %v", e.name, names)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+
+		if uploadedFile.ContentType != "image/png" || uploadedFile.OriginalFileName != "avatar.png" {
+			t.Errorf("%s: expected avatar.png of type image/png, got %s of type %s", e.name, uploadedFile.OriginalFileName, uploadedFile.ContentType)
+		}
+		if uploadedFile.NewFileName == "avatar.png" || filepath.Ext(uploadedFile.NewFileName) != ".png" {
+			t.Errorf("%s: expected a random name, got %s", e.name, uploadedFile.NewFileName)
+		}
+		if saved, _ := os.ReadFile(filepath.Join(dir, uploadedFile.NewFileName)); !bytes.Equal(saved, img) || uploadedFile.FileSize != int64(len(img)) {
+			t.Errorf("%s: expected the decoded file to be saved", e.name)
+		}
+	}
+}
+
+func TestTools_UploadBase64FileKeepName(t *testing.T) {
+	var testTools Tools
+	dir := t.TempDir()
+
+	uploadedFile, err := testTools.UploadBase64File(base64.StdEncoding.EncodeToString([]byte("hello world")), "notes.txt", dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploadedFile.NewFileName != "notes.txt" {
+		t.Errorf("expected the original name to be kept, got %s", uploadedFile.NewFileName)
+	}
+	if saved, _ := os.ReadFile(filepath.Join(dir, "notes.txt")); string(saved) != "hello world" {
+		t.Errorf("expected the decoded text to be saved, got %q", saved)
+	}
+}
+
+func TestTools_UploadBase64FileCanceled(t *testing.T) {
+	var testTools Tools
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := testTools.UploadBase64FileContext(ctx, base64.StdEncoding.EncodeToString([]byte("hello world")), "notes.txt", dir); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if names := remainingFiles(t, dir); names != nil {
+		t.Errorf("expected nothing to be saved, got %v", names)
+	}
+}
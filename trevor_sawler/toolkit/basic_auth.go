@@ -0,0 +1,69 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+)
+
+// contextKey is the type used for values the toolkit stores in a request context, so that they can't
+// collide with keys from other packages
+type contextKey string
+
+const basicAuthUserKey contextKey = "basic_auth_user"
+
+// BasicAuth is middleware that checks HTTP basic auth credentials with validate, and sends a 401 with a
+// WWW-Authenticate challenge for realm when they fail. BasicAuthUser returns the user
+func (t *Tools) BasicAuth(validate func(user, pass string) bool, realm string) func(http.Handler) http.Handler {
+	challenge := fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var err error
+
+			user, pass, ok := r.BasicAuth()
+			switch {
+			case r.Header.Get("Authorization") == "":
+				err = newMessageError("auth.required")
+			case !ok:
+				err = newMessageError("auth.malformed_header")
+			case !validate(user, pass):
+				err = newMessageError("auth.invalid_credentials")
+			}
+
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", challenge)
+				_ = t.LocalizedErrorJSON(w, r, err, http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), basicAuthUserKey, user)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// BasicAuthUser returns the username stored in the request context by BasicAuth
+func BasicAuthUser(r *http.Request) (string, bool) {
+	user, ok := r.Context().Value(basicAuthUserKey).(string)
+	return user, ok
+}
+
+// BasicAuthCredentials returns a validator for BasicAuth that accepts a single username and password,
+// compared in constant time
+func BasicAuthCredentials(user, pass string) func(user, pass string) bool {
+	expectedUser := sha256.Sum256([]byte(user))
+	expectedPass := sha256.Sum256([]byte(pass))
+
+	return func(u, p string) bool {
+		gotUser := sha256.Sum256([]byte(u))
+		gotPass := sha256.Sum256([]byte(p))
+
+		userMatch := subtle.ConstantTimeCompare(gotUser[:], expectedUser[:])
+		passMatch := subtle.ConstantTimeCompare(gotPass[:], expectedPass[:])
+
+		return userMatch&passMatch == 1
+	}
+}
@@ -0,0 +1,66 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var basicAuthTests = []struct {
+	name           string
+	authorization  string
+	expectedStatus int
+	expectedUser   string
+}{
+	{name: "missing", authorization: "", expectedStatus: http.StatusUnauthorized},
+	{name: "wrong scheme", authorization: "Bearer abc.def.ghi", expectedStatus: http.StatusUnauthorized},
+	{name: "bad base64", authorization: "Basic !!!notbase64", expectedStatus: http.StatusUnauthorized},
+	{name: "no colon", authorization: "Basic YWRtaW4=", expectedStatus: http.StatusUnauthorized},
+	{name: "wrong password", authorization: "Basic YWRtaW46d3Jvbmc=", expectedStatus: http.StatusUnauthorized},
+	{name: "wrong user", authorization: "Basic cm9vdDpzZWNyZXQ=", expectedStatus: http.StatusUnauthorized},
+	{name: "correct", authorization: "Basic YWRtaW46c2VjcmV0", expectedStatus: http.StatusOK, expectedUser: "admin"},
+}
+
+func TestTools_BasicAuth(t *testing.T) {
+	var testTools Tools
+
+	mw := testTools.BasicAuth(BasicAuthCredentials("admin", "secret"), "admin area")
+
+	for _, e := range basicAuthTests {
+		var gotUser string
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotUser, _ = BasicAuthUser(r)
+			w.WriteHeader(http.StatusOK)
+		}))
+
+		req := httptest.NewRequest("GET", "/admin", nil)
+		if e.authorization != "" {
+			req.Header.Set("Authorization", e.authorization)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if gotUser != e.expectedUser {
+			t.Errorf("%s: expected user %q in context but got %q", e.name, e.expectedUser, gotUser)
+		}
+
+		if e.expectedStatus == http.StatusUnauthorized {
+			if rr.Header().Get("WWW-Authenticate") != `Basic realm="admin area", charset="UTF-8"` {
+				t.Errorf("%s: wrong WWW-Authenticate header: %s", e.name, rr.Header().Get("WWW-Authenticate"))
+			}
+
+			var payload JSONResponse
+			if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+				t.Errorf("%s: could not decode JSON error: %s", e.name, err)
+			}
+			if !payload.Error {
+				t.Errorf("%s: error set to false in JSON, and it should be true", e.name)
+			}
+		}
+	}
+}
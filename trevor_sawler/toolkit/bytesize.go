@@ -0,0 +1,55 @@
+package toolkit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// byteUnits maps the unit suffixes ParseBytes accepts, in lower case, to their size in bytes
+var byteUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1 << 40,
+	"tib": 1 << 40,
+}
+
+// ParseBytes parses a size such as "512", "10MB", "1.5 GiB" or "64k" into a number of bytes. Units are powers of
+// 1024 and case insensitive
+func ParseBytes(s string) (int64, error) {
+	trimmed := strings.TrimSpace(s)
+
+	i := 0
+	for i < len(trimmed) && (trimmed[i] >= '0' && trimmed[i] <= '9' || trimmed[i] == '.') {
+		i++
+	}
+	number, unit := trimmed[:i], strings.ToLower(strings.TrimSpace(trimmed[i:]))
+
+	multiplier, ok := byteUnits[unit]
+	if number == "" || !ok {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+
+	size := n * multiplier
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("byte size %q is too large", s)
+	}
+
+	return int64(size), nil
+}
@@ -0,0 +1,44 @@
+package toolkit
+
+import "testing"
+
+var parseBytesTests = []struct {
+	name          string
+	input         string
+	expected      int64
+	errorExpected bool
+}{
+	{name: "plain number", input: "512", expected: 512},
+	{name: "bytes", input: "512B", expected: 512},
+	{name: "kilobytes", input: "64k", expected: 64 * 1024},
+	{name: "megabytes", input: "10MB", expected: 10 * 1024 * 1024},
+	{name: "mebibytes", input: "10MiB", expected: 10 * 1024 * 1024},
+	{name: "fraction with space", input: " 1.5 GiB ", expected: 1536 * 1024 * 1024},
+	{name: "lower case", input: "2gb", expected: 2 * 1024 * 1024 * 1024},
+	{name: "terabytes", input: "1TB", expected: 1 << 40},
+	{name: "empty", input: "", errorExpected: true},
+	{name: "no number", input: "MB", errorExpected: true},
+	{name: "unknown unit", input: "10 parsecs", errorExpected: true},
+	{name: "negative", input: "-1MB", errorExpected: true},
+	{name: "two points", input: "1.2.3MB", errorExpected: true},
+	{name: "too large", input: "9000000000TB", errorExpected: true},
+}
+
+func TestParseBytes(t *testing.T) {
+	for _, e := range parseBytesTests {
+		got, err := ParseBytes(e.input)
+		if e.errorExpected {
+			if err == nil {
+				t.Errorf("%s: error expected, but none received", e.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error not expected, but one received: %s", e.name, err)
+			continue
+		}
+		if got != e.expected {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultCaptureMaxBodySize is the most of each request body CaptureBodies records, when MaxBodySize is not set
+const defaultCaptureMaxBodySize = 64 * 1024
+
+// redactedValue replaces every redacted header and field in a capture
+const redactedValue = "[REDACTED]"
+
+// alwaysRedactedHeaders are redacted from every capture, whatever CaptureOptions says
+var alwaysRedactedHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// ErrCaptureIncomplete is returned by ReplayCapture for a capture whose body was truncated or left out, since
+// replaying it would not reproduce the original request
+var ErrCaptureIncomplete = errors.New("capture does not hold the complete request body")
+
+// CaptureOptions configures CaptureBodies
+type CaptureOptions struct {
+	// Dir is the directory captures are written to. It is created if needed, and must be set
+	Dir string
+
+	// Routes lists the path prefixes to capture, such as "/api/orders". When empty, every route is captured
+	Routes []string
+
+	// SampleRate is the fraction of matching requests captured, between 0 and 1. Zero captures them all
+	SampleRate float64
+
+	// MaxBodySize is the most of each body that is recorded. Defaults to 64KB
+	MaxBodySize int64
+
+	// RedactHeaders lists headers whose values are replaced with [REDACTED]. Authorization, Cookie and
+	// Proxy-Authorization are always redacted
+	RedactHeaders []string
+
+	// RedactFields lists JSON object keys, at any depth, and form fields whose values are replaced with
+	// [REDACTED], matched case insensitively. When a body can't be parsed to redact it, it is left out
+	RedactFields []string
+}
+
+// CapturedRequest is a request recorded by CaptureBodies, as stored in its capture file
+type CapturedRequest struct {
+	Time          time.Time   `json:"time"`
+	RequestID     string      `json:"request_id,omitempty"`
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`
+	Host          string      `json:"host"`
+	Header        http.Header `json:"header"`
+	Body          []byte      `json:"body"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+	BodyOmitted   string      `json:"body_omitted,omitempty"`
+	Status        int         `json:"status,omitempty"`
+}
+
+// CaptureBodies is middleware that records a sample of requests, body and response status included, to files in
+// opts.Dir, for ReplayCapture. Write errors are only logged. It panics if opts.Dir is empty
+func (t *Tools) CaptureBodies(opts CaptureOptions) func(http.Handler) http.Handler {
+	if opts.Dir == "" {
+		panic("toolkit: CaptureBodies requires a capture directory")
+	}
+
+	maxBody := opts.MaxBodySize
+	if maxBody <= 0 {
+		maxBody = defaultCaptureMaxBodySize
+	}
+	redactHeaders := append(append([]string{}, alwaysRedactedHeaders...), opts.RedactHeaders...)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !captureRouteMatches(opts.Routes, r.URL.Path) || (opts.SampleRate > 0 && rand.Float64() >= opts.SampleRate) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			captured := CapturedRequest{
+				Time:      time.Now().UTC(),
+				RequestID: RequestIDFromContext(r.Context()),
+				Method:    r.Method,
+				URL:       r.URL.RequestURI(),
+				Host:      r.Host,
+				Header:    r.Header.Clone(),
+			}
+
+			if r.Body != nil && r.Body != http.NoBody {
+				body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+				// whatever was read goes back in front of the rest of the body, so the handler sees it all, and
+				// sees any read error itself
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+				if err != nil {
+					captured.BodyOmitted = "could not read body: " + err.Error()
+					body = nil
+				}
+				if int64(len(body)) > maxBody {
+					body = body[:maxBody]
+					captured.BodyTruncated = true
+				}
+				captured.Body = body
+			}
+
+			// the capture is written even if the handler panics, since those are requests worth looking at
+			sw := &statusWriter{ResponseWriter: w}
+			returned := false
+			defer func() {
+				captured.Status = sw.status
+				if captured.Status == 0 && returned {
+					captured.Status = http.StatusOK
+				}
+				t.writeCapture(opts, redactHeaders, captured)
+			}()
+
+			next.ServeHTTP(sw, r)
+			returned = true
+		})
+	}
+}
+
+// captureRouteMatches reports whether path falls under one of routes, or routes is empty
+func captureRouteMatches(routes []string, path string) bool {
+	if len(routes) == 0 {
+		return true
+	}
+	for _, route := range routes {
+		if path == route || strings.HasPrefix(path, strings.TrimSuffix(route, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCapture redacts c and writes it to a new file in opts.Dir, logging any failure
+func (t *Tools) writeCapture(opts CaptureOptions, redactHeaders []string, c CapturedRequest) {
+	for _, h := range redactHeaders {
+		if values := c.Header.Values(h); len(values) > 0 {
+			c.Header.Set(h, redactedValue)
+		}
+	}
+
+	if len(opts.RedactFields) > 0 && len(c.Body) > 0 {
+		body, err := redactBody(c.Header.Get("Content-Type"), c.Body, c.BodyTruncated, opts.RedactFields)
+		if err != nil {
+			c.Body = nil
+			c.BodyOmitted = err.Error()
+		} else {
+			c.Body = body
+		}
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		t.logger().Printf("capture: could not encode capture: %s", err)
+		return
+	}
+
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		t.logger().Printf("capture: could not create %s: %s", opts.Dir, err)
+		return
+	}
+
+	name := c.Time.Format("20060102T150405.000000000Z") + "-" + t.RandomString(6) + ".json"
+	if err := os.WriteFile(filepath.Join(opts.Dir, name), data, 0600); err != nil {
+		t.logger().Printf("capture: could not write capture: %s", err)
+	}
+}
+
+// redactBody replaces the values of fields in a JSON or form body. Bodies of other types are returned as they
+// are; one that should be redacted but can't be parsed, or was truncated, is an error
+func redactBody(contentType string, body []byte, truncated bool, fields []string) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		if truncated {
+			return nil, errors.New("body left out: truncated JSON can't be redacted")
+		}
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			return nil, errors.New("body left out: invalid JSON can't be redacted")
+		}
+		if !redactJSON(v, fields) {
+			return body, nil
+		}
+		return json.Marshal(v)
+
+	case mediaType == "application/x-www-form-urlencoded":
+		if truncated {
+			return nil, errors.New("body left out: truncated form can't be redacted")
+		}
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, errors.New("body left out: invalid form can't be redacted")
+		}
+		redacted := false
+		for key := range values {
+			if containsFold(fields, key) {
+				values.Set(key, redactedValue)
+				redacted = true
+			}
+		}
+		if !redacted {
+			return body, nil
+		}
+		return []byte(values.Encode()), nil
+	}
+
+	return body, nil
+}
+
+// redactJSON replaces, in place, the values of object keys in fields anywhere in v, reporting whether it did
+func redactJSON(v interface{}, fields []string) bool {
+	redacted := false
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if containsFold(fields, key) {
+				v[key] = redactedValue
+				redacted = true
+			} else if redactJSON(value, fields) {
+				redacted = true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if redactJSON(value, fields) {
+				redacted = true
+			}
+		}
+	}
+	return redacted
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplayCapture sends the captured request in file to targetURL, a base URL, and returns the response, which the
+// caller must close. A truncated capture gets ErrCaptureIncomplete
+func (t *Tools) ReplayCapture(ctx context.Context, file string, targetURL string) (*http.Response, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read capture: %w", err)
+	}
+
+	var c CapturedRequest
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("could not decode capture %s: %w", file, err)
+	}
+	if c.BodyTruncated || c.BodyOmitted != "" {
+		return nil, fmt.Errorf("%w: %s", ErrCaptureIncomplete, file)
+	}
+
+	base, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target URL: %w", err)
+	}
+	ref, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL in capture %s: %w", file, err)
+	}
+	target := *base
+	target.Path = strings.TrimSuffix(base.Path, "/") + ref.Path
+	target.RawPath = ""
+	target.RawQuery = ref.RawQuery
+
+	req, err := http.NewRequestWithContext(ctx, c.Method, target.String(), bytes.NewReader(c.Body))
+	if err != nil {
+		return nil, fmt.Errorf("could not create request: %w", err)
+	}
+	for key, values := range c.Header {
+		if len(values) == 1 && values[0] == redactedValue {
+			continue
+		}
+		switch http.CanonicalHeaderKey(key) {
+		case "Content-Length", "Connection", "Transfer-Encoding", "Keep-Alive", "Upgrade", "Te", "Trailer":
+			continue
+		}
+		req.Header[key] = values
+	}
+
+	resp, err := t.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not replay capture: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,234 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureFiles returns the captures written to dir, decoded
+func captureFiles(t *testing.T, dir string) ([]string, []CapturedRequest) {
+	t.Helper()
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	var captures []CapturedRequest
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var c CapturedRequest
+		if err := json.Unmarshal(data, &c); err != nil {
+			t.Fatal(err)
+		}
+		captures = append(captures, c)
+	}
+	return files, captures
+}
+
+// echoHandler replies 202 with the body it was sent
+var echoHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	w.WriteHeader(http.StatusAccepted)
+	w.Write(body)
+})
+
+func TestTools_CaptureBodies(t *testing.T) {
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+	dir := t.TempDir()
+	handler := testTools.CaptureBodies(CaptureOptions{Dir: dir, Routes: []string{"/api/"}})(echoHandler)
+
+	body := `{"order": 42, "items": ["a", "b"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/orders?dry_run=1", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Partner", "acme")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Body.String() != body {
+		t.Errorf("the handler must see the whole body, got %q", rr.Body.String())
+	}
+
+	_, captures := captureFiles(t, dir)
+	if len(captures) != 1 {
+		t.Fatalf("expected one capture, got %d", len(captures))
+	}
+	c := captures[0]
+	if c.Method != http.MethodPost || c.URL != "/api/orders?dry_run=1" || string(c.Body) != body || c.Status != http.StatusAccepted {
+		t.Errorf("capture does not match the request: %+v", c)
+	}
+	if c.Header.Get("X-Partner") != "acme" || c.Header.Get("Authorization") != redactedValue {
+		t.Errorf("unexpected captured headers: %v", c.Header)
+	}
+	if req.Header.Get("Authorization") != "Bearer secret" {
+		t.Error("redaction must not change the request itself")
+	}
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	if files, _ := captureFiles(t, dir); len(files) != 1 {
+		t.Errorf("expected routes outside the configured prefixes not to be captured, got %d captures", len(files))
+	}
+}
+
+func TestTools_CaptureBodiesTruncatedAndSampled(t *testing.T) {
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+
+	dir := t.TempDir()
+	handler := testTools.CaptureBodies(CaptureOptions{Dir: dir, MaxBodySize: 10})(echoHandler)
+
+	body := strings.Repeat("x", 100)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+	if rr.Body.String() != body {
+		t.Errorf("the handler must see the whole body, got %d bytes", rr.Body.Len())
+	}
+	_, captures := captureFiles(t, dir)
+	if len(captures) != 1 || string(captures[0].Body) != body[:10] || !captures[0].BodyTruncated {
+		t.Errorf("expected a truncated capture, got %+v", captures)
+	}
+
+	dir = t.TempDir()
+	handler = testTools.CaptureBodies(CaptureOptions{Dir: dir, SampleRate: 0.2})(echoHandler)
+	for i := 0; i < 500; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if files, _ := captureFiles(t, dir); len(files) < 50 || len(files) > 150 {
+		t.Errorf("expected about 100 of 500 requests to be captured, got %d", len(files))
+	}
+}
+
+func TestTools_CaptureBodiesRedaction(t *testing.T) {
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+
+	var tests = []struct {
+		name        string
+		contentType string
+		body        string
+		expected    string
+		omitted     bool
+	}{
+		{name: "json", contentType: "application/json", body: `{"user": {"Password": "hunter2", "name": "bob"}, "cards": [{"cvv": 123}]}`, expected: `{"cards":[{"cvv":"[REDACTED]"}],"user":{"Password":"[REDACTED]","name":"bob"}}`},
+		{name: "json without redacted fields is untouched", contentType: "application/json", body: `{"name":  "bob"}`, expected: `{"name":  "bob"}`},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: "name=bob&password=hunter2", expected: "name=bob&password=%5BREDACTED%5D"},
+		{name: "invalid json", contentType: "application/json", body: `{"password": "hunter2"`, omitted: true},
+		{name: "other types untouched", contentType: "text/plain", body: "password=hunter2", expected: "password=hunter2"},
+	}
+
+	for _, e := range tests {
+		dir := t.TempDir()
+		handler := testTools.CaptureBodies(CaptureOptions{
+			Dir:           dir,
+			RedactHeaders: []string{"X-Api-Key"},
+			RedactFields:  []string{"password", "cvv"},
+		})(echoHandler)
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", e.contentType)
+		req.Header.Set("X-Api-Key", "k")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Body.String() != e.body {
+			t.Errorf("%s: the handler must see the original body, got %q", e.name, rr.Body.String())
+		}
+
+		_, captures := captureFiles(t, dir)
+		if len(captures) != 1 {
+			t.Fatalf("%s: expected one capture, got %d", e.name, len(captures))
+		}
+		c := captures[0]
+		if c.Header.Get("X-Api-Key") != redactedValue {
+			t.Errorf("%s: expected the configured header to be redacted, got %q", e.name, c.Header.Get("X-Api-Key"))
+		}
+		if e.omitted {
+			if c.Body != nil || c.BodyOmitted == "" {
+				t.Errorf("%s: expected the body to be left out, got %q", e.name, c.Body)
+			}
+			continue
+		}
+		if string(c.Body) != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, c.Body)
+		}
+	}
+}
+
+func TestTools_CaptureBodiesWriteFailure(t *testing.T) {
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+
+	// a file where the capture directory should be makes every write fail
+	dir := filepath.Join(t.TempDir(), "captures")
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := testTools.CaptureBodies(CaptureOptions{Dir: dir})(echoHandler)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("still works")))
+
+	if rr.Code != http.StatusAccepted || rr.Body.String() != "still works" {
+		t.Errorf("a failed capture must not affect the request, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTools_ReplayCapture(t *testing.T) {
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+	dir := t.TempDir()
+
+	var replayed *http.Request
+	var replayedBody string
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		replayed, replayedBody = r, string(body)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}))
+	defer target.Close()
+
+	handler := testTools.CaptureBodies(CaptureOptions{Dir: dir})(echoHandler)
+	req := httptest.NewRequest(http.MethodPut, "/api/orders/7?v=2", strings.NewReader(`{"qty": 3}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Partner", "acme")
+	req.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	files, _ := captureFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("expected one capture, got %d", len(files))
+	}
+
+	resp, err := testTools.ReplayCapture(context.Background(), files[0], target.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected the target's response, got %d", resp.StatusCode)
+	}
+	if replayed.Method != http.MethodPut || replayed.URL.RequestURI() != "/api/orders/7?v=2" || replayedBody != `{"qty": 3}` {
+		t.Errorf("replay does not match the capture: %s %s %q", replayed.Method, replayed.URL, replayedBody)
+	}
+	if replayed.Header.Get("X-Partner") != "acme" || replayed.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected captured headers to be replayed, got %v", replayed.Header)
+	}
+	if replayed.Header.Get("Authorization") != "" {
+		t.Error("redacted headers must not be replayed")
+	}
+
+	// a truncated capture can't reproduce the request
+	dir = t.TempDir()
+	handler = testTools.CaptureBodies(CaptureOptions{Dir: dir, MaxBodySize: 2})(echoHandler)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too long")))
+	files, _ = captureFiles(t, dir)
+	if _, err := testTools.ReplayCapture(context.Background(), files[0], target.URL); !errors.Is(err, ErrCaptureIncomplete) {
+		t.Errorf("expected ErrCaptureIncomplete, got %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrUnknownUpload is returned by AppendChunk, ChunkedUploadOffset and CompleteChunkedUpload for an upload ID that
+// StartChunkedUpload didn't return, or whose upload has been completed
+var ErrUnknownUpload error = newMessageError("upload.unknown_upload")
+
+// ErrChunkInProgress is returned by AppendChunk and CompleteChunkedUpload when another request is appending to, or
+// completing, the same upload
+var ErrChunkInProgress error = newMessageError("upload.chunk_in_progress")
+
+// ErrChunkOffset is matched, via errors.Is, by the *ChunkOffsetError returned by AppendChunk
+var ErrChunkOffset = errors.New("chunk offset does not match the upload")
+
+// ChunkOffsetError is returned by AppendChunk when a chunk's offset is not the size of the upload so far. Expected is
+// the offset the next chunk must start at, for the client to resume from
+type ChunkOffsetError struct {
+	Offset, Expected int64
+}
+
+// Error implements the error interface
+func (e *ChunkOffsetError) Error() string {
+	return englishMessage("upload.chunk_offset", e.Offset, e.Expected)
+}
+
+// Is reports whether target is ErrChunkOffset
+func (e *ChunkOffsetError) Is(target error) bool {
+	return target == ErrChunkOffset
+}
+
+func (e *ChunkOffsetError) messageKey() (string, []interface{}) {
+	return "upload.chunk_offset", []interface{}{e.Offset, e.Expected}
+}
+
+// chunkedUploadManifest is the state of a chunked upload, kept in dir as <id>.json beside its data in <id>.part. The
+// size of the upload so far is the size of the data file
+type chunkedUploadManifest struct {
+	FileName  string    `json:"file_name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// chunkedUploadFileMode is the permissions of the files of a chunked upload, which only the process needs to read
+const chunkedUploadFileMode = 0600
+
+// chunkedUploadIDLen is the length of a chunked upload ID, 16 random bytes hex encoded
+const chunkedUploadIDLen = 32
+
+// StartChunkedUpload begins an upload of filename sent in chunks, kept in dir until CompleteChunkedUpload saves it,
+// and returns its ID
+func (t *Tools) StartChunkedUpload(dir, filename string) (string, error) {
+	if err := t.checkFileExtension(filename); err != nil {
+		return "", err
+	}
+	if err := t.CreateDirIfNotExist(dir); err != nil {
+		return "", fmt.Errorf("could not create chunked upload directory: %w", err)
+	}
+
+	b := make([]byte, chunkedUploadIDLen/2)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate upload ID: %w", err)
+	}
+	id := hex.EncodeToString(b)
+
+	manifest, err := json.Marshal(chunkedUploadManifest{FileName: filename, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return "", fmt.Errorf("could not encode chunked upload manifest: %w", err)
+	}
+	part, err := os.OpenFile(filepath.Join(dir, id+".part"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, chunkedUploadFileMode)
+	if err != nil {
+		return "", fmt.Errorf("could not start chunked upload: %w", err)
+	}
+	part.Close()
+	if err := WriteFileAtomic(filepath.Join(dir, id+".json"), manifest, chunkedUploadFileMode); err != nil {
+		os.Remove(filepath.Join(dir, id+".part"))
+		return "", fmt.Errorf("could not start chunked upload: %w", err)
+	}
+	return id, nil
+}
+
+// AppendChunk adds the chunk read from r to the upload id in dir at offset, and returns the size of the upload so
+// far. A wrong offset gets a *ChunkOffsetError, and a chunk over MaxFileSize ErrFileTooBig
+func (t *Tools) AppendChunk(dir, id string, offset int64, r io.Reader) (int64, error) {
+	manifest, err := readChunkedUploadManifest(dir, id)
+	if err != nil {
+		return 0, err
+	}
+
+	part, err := os.OpenFile(filepath.Join(dir, id+".part"), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, chunkedUploadError(err)
+	}
+	defer part.Close()
+	if locked, err := tryLockFile(part); err != nil {
+		return 0, fmt.Errorf("could not lock chunked upload: %w", err)
+	} else if !locked {
+		return 0, ErrChunkInProgress
+	}
+	// the upload may have been completed between reading its manifest and taking the lock, and its data file removed
+	if _, err := os.Stat(filepath.Join(dir, id+".json")); err != nil {
+		return 0, chunkedUploadError(err)
+	}
+
+	info, err := part.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("could not read chunked upload: %w", err)
+	}
+	size := info.Size()
+	if offset != size {
+		return size, &ChunkOffsetError{Offset: offset, Expected: size}
+	}
+	if _, err := part.Seek(size, io.SeekStart); err != nil {
+		return size, fmt.Errorf("could not write chunk: %w", err)
+	}
+
+	limit, tooBig := t.chunkedUploadLimit(manifest.FileName)
+	n, err := io.Copy(part, io.LimitReader(r, limit-size+1))
+	if size+n > limit {
+		if err := part.Truncate(size); err != nil {
+			return size + n, fmt.Errorf("could not drop chunk: %w", err)
+		}
+		return size, tooBig
+	}
+	if err != nil {
+		return size + n, fmt.Errorf("could not write chunk: %w", err)
+	}
+	return size + n, nil
+}
+
+// ChunkedUploadOffset returns the size of the upload id in dir so far, which is the offset its next chunk must start
+// at. A client that lost its connection asks for it to know where to resume from
+func (t *Tools) ChunkedUploadOffset(dir, id string) (int64, error) {
+	if _, err := readChunkedUploadManifest(dir, id); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(filepath.Join(dir, id+".part"))
+	if err != nil {
+		return 0, chunkedUploadError(err)
+	}
+	return info.Size(), nil
+}
+
+// CompleteChunkedUpload saves the chunked upload id in dir to uploadDir, with the same checks as StreamUploadFiles,
+// and removes its chunks whether it is saved or not
+func (t *Tools) CompleteChunkedUpload(ctx context.Context, dir, id, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	manifest, err := readChunkedUploadManifest(dir, id)
+	if err != nil {
+		return nil, err
+	}
+
+	partPath := filepath.Join(dir, id+".part")
+	part, err := os.Open(partPath)
+	if err != nil {
+		return nil, chunkedUploadError(err)
+	}
+	defer part.Close()
+	if locked, err := tryLockFile(part); err != nil {
+		return nil, fmt.Errorf("could not lock chunked upload: %w", err)
+	} else if !locked {
+		return nil, ErrChunkInProgress
+	}
+	info, err := part.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("could not read chunked upload: %w", err)
+	}
+
+	// the manifest goes first, so that the upload is unknown from now on whatever happens to the data
+	if err := os.Remove(filepath.Join(dir, id+".json")); err != nil {
+		return nil, chunkedUploadError(err)
+	}
+
+	uploadedFile, err := t.uploadStreamToDir(ctx, uploadDir, info.Size(), manifest.FileName, part, renameFile, func(err error) error {
+		return fmt.Errorf("could not read chunked upload: %w", err)
+	})
+	// The data file is closed, which releases the lock, before it is removed, so that an AppendChunk that opened it
+	// before then finds the manifest gone once it takes the lock, rather than writing to a removed file
+	part.Close()
+	os.Remove(partPath)
+	return uploadedFile, err
+}
+
+// chunkedUploadLimit returns the largest size the upload of filename may reach, and the error for going over it
+func (t *Tools) chunkedUploadLimit(filename string) (int64, error) {
+	limit := int64(defaultMaxFileSize)
+	if t.MaxFileSize != 0 {
+		limit = int64(t.MaxFileSize)
+	}
+	// The file's type isn't known until it is complete, so it may reach the largest limit of any type
+	perFile := t.MaxFilePerSize
+	for _, typeLimit := range t.SizeLimitsByType {
+		if perFile > 0 && typeLimit > perFile {
+			perFile = typeLimit
+		}
+	}
+	if perFile > 0 && perFile < limit {
+		return perFile, &FileTooBigError{FileName: filename, Limit: perFile}
+	}
+	return limit, ErrFileTooBig
+}
+
+// readChunkedUploadManifest reads the manifest of the upload id in dir
+func readChunkedUploadManifest(dir, id string) (*chunkedUploadManifest, error) {
+	if !validChunkedUploadID(id) {
+		return nil, ErrUnknownUpload
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return nil, chunkedUploadError(err)
+	}
+	var manifest chunkedUploadManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("could not read chunked upload manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// validChunkedUploadID reports whether id has the form of an ID from StartChunkedUpload, so that it is safe to use in
+// a file name
+func validChunkedUploadID(id string) bool {
+	if len(id) != chunkedUploadIDLen {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// chunkedUploadError reports a missing manifest or data file as ErrUnknownUpload
+func chunkedUploadError(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrUnknownUpload
+	}
+	return fmt.Errorf("could not read chunked upload: %w", err)
+}
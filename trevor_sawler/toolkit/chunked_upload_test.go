@@ -0,0 +1,168 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestTools_ChunkedUpload(t *testing.T) {
+	var testTools Tools
+	img := readTestFile(t, "img.png")
+	stagingDir := filepath.Join(t.TempDir(), "chunks")
+	uploadDir := t.TempDir()
+
+	id, err := testTools.StartChunkedUpload(stagingDir, "img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second chunk fails part way through, and is resumed from where it got to
+	third := len(img) / 3
+	size, err := testTools.AppendChunk(stagingDir, id, 0, bytes.NewReader(img[:third]))
+	if err != nil || size != int64(third) {
+		t.Fatalf("expected the first chunk to take the upload to %d bytes, got %d, %v", third, size, err)
+	}
+	failing := &failingReader{r: bytes.NewReader(img[third:]), after: 100, check: func() {}}
+	size, err = testTools.AppendChunk(stagingDir, id, size, failing)
+	if err == nil {
+		t.Fatal("expected the failing reader's error, got none")
+	}
+	if offset, err := testTools.ChunkedUploadOffset(stagingDir, id); err != nil || offset != size || size < int64(third) {
+		t.Fatalf("expected the upload to be resumable from %d, got %d, %v", size, offset, err)
+	}
+
+	// a chunk at the wrong offset is refused, saying where to resume from
+	_, err = testTools.AppendChunk(stagingDir, id, 0, bytes.NewReader(img))
+	var offsetErr *ChunkOffsetError
+	if !errors.As(err, &offsetErr) || !errors.Is(err, ErrChunkOffset) || offsetErr.Expected != size {
+		t.Errorf("expected a *ChunkOffsetError expecting %d, got %v", size, err)
+	}
+
+	if size, err = testTools.AppendChunk(stagingDir, id, size, bytes.NewReader(img[size:])); err != nil || size != int64(len(img)) {
+		t.Fatalf("expected the last chunk to complete the file, got %d, %v", size, err)
+	}
+
+	uploadedFile, err := testTools.CompleteChunkedUpload(context.Background(), stagingDir, id, uploadDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploadedFile.NewFileName != "img.png" || uploadedFile.ContentType != "image/png" || uploadedFile.FileSize != int64(len(img)) {
+		t.Errorf("unexpected uploaded file %+v", uploadedFile)
+	}
+	if saved, _ := os.ReadFile(filepath.Join(uploadDir, "img.png")); !bytes.Equal(saved, img) {
+		t.Error("expected the chunks to be saved as the whole file")
+	}
+	if names := remainingFiles(t, stagingDir); names != nil {
+		t.Errorf("expected the chunks to be removed, got %v", names)
+	}
+	if _, err := testTools.AppendChunk(stagingDir, id, size, bytes.NewReader(img)); !errors.Is(err, ErrUnknownUpload) {
+		t.Errorf("expected a completed upload to be unknown, got %v", err)
+	}
+}
+
+func TestTools_ChunkedUploadChecks(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	stagingDir := t.TempDir()
+
+	// a chunk over MaxFileSize is dropped
+	testTools := Tools{MaxFileSize: 1000}
+	id, err := testTools.StartChunkedUpload(stagingDir, "img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTools.AppendChunk(stagingDir, id, 0, bytes.NewReader(img[:600])); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := testTools.AppendChunk(stagingDir, id, 600, bytes.NewReader(img[600:])); !errors.Is(err, ErrFileTooBig) || size != 600 {
+		t.Errorf("expected ErrFileTooBig leaving 600 bytes, got %d, %v", size, err)
+	}
+	if offset, _ := testTools.ChunkedUploadOffset(stagingDir, id); offset != 600 {
+		t.Errorf("expected the oversize chunk to be dropped, got %d bytes", offset)
+	}
+
+	// the completed file goes through the upload checks, and is removed when it fails them
+	testTools = Tools{AllowedFileTypes: []string{"image/jpeg"}}
+	id, err = testTools.StartChunkedUpload(stagingDir, "img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTools.AppendChunk(stagingDir, id, 0, bytes.NewReader(img)); err != nil {
+		t.Fatal(err)
+	}
+	uploadDir := t.TempDir()
+	if _, err := testTools.CompleteChunkedUpload(context.Background(), stagingDir, id, uploadDir); !errors.Is(err, ErrFileTypeNotPermitted) {
+		t.Errorf("expected ErrFileTypeNotPermitted, got %v", err)
+	}
+	if names := remainingFiles(t, uploadDir); names != nil {
+		t.Errorf("expected nothing to be saved, got %v", names)
+	}
+	if _, err := testTools.ChunkedUploadOffset(stagingDir, id); !errors.Is(err, ErrUnknownUpload) {
+		t.Errorf("expected the refused upload to be removed, got %v", err)
+	}
+
+	// the request completing the upload has gone, so nothing is saved
+	testTools = Tools{}
+	id, err = testTools.StartChunkedUpload(stagingDir, "img.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testTools.AppendChunk(stagingDir, id, 0, bytes.NewReader(img)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := testTools.CompleteChunkedUpload(ctx, stagingDir, id, uploadDir); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if names := remainingFiles(t, uploadDir); names != nil {
+		t.Errorf("expected nothing to be saved, got %v", names)
+	}
+
+	testTools = Tools{AllowedFileExtensions: []string{"png"}}
+	if _, err := testTools.StartChunkedUpload(stagingDir, "notes.txt"); !errors.Is(err, ErrFileExtensionNotPermitted) {
+		t.Errorf("expected ErrFileExtensionNotPermitted, got %v", err)
+	}
+
+	for _, id := range []string{"", "../../etc/passwd", "0123456789abcdef0123456789abcdeg", "0123456789abcdef0123456789abcdef"} {
+		if _, err := testTools.AppendChunk(stagingDir, id, 0, bytes.NewReader(img)); !errors.Is(err, ErrUnknownUpload) {
+			t.Errorf("%q: expected ErrUnknownUpload, got %v", id, err)
+		}
+	}
+}
+
+func TestTools_ChunkedUploadInProgress(t *testing.T) {
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd":
+	default:
+		t.Skip("chunked uploads are only locked where files can be")
+	}
+
+	var testTools Tools
+	stagingDir := t.TempDir()
+	id, err := testTools.StartChunkedUpload(stagingDir, "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hold the lock a request appending to the upload would hold
+	part, err := os.Open(filepath.Join(stagingDir, id+".part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer part.Close()
+	if locked, err := tryLockFile(part); !locked || err != nil {
+		t.Fatalf("could not lock the upload: %t, %v", locked, err)
+	}
+
+	if _, err := testTools.AppendChunk(stagingDir, id, 0, bytes.NewReader([]byte("hello"))); !errors.Is(err, ErrChunkInProgress) {
+		t.Errorf("expected ErrChunkInProgress appending, got %v", err)
+	}
+	if _, err := testTools.CompleteChunkedUpload(context.Background(), stagingDir, id, t.TempDir()); !errors.Is(err, ErrChunkInProgress) {
+		t.Errorf("expected ErrChunkInProgress completing, got %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestTools_ConcurrentUse exercises one shared *Tools from many goroutines. Run it with -race to catch any method
+// that writes to the Tools it is called on
+func TestTools_ConcurrentUse(t *testing.T) {
+	client := NewTestClient(func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(bytes.NewBufferString("ok")),
+			Header:     make(http.Header),
+		}
+	})
+
+	// deliberately left at zero values, so that every method has to apply its defaults
+	var testTools Tools
+	testTools.HTTPClient = client
+
+	uploadDir := t.TempDir()
+	img := readTestFile(t, "img.png")
+
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*5)
+
+	// upload requests are built up front, since newUploadRequest may call t.Fatal
+	uploads := make([]*http.Request, workers)
+	for i := range uploads {
+		uploads[i] = newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: img})
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(upload *http.Request) {
+			defer wg.Done()
+
+			if _, err := testTools.UploadFiles(upload, uploadDir); err != nil {
+				errs <- err
+			}
+
+			var payload struct {
+				Foo string `json:"foo"`
+			}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"foo": "bar"}`))
+			if err := testTools.ReadJSON(httptest.NewRecorder(), req, &payload); err != nil {
+				errs <- err
+			}
+
+			if err := testTools.WriteJSON(httptest.NewRecorder(), http.StatusOK, payload); err != nil {
+				errs <- err
+			}
+
+			if _, err := testTools.Slugify("Now is the time 123"); err != nil {
+				errs <- err
+			}
+
+			if _, _, err := testTools.PushJSONToRemote("http://example.com/some/path", payload); err != nil {
+				errs <- err
+			}
+		}(uploads[i])
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if testTools.MaxFileSize != 0 || testTools.MaxJSONSize != 0 {
+		t.Error("methods should not write their defaults back to the shared Tools")
+	}
+}
+
+// TestTools_ConcurrentUploads runs every upload method at once against one zero value Tools, with -race, so that
+// none of them can start applying its defaults, MaxFileSize among them, to the shared Tools
+func TestTools_ConcurrentUploads(t *testing.T) {
+	var testTools Tools
+	uploadDir := t.TempDir()
+	img := readTestFile(t, "img.png")
+
+	uploads := map[string]func(r *http.Request) error{
+		"UploadFiles": func(r *http.Request) error {
+			_, err := testTools.UploadFiles(r, uploadDir)
+			return err
+		},
+		"UploadFilesContext": func(r *http.Request) error {
+			_, err := testTools.UploadFilesContext(r.Context(), r, uploadDir)
+			return err
+		},
+		"UploadOneFile": func(r *http.Request) error {
+			_, err := testTools.UploadOneFile(r, uploadDir)
+			return err
+		},
+		"StreamUploadFiles": func(r *http.Request) error {
+			_, err := testTools.StreamUploadFiles(r, uploadDir)
+			return err
+		},
+	}
+
+	const perMethod = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, perMethod*len(uploads))
+
+	for name, upload := range uploads {
+		for i := 0; i < perMethod; i++ {
+			req := newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: img})
+			wg.Add(1)
+			go func(name string, upload func(*http.Request) error, req *http.Request) {
+				defer wg.Done()
+				if err := upload(req); err != nil {
+					errs <- fmt.Errorf("%s: %w", name, err)
+				}
+			}(name, upload, req)
+		}
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(testTools, Tools{}) {
+		t.Errorf("expected the shared Tools to be left at its zero value, got %+v", testTools)
+	}
+}
+
+func TestTools_UploadFilesConcurrency(t *testing.T) {
+	const files = 20
+	var uploads []testUpload
+	for i := 0; i < files; i++ {
+		uploads = append(uploads, testUpload{field: "file", filename: fmt.Sprintf("f%02d.txt", i), content: []byte(fmt.Sprintf("file number %d", i))})
+	}
+
+	t.Run("order kept", func(t *testing.T) {
+		testTools := Tools{Concurrency: 4}
+		uploadDir := t.TempDir()
+
+		uploadedFiles, err := testTools.UploadFiles(newUploadRequest(t, uploads...), uploadDir, WithRename(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(uploadedFiles) != files {
+			t.Fatalf("expected %d files, got %d", files, len(uploadedFiles))
+		}
+		for i, f := range uploadedFiles {
+			if f.NewFileName != uploads[i].filename {
+				t.Errorf("expected file %d to be %s, got %s", i, uploads[i].filename, f.NewFileName)
+			}
+			content, err := os.ReadFile(filepath.Join(uploadDir, f.NewFileName))
+			if err != nil || !bytes.Equal(content, uploads[i].content) {
+				t.Errorf("expected %s to hold %q, got %q, %v", f.NewFileName, uploads[i].content, content, err)
+			}
+		}
+	})
+
+	t.Run("failure undone", func(t *testing.T) {
+		testTools := Tools{Concurrency: 4, AllowedFileTypes: []string{"text/plain"}}
+		uploadDir := t.TempDir()
+
+		withImage := append(append([]testUpload(nil), uploads...), testUpload{field: "file", filename: "img.png", content: readTestFile(t, "img.png")})
+		_, err := testTools.UploadFiles(newUploadRequest(t, withImage...), uploadDir)
+		if !errors.Is(err, ErrFileTypeNotPermitted) {
+			t.Errorf("expected ErrFileTypeNotPermitted, got %v", err)
+		}
+		if names := remainingFiles(t, uploadDir); names != nil {
+			t.Errorf("expected every saved file to be removed, got %v", names)
+		}
+	})
+
+	t.Run("several failures", func(t *testing.T) {
+		testTools := Tools{Concurrency: 2}
+
+		// both files fail only once both are being saved, so that neither stops the other being started
+		store := &barrierStore{}
+		store.arrived.Add(2)
+		_, err := testTools.UploadFilesTo(newUploadRequest(t, uploads[:2]...), store)
+		var multi MultiError
+		if !errors.As(err, &multi) || len(multi) != 2 {
+			t.Errorf("expected a MultiError of both files, got %v", err)
+		}
+		if !errors.Is(err, errBarrier) {
+			t.Errorf("expected the store's error, got %v", err)
+		}
+	})
+
+	t.Run("same name from RenameFunc", func(t *testing.T) {
+		testTools := Tools{Concurrency: 8, RenameFunc: func(string) string { return "same.txt" }}
+
+		var store MemoryStore
+		uploadedFiles, err := testTools.UploadFilesTo(newUploadRequest(t, uploads...), &store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := store.Names(); len(names) != files {
+			t.Errorf("expected %d distinct files, got %d", files, len(names))
+		}
+		for i, f := range uploadedFiles {
+			if content, _ := store.File(f.NewFileName); !bytes.Equal(content, uploads[i].content) {
+				t.Errorf("expected %s to hold %q, got %q", f.NewFileName, uploads[i].content, content)
+			}
+		}
+	})
+
+	t.Run("same content", func(t *testing.T) {
+		testTools := Tools{Concurrency: 8, NamingStrategy: NamingContentHash}
+
+		same := make([]testUpload, 8)
+		for i := range same {
+			same[i] = testUpload{field: "file", filename: "a.txt", content: []byte("the same content")}
+		}
+		var store MemoryStore
+		uploadedFiles, err := testTools.UploadFilesTo(newUploadRequest(t, same...), &store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deduplicated := 0
+		for _, f := range uploadedFiles {
+			if f.Deduplicated {
+				deduplicated++
+			}
+		}
+		if len(store.Names()) != 1 || deduplicated != len(same)-1 {
+			t.Errorf("expected one stored file and %d deduplicated, got %v and %d", len(same)-1, store.Names(), deduplicated)
+		}
+	})
+
+	t.Run("same content, first save fails", func(t *testing.T) {
+		testTools := Tools{Concurrency: 2, NamingStrategy: NamingContentHash, ContinueOnError: true}
+
+		same := []testUpload{{"file", "a.txt", []byte("the same content")}, {"file", "b.txt", []byte("the same content")}}
+		store := &failOnceStore{}
+		uploadedFiles, err := testTools.UploadFilesTo(newUploadRequest(t, same...), store)
+		var multi MultiError
+		if !errors.As(err, &multi) || len(multi) != 1 {
+			t.Fatalf("expected a MultiError of one file, got %v", err)
+		}
+		if len(uploadedFiles) != 1 || uploadedFiles[0].Deduplicated {
+			t.Fatalf("expected one file saved rather than deduplicated, got %+v", uploadedFiles)
+		}
+		if _, ok := store.File(uploadedFiles[0].NewFileName); !ok {
+			t.Errorf("expected %s to be in the store, got %v", uploadedFiles[0].NewFileName, store.Names())
+		}
+	})
+}
+
+// failOnceStore is a MemoryStore whose first Save fails with errBarrier, after the other files have had time to start
+type failOnceStore struct {
+	MemoryStore
+	failed atomic.Bool
+}
+
+func (s *failOnceStore) Save(ctx context.Context, name string, r io.Reader) (int64, error) {
+	if s.failed.CompareAndSwap(false, true) {
+		time.Sleep(50 * time.Millisecond)
+		return 0, errBarrier
+	}
+	return s.MemoryStore.Save(ctx, name, r)
+}
+
+var errBarrier = errors.New("barrier store")
+
+// barrierStore is a MemoryStore whose Save waits until arrived is done, and then fails with errBarrier
+type barrierStore struct {
+	MemoryStore
+	arrived sync.WaitGroup
+}
+
+func (s *barrierStore) Save(ctx context.Context, name string, r io.Reader) (int64, error) {
+	s.arrived.Done()
+	s.arrived.Wait()
+	return 0, errBarrier
+}
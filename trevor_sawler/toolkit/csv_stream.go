@@ -0,0 +1,118 @@
+package toolkit
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// csvFlushRows is how many rows WriteCSVStream writes between flushes to the client
+const csvFlushRows = 1000
+
+// WriteCSVStream streams a CSV download named filename, the header (if not empty) and then each row from next until
+// it returns io.EOF. An error after the first row leaves the client with a truncated file
+func (t *Tools) WriteCSVStream(w http.ResponseWriter, filename string, header []string, next func() ([]string, error)) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	cw := csv.NewWriter(w)
+
+	flush := func() error {
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return fmt.Errorf("could not write CSV to client: %w", err)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	if len(header) > 0 {
+		if err := cw.Write(header); err != nil {
+			return fmt.Errorf("could not write CSV to client: %w", err)
+		}
+	}
+
+	for rows := 0; ; rows++ {
+		if rows > 0 && rows%csvFlushRows == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			_ = flush()
+			return err
+		}
+
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("could not write CSV to client: %w", err)
+		}
+	}
+
+	return flush()
+}
+
+// SQLRows is the subset of *sql.Rows used by SQLRowSource
+type SQLRows interface {
+	Columns() ([]string, error)
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+// SQLRowSource adapts rows, typically a *sql.Rows, into a row source for WriteCSVStream. The caller remains
+// responsible for closing rows
+func SQLRowSource(rows SQLRows) func() ([]string, error) {
+	var values []interface{}
+	var dest []interface{}
+
+	return func() ([]string, error) {
+		if values == nil {
+			cols, err := rows.Columns()
+			if err != nil {
+				return nil, err
+			}
+			values = make([]interface{}, len(cols))
+			dest = make([]interface{}, len(cols))
+			for i := range values {
+				dest[i] = &values[i]
+			}
+		}
+
+		if !rows.Next() {
+			if err := rows.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		record := make([]string, len(values))
+		for i, v := range values {
+			switch v := v.(type) {
+			case nil:
+			case []byte:
+				record[i] = string(v)
+			case time.Time:
+				record[i] = v.Format(time.RFC3339)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		return record, nil
+	}
+}
@@ -0,0 +1,167 @@
+package toolkit
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// rowGenerator returns a row source producing n rows, counting how many times it is called
+func rowGenerator(n int, calls *int) func() ([]string, error) {
+	return func() ([]string, error) {
+		*calls++
+		if *calls > n {
+			return nil, io.EOF
+		}
+		return []string{strconv.Itoa(*calls), "name, with comma", `quote "here"`}, nil
+	}
+}
+
+func TestTools_WriteCSVStream(t *testing.T) {
+	var testTools Tools
+	var calls int
+
+	rr := httptest.NewRecorder()
+	err := testTools.WriteCSVStream(rr, "export.csv", []string{"id", "name", "note"}, rowGenerator(100000, &calls))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("wrong content type: %s", ct)
+	}
+	if cd := rr.Header().Get("Content-Disposition"); cd != `attachment; filename="export.csv"` {
+		t.Errorf("wrong content disposition: %s", cd)
+	}
+	if !rr.Flushed {
+		t.Error("expected the response to be flushed while streaming")
+	}
+
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 100001 {
+		t.Fatalf("expected 100001 records, got %d", len(records))
+	}
+	if records[0][0] != "id" || records[100000][0] != "100000" || records[5][2] != `quote "here"` {
+		t.Errorf("unexpected records: %v %v %v", records[0], records[100000], records[5])
+	}
+}
+
+func TestTools_WriteCSVStreamSourceError(t *testing.T) {
+	var testTools Tools
+	boom := errors.New("database went away")
+
+	calls := 0
+	next := func() ([]string, error) {
+		calls++
+		if calls > 2500 {
+			return nil, boom
+		}
+		return []string{"row"}, nil
+	}
+
+	rr := httptest.NewRecorder()
+	err := testTools.WriteCSVStream(rr, "export.csv", nil, next)
+	if !errors.Is(err, boom) {
+		t.Fatalf("expected the source error, got %v", err)
+	}
+	if calls != 2501 {
+		t.Errorf("expected next to stop being called after the error, got %d calls", calls)
+	}
+
+	records, _ := csv.NewReader(rr.Body).ReadAll()
+	if len(records) != 2500 {
+		t.Errorf("expected the 2500 rows before the error to be sent, got %d", len(records))
+	}
+}
+
+// disconnectingWriter fails every write once limit bytes have been written, like a client that went away
+type disconnectingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (d *disconnectingWriter) Write(b []byte) (int, error) {
+	if d.Body.Len()+len(b) > d.limit {
+		return 0, errors.New("write: broken pipe")
+	}
+	return d.ResponseRecorder.Write(b)
+}
+
+func TestTools_WriteCSVStreamClientGone(t *testing.T) {
+	var testTools Tools
+	var calls int
+
+	w := &disconnectingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 64 * 1024}
+	err := testTools.WriteCSVStream(w, "export.csv", nil, rowGenerator(1000000, &calls))
+	if err == nil {
+		t.Fatal("expected an error when the client disconnects")
+	}
+	if calls > 10000 {
+		t.Errorf("expected next to stop being called soon after the disconnect, got %d calls", calls)
+	}
+}
+
+// fakeSQLRows implements SQLRows over a fixed table
+type fakeSQLRows struct {
+	cols []string
+	data [][]interface{}
+	pos  int
+	err  error
+}
+
+func (f *fakeSQLRows) Columns() ([]string, error) { return f.cols, nil }
+func (f *fakeSQLRows) Err() error                 { return f.err }
+
+func (f *fakeSQLRows) Next() bool {
+	if f.pos >= len(f.data) {
+		return false
+	}
+	f.pos++
+	return true
+}
+
+func (f *fakeSQLRows) Scan(dest ...interface{}) error {
+	for i, v := range f.data[f.pos-1] {
+		*dest[i].(*interface{}) = v
+	}
+	return nil
+}
+
+func TestSQLRowSource(t *testing.T) {
+	var testTools Tools
+
+	rows := &fakeSQLRows{
+		cols: []string{"id", "name", "created", "note"},
+		data: [][]interface{}{
+			{int64(1), []byte("alice"), time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC), nil},
+			{int64(2), "bob", time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC), 1.5},
+		},
+	}
+
+	rr := httptest.NewRecorder()
+	if err := testTools.WriteCSVStream(rr, "users.csv", rows.cols, SQLRowSource(rows)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "id,name,created,note\n1,alice,2024-05-06T07:08:09Z,\n2,bob,2024-05-07T00:00:00Z,1.5\n"
+	if rr.Body.String() != expected {
+		t.Errorf("expected %q, got %q", expected, rr.Body.String())
+	}
+
+	rows = &fakeSQLRows{cols: []string{"id"}, err: errors.New("connection reset")}
+	rr = httptest.NewRecorder()
+	if err := testTools.WriteCSVStream(rr, "users.csv", nil, SQLRowSource(rows)); err == nil || err.Error() != "connection reset" {
+		t.Errorf("expected rows.Err to be returned, got %v", err)
+	}
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rr.Code)
+	}
+}
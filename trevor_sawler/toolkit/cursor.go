@@ -0,0 +1,130 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidCursor is matched, via errors.Is, by every *CursorError
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorError is returned by DecodeCursor when a cursor is malformed, truncated or has been tampered with
+type CursorError struct {
+	Reason string
+	Err    error
+}
+
+// Error implements the error interface
+func (e *CursorError) Error() string {
+	return "invalid cursor: " + e.Reason
+}
+
+// Unwrap returns the underlying error, if any
+func (e *CursorError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrInvalidCursor
+func (e *CursorError) Is(target error) bool {
+	return target == ErrInvalidCursor
+}
+
+func (e *CursorError) messageKey() (string, []interface{}) {
+	return "pagination.invalid_cursor", nil
+}
+
+// EncodeCursor returns v, JSON encoded, as an opaque URL-safe string for use as a pagination cursor. When
+// CursorSecret is set, the cursor is signed with HMAC-SHA256 so that DecodeCursor can detect tampering
+func (t *Tools) EncodeCursor(v interface{}) (string, error) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("could not encode cursor: %w", err)
+	}
+
+	cursor := base64.RawURLEncoding.EncodeToString(payload)
+	if len(t.CursorSecret) > 0 {
+		cursor += "." + base64.RawURLEncoding.EncodeToString(t.cursorSignature(payload))
+	}
+
+	return cursor, nil
+}
+
+// DecodeCursor decodes a cursor made by EncodeCursor into dst. When CursorSecret is set the signature is verified,
+// and unsigned cursors are rejected. Any problem with the cursor itself is reported as a *CursorError
+func (t *Tools) DecodeCursor(s string, dst interface{}) error {
+	encoded, sig, signed := strings.Cut(s, ".")
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(payload) == 0 {
+		return &CursorError{Reason: "malformed encoding", Err: err}
+	}
+
+	if len(t.CursorSecret) > 0 {
+		if !signed {
+			return &CursorError{Reason: "cursor is not signed"}
+		}
+		mac, err := base64.RawURLEncoding.DecodeString(sig)
+		if err != nil || !hmac.Equal(mac, t.cursorSignature(payload)) {
+			return &CursorError{Reason: "signature mismatch", Err: err}
+		}
+	} else if signed {
+		return &CursorError{Reason: "unexpected signature"}
+	}
+
+	if err := json.Unmarshal(payload, dst); err != nil {
+		var invalidUnmarshalError *json.InvalidUnmarshalError
+		if errors.As(err, &invalidUnmarshalError) {
+			return fmt.Errorf("could not decode cursor: %w", err)
+		}
+		return &CursorError{Reason: "malformed payload", Err: err}
+	}
+
+	return nil
+}
+
+// cursorSignature returns the HMAC-SHA256 of payload under CursorSecret
+func (t *Tools) cursorSignature(payload []byte) []byte {
+	mac := hmac.New(sha256.New, t.CursorSecret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// CursorInfo holds the cursors for the pages either side of the one sent by WriteCursorPaginatedJSON
+type CursorInfo struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// CursorPaginatedResponse is the JSON envelope sent by WriteCursorPaginatedJSON
+type CursorPaginatedResponse struct {
+	Error      bool        `json:"error"`
+	Message    string      `json:"message,omitempty"`
+	Data       interface{} `json:"data"`
+	Pagination CursorInfo  `json:"pagination"`
+}
+
+// WriteCursorPaginatedJSON sends one page of results, data, along with cursors for the next and previous pages,
+// which are left out when nil
+func (t *Tools) WriteCursorPaginatedJSON(w http.ResponseWriter, data interface{}, next, prev interface{}, headers ...http.Header) error {
+	var info CursorInfo
+	var err error
+
+	if next != nil {
+		if info.NextCursor, err = t.EncodeCursor(next); err != nil {
+			return err
+		}
+	}
+	if prev != nil {
+		if info.PrevCursor, err = t.EncodeCursor(prev); err != nil {
+			return err
+		}
+	}
+
+	return t.WriteJSON(w, http.StatusOK, CursorPaginatedResponse{Data: data, Pagination: info}, headers...)
+}
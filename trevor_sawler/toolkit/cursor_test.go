@@ -0,0 +1,124 @@
+package toolkit
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCursor struct {
+	ID        int       `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func TestTools_CursorRoundTrip(t *testing.T) {
+	for _, secret := range [][]byte{nil, []byte("secret")} {
+		testTools := Tools{CursorSecret: secret}
+
+		in := testCursor{ID: 42, CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+		cursor, err := testTools.EncodeCursor(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.ContainsAny(cursor, "+/= ") {
+			t.Errorf("cursor %q is not URL safe", cursor)
+		}
+
+		var out testCursor
+		if err := testTools.DecodeCursor(cursor, &out); err != nil {
+			t.Errorf("signed=%t: unexpected error decoding struct cursor: %s", secret != nil, err)
+		}
+		if out != in {
+			t.Errorf("signed=%t: expected %+v, got %+v", secret != nil, in, out)
+		}
+
+		mapIn := map[string]interface{}{"id": 7.0, "name": "späti", "desc": true}
+		cursor, err = testTools.EncodeCursor(mapIn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var mapOut map[string]interface{}
+		if err := testTools.DecodeCursor(cursor, &mapOut); err != nil {
+			t.Errorf("signed=%t: unexpected error decoding map cursor: %s", secret != nil, err)
+		}
+		if !reflect.DeepEqual(mapIn, mapOut) {
+			t.Errorf("signed=%t: expected %v, got %v", secret != nil, mapIn, mapOut)
+		}
+	}
+}
+
+func TestTools_DecodeCursorInvalid(t *testing.T) {
+	signer := Tools{CursorSecret: []byte("secret")}
+	signed, _ := signer.EncodeCursor(testCursor{ID: 1})
+	payload, sig, _ := strings.Cut(signed, ".")
+
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"id":2}`))
+	otherKey, _ := (&Tools{CursorSecret: []byte("other")}).EncodeCursor(testCursor{ID: 1})
+
+	var plain Tools
+	unsigned, _ := plain.EncodeCursor(testCursor{ID: 1})
+
+	var tests = []struct {
+		name   string
+		tools  *Tools
+		cursor string
+	}{
+		{name: "empty", tools: &signer, cursor: ""},
+		{name: "not base64", tools: &signer, cursor: "!!!." + sig},
+		{name: "truncated payload", tools: &signer, cursor: payload[:len(payload)-3] + "." + sig},
+		{name: "truncated signature", tools: &signer, cursor: payload + "." + sig[:10]},
+		{name: "forged payload", tools: &signer, cursor: forged + "." + sig},
+		{name: "signed with another key", tools: &signer, cursor: otherKey},
+		{name: "missing signature", tools: &signer, cursor: payload},
+		{name: "unexpected signature", tools: &plain, cursor: signed},
+		{name: "truncated unsigned", tools: &plain, cursor: unsigned[:len(unsigned)-4]},
+		{name: "not json", tools: &plain, cursor: base64.RawURLEncoding.EncodeToString([]byte("nope"))},
+	}
+
+	for _, e := range tests {
+		var out testCursor
+		err := e.tools.DecodeCursor(e.cursor, &out)
+
+		var cursorErr *CursorError
+		if !errors.As(err, &cursorErr) || !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: expected *CursorError, got %v", e.name, err)
+		}
+	}
+}
+
+func TestTools_WriteCursorPaginatedJSON(t *testing.T) {
+	testTools := Tools{CursorSecret: []byte("secret")}
+
+	rr := httptest.NewRecorder()
+	if err := testTools.WriteCursorPaginatedJSON(rr, []int{1, 2}, testCursor{ID: 2}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp struct {
+		Data       []int                  `json:"data"`
+		Pagination map[string]interface{} `json:"pagination"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rr.Code)
+	}
+	if _, ok := resp.Pagination["prev_cursor"]; ok {
+		t.Error("expected prev_cursor to be left out")
+	}
+
+	next, _ := resp.Pagination["next_cursor"].(string)
+	var out testCursor
+	if err := testTools.DecodeCursor(next, &out); err != nil || out.ID != 2 {
+		t.Errorf("expected next cursor to decode to ID 2, got %+v (%v)", out, err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd)
+
+package toolkit
+
+// diskFree is not supported on this platform
+func diskFree(path string) (uint64, error) {
+	return 0, errDiskFreeUnsupported
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package toolkit
+
+import "syscall"
+
+// diskFree returns the number of bytes available to unprivileged users on the file system holding path
+func diskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
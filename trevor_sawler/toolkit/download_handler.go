@@ -0,0 +1,186 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsafePath is returned by SafeJoin when a name would resolve outside of its root directory
+var ErrUnsafePath error = newMessageError("download.unsafe_path")
+
+// SafeJoin joins name onto root, returning ErrUnsafePath if name is absolute, empty, or would climb out of root
+func (t *Tools) SafeJoin(root, name string) (string, error) {
+	if name == "" || strings.ContainsRune(name, 0) {
+		return "", ErrUnsafePath
+	}
+
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" || strings.HasPrefix(name, string(filepath.Separator)) {
+		return "", ErrUnsafePath
+	}
+
+	cleaned := filepath.Clean(name)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", ErrUnsafePath
+	}
+
+	return filepath.Join(root, cleaned), nil
+}
+
+// DownloadHandlerOption configures the handler returned by DownloadHandler
+type DownloadHandlerOption func(*downloadHandlerConfig)
+
+type downloadHandlerConfig struct {
+	param     string
+	nameParam string
+	inline    bool
+	secret    []byte
+	auditHook func(r *http.Request, file string, err error)
+}
+
+// WithDownloadParam sets the query parameter holding the requested file name. Defaults to "file". When the
+// parameter is absent, the trailing path segment of the request URL is used instead
+func WithDownloadParam(name string) DownloadHandlerOption {
+	return func(c *downloadHandlerConfig) { c.param = name }
+}
+
+// WithDownloadNameParam sets the query parameter holding the name the browser should save the file as.
+// Defaults to "name"; when absent the file's own name is used
+func WithDownloadNameParam(name string) DownloadHandlerOption {
+	return func(c *downloadHandlerConfig) { c.nameParam = name }
+}
+
+// WithDownloadInline serves files with an inline Content-Disposition, so that browsers display them rather than
+// saving them
+func WithDownloadInline(inline bool) DownloadHandlerOption {
+	return func(c *downloadHandlerConfig) { c.inline = inline }
+}
+
+// WithDownloadSignature requires every request to carry a valid "expires" and "signature" query parameter,
+// created with SignDownload using the same secret
+func WithDownloadSignature(secret []byte) DownloadHandlerOption {
+	return func(c *downloadHandlerConfig) { c.secret = secret }
+}
+
+// WithDownloadAuditHook registers a function called after every download attempt with the requested file name
+// and the error, if any, that stopped it
+func WithDownloadAuditHook(fn func(r *http.Request, file string, err error)) DownloadHandlerOption {
+	return func(c *downloadHandlerConfig) { c.auditHook = fn }
+}
+
+// DownloadHandler returns a handler that serves the file named in the URL from rootDir, confined with SafeJoin, as
+// an attachment with Range support
+func (t *Tools) DownloadHandler(rootDir string, opts ...DownloadHandlerOption) http.HandlerFunc {
+	cfg := downloadHandlerConfig{param: "file", nameParam: "name"}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return t.AllowMethods(func(w http.ResponseWriter, r *http.Request) {
+		file := r.URL.Query().Get(cfg.param)
+		if file == "" && !strings.HasSuffix(r.URL.Path, "/") {
+			file = path.Base(r.URL.Path)
+		}
+
+		status, err := t.serveDownload(w, r, rootDir, file, cfg)
+		if cfg.auditHook != nil {
+			cfg.auditHook(r, file, err)
+		}
+		if err != nil {
+			_ = t.LocalizedErrorJSON(w, r, err, status)
+		}
+	}, http.MethodGet)
+}
+
+// serveDownload validates the request and serves the file, returning a status and error when it can't
+func (t *Tools) serveDownload(w http.ResponseWriter, r *http.Request, rootDir, file string, cfg downloadHandlerConfig) (int, error) {
+	if file == "" {
+		return http.StatusBadRequest, newMessageError("download.no_file")
+	}
+
+	if len(cfg.secret) > 0 {
+		if err := verifyDownloadSignature(cfg.secret, file, r.URL.Query()); err != nil {
+			return http.StatusForbidden, err
+		}
+	}
+
+	fp, err := t.SafeJoin(rootDir, file)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	info, err := os.Stat(fp)
+	if err != nil || info.IsDir() {
+		return http.StatusNotFound, newMessageError("download.not_found")
+	}
+
+	displayName := r.URL.Query().Get(cfg.nameParam)
+	if displayName == "" {
+		displayName = filepath.Base(fp)
+	}
+
+	disposition := "attachment"
+	if cfg.inline {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(disposition, displayName))
+	http.ServeFile(w, r, fp)
+	return http.StatusOK, nil
+}
+
+// contentDisposition returns a Content-Disposition header of type disposition naming filename, quoted (or encoded
+// as filename* when it is not ASCII) so that the name can't add parameters of its own
+func contentDisposition(disposition, filename string) string {
+	if v := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); v != "" {
+		return v
+	}
+	return disposition
+}
+
+// SignDownload returns the query parameters that authorise a download of file until expires, for handlers
+// created with WithDownloadSignature
+func SignDownload(secret []byte, file string, expires time.Time) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+
+	v := url.Values{}
+	v.Set("expires", exp)
+	v.Set("signature", downloadSignature(secret, file, exp))
+	return v
+}
+
+// verifyDownloadSignature checks the expires and signature parameters of a signed download
+func verifyDownloadSignature(secret []byte, file string, q url.Values) error {
+	exp := q.Get("expires")
+	sig, err := hex.DecodeString(q.Get("signature"))
+	if exp == "" || err != nil || len(sig) == 0 {
+		return newMessageError("download.unsigned")
+	}
+
+	expected, _ := hex.DecodeString(downloadSignature(secret, file, exp))
+	if !hmac.Equal(sig, expected) {
+		return newMessageError("download.bad_signature")
+	}
+
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().After(time.Unix(unix, 0)) {
+		return newMessageError("download.expired")
+	}
+
+	return nil
+}
+
+func downloadSignature(secret []byte, file, expires string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(file + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
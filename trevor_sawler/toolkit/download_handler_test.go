@@ -0,0 +1,139 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var safeJoinTests = []struct {
+	name          string
+	file          string
+	errorExpected bool
+}{
+	{name: "simple", file: "pic.jpg", errorExpected: false},
+	{name: "nested", file: "sub/pic.jpg", errorExpected: false},
+	{name: "cleaned inside", file: "sub/../pic.jpg", errorExpected: false},
+	{name: "empty", file: "", errorExpected: true},
+	{name: "dot", file: ".", errorExpected: true},
+	{name: "parent", file: "..", errorExpected: true},
+	{name: "climb", file: "../secret.txt", errorExpected: true},
+	{name: "deep climb", file: "sub/../../secret.txt", errorExpected: true},
+	{name: "absolute", file: "/etc/passwd", errorExpected: true},
+	{name: "nul byte", file: "pic.jpg\x00.png", errorExpected: true},
+}
+
+func TestTools_SafeJoin(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range safeJoinTests {
+		p, err := testTools.SafeJoin("./testdata", e.file)
+		if e.errorExpected {
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Errorf("%s: expected ErrUnsafePath but got %v (%s)", e.name, err, p)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: error not expected, but one received: %s", e.name, err)
+		}
+		if rel, _ := filepath.Rel("testdata", p); rel == "" || rel[0] == '.' {
+			t.Errorf("%s: joined path %s escaped the root", e.name, p)
+		}
+	}
+}
+
+func TestTools_DownloadHandler(t *testing.T) {
+	var downloadTests = []struct {
+		name            string
+		url             string
+		rangeHeader     string
+		opts            []DownloadHandlerOption
+		expectedStatus  int
+		expectedDisp    string
+		expectedLength  string
+		expectedContent string
+	}{
+		{name: "query param", url: "/download?file=pic.jpg", expectedStatus: http.StatusOK, expectedDisp: `attachment; filename=pic.jpg`, expectedLength: "98827"},
+		{name: "path segment", url: "/download/pic.jpg", expectedStatus: http.StatusOK, expectedDisp: `attachment; filename=pic.jpg`, expectedLength: "98827"},
+		{name: "display name", url: "/download?file=pic.jpg&name=puppy.jpg", expectedStatus: http.StatusOK, expectedDisp: `attachment; filename=puppy.jpg`},
+		{name: "inline", url: "/download?file=pic.jpg", opts: []DownloadHandlerOption{WithDownloadInline(true)}, expectedStatus: http.StatusOK, expectedDisp: `inline; filename=pic.jpg`},
+		{name: "quoted display name", url: "/download?file=pic.jpg&name=" + url.QueryEscape(`a".jpg; filename*=utf-8''evil.exe`), expectedStatus: http.StatusOK, expectedDisp: `attachment; filename="a\".jpg; filename*=utf-8''evil.exe"`},
+		{name: "inline quoted display name", url: "/download?file=pic.jpg&name=" + url.QueryEscape(`a".jpg; filename*=utf-8''evil.exe`), opts: []DownloadHandlerOption{WithDownloadInline(true)}, expectedStatus: http.StatusOK, expectedDisp: `inline; filename="a\".jpg; filename*=utf-8''evil.exe"`},
+		{name: "custom param", url: "/download?f=pic.jpg", opts: []DownloadHandlerOption{WithDownloadParam("f")}, expectedStatus: http.StatusOK},
+		{name: "range", url: "/download?file=pic.jpg", rangeHeader: "bytes=0-99", expectedStatus: http.StatusPartialContent, expectedLength: "100", expectedContent: "bytes 0-99/98827"},
+		{name: "missing file", url: "/download?file=nope.jpg", expectedStatus: http.StatusNotFound},
+		{name: "directory", url: "/download?file=uploads", expectedStatus: http.StatusNotFound},
+		{name: "no file", url: "/download/", expectedStatus: http.StatusBadRequest},
+		{name: "traversal", url: "/download?file=../tools.go", expectedStatus: http.StatusBadRequest},
+		{name: "encoded traversal", url: "/download?file=..%2F..%2Fgo.mod", expectedStatus: http.StatusBadRequest},
+		{name: "absolute", url: "/download?file=%2Fetc%2Fpasswd", expectedStatus: http.StatusBadRequest},
+	}
+
+	var testTools Tools
+
+	for _, e := range downloadTests {
+		req := httptest.NewRequest("GET", e.url, nil)
+		if e.rangeHeader != "" {
+			req.Header.Set("Range", e.rangeHeader)
+		}
+		rr := httptest.NewRecorder()
+		testTools.DownloadHandler("./testdata", e.opts...)(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body.String())
+			continue
+		}
+		if e.expectedDisp != "" && rr.Header().Get("Content-Disposition") != e.expectedDisp {
+			t.Errorf("%s: wrong content disposition %q", e.name, rr.Header().Get("Content-Disposition"))
+		}
+		if e.expectedLength != "" && rr.Header().Get("Content-Length") != e.expectedLength {
+			t.Errorf("%s: wrong content length %q", e.name, rr.Header().Get("Content-Length"))
+		}
+		if e.expectedContent != "" && rr.Header().Get("Content-Range") != e.expectedContent {
+			t.Errorf("%s: wrong content range %q", e.name, rr.Header().Get("Content-Range"))
+		}
+		if e.expectedStatus >= 400 && rr.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected a JSON error", e.name)
+		}
+	}
+}
+
+func TestTools_DownloadHandlerSigned(t *testing.T) {
+	var testTools Tools
+	secret := []byte("download-secret")
+
+	var audited []string
+	h := testTools.DownloadHandler("./testdata", WithDownloadSignature(secret), WithDownloadAuditHook(func(r *http.Request, file string, err error) {
+		audited = append(audited, file)
+	}))
+
+	var signedTests = []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{name: "valid", query: SignDownload(secret, "pic.jpg", time.Now().Add(time.Minute)).Encode(), expectedStatus: http.StatusOK},
+		{name: "unsigned", query: "", expectedStatus: http.StatusForbidden},
+		{name: "expired", query: SignDownload(secret, "pic.jpg", time.Now().Add(-time.Minute)).Encode(), expectedStatus: http.StatusForbidden},
+		{name: "other file", query: SignDownload(secret, "img.png", time.Now().Add(time.Minute)).Encode(), expectedStatus: http.StatusForbidden},
+		{name: "wrong secret", query: SignDownload([]byte("nope"), "pic.jpg", time.Now().Add(time.Minute)).Encode(), expectedStatus: http.StatusForbidden},
+	}
+
+	for _, e := range signedTests {
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest("GET", "/download/pic.jpg?"+e.query, nil))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+
+	if len(audited) != len(signedTests) {
+		t.Errorf("expected audit hook to be called %d times, got %d", len(signedTests), len(audited))
+	}
+}
@@ -0,0 +1,122 @@
+package toolkit
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrDecryptionFailed is returned by Decrypt when a ciphertext is malformed, has been tampered with, or was not
+// encrypted with any of the EncryptionKeys
+var ErrDecryptionFailed = errors.New("could not decrypt value")
+
+// encryptionKeySize is the key size for AES-256
+const encryptionKeySize = 32
+
+// passphraseIterations is the PBKDF2 iteration count used by DeriveEncryptionKey, as recommended by OWASP for
+// PBKDF2-HMAC-SHA256
+const passphraseIterations = 600000
+
+// Encrypt encrypts plaintext with AES-256-GCM using the first of EncryptionKeys, and returns it URL-safe base64
+// encoded
+func (t *Tools) Encrypt(plaintext []byte) (string, error) {
+	if len(t.EncryptionKeys) == 0 {
+		return "", errors.New("no encryption keys configured")
+	}
+
+	gcm, err := newGCM(t.EncryptionKeys[0])
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %w", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt reverses Encrypt with any of EncryptionKeys, so keys can be rotated. Any failure is reported as
+// ErrDecryptionFailed
+func (t *Tools) Decrypt(ciphertext string) ([]byte, error) {
+	if len(t.EncryptionKeys) == 0 {
+		return nil, errors.New("no encryption keys configured")
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	for _, key := range t.EncryptionKeys {
+		gcm, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(sealed) < gcm.NonceSize()+gcm.Overhead() {
+			return nil, ErrDecryptionFailed
+		}
+
+		nonce, box := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+		if plaintext, err := gcm.Open(nil, nonce, box, nil); err == nil {
+			return plaintext, nil
+		}
+	}
+
+	return nil, ErrDecryptionFailed
+}
+
+// DeriveEncryptionKey derives a 32 byte key for EncryptionKeys from a passphrase and a random salt using
+// PBKDF2-HMAC-SHA256, which is deliberately slow
+func DeriveEncryptionKey(passphrase string, salt []byte) []byte {
+	return pbkdf2SHA256([]byte(passphrase), salt, passphraseIterations, encryptionKeySize)
+}
+
+// newGCM returns an AES-GCM cipher for key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2SHA256 implements PBKDF2 (RFC 8018) with HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	blocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	key := make([]byte, 0, blocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= blocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		u = prf.Sum(u[:0])
+
+		t := make([]byte, hashLen)
+		copy(t, u)
+		for n := 1; n < iterations; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range t {
+				t[i] ^= u[i]
+			}
+		}
+		key = append(key, t...)
+	}
+
+	return key[:keyLen]
+}
@@ -0,0 +1,154 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+var (
+	encryptionKeyOld = bytes.Repeat([]byte{1}, 32)
+	encryptionKeyNew = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestTools_EncryptRoundTrip(t *testing.T) {
+	testTools := Tools{EncryptionKeys: [][]byte{encryptionKeyNew}}
+
+	for _, plaintext := range [][]byte{[]byte("alice@example.com"), {}, bytes.Repeat([]byte{0, 255}, 4096)} {
+		ciphertext, err := testTools.Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := base64.RawURLEncoding.DecodeString(ciphertext); err != nil {
+			t.Errorf("expected URL-safe base64, got %q", ciphertext)
+		}
+
+		got, err := testTools.Decrypt(ciphertext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("expected %q, got %q", plaintext, got)
+		}
+	}
+}
+
+func TestTools_EncryptNonceUniqueness(t *testing.T) {
+	testTools := Tools{EncryptionKeys: [][]byte{encryptionKeyNew}}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10000; i++ {
+		ciphertext, err := testTools.Encrypt([]byte("same plaintext"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, _ := base64.RawURLEncoding.DecodeString(ciphertext)
+		nonce := string(sealed[:12])
+		if seen[nonce] {
+			t.Fatalf("nonce repeated after %d encryptions", i)
+		}
+		seen[nonce] = true
+	}
+}
+
+func TestTools_DecryptRejected(t *testing.T) {
+	testTools := Tools{EncryptionKeys: [][]byte{encryptionKeyNew}}
+	otherTools := Tools{EncryptionKeys: [][]byte{encryptionKeyOld}}
+
+	ciphertext, err := testTools.Encrypt([]byte("alice@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, _ := base64.RawURLEncoding.DecodeString(ciphertext)
+	flipped := append([]byte{}, sealed...)
+	flipped[len(flipped)-1] ^= 1
+
+	var tests = []struct {
+		name       string
+		tools      Tools
+		ciphertext string
+	}{
+		{name: "wrong key", tools: otherTools, ciphertext: ciphertext},
+		{name: "truncated", tools: testTools, ciphertext: ciphertext[:len(ciphertext)-4]},
+		{name: "shorter than a nonce", tools: testTools, ciphertext: base64.RawURLEncoding.EncodeToString(sealed[:8])},
+		{name: "tampered", tools: testTools, ciphertext: base64.RawURLEncoding.EncodeToString(flipped)},
+		{name: "not base64", tools: testTools, ciphertext: "not base64!"},
+		{name: "empty", tools: testTools, ciphertext: ""},
+	}
+
+	for _, e := range tests {
+		if _, err := e.tools.Decrypt(e.ciphertext); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("%s: expected ErrDecryptionFailed, got %v", e.name, err)
+		}
+	}
+}
+
+func TestTools_EncryptKeyRotation(t *testing.T) {
+	oldTools := Tools{EncryptionKeys: [][]byte{encryptionKeyOld}}
+	rotated := Tools{EncryptionKeys: [][]byte{encryptionKeyNew, encryptionKeyOld}}
+	newOnly := Tools{EncryptionKeys: [][]byte{encryptionKeyNew}}
+
+	ciphertext, err := oldTools.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := rotated.Decrypt(ciphertext); err != nil || string(got) != "hello" {
+		t.Errorf("expected the old key to still decrypt during rotation, got %q, %v", got, err)
+	}
+
+	ciphertext, err = rotated.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newOnly.Decrypt(ciphertext); err != nil {
+		t.Errorf("expected new values to be encrypted with the first key, got %v", err)
+	}
+}
+
+func TestTools_EncryptNoKeys(t *testing.T) {
+	var testTools Tools
+	if _, err := testTools.Encrypt([]byte("x")); err == nil {
+		t.Error("expected an error from Encrypt without keys")
+	}
+	if _, err := testTools.Decrypt("x"); err == nil {
+		t.Error("expected an error from Decrypt without keys")
+	}
+}
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// test vector from RFC 7914, section 11
+	expected := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+
+	got := hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1, 64))
+	if got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestWithEncryptionPassphrase(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	testTools, err := New(WithEncryptionPassphrase("correct horse battery staple", salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ciphertext, err := testTools.Encrypt([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameKey := Tools{EncryptionKeys: [][]byte{DeriveEncryptionKey("correct horse battery staple", salt)}}
+	if got, err := sameKey.Decrypt(ciphertext); err != nil || string(got) != "hello" {
+		t.Errorf("expected the derived key to be deterministic, got %q, %v", got, err)
+	}
+
+	if _, err := New(WithEncryptionPassphrase("", salt)); err == nil {
+		t.Error("expected an error for an empty passphrase")
+	}
+	if _, err := New(WithEncryptionKeys([]byte("too short"))); err == nil {
+		t.Error("expected an error for a key that is not 32 bytes")
+	}
+}
@@ -0,0 +1,322 @@
+package toolkit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrEnvMissing is matched, via errors.Is, by the LoadEnv error for each required variable that is not set
+var ErrEnvMissing = errors.New("required environment variable is not set")
+
+// MultiError is several errors reported together, such as every problem LoadEnv found. errors.Is and errors.As
+// match it when they match any of its errors
+type MultiError []error
+
+// Error implements the error interface
+func (m MultiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors matches target
+func (m MultiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unwrap returns the errors, for errors.Is and errors.As from Go 1.20
+func (m MultiError) Unwrap() []error {
+	return m
+}
+
+// As finds the first of the errors that matches target
+func (m MultiError) As(target interface{}) bool {
+	for _, err := range m {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvOption configures a single call to LoadEnv
+type EnvOption func(*envConfig)
+
+type envConfig struct {
+	prefix string
+	lookup func(string) (string, bool)
+}
+
+// WithEnvPrefix puts prefix in front of every variable name, so that `env:"PORT"` reads APP_PORT with the
+// prefix "APP_"
+func WithEnvPrefix(prefix string) EnvOption {
+	return func(c *envConfig) { c.prefix = prefix }
+}
+
+// WithEnvLookup reads variables with lookup instead of os.LookupEnv
+func WithEnvLookup(lookup func(string) (string, bool)) EnvOption {
+	return func(c *envConfig) { c.lookup = lookup }
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadEnv sets the fields of the struct dst points to from the environment variables named in their `env` tags,
+// with `default` and `env:"NAME,required"` tags. Missing or bad variables are reported together in a MultiError
+func (t *Tools) LoadEnv(dst interface{}, opts ...EnvOption) error {
+	cfg := envConfig{lookup: os.LookupEnv}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("LoadEnv requires a pointer to a struct, got %T", dst)
+	}
+
+	var problems MultiError
+	if err := loadEnvStruct(rv.Elem(), cfg, &problems); err != nil {
+		return err
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// loadEnvStruct loads the fields of the struct rv, adding missing and invalid variables to problems
+func loadEnvStruct(rv reflect.Value, cfg envConfig, problems *MultiError) error {
+	typ := rv.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		fv := rv.Field(i)
+
+		tag, tagged := sf.Tag.Lookup("env")
+		if !tagged {
+			if fv.Kind() == reflect.Struct {
+				if err := loadEnvStruct(fv, cfg, problems); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		name, flags, _ := strings.Cut(tag, ",")
+		var required, bytes bool
+		for _, flag := range strings.Split(flags, ",") {
+			switch flag {
+			case "":
+			case "required":
+				required = true
+			case "bytes":
+				bytes = true
+			default:
+				return fmt.Errorf("field %s has unknown env option %q", sf.Name, flag)
+			}
+		}
+		if name == "" {
+			return fmt.Errorf("field %s has an env tag with no variable name", sf.Name)
+		}
+		name = cfg.prefix + name
+
+		value, _ := cfg.lookup(name)
+		if value == "" {
+			value = sf.Tag.Get("default")
+		}
+		if value == "" {
+			if required {
+				*problems = append(*problems, fmt.Errorf("%s: %w", name, ErrEnvMissing))
+			}
+			continue
+		}
+
+		if err := setEnvField(fv, value, bytes); err != nil {
+			if errors.Is(err, errUnsupportedEnvField) {
+				return fmt.Errorf("field %s: %w", sf.Name, err)
+			}
+			*problems = append(*problems, fmt.Errorf("%s: invalid value %q: %w", name, value, err))
+		}
+	}
+
+	return nil
+}
+
+var errUnsupportedEnvField = errors.New("unsupported field type for LoadEnv")
+
+// setEnvField parses value into fv according to its type
+func setEnvField(fv reflect.Value, value string, bytes bool) error {
+	if bytes {
+		switch fv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := ParseBytes(value)
+			if err != nil {
+				return err
+			}
+			if fv.OverflowInt(n) {
+				return errors.New("value out of range")
+			}
+			fv.SetInt(n)
+			return nil
+		}
+		return fmt.Errorf("%w: bytes requires an integer field, got %s", errUnsupportedEnvField, fv.Type())
+	}
+
+	if fv.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("%w: %s", errUnsupportedEnvField, fv.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		fv.Set(reflect.ValueOf(items).Convert(fv.Type()))
+
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedEnvField, fv.Type())
+	}
+
+	return nil
+}
+
+// LoadDotEnv sets environment variables from the KEY=value lines of a .env file, leaving those already set alone.
+// A missing file gets an error matching os.ErrNotExist
+func LoadDotEnv(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return fmt.Errorf("%s:%d: expected KEY=value", filename, lineNo)
+		}
+
+		value, err := parseDotEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", filename, lineNo, err)
+		}
+
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", filename, lineNo, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// parseDotEnvValue removes the quotes or trailing comment from a .env value
+func parseDotEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	switch value[0] {
+	case '\'':
+		end := strings.IndexByte(value[1:], '\'')
+		if end < 0 {
+			return "", errors.New("unterminated quoted value")
+		}
+		return value[1 : end+1], nil
+
+	case '"':
+		var b strings.Builder
+		for i := 1; i < len(value); i++ {
+			c := value[i]
+			switch {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				switch value[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(value[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated quoted value")
+	}
+
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
@@ -0,0 +1,187 @@
+package toolkit
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type envUploadConfig struct {
+	Dir         string `env:"UPLOAD_DIR" default:"./uploads"`
+	MaxFileSize int64  `env:"MAX_FILE_SIZE,bytes" default:"10MB"`
+}
+
+type envConfigTest struct {
+	Name     string        `env:"APP_NAME,required"`
+	Port     int           `env:"PORT" default:"8080"`
+	Workers  int64         `env:"WORKERS"`
+	Debug    bool          `env:"DEBUG"`
+	Ratio    float64       `env:"RATIO" default:"0.5"`
+	Timeout  time.Duration `env:"TIMEOUT" default:"5s"`
+	Hosts    []string      `env:"HOSTS"`
+	Upload   envUploadConfig
+	internal string
+	Ignored  string
+}
+
+func TestTools_LoadEnv(t *testing.T) {
+	var testTools Tools
+
+	t.Setenv("APP_NAME", "uploader")
+	t.Setenv("WORKERS", "12")
+	t.Setenv("DEBUG", "true")
+	t.Setenv("TIMEOUT", "1m30s")
+	t.Setenv("HOSTS", "a.example.com, b.example.com,,")
+	t.Setenv("MAX_FILE_SIZE", "1.5GB")
+	t.Setenv("PORT", "")
+
+	var cfg envConfigTest
+	if err := testTools.LoadEnv(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := envConfigTest{
+		Name:    "uploader",
+		Port:    8080,
+		Workers: 12,
+		Debug:   true,
+		Ratio:   0.5,
+		Timeout: 90 * time.Second,
+		Hosts:   []string{"a.example.com", "b.example.com"},
+		Upload:  envUploadConfig{Dir: "./uploads", MaxFileSize: 1536 * 1024 * 1024},
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %+v, got %+v", expected, cfg)
+	}
+}
+
+func TestTools_LoadEnvErrors(t *testing.T) {
+	var testTools Tools
+
+	var required struct {
+		A    string `env:"TOOLKIT_TEST_A,required"`
+		B    int    `env:"TOOLKIT_TEST_B,required"`
+		C    int    `env:"TOOLKIT_TEST_C"`
+		Size int    `env:"TOOLKIT_TEST_SIZE,bytes"`
+	}
+	t.Setenv("TOOLKIT_TEST_C", "lots")
+	t.Setenv("TOOLKIT_TEST_SIZE", "big")
+
+	err := testTools.LoadEnv(&required)
+	var multi MultiError
+	if !errors.As(err, &multi) {
+		t.Fatalf("expected a MultiError, got %v", err)
+	}
+	if len(multi) != 4 {
+		t.Errorf("expected all 4 problems to be reported together, got %d: %v", len(multi), err)
+	}
+	if !errors.Is(err, ErrEnvMissing) {
+		t.Error("expected the error to match ErrEnvMissing")
+	}
+	for _, name := range []string{"TOOLKIT_TEST_A", "TOOLKIT_TEST_B", "TOOLKIT_TEST_C", "TOOLKIT_TEST_SIZE"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("expected the error to name %s, got %q", name, err)
+		}
+	}
+
+	var badTests = []struct {
+		name string
+		dst  interface{}
+	}{
+		{name: "not a pointer", dst: envConfigTest{}},
+		{name: "nil pointer", dst: (*envConfigTest)(nil)},
+		{name: "unsupported type", dst: &struct {
+			M map[string]string `env:"TOOLKIT_TEST_C"`
+		}{}},
+		{name: "bytes on a string", dst: &struct {
+			S string `env:"TOOLKIT_TEST_SIZE,bytes"`
+		}{}},
+		{name: "unknown option", dst: &struct {
+			S string `env:"TOOLKIT_TEST_C,secret"`
+		}{}},
+	}
+
+	for _, e := range badTests {
+		err := testTools.LoadEnv(e.dst)
+		if err == nil || errors.As(err, &multi) {
+			t.Errorf("%s: expected a plain error, got %v", e.name, err)
+		}
+	}
+}
+
+func TestTools_LoadEnvOptions(t *testing.T) {
+	var testTools Tools
+
+	vars := map[string]string{"SVC_APP_NAME": "from lookup", "APP_NAME": "ignored"}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+
+	var cfg envConfigTest
+	if err := testTools.LoadEnv(&cfg, WithEnvPrefix("SVC_"), WithEnvLookup(lookup)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "from lookup" {
+		t.Errorf("expected the prefixed variable from the lookup, got %q", cfg.Name)
+	}
+}
+
+func TestLoadDotEnv(t *testing.T) {
+	content := `# settings
+export TOOLKIT_DOTENV_PLAIN=hello world # trailing comment
+TOOLKIT_DOTENV_DOUBLE="line one\nline \"two\""
+TOOLKIT_DOTENV_SINGLE='no $escapes\n # here'
+TOOLKIT_DOTENV_EMPTY=
+
+TOOLKIT_DOTENV_SET=from file
+TOOLKIT_DOTENV_HASH=a#b
+`
+	file := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"TOOLKIT_DOTENV_PLAIN", "TOOLKIT_DOTENV_DOUBLE", "TOOLKIT_DOTENV_SINGLE", "TOOLKIT_DOTENV_EMPTY", "TOOLKIT_DOTENV_HASH"} {
+		// register cleanup for the variables LoadDotEnv sets
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	t.Setenv("TOOLKIT_DOTENV_SET", "from environment")
+
+	if err := LoadDotEnv(file); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"TOOLKIT_DOTENV_PLAIN":  "hello world",
+		"TOOLKIT_DOTENV_DOUBLE": "line one\nline \"two\"",
+		"TOOLKIT_DOTENV_SINGLE": `no $escapes\n # here`,
+		"TOOLKIT_DOTENV_EMPTY":  "",
+		"TOOLKIT_DOTENV_SET":    "from environment",
+		"TOOLKIT_DOTENV_HASH":   "a#b",
+	}
+	for name, value := range expected {
+		got, ok := os.LookupEnv(name)
+		if !ok || got != value {
+			t.Errorf("%s: expected %q, got %q (set: %v)", name, value, got, ok)
+		}
+	}
+
+	if err := LoadDotEnv(filepath.Join(t.TempDir(), "missing.env")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected os.ErrNotExist for a missing file, got %v", err)
+	}
+
+	for _, bad := range []string{"NO_EQUALS_SIGN", "BAD KEY=x", `UNTERMINATED="oops`} {
+		if err := os.WriteFile(file, []byte(bad+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := LoadDotEnv(file); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var readJSONErrorTests = []struct {
+	name    string
+	json    string
+	maxSize int
+	kind    error
+	target  interface{}
+	is      error
+}{
+	{name: "syntax", json: `{"foo": }`, kind: ErrInvalidJSON, target: new(*json.SyntaxError)},
+	{name: "truncated", json: `{"foo": "bar"`, kind: ErrInvalidJSON, is: io.ErrUnexpectedEOF},
+	{name: "wrong type", json: `{"foo": 1}`, kind: ErrInvalidJSON, target: new(*json.UnmarshalTypeError)},
+	{name: "empty", json: ``, kind: ErrInvalidJSON, is: io.EOF},
+	{name: "unknown field", json: `{"bar": "baz"}`, kind: ErrInvalidJSON},
+	{name: "two values", json: `{"foo": "a"}{"foo": "b"}`, kind: ErrInvalidJSON},
+	{name: "too large", json: `{"foo": "` + strings.Repeat("a", 100) + `"}`, maxSize: 10, kind: ErrJSONTooLarge, target: new(*http.MaxBytesError)},
+}
+
+func TestTools_ReadJSONErrorChains(t *testing.T) {
+	for _, e := range readJSONErrorTests {
+		testTools := Tools{MaxJSONSize: e.maxSize}
+
+		var payload struct {
+			Foo string `json:"foo"`
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(e.json))
+		err := testTools.ReadJSON(httptest.NewRecorder(), req, &payload)
+
+		var jsonErr *JSONError
+		if !errors.As(err, &jsonErr) {
+			t.Errorf("%s: expected a *JSONError, got %v", e.name, err)
+			continue
+		}
+		if !errors.Is(err, e.kind) {
+			t.Errorf("%s: expected error to match %v", e.name, e.kind)
+		}
+		if e.target != nil && !errors.As(err, e.target) {
+			t.Errorf("%s: expected error to unwrap to %T", e.name, e.target)
+		}
+		if e.is != nil && !errors.Is(err, e.is) {
+			t.Errorf("%s: expected error to unwrap to %v", e.name, e.is)
+		}
+	}
+}
+
+func TestTools_UploadErrorChains(t *testing.T) {
+	var testTools Tools
+	img := readTestFile(t, "img.png")
+
+	// a directory where the upload should be renamed to stands in for a failing disk, since it fails even as root
+	uploadDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(uploadDir, "img.png"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	req := newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: img})
+	_, err := testTools.UploadFiles(req, uploadDir, WithRename(false))
+
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) {
+		t.Errorf("expected upload error to unwrap to *os.LinkError, got %v", err)
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected upload error to unwrap to fs.ErrExist, got %v", err)
+	}
+	if got := remainingFiles(t, uploadDir); len(got) != 0 {
+		t.Errorf("expected the temporary file to be removed, got %v", got)
+	}
+
+	// the upload directory can't be created beneath a regular file
+	blocker := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	req = newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: img})
+	_, err = testTools.UploadFiles(req, filepath.Join(blocker, "uploads"))
+	var pathErr *fs.PathError
+	if !errors.As(err, &pathErr) {
+		t.Errorf("expected directory error to unwrap to *fs.PathError, got %v", err)
+	}
+
+	testTools.AllowedFileTypes = []string{"image/jpeg"}
+	req = newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: img})
+	if _, err := testTools.UploadFiles(req, t.TempDir()); !errors.Is(err, ErrFileTypeNotPermitted) {
+		t.Errorf("expected ErrFileTypeNotPermitted, got %v", err)
+	}
+}
+
+func TestTools_PushJSONErrorChains(t *testing.T) {
+	var testTools Tools
+
+	_, _, err := testTools.PushJSONToRemote("http://example.com", make(chan int))
+	var unsupported *json.UnsupportedTypeError
+	if !errors.As(err, &unsupported) {
+		t.Errorf("expected encoding error to unwrap to *json.UnsupportedTypeError, got %v", err)
+	}
+
+	refused := errors.New("connection refused")
+	client := &http.Client{Transport: failingTransport{err: refused}}
+
+	_, _, err = testTools.PushJSONToRemote("http://example.com", "foo", client)
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		t.Errorf("expected transport error to unwrap to *url.Error, got %v", err)
+	}
+	if !errors.Is(err, refused) {
+		t.Errorf("expected transport error to unwrap to the transport's error, got %v", err)
+	}
+}
+
+func TestTools_SlugifyErrors(t *testing.T) {
+	var testTools Tools
+
+	if _, err := testTools.Slugify(""); !errors.Is(err, ErrEmptyString) {
+		t.Errorf("expected ErrEmptyString, got %v", err)
+	}
+	if _, err := testTools.Slugify("!!!"); !errors.Is(err, ErrEmptySlug) {
+		t.Errorf("expected ErrEmptySlug, got %v", err)
+	}
+}
+
+// failingTransport is a RoundTripper that always fails with err
+type failingTransport struct {
+	err error
+}
+
+func (f failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, f.err
+}
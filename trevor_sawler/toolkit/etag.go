@@ -0,0 +1,143 @@
+package toolkit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ETag is middleware that adds a strong ETag to 200 responses to GET and HEAD, and answers a matching If-None-Match
+// with 304. Responses over ETagMaxBufferSize, or flushed, are passed through
+func (t *Tools) ETag(next http.Handler) http.Handler {
+	limit := defaultETagMaxBufferSize
+	if t.ETagMaxBufferSize != 0 {
+		limit = t.ETagMaxBufferSize
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &etagWriter{w: w, limit: limit}
+		next.ServeHTTP(ew, r)
+
+		if ew.passthrough {
+			return
+		}
+		if r.Method == http.MethodHead && ew.buf.Len() == 0 {
+			// A handler that answers HEAD without a body, as http.ServeContent does, can't be hashed: the ETag of no
+			// body would not be the one GET gives, so the response is sent as it is, with its own Content-Length
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		sum := sha256.Sum256(ew.buf.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+		w.Header().Set("ETag", etag)
+
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			for _, h := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+				w.Header().Del(h)
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if w.Header().Get("Content-Length") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(ew.buf.Len()))
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(ew.buf.Bytes())
+	})
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the weak comparison that
+// RFC 9110 requires for If-None-Match
+func etagMatches(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagWriter buffers a 200 response so that it can be hashed, switching to writing straight through to the
+// underlying writer as soon as the response turns out to be unsuitable
+type etagWriter struct {
+	w     http.ResponseWriter
+	limit int
+
+	buf         bytes.Buffer
+	wroteHeader bool
+	passthrough bool
+}
+
+func (ew *etagWriter) Header() http.Header {
+	return ew.w.Header()
+}
+
+func (ew *etagWriter) WriteHeader(status int) {
+	if ew.wroteHeader {
+		return
+	}
+	ew.wroteHeader = true
+
+	if status != http.StatusOK || ew.w.Header().Get("ETag") != "" {
+		ew.passthrough = true
+		ew.w.WriteHeader(status)
+	}
+}
+
+func (ew *etagWriter) Write(b []byte) (int, error) {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+
+	if ew.passthrough {
+		return ew.w.Write(b)
+	}
+
+	if ew.buf.Len()+len(b) > ew.limit {
+		if err := ew.startPassthrough(); err != nil {
+			return 0, err
+		}
+		return ew.w.Write(b)
+	}
+
+	return ew.buf.Write(b)
+}
+
+// Flush implements http.Flusher. A handler that flushes is streaming, so buffering stops for good
+func (ew *etagWriter) Flush() {
+	if !ew.wroteHeader {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if !ew.passthrough {
+		if err := ew.startPassthrough(); err != nil {
+			return
+		}
+	}
+	if f, ok := ew.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// startPassthrough gives up on buffering, sending the status and whatever has been buffered so far
+func (ew *etagWriter) startPassthrough() error {
+	ew.passthrough = true
+	ew.w.WriteHeader(http.StatusOK)
+	_, err := ew.w.Write(ew.buf.Bytes())
+	ew.buf.Reset()
+	return err
+}
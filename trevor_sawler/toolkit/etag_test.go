@@ -0,0 +1,175 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_ETag(t *testing.T) {
+	testTools := Tools{ETagMaxBufferSize: 64}
+
+	body := "hello, etag"
+	h := testTools.ETag(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(body))
+	}))
+
+	// first request receives the body and an ETag
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 but got %d", rr.Code)
+	}
+	if rr.Body.String() != body {
+		t.Errorf("wrong body returned: %s", rr.Body.String())
+	}
+
+	etag := rr.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("expected a strong ETag but got %q", etag)
+	}
+
+	var conditionalTests = []struct {
+		name           string
+		method         string
+		ifNoneMatch    string
+		expectedStatus int
+	}{
+		{name: "matching", method: "GET", ifNoneMatch: etag, expectedStatus: http.StatusNotModified},
+		{name: "matching in list", method: "GET", ifNoneMatch: `"abc", ` + etag, expectedStatus: http.StatusNotModified},
+		{name: "weak form matches", method: "GET", ifNoneMatch: "W/" + etag, expectedStatus: http.StatusNotModified},
+		{name: "star", method: "GET", ifNoneMatch: "*", expectedStatus: http.StatusNotModified},
+		{name: "head matching", method: "HEAD", ifNoneMatch: etag, expectedStatus: http.StatusNotModified},
+		{name: "not matching", method: "GET", ifNoneMatch: `"abc"`, expectedStatus: http.StatusOK},
+	}
+
+	for _, e := range conditionalTests {
+		req := httptest.NewRequest(e.method, "/", nil)
+		req.Header.Set("If-None-Match", e.ifNoneMatch)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if rr.Header().Get("ETag") != etag {
+			t.Errorf("%s: expected ETag %s but got %s", e.name, etag, rr.Header().Get("ETag"))
+		}
+		if e.expectedStatus == http.StatusNotModified && rr.Body.Len() != 0 {
+			t.Errorf("%s: expected no body with 304", e.name)
+		}
+	}
+}
+
+var etagPassthroughTests = []struct {
+	name    string
+	method  string
+	handler http.HandlerFunc
+	status  int
+	body    string
+	etag    string
+}{
+	{
+		name:   "post",
+		method: "POST",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("created"))
+		},
+		status: http.StatusOK,
+		body:   "created",
+	},
+	{
+		name:   "too large",
+		method: "GET",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(strings.Repeat("a", 40)))
+			_, _ = w.Write([]byte(strings.Repeat("b", 40)))
+		},
+		status: http.StatusOK,
+		body:   strings.Repeat("a", 40) + strings.Repeat("b", 40),
+	},
+	{
+		name:   "not found",
+		method: "GET",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("missing"))
+		},
+		status: http.StatusNotFound,
+		body:   "missing",
+	},
+	{
+		name:   "existing etag",
+		method: "GET",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"mine"`)
+			_, _ = w.Write([]byte("mine"))
+		},
+		status: http.StatusOK,
+		body:   "mine",
+		etag:   `"mine"`,
+	},
+	{
+		name:   "streaming",
+		method: "GET",
+		handler: func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("part one;"))
+			w.(http.Flusher).Flush()
+			_, _ = w.Write([]byte("part two"))
+		},
+		status: http.StatusOK,
+		body:   "part one;part two",
+	},
+}
+
+func TestTools_ETagServeContent(t *testing.T) {
+	testTools := Tools{ETagMaxBufferSize: 64}
+	body := "hello, etag"
+	h := testTools.ETag(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "hello.txt", time.Time{}, strings.NewReader(body))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != body || rr.Header().Get("ETag") == "" {
+		t.Fatalf("expected GET to get the body with an ETag, got %d %q %q", rr.Code, rr.Body.String(), rr.Header().Get("ETag"))
+	}
+
+	// ServeContent writes no body for HEAD, so no ETag can be made, and its Content-Length is kept
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("HEAD", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 but got %d", rr.Code)
+	}
+	if etag := rr.Header().Get("ETag"); etag != "" {
+		t.Errorf("expected no ETag for a HEAD response without a body, got %s", etag)
+	}
+	if length := rr.Header().Get("Content-Length"); length != "11" {
+		t.Errorf("expected the Content-Length of the handler, 11, got %q", length)
+	}
+}
+
+func TestTools_ETagPassthrough(t *testing.T) {
+	testTools := Tools{ETagMaxBufferSize: 64}
+
+	for _, e := range etagPassthroughTests {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(e.method, "/", nil)
+		req.Header.Set("If-None-Match", "*")
+		testTools.ETag(e.handler).ServeHTTP(rr, req)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.status, rr.Code)
+		}
+		if rr.Body.String() != e.body {
+			t.Errorf("%s: wrong body returned: %s", e.name, rr.Body.String())
+		}
+		if rr.Header().Get("ETag") != e.etag {
+			t.Errorf("%s: expected ETag %q but got %q", e.name, e.etag, rr.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package toolkit
+
+import (
+	"errors"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// ErrExtensionMismatch is matched, via errors.Is, by the *ExtensionMismatchError returned by UploadFiles when
+// RejectExtensionMismatch is set and a file's content is not of the type its extension claims
+var ErrExtensionMismatch = errors.New("uploaded file extension does not match its content")
+
+// ExtensionMismatchError is returned by UploadFiles when RejectExtensionMismatch is set and the type detected from a
+// file's content is not one expected for its extension, such as an invoice.pdf that holds a PNG image
+type ExtensionMismatchError struct {
+	FileName    string
+	Extension   string
+	ContentType string
+}
+
+// Error implements the error interface
+func (e *ExtensionMismatchError) Error() string {
+	return englishMessage("upload.ext_mismatch", e.FileName, e.Extension, e.ContentType)
+}
+
+// Is reports whether target is ErrExtensionMismatch
+func (e *ExtensionMismatchError) Is(target error) bool {
+	return target == ErrExtensionMismatch
+}
+
+func (e *ExtensionMismatchError) messageKey() (string, []interface{}) {
+	return "upload.ext_mismatch", []interface{}{e.FileName, e.Extension, e.ContentType}
+}
+
+// extensionTypes maps the extensions checked by RejectExtensionMismatch to the types http.DetectContentType finds for
+// files that have them
+var extensionTypes = map[string][]string{
+	"png":  {"image/png"},
+	"jpg":  {"image/jpeg"},
+	"jpeg": {"image/jpeg"},
+	"gif":  {"image/gif"},
+	"webp": {"image/webp"},
+	"bmp":  {"image/bmp"},
+	"ico":  {"image/x-icon"},
+	"pdf":  {"application/pdf"},
+	"zip":  {"application/zip"},
+	"docx": {"application/zip"},
+	"xlsx": {"application/zip"},
+	"pptx": {"application/zip"},
+	"gz":   {"application/x-gzip"},
+	"rar":  {"application/x-rar-compressed"},
+	"mp3":  {"audio/mpeg"},
+	"wav":  {"audio/wave"},
+	"ogg":  {"application/ogg", "audio/ogg"},
+	"mp4":  {"video/mp4"},
+	"webm": {"video/webm"},
+	"txt":  {"text/plain"},
+	"csv":  {"text/plain"},
+	"json": {"text/plain"},
+	"md":   {"text/plain"},
+	"html": {"text/html"},
+	"htm":  {"text/html"},
+	"xml":  {"text/xml", "text/plain"},
+	"svg":  {"text/xml", "text/plain", "text/html", svgContentType},
+}
+
+// checkExtensionMatches returns an *ExtensionMismatchError when RejectExtensionMismatch is set and fileType
+// conflicts with the extension of filename
+func (t *Tools) checkExtensionMatches(filename, fileType string) error {
+	if !t.RejectExtensionMismatch {
+		return nil
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	expected, ok := extensionTypes[ext]
+	if !ok {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(fileType)
+	if err != nil {
+		mediaType = fileType
+	}
+	if mediaType == "application/octet-stream" {
+		return nil
+	}
+	for _, x := range expected {
+		if mediaType == x {
+			return nil
+		}
+	}
+	return &ExtensionMismatchError{FileName: filename, Extension: ext, ContentType: mediaType}
+}
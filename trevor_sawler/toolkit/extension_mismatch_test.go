@@ -0,0 +1,68 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestTools_UploadFilesExtensionMismatch(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	pic := readTestFile(t, "pic.jpg")
+
+	var tests = []struct {
+		name     string
+		reject   bool
+		filename string
+		content  []byte
+		expected bool
+	}{
+		{name: "matching png", reject: true, filename: "img.png", content: img},
+		{name: "upper case extension", reject: true, filename: "PIC.JPG", content: pic},
+		{name: "png as pdf", reject: true, filename: "invoice.pdf", content: img, expected: true},
+		{name: "png as jpeg", reject: true, filename: "photo.jpg", content: img, expected: true},
+		{name: "text as png", reject: true, filename: "img.png", content: []byte("just some text"), expected: true},
+		{name: "json", reject: true, filename: "data.json", content: []byte(`{"a": 1}`)},
+		{name: "unknown extension", reject: true, filename: "img.dat", content: img},
+		{name: "octet stream", reject: true, filename: "report.pdf", content: []byte{0x00, 0x01, 0x02, 0x03, 0xfe}},
+		{name: "not rejected", filename: "invoice.pdf", content: img},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			testTools := Tools{RejectExtensionMismatch: e.reject}
+			dir := t.TempDir()
+			req := newUploadRequest(t, testUpload{field: "file", filename: e.filename, content: e.content})
+			_, err := upload(&testTools, req, dir)
+
+			if !e.expected {
+				if err != nil {
+					t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				}
+				continue
+			}
+
+			var mismatch *ExtensionMismatchError
+			if !errors.As(err, &mismatch) || !errors.Is(err, ErrExtensionMismatch) {
+				t.Errorf("%s, %s: expected an *ExtensionMismatchError, got %v", e.name, name, err)
+				continue
+			}
+			if mismatch.FileName != e.filename {
+				t.Errorf("%s, %s: expected the error to name %s, got %s", e.name, name, e.filename, mismatch.FileName)
+			}
+			if names := remainingFiles(t, dir); names != nil {
+				t.Errorf("%s, %s: expected nothing to be saved, got %v", e.name, name, names)
+			}
+		}
+	}
+}
+
+func TestExtensionMismatchError(t *testing.T) {
+	err := error(&ExtensionMismatchError{FileName: "invoice.pdf", Extension: "pdf", ContentType: "image/png"})
+	if err.Error() != `the uploaded file "invoice.pdf" has the extension "pdf", but its content is image/png` {
+		t.Errorf("unexpected message %q", err)
+	}
+	if status := uploadErrorStatus(err); status != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status 415, got %d", status)
+	}
+}
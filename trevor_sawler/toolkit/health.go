@@ -0,0 +1,207 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthCheck is a single named dependency check used by HealthHandler. Check should return nil
+// when the dependency is healthy, and should give up when ctx is done
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthCheckResult is the outcome of running one HealthCheck
+type HealthCheckResult struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Latency string `json:"latency"`
+}
+
+// HealthReport is the JSON body written by HealthHandler
+type HealthReport struct {
+	Status    string              `json:"status"`
+	CheckedAt time.Time           `json:"checked_at"`
+	Checks    []HealthCheckResult `json:"checks"`
+}
+
+// healthHandler holds the checks for one handler, along with the most recently cached report
+type healthHandler struct {
+	tools   *Tools
+	checks  []HealthCheck
+	timeout time.Duration
+	ttl     time.Duration
+
+	mu      sync.Mutex
+	report  HealthReport
+	healthy bool
+	expires time.Time
+}
+
+// HealthHandler returns a handler for /healthz and /readyz that runs checks concurrently and responds 200, or 503 if
+// any failed, with a JSON report. Reports are cached for HealthCacheTTL
+func (t *Tools) HealthHandler(checks ...HealthCheck) http.Handler {
+	timeout := defaultHealthCheckTimeout
+	if t.HealthCheckTimeout != 0 {
+		timeout = t.HealthCheckTimeout
+	}
+
+	ttl := defaultHealthCacheTTL
+	if t.HealthCacheTTL != 0 {
+		ttl = t.HealthCacheTTL
+	}
+
+	return &healthHandler{
+		tools:   t,
+		checks:  checks,
+		timeout: timeout,
+		ttl:     ttl,
+	}
+}
+
+func (h *healthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report, healthy := h.run()
+
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "no-store")
+
+	_ = h.tools.WriteJSON(w, status, report, headers)
+}
+
+// run returns the cached report if it is still fresh, otherwise it runs all checks, holding the lock so that
+// concurrent probes wait for one run, and caches the result
+func (h *healthHandler) run() (HealthReport, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(h.expires) {
+		return h.report, h.healthy
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	results := make([]HealthCheckResult, len(h.checks))
+
+	var wg sync.WaitGroup
+	for i, c := range h.checks {
+		wg.Add(1)
+		go func(i int, c HealthCheck) {
+			defer wg.Done()
+			results[i] = runHealthCheck(ctx, c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	healthy := true
+	for _, res := range results {
+		if res.Status != "ok" {
+			healthy = false
+		}
+	}
+
+	report := HealthReport{
+		Status:    "ok",
+		CheckedAt: now.UTC(),
+		Checks:    results,
+	}
+	if !healthy {
+		report.Status = "unavailable"
+	}
+
+	h.report, h.healthy, h.expires = report, healthy, now.Add(h.ttl)
+
+	return report, healthy
+}
+
+// runHealthCheck runs a single check, treating a panic or a check that ignores the deadline as a failure
+func runHealthCheck(ctx context.Context, c HealthCheck) HealthCheckResult {
+	start := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		defer func() {
+			if rec := recover(); rec != nil {
+				done <- fmt.Errorf("check panicked: %v", rec)
+			}
+		}()
+		done <- c.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := HealthCheckResult{
+		Name:    c.Name,
+		Status:  "ok",
+		Latency: time.Since(start).String(),
+	}
+	if err != nil {
+		res.Status = "fail"
+		res.Error = err.Error()
+	}
+
+	return res
+}
+
+// DiskSpaceCheck returns a HealthCheck that fails when the file system holding dir has fewer than
+// minFree bytes available
+func (t *Tools) DiskSpaceCheck(dir string, minFree uint64) HealthCheck {
+	return HealthCheck{
+		Name: "disk:" + dir,
+		Check: func(ctx context.Context) error {
+			free, err := diskFree(dir)
+			if errors.Is(err, errDiskFreeUnsupported) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if free < minFree {
+				return fmt.Errorf("only %d bytes free, need at least %d", free, minFree)
+			}
+			return nil
+		},
+	}
+}
+
+// URLCheck returns a HealthCheck that issues a GET request to uri using the toolkit's HTTP client, and
+// fails if the request errors or the response status is not 2xx
+func (t *Tools) URLCheck(name, uri string) HealthCheck {
+	return HealthCheck{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			request, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+			if err != nil {
+				return err
+			}
+
+			response, err := t.httpClient().Do(request)
+			if err != nil {
+				return err
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode < 200 || response.StatusCode > 299 {
+				return fmt.Errorf("unexpected status code %d", response.StatusCode)
+			}
+			return nil
+		},
+	}
+}
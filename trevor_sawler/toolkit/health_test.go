@@ -0,0 +1,189 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var healthTests = []struct {
+	name           string
+	checks         []HealthCheck
+	expectedStatus int
+	expectedChecks int
+}{
+	{
+		name:           "no checks",
+		checks:         nil,
+		expectedStatus: http.StatusOK,
+		expectedChecks: 0,
+	},
+	{
+		name: "all passing",
+		checks: []HealthCheck{
+			{Name: "one", Check: func(ctx context.Context) error { return nil }},
+			{Name: "two", Check: func(ctx context.Context) error { return nil }},
+		},
+		expectedStatus: http.StatusOK,
+		expectedChecks: 2,
+	},
+	{
+		name: "one failing",
+		checks: []HealthCheck{
+			{Name: "one", Check: func(ctx context.Context) error { return nil }},
+			{Name: "two", Check: func(ctx context.Context) error { return errors.New("down") }},
+		},
+		expectedStatus: http.StatusServiceUnavailable,
+		expectedChecks: 2,
+	},
+	{
+		name: "panicking check",
+		checks: []HealthCheck{
+			{Name: "one", Check: func(ctx context.Context) error { panic("boom") }},
+		},
+		expectedStatus: http.StatusServiceUnavailable,
+		expectedChecks: 1,
+	},
+	{
+		name: "check ignores deadline",
+		checks: []HealthCheck{
+			{Name: "slow", Check: func(ctx context.Context) error { time.Sleep(time.Second); return nil }},
+		},
+		expectedStatus: http.StatusServiceUnavailable,
+		expectedChecks: 1,
+	},
+}
+
+func TestTools_HealthHandler(t *testing.T) {
+	testTools := Tools{HealthCheckTimeout: 50 * time.Millisecond}
+
+	for _, e := range healthTests {
+		h := testTools.HealthHandler(e.checks...)
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/healthz", nil)
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		var report HealthReport
+		if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+			t.Errorf("%s: could not decode report: %s", e.name, err)
+			continue
+		}
+
+		if len(report.Checks) != e.expectedChecks {
+			t.Errorf("%s: expected %d checks in report but got %d", e.name, e.expectedChecks, len(report.Checks))
+		}
+
+		for _, c := range report.Checks {
+			if c.Latency == "" {
+				t.Errorf("%s: no latency reported for %s", e.name, c.Name)
+			}
+		}
+	}
+}
+
+func TestTools_HealthHandlerCache(t *testing.T) {
+	testTools := Tools{HealthCacheTTL: time.Hour}
+
+	var calls int32
+	h := testTools.HealthHandler(HealthCheck{
+		Name: "counter",
+		Check: func(ctx context.Context) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		},
+	})
+
+	for i := 0; i < 5; i++ {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected 200 but got %d", rr.Code)
+		}
+	}
+
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected check to be run once, but it was run %d times", calls)
+	}
+}
+
+func TestTools_DiskSpaceCheck(t *testing.T) {
+	var testTools Tools
+
+	ok := testTools.DiskSpaceCheck("./testdata", 1)
+	if err := ok.Check(context.Background()); err != nil {
+		t.Error("expected disk space check to pass:", err)
+	}
+
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" && runtime.GOOS != "freebsd" {
+		return
+	}
+
+	tooMuch := testTools.DiskSpaceCheck("./testdata", 1<<62)
+	if err := tooMuch.Check(context.Background()); err == nil {
+		t.Error("expected disk space check to fail")
+	}
+
+	missing := testTools.DiskSpaceCheck("./testdata/does-not-exist", 1)
+	if err := missing.Check(context.Background()); err == nil {
+		t.Error("expected disk space check on missing directory to fail")
+	}
+}
+
+func TestTools_URLCheck(t *testing.T) {
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		status := status
+		testTools := Tools{
+			HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+				return &http.Response{
+					StatusCode: status,
+					Body:       io.NopCloser(bytes.NewBufferString("ok")),
+					Header:     make(http.Header),
+				}
+			}),
+		}
+
+		err := testTools.URLCheck("remote", "http://example.com/ping").Check(context.Background())
+		if status == http.StatusOK && err != nil {
+			t.Error("expected url check to pass:", err)
+		}
+		if status != http.StatusOK && err == nil {
+			t.Error("expected url check to fail for status", status)
+		}
+	}
+}
+
+func TestTools_HealthHandlerCanceledProbe(t *testing.T) {
+	testTools := Tools{HealthCacheTTL: time.Hour}
+
+	h := testTools.HealthHandler(HealthCheck{
+		Name: "ctx",
+		Check: func(ctx context.Context) error {
+			return ctx.Err()
+		},
+	})
+
+	// the first probe has already disconnected, which must not fail the checks cached for the others
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil).WithContext(ctx))
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200 but got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,126 @@
+package toolkit
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// testUpload is a single file to be posted by newUploadRequest, or a text field when it has no filename
+type testUpload struct {
+	field    string
+	filename string
+	content  []byte
+}
+
+// newUploadBody encodes the given files and fields, in order, as a multipart form, and returns it with its content type
+func newUploadBody(t testing.TB, uploads ...testUpload) (*bytes.Buffer, string) {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for _, u := range uploads {
+		if u.filename == "" {
+			if err := writer.WriteField(u.field, string(u.content)); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		part, err := writer.CreateFormFile(u.field, u.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(u.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return body, writer.FormDataContentType()
+}
+
+// newUploadRequest builds a multipart POST request containing the given files and fields, in order
+func newUploadRequest(t testing.TB, uploads ...testUpload) *http.Request {
+	t.Helper()
+
+	body, contentType := newUploadBody(t, uploads...)
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+// encodedDisposition returns the Content-Disposition of a file whose name is sent RFC 2231 encoded
+func encodedDisposition(filename string) string {
+	return `form-data; name="file"; filename*=UTF-8''` + url.PathEscape(filename)
+}
+
+// encodedNameRequest builds an upload request for one file, whose part has the Content-Disposition disposition
+func encodedNameRequest(t *testing.T, disposition string, content []byte) *http.Request {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", disposition)
+	part, err := writer.CreatePart(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// readTestFile returns the contents of a file in testdata
+func readTestFile(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("./testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// remainingFiles lists the files left under dir, relative to it
+func remainingFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var names []string
+	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names
+}
+
+// uploadFunc is uploadFilesRename or StreamUploadFiles
+type uploadFunc func(t *Tools, r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error)
+
+// uploadFilesRename calls UploadFiles with WithRename for a rename flag
+func uploadFilesRename(t *Tools, r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
+	var opts []UploadOption
+	if len(rename) > 0 {
+		opts = append(opts, WithRename(rename[0]))
+	}
+	return t.UploadFiles(r, uploadDir, opts...)
+}
+
+// uploadFuncs are the two ways of uploading a multipart form, which tests of their shared checks run for both
+var uploadFuncs = map[string]uploadFunc{"UploadFiles": uploadFilesRename, "StreamUploadFiles": (*Tools).StreamUploadFiles}
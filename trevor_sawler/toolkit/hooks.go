@@ -0,0 +1,73 @@
+package toolkit
+
+import (
+	"context"
+	"fmt"
+)
+
+// HookError is returned when BeforeSave or AfterSave, named by Hook, fails for a file
+type HookError struct {
+	Hook     string
+	FileName string
+	Err      error
+}
+
+// Error implements the error interface
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook failed for uploaded file %q: %s", e.Hook, e.FileName, e.Err)
+}
+
+// Unwrap returns the error the hook returned
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// beforeSave calls BeforeSave, when it is set, for uploadedFile, which is about to be saved, and returns its error as
+// a *HookError
+func (t *Tools) beforeSave(ctx context.Context, uploadedFile *UploadedFile) error {
+	if t.BeforeSave == nil {
+		return nil
+	}
+	if err := t.BeforeSave(ctx, uploadedFile); err != nil {
+		return &HookError{Hook: "BeforeSave", FileName: uploadedFile.OriginalFileName, Err: err}
+	}
+	return nil
+}
+
+// afterSaveAll calls AfterSave, in order, for each file the upload wrote, once all of it is saved. A file it fails
+// for is removed, and with ContinueOnError its *HookError is added to err; otherwise the upload is undone
+func (t *Tools) afterSaveAll(ctx context.Context, store FileStore, uploadedFiles []*UploadedFile, err error) ([]*UploadedFile, error) {
+	if t.AfterSave == nil {
+		return uploadedFiles, err
+	}
+
+	failures, _ := err.(MultiError)
+	var hookErrs MultiError
+	var kept []*UploadedFile
+	for _, f := range uploadedFiles {
+		if !f.Deduplicated {
+			if hookErr := t.AfterSave(ctx, f); hookErr != nil {
+				removeUploadedFile(store, f)
+				hookErr = &HookError{Hook: "AfterSave", FileName: f.OriginalFileName, Err: hookErr}
+				if t.ContinueOnError {
+					failures = append(failures, &UploadFileError{FileName: f.OriginalFileName, Err: hookErr})
+				} else {
+					hookErrs = append(hookErrs, hookErr)
+				}
+				continue
+			}
+		}
+		kept = append(kept, f)
+	}
+
+	switch {
+	case len(hookErrs) == 1:
+		return t.undoUpload(store, kept, hookErrs[0])
+	case len(hookErrs) > 1:
+		return t.undoUpload(store, kept, hookErrs)
+	case len(failures) > 0:
+		return kept, failures
+	default:
+		return kept, nil
+	}
+}
@@ -0,0 +1,93 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestTools_UploadFilesSaveHooks(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	errQuota := errors.New("quota exceeded")
+	errQueue := errors.New("queue is down")
+
+	var tests = []struct {
+		name      string
+		rejectIn  string
+		reject    string
+		keepGoing bool
+		saved     []string
+		after     []string
+		err       error
+	}{
+		{name: "both pass", saved: []string{"a.png", "b.png"}, after: []string{"a.png", "b.png"}},
+		// AfterSave is only called once the whole upload has been saved, so never for a.png, which is removed
+		{name: "before save fails", rejectIn: "BeforeSave", reject: "b.png", err: errQuota},
+		// without ContinueOnError, a.png is removed along with b.png, as for any other failure
+		{name: "after save fails", rejectIn: "AfterSave", reject: "b.png", after: []string{"a.png", "b.png"}, err: errQueue},
+		{name: "continue on error", rejectIn: "BeforeSave", reject: "b.png", keepGoing: true, saved: []string{"a.png"}, after: []string{"a.png"}, err: errQuota},
+		{name: "continue after save fails", rejectIn: "AfterSave", reject: "b.png", keepGoing: true, saved: []string{"a.png"}, after: []string{"a.png", "b.png"}, err: errQueue},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			var before, after []string
+			testTools := Tools{ContinueOnError: e.keepGoing}
+			testTools.BeforeSave = func(ctx context.Context, f *UploadedFile) error {
+				before = append(before, f.OriginalFileName)
+				if f.NewFileName != f.OriginalFileName || f.ContentType != "image/png" {
+					t.Errorf("%s, %s: expected BeforeSave to see the name and type, got %+v", e.name, name, f)
+				}
+				if _, err := os.Stat(f.SavedPath); !os.IsNotExist(err) {
+					t.Errorf("%s, %s: expected BeforeSave to be called before %s is written, got %v", e.name, name, f.OriginalFileName, err)
+				}
+				if e.rejectIn == "BeforeSave" && f.OriginalFileName == e.reject {
+					return errQuota
+				}
+				return nil
+			}
+			testTools.AfterSave = func(ctx context.Context, f *UploadedFile) error {
+				after = append(after, f.OriginalFileName)
+				if info, err := os.Stat(f.SavedPath); err != nil || info.Size() != int64(len(png)) || f.FileSize != info.Size() {
+					t.Errorf("%s, %s: expected AfterSave to be called once %s is saved, got %v", e.name, name, f.OriginalFileName, err)
+				}
+				if e.rejectIn == "AfterSave" && f.OriginalFileName == e.reject {
+					return errQueue
+				}
+				return nil
+			}
+
+			dir := t.TempDir()
+			files, err := upload(&testTools, newUploadRequest(t, testUpload{"file", "a.png", png}, testUpload{"file", "b.png", png}), dir, false)
+			if e.err == nil && err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+			if e.err != nil {
+				var hookErr *HookError
+				if !errors.As(err, &hookErr) || !errors.Is(err, e.err) || hookErr.Hook != e.rejectIn || hookErr.FileName != e.reject {
+					t.Errorf("%s, %s: expected a %s HookError for %s, got %v", e.name, name, e.rejectIn, e.reject, err)
+				}
+			}
+
+			var saved []string
+			for _, f := range files {
+				saved = append(saved, f.NewFileName)
+			}
+			sort.Strings(saved)
+			if !reflect.DeepEqual(saved, e.saved) || !reflect.DeepEqual(remainingFiles(t, dir), e.saved) {
+				t.Errorf("%s, %s: expected %v to be saved, got %v and %v on disk", e.name, name, e.saved, saved, remainingFiles(t, dir))
+			}
+			sort.Strings(after)
+			if !reflect.DeepEqual(after, e.after) {
+				t.Errorf("%s, %s: expected AfterSave to be called for %v, got %v", e.name, name, e.after, after)
+			}
+			if len(before) == 0 {
+				t.Errorf("%s, %s: expected BeforeSave to be called", e.name, name)
+			}
+		}
+	}
+}
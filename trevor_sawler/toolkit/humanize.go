@@ -0,0 +1,213 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// day is the length of a day, ignoring daylight saving changes
+const day = 24 * time.Hour
+
+// translateFunc returns the text for a message key, or "" when there is none
+type translateFunc func(key string, args ...interface{}) string
+
+// TimeAgo describes tm relative to now, which defaults to the current time, such as "5 minutes ago" or "in 2 days"
+func (t *Tools) TimeAgo(tm time.Time, now ...time.Time) string {
+	return timeAgo(englishMessage, tm, pickNow(now))
+}
+
+// TimeAgoFor is like TimeAgo, but in the language the Accept-Language header of r prefers
+func (t *Tools) TimeAgoFor(r *http.Request, tm time.Time, now ...time.Time) string {
+	at := pickNow(now)
+	for _, tr := range t.translators(r) {
+		if s := timeAgo(tr, tm, at); s != "" {
+			return s
+		}
+	}
+	return timeAgo(englishMessage, tm, at)
+}
+
+// HumanDuration describes d by its two largest units, such as "2h 15m" or "1m 30s"
+func (t *Tools) HumanDuration(d time.Duration) string {
+	return humanDuration(englishMessage, d)
+}
+
+// HumanDurationFor is like HumanDuration, but in the language the client prefers, according to the Accept-Language
+// header of r. The units are built from the "duration.*" message keys
+func (t *Tools) HumanDurationFor(r *http.Request, d time.Duration) string {
+	for _, tr := range t.translators(r) {
+		if s := humanDuration(tr, d); s != "" {
+			return s
+		}
+	}
+	return humanDuration(englishMessage, d)
+}
+
+// HumanTime is a time that is sent in JSON as both its RFC 3339 value and a humanized form, for example
+// {"time": "2024-05-01T12:00:00Z", "human": "5 minutes ago"}
+type HumanTime struct {
+	Time  time.Time
+	Human string
+}
+
+type humanTimeJSON struct {
+	Time  time.Time `json:"time"`
+	Human string    `json:"human"`
+}
+
+// HumanizeTime returns tm as a HumanTime described relative to the current time, in the language the client
+// prefers. r may be nil, for English
+func (t *Tools) HumanizeTime(r *http.Request, tm time.Time) HumanTime {
+	return HumanTime{Time: tm, Human: t.TimeAgoFor(r, tm)}
+}
+
+// MarshalJSON implements json.Marshaler
+func (h HumanTime) MarshalJSON() ([]byte, error) {
+	human := h.Human
+	if human == "" {
+		human = timeAgo(englishMessage, h.Time, time.Now())
+	}
+	return json.Marshal(humanTimeJSON{Time: h.Time, Human: human})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (h *HumanTime) UnmarshalJSON(data []byte) error {
+	var v humanTimeJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	h.Time, h.Human = v.Time, v.Human
+	return nil
+}
+
+// translators returns a translateFunc for each of the client's preferred languages, or none when there is no
+// Translator
+func (t *Tools) translators(r *http.Request) []translateFunc {
+	if t.Translator == nil || r == nil {
+		return nil
+	}
+
+	var funcs []translateFunc
+	for _, lang := range AcceptedLanguages(r) {
+		lang := lang
+		funcs = append(funcs, func(key string, args ...interface{}) string {
+			return t.Translator.Translate(lang, key, args...)
+		})
+	}
+	return funcs
+}
+
+// pickNow returns the optional now argument, or the current time
+func pickNow(now []time.Time) time.Time {
+	if len(now) > 0 {
+		return now[0]
+	}
+	return time.Now()
+}
+
+// timeAgo builds the relative description of tm with tr, or returns "" if tr is missing any of the messages
+func timeAgo(tr translateFunc, tm, now time.Time) string {
+	d := now.Sub(tm)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	var unit string
+	var n int64
+	switch {
+	case d < time.Second:
+		return tr("time.just_now")
+	case d < time.Minute:
+		unit, n = "second", int64(d/time.Second)
+	case d < time.Hour:
+		unit, n = "minute", int64(d/time.Minute)
+	case d < day:
+		unit, n = "hour", int64(d/time.Hour)
+	default:
+		days := int64(d / day)
+		switch {
+		case days < 30:
+			unit, n = "day", days
+		case days < 365:
+			unit, n = "month", days/30
+		default:
+			unit, n = "year", days/365
+		}
+	}
+
+	key := "time." + unit
+	if n != 1 {
+		key += "s"
+	}
+	amount := tr(key, n)
+	if amount == "" {
+		return ""
+	}
+
+	if future {
+		return tr("time.from_now", amount)
+	}
+	return tr("time.ago", amount)
+}
+
+// durationUnits are the units used by HumanDuration, largest first
+var durationUnits = []struct {
+	key  string
+	size time.Duration
+}{
+	{"duration.years", 365 * day},
+	{"duration.days", day},
+	{"duration.hours", time.Hour},
+	{"duration.minutes", time.Minute},
+	{"duration.seconds", time.Second},
+}
+
+// humanDuration builds the description of d with tr, or returns "" if tr is missing any of the messages
+func humanDuration(tr translateFunc, d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign = "-"
+		if d == math.MinInt64 {
+			d = math.MaxInt64
+		} else {
+			d = -d
+		}
+	}
+
+	if d < time.Second {
+		if d == 0 {
+			return tr("duration.seconds", int64(0))
+		}
+		ms := tr("duration.milliseconds", int64(d/time.Millisecond))
+		if ms == "" {
+			return ""
+		}
+		return sign + ms
+	}
+
+	var parts []string
+	for i, u := range durationUnits {
+		if d < u.size {
+			continue
+		}
+		parts = append(parts, tr(u.key, int64(d/u.size)))
+		if i+1 < len(durationUnits) {
+			next := durationUnits[i+1]
+			if n := int64(d % u.size / next.size); n > 0 {
+				parts = append(parts, tr(next.key, n))
+			}
+		}
+		break
+	}
+
+	for _, p := range parts {
+		if p == "" {
+			return ""
+		}
+	}
+	return sign + strings.Join(parts, " ")
+}
@@ -0,0 +1,188 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// catalogTranslator translates from a table of format strings per language
+type catalogTranslator map[string]map[string]string
+
+func (c catalogTranslator) Translate(lang, key string, args ...interface{}) string {
+	format, ok := c[lang][key]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+var germanTime = map[string]string{
+	"time.just_now":    "gerade eben",
+	"time.ago":         "vor %s",
+	"time.from_now":    "in %s",
+	"time.second":      "%d Sekunde",
+	"time.seconds":     "%d Sekunden",
+	"time.minute":      "%d Minute",
+	"time.minutes":     "%d Minuten",
+	"time.hour":        "%d Stunde",
+	"time.hours":       "%d Stunden",
+	"time.day":         "%d Tag",
+	"time.days":        "%d Tagen",
+	"duration.days":    "%d T.",
+	"duration.hours":   "%d Std.",
+	"duration.minutes": "%d Min.",
+	"duration.seconds": "%d Sek.",
+}
+
+var timeAgoTests = []struct {
+	name     string
+	offset   time.Duration
+	expected string
+}{
+	{name: "now", offset: 0, expected: "just now"},
+	{name: "under a second", offset: 999 * time.Millisecond, expected: "just now"},
+	{name: "one second", offset: time.Second, expected: "1 second ago"},
+	{name: "59 seconds", offset: 59 * time.Second, expected: "59 seconds ago"},
+	{name: "61 seconds", offset: 61 * time.Second, expected: "1 minute ago"},
+	{name: "59 minutes", offset: 59*time.Minute + 59*time.Second, expected: "59 minutes ago"},
+	{name: "one hour", offset: time.Hour, expected: "1 hour ago"},
+	{name: "23 hours", offset: 23 * time.Hour, expected: "23 hours ago"},
+	{name: "25 hours", offset: 25 * time.Hour, expected: "1 day ago"},
+	{name: "29 days", offset: 29 * day, expected: "29 days ago"},
+	{name: "30 days", offset: 30 * day, expected: "1 month ago"},
+	{name: "364 days", offset: 364 * day, expected: "12 months ago"},
+	{name: "365 days", offset: 365 * day, expected: "1 year ago"},
+	{name: "three years", offset: 3 * 365 * day, expected: "3 years ago"},
+	{name: "future", offset: -5 * time.Minute, expected: "in 5 minutes"},
+	{name: "future day", offset: -25 * time.Hour, expected: "in 1 day"},
+}
+
+func TestTools_TimeAgo(t *testing.T) {
+	var testTools Tools
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, e := range timeAgoTests {
+		if got := testTools.TimeAgo(now.Add(-e.offset), now); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+
+	if got := testTools.TimeAgo(time.Now().Add(-2 * time.Hour)); got != "2 hours ago" {
+		t.Errorf("expected now to default to the current time, got %q", got)
+	}
+}
+
+var humanDurationTests = []struct {
+	name     string
+	d        time.Duration
+	expected string
+}{
+	{name: "zero", d: 0, expected: "0s"},
+	{name: "milliseconds", d: 450 * time.Millisecond, expected: "450ms"},
+	{name: "seconds", d: 59 * time.Second, expected: "59s"},
+	{name: "minute and seconds", d: 90 * time.Second, expected: "1m 30s"},
+	{name: "whole hour", d: time.Hour + 5*time.Second, expected: "1h"},
+	{name: "hours and minutes", d: 2*time.Hour + 15*time.Minute + 10*time.Second, expected: "2h 15m"},
+	{name: "23 hours", d: 23 * time.Hour, expected: "23h"},
+	{name: "25 hours", d: 25 * time.Hour, expected: "1d 1h"},
+	{name: "years", d: 400 * day, expected: "1y 35d"},
+	{name: "negative", d: -90 * time.Second, expected: "-1m 30s"},
+	{name: "negative milliseconds", d: -5 * time.Millisecond, expected: "-5ms"},
+	{name: "minimum", d: math.MinInt64, expected: "-292y 171d"},
+}
+
+func TestTools_HumanDuration(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range humanDurationTests {
+		if got := testTools.HumanDuration(e.d); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTools_HumanizeLocales(t *testing.T) {
+	testTools := Tools{Translator: catalogTranslator{"de": germanTime}}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name     string
+		lang     string
+		offset   time.Duration
+		expected string
+	}{
+		{name: "german seconds", lang: "de", offset: 59 * time.Second, expected: "vor 59 Sekunden"},
+		{name: "german minute", lang: "de", offset: 61 * time.Second, expected: "vor 1 Minute"},
+		{name: "german hours", lang: "de", offset: 23 * time.Hour, expected: "vor 23 Stunden"},
+		{name: "german day", lang: "de", offset: 25 * time.Hour, expected: "vor 1 Tag"},
+		{name: "german future", lang: "de", offset: -5 * time.Minute, expected: "in 5 Minuten"},
+		{name: "german just now", lang: "de", offset: 0, expected: "gerade eben"},
+		{name: "untranslated unit falls back to english", lang: "de", offset: 60 * day, expected: "2 months ago"},
+		{name: "second preference", lang: "fr, de;q=0.5", offset: time.Hour, expected: "vor 1 Stunde"},
+		{name: "unknown language", lang: "fr", offset: time.Hour, expected: "1 hour ago"},
+		{name: "no header", offset: time.Hour, expected: "1 hour ago"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if e.lang != "" {
+			req.Header.Set("Accept-Language", e.lang)
+		}
+		if got := testTools.TimeAgoFor(req, now.Add(-e.offset), now); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "de")
+	if got := testTools.HumanDurationFor(req, 2*time.Hour+15*time.Minute); got != "2 Std. 15 Min." {
+		t.Errorf("expected a german duration, got %q", got)
+	}
+	if got := testTools.HumanDurationFor(req, 400*day); got != "1y 35d" {
+		t.Errorf("expected an untranslated unit to fall back to english, got %q", got)
+	}
+	if got := testTools.HumanDurationFor(nil, time.Minute); got != "1m" {
+		t.Errorf("expected english without a request, got %q", got)
+	}
+}
+
+func TestHumanTime_JSON(t *testing.T) {
+	testTools := Tools{Translator: catalogTranslator{"de": germanTime}}
+	tm := time.Now().Add(-5 * time.Minute).Truncate(time.Second).UTC()
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "de")
+
+	data, err := json.Marshal(struct {
+		Created HumanTime `json:"created"`
+	}{testTools.HumanizeTime(req, tm)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(`{"created":{"time":"%s","human":"vor 5 Minuten"}}`, tm.Format(time.RFC3339))
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+
+	var decoded HumanTime
+	if err := json.Unmarshal(data[len(`{"created":`):len(data)-1], &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Time.Equal(tm) || decoded.Human != "vor 5 Minuten" {
+		t.Errorf("expected the value to round trip, got %+v", decoded)
+	}
+
+	data, err = json.Marshal(HumanTime{Time: tm})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"human":"5 minutes ago"`) {
+		t.Errorf("expected the English form when Human is empty, got %s", data)
+	}
+}
@@ -0,0 +1,242 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Translator translates the toolkit's client-facing messages, by the keys of EnglishMessages, into lang. It returns
+// "" when it has no translation
+type Translator interface {
+	Translate(lang, key string, args ...interface{}) string
+}
+
+// englishMessages holds the built-in English text for every message key, as fmt format strings. Keys and the order
+// of their arguments are part of the public API, and must not change
+var englishMessages = map[string]string{
+	"json.badly_formed":          "body contains badly formed JSON",
+	"json.badly_formed_at":       "body contains badly formed JSON (at character %d)",
+	"json.wrong_type_field":      "body contains incorrect JSON type for field %q",
+	"json.wrong_type_at":         "body contains incorrect JSON type (at character %d)",
+	"json.empty_body":            "body must not be empty",
+	"json.unknown_field":         "body contains unknown key %q",
+	"json.body_too_large":        "body must not be larger than %d bytes",
+	"json.multiple_values":       "body must contain only one JSON value",
+	"upload.too_big":             "the uploaded file is too big",
+	"upload.file_too_big":        "the uploaded file %q is larger than %d bytes",
+	"upload.type_too_big":        "the uploaded file %q of type %s is larger than %d bytes",
+	"upload.file_too_small":      "the uploaded file %q is smaller than %d bytes",
+	"upload.file_empty":          "the uploaded file %q is empty",
+	"upload.request_too_big":     "the uploaded files are larger than %d bytes in all",
+	"upload.too_many_files":      "too many files uploaded (the limit is %d)",
+	"upload.multiple_files":      "%d files were uploaded, but only one is allowed",
+	"upload.type_not_permitted":  "the uploaded file type is not permitted",
+	"upload.file_type_denied":    "the uploaded file %q has type %s, which is not permitted",
+	"upload.no_files":            "no files were uploaded",
+	"upload.file_exists":         "a file named %q has already been uploaded",
+	"upload.field_not_permitted": "files may not be uploaded under the form field %q",
+	"upload.ext_not_permitted":   "the uploaded file extension is not permitted",
+	"upload.file_ext_denied":     "the uploaded file %q has the extension %q, which is not permitted",
+	"upload.file_no_ext":         "the uploaded file %q has no extension, and one is required",
+	"upload.ext_mismatch":        "the uploaded file %q has the extension %q, but its content is %s",
+	"upload.image_too_large":     "the uploaded image %q is %dx%d pixels, which is larger than permitted",
+	"upload.image_invalid":       "the uploaded file %q is not a valid image",
+	"upload.image_animated":      "the uploaded image %q is animated, and can't be converted to %s",
+	"upload.zip_invalid":         "the uploaded file %q is not a valid ZIP archive",
+	"upload.zip_entries":         "the uploaded archive %q has more entries than permitted",
+	"upload.zip_too_large":       "the uploaded archive %q is larger than permitted once extracted",
+	"upload.zip_ratio":           "the entry %q of the uploaded archive %q is compressed more than permitted",
+	"upload.zip_unsafe_path":     "the entry %q of the uploaded archive %q would be extracted outside its directory",
+	"upload.zip_entry_refused":   "the entry %q of the uploaded archive %q is not permitted",
+	"upload.infected":            "the uploaded file %q was rejected by the virus scan",
+	"upload.scan_failed":         "the uploaded file %q could not be scanned for viruses",
+	"upload.invalid_name":        "the uploaded file name is not valid",
+	"upload.name_too_long":       "the uploaded file %q has a name that can't be shortened to %d bytes",
+	"upload.hidden_name":         "the uploaded file %q has a hidden name, beginning with a dot",
+	"upload.path_escape":         "the uploaded file %q would be saved outside the upload directory",
+	"upload.invalid_base64":      "the uploaded file is not valid base64",
+	"upload.invalid_url":         "the URL to upload from is not a valid http or https URL",
+	"upload.url_forbidden":       "the URL to upload from points to an address that is not permitted",
+	"upload.url_fetch_failed":    "fetching %s failed with status %d",
+	"upload.unknown_upload":      "there is no upload in progress with that ID",
+	"upload.chunk_in_progress":   "another chunk of this upload is being written",
+	"upload.chunk_offset":        "the chunk starts at byte %d, but the upload so far is %d bytes",
+	"upload.malformed_form":      "could not parse multipart form",
+	"upload.quota_exceeded":      "there is not enough storage space left for this upload",
+	"upload.no_disk_space":       "there is not enough free disk space for this upload",
+	"download.no_file":           "no file specified",
+	"download.not_found":         "file not found",
+	"download.unsafe_path":       "path is outside of the permitted directory",
+	"download.unsigned":          "download link is not signed",
+	"download.bad_signature":     "download link signature is invalid",
+	"download.expired":           "download link has expired",
+	"auth.required":              "authorization required",
+	"auth.malformed_header":      "malformed authorization header",
+	"auth.invalid_credentials":   "invalid credentials",
+	"auth.bearer_required":       "bearer token required",
+	"auth.token_expired":         "token has expired",
+	"auth.token_invalid":         "invalid token",
+	"access.denied":              "access denied for %s",
+	"access.unknown_client":      "could not determine client address",
+	"access.malformed_header":    "malformed %s header",
+	"request.method_not_allowed": "method %s not allowed",
+	"request.timed_out":          "the request timed out",
+	"request.internal_error":     "internal server error",
+	"pagination.invalid_cursor":  "invalid cursor",
+	"time.just_now":              "just now",
+	"time.ago":                   "%s ago",
+	"time.from_now":              "in %s",
+	"time.second":                "%d second",
+	"time.seconds":               "%d seconds",
+	"time.minute":                "%d minute",
+	"time.minutes":               "%d minutes",
+	"time.hour":                  "%d hour",
+	"time.hours":                 "%d hours",
+	"time.day":                   "%d day",
+	"time.days":                  "%d days",
+	"time.month":                 "%d month",
+	"time.months":                "%d months",
+	"time.year":                  "%d year",
+	"time.years":                 "%d years",
+	"duration.years":             "%dy",
+	"duration.days":              "%dd",
+	"duration.hours":             "%dh",
+	"duration.minutes":           "%dm",
+	"duration.seconds":           "%ds",
+	"duration.milliseconds":      "%dms",
+}
+
+// EnglishMessages returns a copy of the built-in English format string for every message key, as a starting point
+// for writing a Translator
+func EnglishMessages() map[string]string {
+	messages := make(map[string]string, len(englishMessages))
+	for key, format := range englishMessages {
+		messages[key] = format
+	}
+	return messages
+}
+
+// englishMessage formats the English text for key
+func englishMessage(key string, args ...interface{}) string {
+	format, ok := englishMessages[key]
+	if !ok {
+		return key
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// messageKeyer is implemented by errors whose message can be translated
+type messageKeyer interface {
+	messageKey() (string, []interface{})
+}
+
+// messageError is a client-facing error identified by a message key. err, when set, is the underlying cause; it is
+// included in the English message but not in translations
+type messageError struct {
+	key  string
+	args []interface{}
+	err  error
+}
+
+// newMessageError returns a messageError for key with the given format arguments
+func newMessageError(key string, args ...interface{}) *messageError {
+	return &messageError{key: key, args: args}
+}
+
+// Error implements the error interface
+func (e *messageError) Error() string {
+	if e.err != nil {
+		return englishMessage(e.key, e.args...) + ": " + e.err.Error()
+	}
+	return englishMessage(e.key, e.args...)
+}
+
+// Unwrap returns the underlying cause
+func (e *messageError) Unwrap() error {
+	return e.err
+}
+
+func (e *messageError) messageKey() (string, []interface{}) {
+	return e.key, e.args
+}
+
+// LocalizeError returns the message for err in the language the Accept-Language header of r prefers, or else
+// err.Error()
+func (t *Tools) LocalizeError(r *http.Request, err error) string {
+	var keyed messageKeyer
+	if t.Translator == nil || r == nil || !errors.As(err, &keyed) {
+		return err.Error()
+	}
+
+	key, args := keyed.messageKey()
+	for _, lang := range AcceptedLanguages(r) {
+		if msg := t.Translator.Translate(lang, key, args...); msg != "" {
+			return msg
+		}
+	}
+
+	return err.Error()
+}
+
+// LocalizedErrorJSON is like ErrorJSON, but sends the error message in the language the client prefers, as chosen
+// by LocalizeError
+func (t *Tools) LocalizedErrorJSON(w http.ResponseWriter, r *http.Request, err error, status ...int) error {
+	statusCode := http.StatusBadRequest
+
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+
+	var payload JSONResponse
+	payload.Error = true
+	payload.Message = t.LocalizeError(r, err)
+
+	return t.WriteJSON(w, statusCode, payload)
+}
+
+// AcceptedLanguages returns the language tags from the Accept-Language header of r, most preferred first.
+// Wildcards and languages with a quality of zero are left out
+func AcceptedLanguages(r *http.Request) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+
+	var langs []weighted
+	for _, header := range r.Header.Values("Accept-Language") {
+		for _, part := range strings.Split(header, ",") {
+			fields := strings.Split(part, ";")
+			lang := strings.TrimSpace(fields[0])
+			if lang == "" || lang == "*" {
+				continue
+			}
+
+			q := 1.0
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+						q = v
+					}
+				}
+			}
+			if q <= 0 {
+				continue
+			}
+
+			langs = append(langs, weighted{lang: lang, q: q})
+		}
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	tags := make([]string, len(langs))
+	for i, l := range langs {
+		tags[i] = l.lang
+	}
+	return tags
+}
@@ -0,0 +1,271 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingTranslator records every call, and translates only the languages in its table
+type recordingTranslator struct {
+	mu       sync.Mutex
+	calls    []translateCall
+	prefixes map[string]string
+}
+
+type translateCall struct {
+	lang string
+	key  string
+	args []interface{}
+}
+
+func (rt *recordingTranslator) Translate(lang, key string, args ...interface{}) string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.calls = append(rt.calls, translateCall{lang: lang, key: key, args: args})
+	if prefix, ok := rt.prefixes[lang]; ok {
+		return prefix + key + fmt.Sprint(args...)
+	}
+	return ""
+}
+
+func (rt *recordingTranslator) last() translateCall {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if len(rt.calls) == 0 {
+		return translateCall{}
+	}
+	return rt.calls[len(rt.calls)-1]
+}
+
+var readJSONMessageTests = []struct {
+	name string
+	json string
+	key  string
+	args []interface{}
+}{
+	{name: "syntax", json: `{"foo": }`, key: "json.badly_formed_at", args: []interface{}{int64(9)}},
+	{name: "truncated", json: `{"foo": "bar"`, key: "json.badly_formed"},
+	{name: "wrong type", json: `{"foo": 1}`, key: "json.wrong_type_field", args: []interface{}{"foo"}},
+	{name: "empty", json: ``, key: "json.empty_body"},
+	{name: "unknown field", json: `{"bar": "baz"}`, key: "json.unknown_field", args: []interface{}{"bar"}},
+	{name: "two values", json: `{"foo": "a"}{"foo": "b"}`, key: "json.multiple_values"},
+	{name: "too large", json: `{"foo": "` + strings.Repeat("a", 100) + `"}`, key: "json.body_too_large", args: []interface{}{10}},
+}
+
+func TestTools_LocalizeReadJSON(t *testing.T) {
+	rt := &recordingTranslator{prefixes: map[string]string{"de": "DE:"}}
+	for _, e := range readJSONMessageTests {
+		testTools := Tools{Translator: rt}
+		if e.key == "json.body_too_large" {
+			testTools.MaxJSONSize = 10
+		}
+
+		var payload struct {
+			Foo string `json:"foo"`
+		}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(e.json))
+		req.Header.Set("Accept-Language", "de-CH, de;q=0.9")
+		err := testTools.ReadJSON(httptest.NewRecorder(), req, &payload)
+		if err == nil {
+			t.Errorf("%s: expected an error", e.name)
+			continue
+		}
+
+		msg := testTools.LocalizeError(req, err)
+		call := rt.last()
+
+		if call.lang != "de" || call.key != e.key || !reflect.DeepEqual(call.args, e.args) {
+			t.Errorf("%s: expected Translate(de, %s, %v), got Translate(%s, %s, %v)", e.name, e.key, e.args, call.lang, call.key, call.args)
+		}
+		if !strings.HasPrefix(msg, "DE:"+e.key) {
+			t.Errorf("%s: expected translated message, got %q", e.name, msg)
+		}
+
+		// the English fallback must be the same text as the error itself
+		if englishMessage(call.key, call.args...) != err.Error() {
+			t.Errorf("%s: English catalog gives %q but error says %q", e.name, englishMessage(call.key, call.args...), err.Error())
+		}
+	}
+}
+
+var handlerMessageTests = []struct {
+	name    string
+	handler func(tools *Tools) http.Handler
+	method  string
+	target  string
+	key     string
+	args    []interface{}
+}{
+	{
+		name: "method not allowed",
+		handler: func(tools *Tools) http.Handler {
+			return tools.AllowMethods(func(http.ResponseWriter, *http.Request) {}, http.MethodGet)
+		},
+		method: http.MethodDelete,
+		key:    "request.method_not_allowed",
+		args:   []interface{}{http.MethodDelete},
+	},
+	{
+		name: "authorization required",
+		handler: func(tools *Tools) http.Handler {
+			return tools.BasicAuth(BasicAuthCredentials("u", "p"), "test")(http.NotFoundHandler())
+		},
+		method: http.MethodGet,
+		key:    "auth.required",
+	},
+	{
+		name: "bearer token required",
+		handler: func(tools *Tools) http.Handler {
+			return tools.RequireJWT(JWTOptions{Secret: []byte("s")})(http.NotFoundHandler())
+		},
+		method: http.MethodGet,
+		key:    "auth.bearer_required",
+	},
+	{
+		name:    "download not found",
+		handler: func(tools *Tools) http.Handler { return tools.DownloadHandler("./testdata") },
+		method:  http.MethodGet,
+		target:  "/download?file=missing.png",
+		key:     "download.not_found",
+	},
+	{
+		name:    "download unsafe path",
+		handler: func(tools *Tools) http.Handler { return tools.DownloadHandler("./testdata") },
+		method:  http.MethodGet,
+		target:  "/download?file=../tools.go",
+		key:     "download.unsafe_path",
+	},
+	{
+		name: "access denied",
+		handler: func(tools *Tools) http.Handler {
+			return tools.IPFilter(IPFilterOptions{Deny: []string{"192.0.2.1"}})(http.NotFoundHandler())
+		},
+		method: http.MethodGet,
+		key:    "access.denied",
+		args:   []interface{}{"192.0.2.1"},
+	},
+	{
+		name: "panic",
+		handler: func(tools *Tools) http.Handler {
+			return tools.Recoverer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") }))
+		},
+		method: http.MethodGet,
+		key:    "request.internal_error",
+	},
+}
+
+func TestTools_LocalizedHandlers(t *testing.T) {
+	for _, e := range handlerMessageTests {
+		rt := &recordingTranslator{prefixes: map[string]string{"fr": "FR:"}}
+		testTools := Tools{Translator: rt, Logger: log.New(io.Discard, "", 0)}
+
+		target := e.target
+		if target == "" {
+			target = "/"
+		}
+		req := httptest.NewRequest(e.method, target, nil)
+		req.Header.Set("Accept-Language", "fr")
+		rr := httptest.NewRecorder()
+
+		e.handler(&testTools).ServeHTTP(rr, req)
+
+		call := rt.last()
+		if call.key != e.key || !reflect.DeepEqual(call.args, e.args) {
+			t.Errorf("%s: expected Translate(fr, %s, %v), got Translate(%s, %s, %v)", e.name, e.key, e.args, call.lang, call.key, call.args)
+		}
+
+		var payload JSONResponse
+		if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+			t.Errorf("%s: could not decode response: %s", e.name, err)
+			continue
+		}
+		if !strings.HasPrefix(payload.Message, "FR:"+e.key) {
+			t.Errorf("%s: expected translated message, got %q", e.name, payload.Message)
+		}
+	}
+}
+
+func TestTools_LocalizeErrorFallback(t *testing.T) {
+	rt := &recordingTranslator{prefixes: map[string]string{"fr": "FR:"}}
+	testTools := Tools{Translator: rt}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "de;q=0.8, it, fr;q=0.5")
+
+	msg := testTools.LocalizeError(req, ErrFileTooBig)
+	if msg != "FR:upload.too_big" {
+		t.Errorf("expected the French translation, got %q", msg)
+	}
+
+	var langs []string
+	for _, c := range rt.calls {
+		langs = append(langs, c.lang)
+	}
+	if !reflect.DeepEqual(langs, []string{"it", "de", "fr"}) {
+		t.Errorf("expected languages to be tried in order of preference, got %v", langs)
+	}
+
+	// no translation at all falls back to the English error, detail included
+	req.Header.Set("Accept-Language", "es")
+	err := fmt.Errorf("%w: wrong audience", ErrTokenInvalid)
+	if msg := testTools.LocalizeError(req, err); msg != "invalid token: wrong audience" {
+		t.Errorf("expected English fallback, got %q", msg)
+	}
+
+	// errors without a key are never translated
+	calls := len(rt.calls)
+	if msg := testTools.LocalizeError(req, fmt.Errorf("something else")); msg != "something else" || len(rt.calls) != calls {
+		t.Errorf("expected plain error to pass through untranslated, got %q", msg)
+	}
+}
+
+var acceptLanguageTests = []struct {
+	header   string
+	expected []string
+}{
+	{header: "", expected: []string{}},
+	{header: "de", expected: []string{"de"}},
+	{header: "fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5", expected: []string{"fr-CH", "fr", "en", "de"}},
+	{header: "en;q=0.1, de", expected: []string{"de", "en"}},
+	{header: "de;q=0, fr", expected: []string{"fr"}},
+	{header: "de;q=bogus, fr;q=0.5", expected: []string{"de", "fr"}},
+}
+
+func TestAcceptedLanguages(t *testing.T) {
+	for _, e := range acceptLanguageTests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if e.header != "" {
+			req.Header.Set("Accept-Language", e.header)
+		}
+
+		if got := AcceptedLanguages(req); !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%q: expected %v, got %v", e.header, e.expected, got)
+		}
+	}
+}
+
+func TestEnglishMessages(t *testing.T) {
+	messages := EnglishMessages()
+	messages["json.empty_body"] = "changed"
+
+	if englishMessages["json.empty_body"] == "changed" {
+		t.Error("EnglishMessages should return a copy")
+	}
+
+	for key := range englishMessages {
+		parts := strings.SplitN(key, ".", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			t.Errorf("message key %q should be of the form area.name", key)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package toolkit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// crockfordBase32 is the alphabet of Crockford's base32, which leaves out I, L, O and U, that ULIDs are written in
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ulidState is the time and randomness of the last ULID made, so that a ULID made in the same millisecond as the
+// one before it has that randomness plus one, and sorts after it
+var ulidState struct {
+	sync.Mutex
+	ms      uint64
+	entropy [10]byte
+}
+
+// UUIDv4 returns a random version 4 UUID, as RFC 4122 sets out, in its usual lowercase form with hyphens, such as
+// 3f8e2a9c-5b1d-4e7a-9c2f-8d4b6a1e0c7f. Its characters are all safe in file names and URLs
+func (t *Tools) UUIDv4() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // the variant of RFC 4122
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+// ULID returns a ULID, such as 01HZX3M8Q7K2V5T9R4N6B0C1D2, which sort in the order they were made
+func (t *Tools) ULID() string {
+	ms := uint64(time.Now().UnixMilli())
+
+	ulidState.Lock()
+	if ms <= ulidState.ms {
+		// In the same millisecond, or when the clock has gone back, the last randomness is incremented instead
+		ms = ulidState.ms
+		for i := len(ulidState.entropy) - 1; i >= 0; i-- {
+			ulidState.entropy[i]++
+			if ulidState.entropy[i] != 0 {
+				break
+			}
+		}
+	} else {
+		ulidState.ms = ms
+		_, _ = rand.Read(ulidState.entropy[:])
+	}
+	var b [16]byte
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+	copy(b[6:], ulidState.entropy[:])
+	ulidState.Unlock()
+
+	// The 128 bits are written 5 at a time, from the last, with 2 bits of padding in front of the first character
+	var s [26]byte
+	for i := len(s) - 1; i >= 0; i-- {
+		s[i] = crockfordBase32[b[15]&0x1f]
+		shiftRight5(&b)
+	}
+	return string(s[:])
+}
+
+// shiftRight5 shifts the 128 bit number b, most significant byte first, 5 bits to the right
+func shiftRight5(b *[16]byte) {
+	for i := len(b) - 1; i > 0; i-- {
+		b[i] = b[i]>>5 | b[i-1]<<3
+	}
+	b[0] >>= 5
+}
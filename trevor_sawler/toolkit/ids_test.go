@@ -0,0 +1,63 @@
+package toolkit
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	uuidV4Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulidPattern   = regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+)
+
+func TestTools_UUIDv4(t *testing.T) {
+	var testTools Tools
+
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		id := testTools.UUIDv4()
+		if !uuidV4Pattern.MatchString(id) {
+			t.Fatalf("expected a version 4 UUID, got %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("expected the UUIDs to differ, got %q twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestTools_ULID(t *testing.T) {
+	var testTools Tools
+
+	before := time.Now().UnixMilli()
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = testTools.ULID()
+		if !ulidPattern.MatchString(ids[i]) {
+			t.Fatalf("expected a ULID, got %q", ids[i])
+		}
+	}
+	after := time.Now().UnixMilli()
+
+	// ULIDs made one after the other, many of them in the same millisecond, sort in the order they were made
+	if !sort.StringsAreSorted(ids) {
+		t.Error("expected the ULIDs to sort in the order they were made")
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] == ids[i-1] {
+			t.Fatalf("expected the ULIDs to differ, got %q twice", ids[i])
+		}
+	}
+
+	// the first 10 characters are the time in milliseconds
+	var ms int64
+	for _, c := range ids[0][:10] {
+		ms = ms<<5 | int64(strings.IndexRune(crockfordBase32, c))
+	}
+	if ms < before || ms > after {
+		t.Errorf("expected the time of the ULID to be between %d and %d, got %d", before, after, ms)
+	}
+}
@@ -0,0 +1,143 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// normalizedImageTypes maps each format NormalizeImageFormat may be set to to the content type images are saved as
+var normalizedImageTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+}
+
+// normalizedImageExtensions maps each format NormalizeImageFormat may be set to to the extensions a file in that
+// format may have, the one a converted image is given first
+var normalizedImageExtensions = map[string][]string{
+	"jpeg": {".jpg", ".jpeg"},
+	"png":  {".png"},
+}
+
+// ErrAnimatedImage is matched, via errors.Is, by the *AnimatedImageError returned by UploadFiles for an animated
+// GIF when NormalizeImageFormat is set
+var ErrAnimatedImage = errors.New("uploaded image is animated")
+
+// AnimatedImageError is returned by UploadFiles when NormalizeImageFormat is set and an uploaded GIF has more than
+// one frame, which would be lost by converting it to Format
+type AnimatedImageError struct {
+	FileName string
+	Format   string
+}
+
+// Error implements the error interface
+func (e *AnimatedImageError) Error() string {
+	return englishMessage("upload.image_animated", e.FileName, e.Format)
+}
+
+// Is reports whether target is ErrAnimatedImage
+func (e *AnimatedImageError) Is(target error) bool {
+	return target == ErrAnimatedImage
+}
+
+func (e *AnimatedImageError) messageKey() (string, []interface{}) {
+	return "upload.image_animated", []interface{}{e.FileName, e.Format}
+}
+
+// normalizesImage reports whether an uploaded file of type fileType is converted to NormalizeImageFormat: it is a
+// PNG, JPEG or GIF image in another format. Other files, images of other types among them, are saved as they are
+func (t *Tools) normalizesImage(fileType string) bool {
+	if t.NormalizeImageFormat == "" || fileType == normalizedImageTypes[t.NormalizeImageFormat] {
+		return false
+	}
+	switch fileType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// normalizeUploadedImage reads the image filename from r and returns it converted to NormalizeImageFormat
+func (t *Tools) normalizeUploadedImage(filename, fileType string, r io.Reader) (*bytes.Buffer, error) {
+	limit, tooBig := t.fileSizeLimit(filename, fileType)
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, tooBig
+	}
+
+	var img image.Image
+	if fileType == "image/gif" {
+		// Only a GIF can be animated, and converting it would keep its first frame alone
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, &InvalidImageError{FileName: filename, Err: err}
+		}
+		if len(g.Image) > 1 {
+			return nil, &AnimatedImageError{FileName: filename, Format: t.NormalizeImageFormat}
+		}
+		img = g.Image[0]
+	} else if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+		return nil, &InvalidImageError{FileName: filename, Err: err}
+	}
+
+	var converted bytes.Buffer
+	if t.NormalizeImageFormat == "png" {
+		err = png.Encode(&converted, img)
+	} else {
+		quality := jpeg.DefaultQuality
+		if t.NormalizeJPEGQuality > 0 {
+			quality = t.NormalizeJPEGQuality
+		}
+		err = jpeg.Encode(&converted, flattenImage(img), &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return nil, &InvalidImageError{FileName: filename, Err: err}
+	}
+	return &converted, nil
+}
+
+// flattenImage draws img onto a white background when it may have transparent pixels, which JPEG can't hold, so that
+// they don't come out black
+func flattenImage(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
+
+// normalizedImageName returns the name filename is saved under, with the extension of NormalizeImageFormat when it
+// converts the image
+func (t *Tools) normalizedImageName(filename, fileType string) string {
+	if t.NormalizeImageFormat == "" || fileType != normalizedImageTypes[t.NormalizeImageFormat] {
+		return filename
+	}
+
+	exts := normalizedImageExtensions[t.NormalizeImageFormat]
+	ext := fileExt(filename, t.compoundExtensions())
+	for _, x := range exts {
+		if strings.EqualFold(ext, x) {
+			return filename
+		}
+	}
+	if ext == filename {
+		// a name such as ".png" is all extension, which is kept as the name
+		ext = ""
+	}
+	return strings.TrimSuffix(filename, ext) + exts[0]
+}
@@ -0,0 +1,125 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testGIF returns a GIF image made of the given number of 4x4 frames
+func testGIF(t *testing.T, frames int) []byte {
+	t.Helper()
+
+	g := &gif.GIF{}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White}))
+		g.Delay = append(g.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTools_UploadFilesNormalizeImageFormat(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	pic := readTestFile(t, "pic.jpg")
+
+	var tests = []struct {
+		name        string
+		format      string
+		filename    string
+		content     []byte
+		newName     string
+		contentType string
+		unchanged   bool
+		expected    error
+	}{
+		{name: "png to jpeg", format: "jpeg", filename: "img.png", content: img, newName: "img.jpg", contentType: "image/jpeg"},
+		{name: "gif to jpeg", format: "jpeg", filename: "still.gif", content: testGIF(t, 1), newName: "still.jpg", contentType: "image/jpeg"},
+		{name: "jpeg to png", format: "png", filename: "pic.jpeg", content: pic, newName: "pic.png", contentType: "image/png"},
+		{name: "already jpeg", format: "jpeg", filename: "pic.JPEG", content: pic, newName: "pic.JPEG", contentType: "image/jpeg", unchanged: true},
+		{name: "jpeg with the wrong extension", format: "jpeg", filename: "pic.png", content: pic, newName: "pic.jpg", contentType: "image/jpeg", unchanged: true},
+		{name: "not an image", format: "jpeg", filename: "notes.txt", content: []byte("just some text"), newName: "notes.txt", contentType: "text/plain; charset=utf-8", unchanged: true},
+		{name: "animated gif", format: "jpeg", filename: "anim.gif", content: testGIF(t, 2), expected: ErrAnimatedImage},
+		{name: "corrupt image", format: "png", filename: "bad.gif", content: []byte("GIF89a, but nothing after it"), expected: ErrInvalidImage},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{NormalizeImageFormat: e.format}
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			files, err := upload(&testTools, newUploadRequest(t, testUpload{"file", e.filename, e.content}), dir, false)
+			if e.expected != nil {
+				if !errors.Is(err, e.expected) {
+					t.Errorf("%s, %s: expected %v, got %v", e.name, name, e.expected, err)
+				}
+				if remaining := remainingFiles(t, dir); remaining != nil {
+					t.Errorf("%s, %s: expected no files to be left, got %v", e.name, name, remaining)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+
+			f := files[0]
+			if f.NewFileName != e.newName || f.ContentType != e.contentType || f.OriginalFileName != e.filename {
+				t.Errorf("%s, %s: expected %s of type %s, got %s of type %s", e.name, name, e.newName, e.contentType, f.NewFileName, f.ContentType)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, f.NewFileName))
+			if err != nil {
+				t.Errorf("%s, %s: expected the file to be saved: %s", e.name, name, err)
+				continue
+			}
+			if bytes.Equal(data, e.content) != e.unchanged || f.FileSize != int64(len(data)) {
+				t.Errorf("%s, %s: expected the file to be saved changed: %v, got %d bytes of %d", e.name, name, !e.unchanged, len(data), len(e.content))
+			}
+			if detected := http.DetectContentType(data); detected != e.contentType {
+				t.Errorf("%s, %s: expected the saved file to be %s, got %s", e.name, name, e.contentType, detected)
+			}
+		}
+	}
+
+	// transparent pixels are flattened onto white for JPEG
+	transparent := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, transparent); err != nil {
+		t.Fatal(err)
+	}
+	testTools := Tools{NormalizeImageFormat: "jpeg", NormalizeJPEGQuality: 100}
+	dir := t.TempDir()
+	files, err := testTools.UploadFiles(newUploadRequest(t, testUpload{"file", "clear.png", buf.Bytes()}), dir, WithRename(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.Open(filepath.Join(dir, files[0].NewFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stored.Close()
+	decoded, _, err := image.Decode(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := decoded.At(4, 4).RGBA(); r < 0xf000 || g < 0xf000 || b < 0xf000 {
+		t.Errorf("expected a transparent pixel to be saved white, got %d %d %d", r>>8, g>>8, b>>8)
+	}
+
+	// the handler refuses an animated GIF with 415
+	rr := httptest.NewRecorder()
+	testTools.UploadHandler(t.TempDir())(rr, newUploadRequest(t, testUpload{"file", "anim.gif", testGIF(t, 3)}))
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected a 415 for an animated GIF, got %d %s", rr.Code, rr.Body.String())
+	}
+}
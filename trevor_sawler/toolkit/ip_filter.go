@@ -0,0 +1,136 @@
+package toolkit
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPFilterOptions configures IPFilter. All lists hold CIDR ranges ("10.0.0.0/8", "2001:db8::/32") or single
+// addresses, which are treated as a /32 or /128
+type IPFilterOptions struct {
+	// Allow lists the ranges permitted to connect. When empty, every address not denied is allowed
+	Allow []string
+
+	// Deny lists ranges that are always rejected. Deny is evaluated before Allow
+	Deny []string
+
+	// TrustedProxies lists the proxies whose X-Forwarded-For and X-Real-IP headers are believed. When the
+	// direct peer is not a trusted proxy, those headers are ignored
+	TrustedProxies []string
+}
+
+// IPFilter is middleware that admits or rejects requests by client IP, which is taken from X-Forwarded-For only
+// when the peer is a trusted proxy, and sends a 403 when rejected. It panics if a range is invalid
+func (t *Tools) IPFilter(opts IPFilterOptions) func(http.Handler) http.Handler {
+	allow := mustParsePrefixes(opts.Allow)
+	deny := mustParsePrefixes(opts.Deny)
+	trusted := mustParsePrefixes(opts.TrustedProxies)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, err := clientIP(r, trusted)
+			if err != nil {
+				_ = t.LocalizedErrorJSON(w, r, err, http.StatusForbidden)
+				return
+			}
+
+			if prefixesContain(deny, ip) || (len(allow) > 0 && !prefixesContain(allow, ip)) {
+				_ = t.LocalizedErrorJSON(w, r, newMessageError("access.denied", ip.String()), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP works out the address of the client, only believing forwarding headers set by trusted proxies
+func clientIP(r *http.Request, trusted []netip.Prefix) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	peer, err := parseIP(host)
+	if err != nil {
+		return netip.Addr{}, newMessageError("access.unknown_client")
+	}
+
+	if !prefixesContain(trusted, peer) {
+		return peer, nil
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := parseIP(hops[i])
+			if err != nil {
+				return netip.Addr{}, newMessageError("access.malformed_header", "X-Forwarded-For")
+			}
+			if !prefixesContain(trusted, hop) {
+				return hop, nil
+			}
+		}
+		// every hop was a trusted proxy, so the left-most one is the best we have
+		hop, _ := parseIP(hops[0])
+		return hop, nil
+	}
+
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		hop, err := parseIP(realIP)
+		if err != nil {
+			return netip.Addr{}, newMessageError("access.malformed_header", "X-Real-IP")
+		}
+		return hop, nil
+	}
+
+	return peer, nil
+}
+
+// parseIP parses an address, removing any IPv6 zone and unmapping IPv4-mapped IPv6 addresses
+func parseIP(s string) (netip.Addr, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.WithZone("").Unmap(), nil
+}
+
+// mustParsePrefixes parses CIDR ranges and bare addresses, panicking on invalid input
+func mustParsePrefixes(list []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			addr, err := parseIP(s)
+			if err != nil {
+				panic(fmt.Sprintf("toolkit: invalid IP address %q: %s", s, err))
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			panic(fmt.Sprintf("toolkit: invalid CIDR range %q: %s", s, err))
+		}
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes
+}
+
+// prefixesContain reports whether any of the prefixes contains ip
+func prefixesContain(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var ipFilterTests = []struct {
+	name           string
+	opts           IPFilterOptions
+	remoteAddr     string
+	forwardedFor   string
+	realIP         string
+	expectedStatus int
+}{
+	{name: "no rules", opts: IPFilterOptions{}, remoteAddr: "203.0.113.9:1234", expectedStatus: http.StatusOK},
+	{name: "allowed v4", opts: IPFilterOptions{Allow: []string{"203.0.113.0/24"}}, remoteAddr: "203.0.113.9:1234", expectedStatus: http.StatusOK},
+	{name: "not in allow list", opts: IPFilterOptions{Allow: []string{"203.0.113.0/24"}}, remoteAddr: "198.51.100.1:1234", expectedStatus: http.StatusForbidden},
+	{name: "single address", opts: IPFilterOptions{Allow: []string{"198.51.100.1"}}, remoteAddr: "198.51.100.1:1234", expectedStatus: http.StatusOK},
+	{name: "deny wins over allow", opts: IPFilterOptions{Allow: []string{"203.0.113.0/24"}, Deny: []string{"203.0.113.9/32"}}, remoteAddr: "203.0.113.9:1234", expectedStatus: http.StatusForbidden},
+	{name: "deny only", opts: IPFilterOptions{Deny: []string{"10.0.0.0/8"}}, remoteAddr: "10.1.2.3:1234", expectedStatus: http.StatusForbidden},
+	{name: "deny only, other address", opts: IPFilterOptions{Deny: []string{"10.0.0.0/8"}}, remoteAddr: "11.1.2.3:1234", expectedStatus: http.StatusOK},
+	{name: "allowed v6", opts: IPFilterOptions{Allow: []string{"2001:db8::/32"}}, remoteAddr: "[2001:db8::1]:1234", expectedStatus: http.StatusOK},
+	{name: "v6 not allowed", opts: IPFilterOptions{Allow: []string{"2001:db8::/32"}}, remoteAddr: "[2001:db9::1]:1234", expectedStatus: http.StatusForbidden},
+	{name: "v6 with zone", opts: IPFilterOptions{Allow: []string{"fe80::/10"}}, remoteAddr: "[fe80::1%eth0]:1234", expectedStatus: http.StatusOK},
+	{name: "mapped v4 peer against v4 range", opts: IPFilterOptions{Allow: []string{"203.0.113.0/24"}}, remoteAddr: "[::ffff:203.0.113.9]:1234", expectedStatus: http.StatusOK},
+	{name: "v4 peer against mapped range", opts: IPFilterOptions{Allow: []string{"::ffff:203.0.113.0/120"}}, remoteAddr: "203.0.113.9:1234", expectedStatus: http.StatusOK},
+	{name: "mapped v4 peer denied", opts: IPFilterOptions{Deny: []string{"203.0.113.0/24"}}, remoteAddr: "[::ffff:203.0.113.9]:1234", expectedStatus: http.StatusForbidden},
+	{name: "bad remote addr", opts: IPFilterOptions{}, remoteAddr: "garbage", expectedStatus: http.StatusForbidden},
+	{
+		name:           "spoofed header from untrusted peer",
+		opts:           IPFilterOptions{Allow: []string{"203.0.113.0/24"}},
+		remoteAddr:     "198.51.100.1:1234",
+		forwardedFor:   "203.0.113.9",
+		expectedStatus: http.StatusForbidden,
+	},
+	{
+		name:           "spoofed real ip from untrusted peer",
+		opts:           IPFilterOptions{Allow: []string{"203.0.113.0/24"}},
+		remoteAddr:     "198.51.100.1:1234",
+		realIP:         "203.0.113.9",
+		expectedStatus: http.StatusForbidden,
+	},
+	{
+		name:           "forwarded by trusted proxy",
+		opts:           IPFilterOptions{Allow: []string{"203.0.113.0/24"}, TrustedProxies: []string{"10.0.0.0/8"}},
+		remoteAddr:     "10.0.0.2:1234",
+		forwardedFor:   "203.0.113.9",
+		expectedStatus: http.StatusOK,
+	},
+	{
+		name:           "client spoofs left-most hop through trusted proxy",
+		opts:           IPFilterOptions{Allow: []string{"203.0.113.0/24"}, TrustedProxies: []string{"10.0.0.0/8"}},
+		remoteAddr:     "10.0.0.2:1234",
+		forwardedFor:   "203.0.113.9, 198.51.100.1",
+		expectedStatus: http.StatusForbidden,
+	},
+	{
+		name:           "chain of trusted proxies",
+		opts:           IPFilterOptions{Allow: []string{"203.0.113.0/24"}, TrustedProxies: []string{"10.0.0.0/8"}},
+		remoteAddr:     "10.0.0.2:1234",
+		forwardedFor:   "203.0.113.9, 10.0.0.7",
+		expectedStatus: http.StatusOK,
+	},
+	{
+		name:           "real ip from trusted proxy",
+		opts:           IPFilterOptions{Allow: []string{"203.0.113.0/24"}, TrustedProxies: []string{"10.0.0.2"}},
+		remoteAddr:     "10.0.0.2:1234",
+		realIP:         "203.0.113.9",
+		expectedStatus: http.StatusOK,
+	},
+	{
+		name:           "malformed forwarded header from trusted proxy",
+		opts:           IPFilterOptions{TrustedProxies: []string{"10.0.0.0/8"}},
+		remoteAddr:     "10.0.0.2:1234",
+		forwardedFor:   "not-an-ip",
+		expectedStatus: http.StatusForbidden,
+	},
+}
+
+func TestTools_IPFilter(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range ipFilterTests {
+		h := testTools.IPFilter(e.opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		req := httptest.NewRequest("POST", "/webhook", nil)
+		req.RemoteAddr = e.remoteAddr
+		if e.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", e.forwardedFor)
+		}
+		if e.realIP != "" {
+			req.Header.Set("X-Real-IP", e.realIP)
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func TestTools_IPFilterInvalidRange(t *testing.T) {
+	var testTools Tools
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected invalid CIDR range to panic")
+		}
+	}()
+
+	testTools.IPFilter(IPFilterOptions{Allow: []string{"10.0.0.0/33"}})
+}
@@ -0,0 +1,120 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// JSONFileError is returned by ReadJSONFile when a file does not hold valid JSON. Offset is the character at which
+// the problem was found. errors.Is matches the wrapped error, so ErrInvalidJSON and ErrJSONTooLarge still apply
+type JSONFileError struct {
+	Path   string
+	Offset int64
+	Err    error
+}
+
+// Error implements the error interface
+func (e *JSONFileError) Error() string {
+	return fmt.Sprintf("%s:%d: %s", e.Path, e.Offset, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *JSONFileError) Unwrap() error {
+	return e.Err
+}
+
+// ReadJSONFile decodes the JSON file at path into dst with the checks of ReadJSON. Invalid JSON gets a *JSONFileError
+func (t *Tools) ReadJSONFile(path string, dst interface{}) error {
+	maxBytes := defaultMaxJSONSize
+	if t.MaxJSONSize != 0 {
+		maxBytes = t.MaxJSONSize
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, int64(maxBytes)+1))
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", path, err)
+	}
+	if len(data) > maxBytes {
+		return &JSONFileError{Path: path, Offset: int64(maxBytes), Err: newJSONError(ErrJSONTooLarge, nil, "json.body_too_large", maxBytes)}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if !t.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+
+	if err := dec.Decode(dst); err != nil {
+		var invalidUnmarshalError *json.InvalidUnmarshalError
+		if errors.As(err, &invalidUnmarshalError) {
+			return fmt.Errorf("error unmarshaling JSON: %w", err)
+		}
+		return &JSONFileError{Path: path, Offset: jsonErrorOffset(err, dec, len(data)), Err: jsonDecodeError(err, maxBytes)}
+	}
+
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return &JSONFileError{Path: path, Offset: dec.InputOffset(), Err: newJSONError(ErrInvalidJSON, err, "json.multiple_values")}
+	}
+
+	if t.SanitizeJSON {
+		if err := t.sanitizeStruct(dst); err != nil {
+			return err
+		}
+	}
+
+	if t.ValidateJSON {
+		return t.ValidateStruct(dst)
+	}
+
+	return nil
+}
+
+// jsonErrorOffset returns the character at which dec failed with err, reading size bytes
+func jsonErrorOffset(err error, dec *json.Decoder, size int) int64 {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		return syntaxError.Offset
+	case errors.As(err, &unmarshalTypeError):
+		return unmarshalTypeError.Offset
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return int64(size)
+	default:
+		return dec.InputOffset()
+	}
+}
+
+// WriteJSONFile writes v as JSON to the file at path with WriteFileAtomic, creating its directory if necessary. The
+// JSON is indented, and ends with a newline, when indent is true
+func (t *Tools) WriteJSONFile(path string, v interface{}, perm os.FileMode, indent ...bool) error {
+	var data []byte
+	var err error
+	if len(indent) > 0 && indent[0] {
+		if data, err = json.MarshalIndent(v, "", "  "); err == nil {
+			data = append(data, '\n')
+		}
+	} else {
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := t.CreateDirIfNotExist(filepath.Dir(path)); err != nil {
+		return err
+	}
+
+	return WriteFileAtomic(path, data, perm)
+}
@@ -0,0 +1,158 @@
+package toolkit
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type jsonFileState struct {
+	Updated time.Time                 `json:"updated"`
+	Name    string                    `json:"name"`
+	Limits  map[string]map[string]int `json:"limits"`
+	Tags    map[string][]string       `json:"tags"`
+}
+
+func TestTools_JSONFileRoundTrip(t *testing.T) {
+	var testTools Tools
+	path := filepath.Join(t.TempDir(), "state", "nested", "state.json")
+
+	in := jsonFileState{
+		Updated: time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.FixedZone("CEST", 2*60*60)),
+		Name:    "app",
+		Limits:  map[string]map[string]int{"acme": {"files": 10, "bytes": 1 << 20}, "globex": {}},
+		Tags:    map[string][]string{"a": {"x", "y"}},
+	}
+
+	for _, indent := range []bool{false, true} {
+		if err := testTools.WriteJSONFile(path, in, 0640, indent); err != nil {
+			t.Fatal(err)
+		}
+
+		var out jsonFileState
+		if err := testTools.ReadJSONFile(path, &out); err != nil {
+			t.Fatal(err)
+		}
+		if !out.Updated.Equal(in.Updated) || !reflect.DeepEqual(out.Limits, in.Limits) || !reflect.DeepEqual(out.Tags, in.Tags) || out.Name != in.Name {
+			t.Errorf("indent %v: expected %+v, got %+v", indent, in, out)
+		}
+
+		data, _ := os.ReadFile(path)
+		if indented := strings.Contains(string(data), "\n  "); indented != indent {
+			t.Errorf("indent %v: unexpected file contents %s", indent, data)
+		}
+	}
+
+	if runtime.GOOS != "windows" {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("expected the file to have mode 0640, got %v", info.Mode())
+		}
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp")); len(tmp) != 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+}
+
+var readJSONFileTests = []struct {
+	name         string
+	contents     string
+	allowUnknown bool
+	maxSize      int
+	kind         error
+	offset       int64
+}{
+	{name: "valid", contents: `{"name": "app"}`},
+	{name: "syntax", contents: "{\n  \"name\": ,\n}", kind: ErrInvalidJSON, offset: 13},
+	{name: "truncated", contents: `{"name": "app"`, kind: ErrInvalidJSON, offset: 14},
+	{name: "wrong type", contents: `{"name": 1}`, kind: ErrInvalidJSON, offset: 10},
+	{name: "unknown field", contents: `{"name": "app", "other": 1}`, kind: ErrInvalidJSON},
+	{name: "unknown field allowed", contents: `{"name": "app", "other": 1}`, allowUnknown: true},
+	{name: "empty", contents: ``, kind: ErrInvalidJSON},
+	{name: "two values", contents: `{"name": "a"}{"name": "b"}`, kind: ErrInvalidJSON},
+	{name: "too large", contents: `{"name": "a long name"}`, maxSize: 10, kind: ErrJSONTooLarge, offset: 10},
+}
+
+func TestTools_ReadJSONFile(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, e := range readJSONFileTests {
+		testTools := Tools{AllowUnknownFields: e.allowUnknown, MaxJSONSize: e.maxSize}
+		path := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(path, []byte(e.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var out jsonFileState
+		err := testTools.ReadJSONFile(path, &out)
+		if e.kind == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			}
+			continue
+		}
+
+		var fileErr *JSONFileError
+		if !errors.Is(err, e.kind) || !errors.As(err, &fileErr) {
+			t.Errorf("%s: expected a JSONFileError matching %v, got %v", e.name, e.kind, err)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), path+":") {
+			t.Errorf("%s: expected the message to start with the path, got %q", e.name, err)
+		}
+		if e.offset != 0 && fileErr.Offset != e.offset {
+			t.Errorf("%s: expected offset %d, got %d", e.name, e.offset, fileErr.Offset)
+		}
+	}
+}
+
+func TestTools_JSONFileErrors(t *testing.T) {
+	var testTools Tools
+	dir := t.TempDir()
+
+	var out jsonFileState
+	if err := testTools.ReadJSONFile(filepath.Join(dir, "missing.json"), &out); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+
+	// a file where the directory should be
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := testTools.WriteJSONFile(filepath.Join(blocker, "state.json"), out, 0644); err == nil {
+		t.Error("expected an error writing below a file")
+	}
+
+	if err := testTools.WriteJSONFile(filepath.Join(dir, "bad.json"), func() {}, 0644); err == nil {
+		t.Error("expected an error for a value that can't be marshaled")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad.json")); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("expected nothing to be written for a value that can't be marshaled")
+	}
+
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced")
+	}
+
+	locked := filepath.Join(dir, "locked.json")
+	if err := testTools.WriteJSONFile(locked, out, 0000); err != nil {
+		t.Fatal(err)
+	}
+	if err := testTools.ReadJSONFile(locked, &out); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected fs.ErrPermission reading, got %v", err)
+	}
+
+	readOnly := filepath.Join(dir, "readonly")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	if err := testTools.WriteJSONFile(filepath.Join(readOnly, "state.json"), out, 0644); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected fs.ErrPermission writing, got %v", err)
+	}
+}
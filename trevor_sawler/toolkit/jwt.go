@@ -0,0 +1,349 @@
+package toolkit
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTokenExpired is returned when a JWT's exp claim is in the past
+var ErrTokenExpired error = newMessageError("auth.token_expired")
+
+// ErrTokenInvalid is returned (wrapped) for every other reason a JWT is rejected
+var ErrTokenInvalid error = newMessageError("auth.token_invalid")
+
+const jwtClaimsKey contextKey = "jwt_claims"
+
+// JWTOptions configures RequireJWT. Exactly one of Secret (HS256), PublicKey (RS256) or JWKSURL (RS256)
+// should be set. Issuer and Audience are only checked when they are not empty
+type JWTOptions struct {
+	Secret    []byte
+	PublicKey *rsa.PublicKey
+	JWKSURL   string
+
+	// JWKSCacheTTL is how long keys fetched from JWKSURL are trusted before being fetched again. Defaults to 1 hour
+	JWKSCacheTTL time.Duration
+
+	Issuer   string
+	Audience string
+
+	// Leeway allows for clock skew when checking exp and nbf
+	Leeway time.Duration
+
+	// RequireExp rejects a token without an exp claim, which is otherwise accepted as one that never expires
+	RequireExp bool
+}
+
+// Claims holds the verified claims of a JWT
+type Claims map[string]interface{}
+
+// ClaimsFromRequest returns the verified claims stored in the request context by RequireJWT
+func ClaimsFromRequest(r *http.Request) (Claims, bool) {
+	claims, ok := r.Context().Value(jwtClaimsKey).(Claims)
+	return claims, ok
+}
+
+// RequireJWT is middleware that requires a valid bearer token signed with the algorithm of opts, and sends a 401
+// otherwise. ClaimsFromRequest returns the claims
+func (t *Tools) RequireJWT(opts JWTOptions) func(http.Handler) http.Handler {
+	v := &jwtVerifier{tools: t, opts: opts}
+	if v.opts.JWKSCacheTTL == 0 {
+		v.opts.JWKSCacheTTL = time.Hour
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := ""
+			if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+				token = strings.TrimSpace(auth[7:])
+			}
+
+			if token == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				_ = t.LocalizedErrorJSON(w, r, newMessageError("auth.bearer_required"), http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := v.verify(r.Context(), token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", "Bearer error=\"invalid_token\"")
+				_ = t.LocalizedErrorJSON(w, r, err, http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), jwtClaimsKey, claims)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// jwtVerifier checks tokens for one RequireJWT middleware, caching keys fetched from a JWKS endpoint
+type jwtVerifier struct {
+	tools *Tools
+	opts  JWTOptions
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	// lastFetch is when the key set was last fetched, whether or not that succeeded
+	lastFetch time.Time
+	// fetching is the fetch of the key set in progress, or nil when there is none
+	fetching *jwksFetch
+}
+
+// jwksFetch is one fetch of the key set, shared by every request that needs it while it runs. done is closed once
+// keys and err are set
+type jwksFetch struct {
+	done chan struct{}
+	keys map[string]*rsa.PublicKey
+	err  error
+}
+
+// jwksRefetchInterval is how often, at most, the key set is fetched again for a kid it doesn't hold
+const jwksRefetchInterval = time.Minute
+
+// jwksFetchTimeout is how long a fetch of the key set may take
+const jwksFetchTimeout = 10 * time.Second
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// verify checks the signature and registered claims of token, returning its claims
+func (v *jwtVerifier) verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: token must have three parts", ErrTokenInvalid)
+	}
+
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: bad header", ErrTokenInvalid)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrTokenInvalid)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+
+	// the algorithm is decided by the configuration, never by the token, to prevent alg confusion attacks
+	switch {
+	case len(v.opts.Secret) > 0:
+		if header.Alg != "HS256" {
+			return nil, fmt.Errorf("%w: unexpected signing algorithm %q", ErrTokenInvalid, header.Alg)
+		}
+		mac := hmac.New(sha256.New, v.opts.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, fmt.Errorf("%w: signature mismatch", ErrTokenInvalid)
+		}
+	case v.opts.PublicKey != nil || v.opts.JWKSURL != "":
+		if header.Alg != "RS256" {
+			return nil, fmt.Errorf("%w: unexpected signing algorithm %q", ErrTokenInvalid, header.Alg)
+		}
+		key := v.opts.PublicKey
+		if key == nil {
+			key, err = v.jwksKey(ctx, header.Kid)
+			if err != nil {
+				return nil, err
+			}
+		}
+		hash := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+			return nil, fmt.Errorf("%w: signature mismatch", ErrTokenInvalid)
+		}
+	default:
+		return nil, fmt.Errorf("%w: no verification key configured", ErrTokenInvalid)
+	}
+
+	var claims Claims
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: bad claims", ErrTokenInvalid)
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// checkClaims validates exp, nbf, iss and aud, and that there is an exp when RequireExp is set
+func (v *jwtVerifier) checkClaims(claims Claims) error {
+	now := time.Now()
+
+	// RFC 7519 requires the current time to be before exp, so a token expires at the second exp names
+	if exp, ok := claims["exp"].(float64); ok {
+		if !now.Before(time.Unix(int64(exp), 0).Add(v.opts.Leeway)) {
+			return ErrTokenExpired
+		}
+	} else if _, present := claims["exp"]; present {
+		return fmt.Errorf("%w: exp must be a number", ErrTokenInvalid)
+	} else if v.opts.RequireExp {
+		return fmt.Errorf("%w: exp is required", ErrTokenInvalid)
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(v.opts.Leeway).Before(time.Unix(int64(nbf), 0)) {
+			return fmt.Errorf("%w: token is not valid yet", ErrTokenInvalid)
+		}
+	} else if _, present := claims["nbf"]; present {
+		return fmt.Errorf("%w: nbf must be a number", ErrTokenInvalid)
+	}
+
+	if v.opts.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.opts.Issuer {
+			return fmt.Errorf("%w: wrong issuer", ErrTokenInvalid)
+		}
+	}
+
+	if v.opts.Audience != "" {
+		found := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			found = aud == v.opts.Audience
+		case []interface{}:
+			for _, a := range aud {
+				if s, ok := a.(string); ok && s == v.opts.Audience {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: wrong audience", ErrTokenInvalid)
+		}
+	}
+
+	return nil
+}
+
+// jwksKey returns the key with the given kid, fetching the key set once, for every waiting request, when the cache
+// is stale or misses the kid. Unknown kids cause at most one fetch per jwksRefetchInterval
+func (v *jwtVerifier) jwksKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	stale := v.keys == nil || time.Since(v.fetchedAt) >= v.opts.JWKSCacheTTL
+	if key, ok := v.keys[kid]; ok && !stale {
+		v.mu.Unlock()
+		return key, nil
+	}
+	if !stale && time.Since(v.lastFetch) < jwksRefetchInterval {
+		v.mu.Unlock()
+		return nil, fmt.Errorf("%w: unknown key id %q", ErrTokenInvalid, kid)
+	}
+
+	fetch := v.fetching
+	if fetch == nil {
+		fetch = &jwksFetch{done: make(chan struct{})}
+		v.fetching = fetch
+		go v.refresh(fetch)
+	}
+	v.mu.Unlock()
+
+	select {
+	case <-fetch.done:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%w: could not fetch signing keys: %s", ErrTokenInvalid, ctx.Err())
+	}
+	if fetch.err != nil {
+		return nil, fmt.Errorf("%w: could not fetch signing keys: %s", ErrTokenInvalid, fetch.err)
+	}
+
+	key, ok := fetch.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key id %q", ErrTokenInvalid, kid)
+	}
+	return key, nil
+}
+
+// refresh runs fetch, and caches the key set it gets. The fetch doesn't run under any one request's context, as that
+// request going away would otherwise fail it for every request waiting on it
+func (v *jwtVerifier) refresh(fetch *jwksFetch) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	keys, err := v.fetchJWKS(ctx)
+
+	v.mu.Lock()
+	now := time.Now()
+	v.lastFetch = now
+	if err == nil {
+		v.keys, v.fetchedAt = keys, now
+	}
+	v.fetching = nil
+	v.mu.Unlock()
+
+	fetch.keys, fetch.err = keys, err
+	close(fetch.done)
+}
+
+// fetchJWKS downloads and parses the RSA keys published at JWKSURL
+func (v *jwtVerifier) fetchJWKS(ctx context.Context) (map[string]*rsa.PublicKey, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, v.opts.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := v.tools.httpClient().Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", response.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+// decodeJWTSegment decodes one base64url encoded JSON segment of a token
+func decodeJWTSegment(seg string, dst interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,256 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signTestJWT builds a token with the given header and claims, signed with an HMAC secret or an RSA key
+func signTestJWT(t *testing.T, header, claims map[string]interface{}, secret []byte, key *rsa.PrivateKey) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+
+	var sig []byte
+	switch {
+	case secret != nil:
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case key != nil:
+		hash := sha256.Sum256([]byte(signed))
+		var err error
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestTools_RequireJWT(t *testing.T) {
+	secret := []byte("shared-secret")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Unix()
+	good := map[string]interface{}{"sub": "user-1", "iss": "idp", "aud": "api", "exp": now + 60, "nbf": now - 60}
+	expired := map[string]interface{}{"sub": "user-1", "iss": "idp", "aud": "api", "exp": now - 60}
+	expiresNow := map[string]interface{}{"sub": "user-1", "iss": "idp", "aud": "api", "exp": now}
+	noExp := map[string]interface{}{"sub": "user-1", "iss": "idp", "aud": "api"}
+	future := map[string]interface{}{"sub": "user-1", "iss": "idp", "aud": "api", "nbf": now + 600}
+	wrongAud := map[string]interface{}{"sub": "user-1", "iss": "idp", "aud": []string{"other", "admin"}, "exp": now + 60}
+	listAud := map[string]interface{}{"sub": "user-1", "iss": "idp", "aud": []string{"other", "api"}, "exp": now + 60}
+	wrongIss := map[string]interface{}{"sub": "user-1", "iss": "evil", "aud": "api", "exp": now + 60}
+
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	rs := map[string]interface{}{"alg": "RS256", "typ": "JWT", "kid": "k1"}
+	none := map[string]interface{}{"alg": "none", "typ": "JWT"}
+
+	// the public key bytes as an HMAC secret, used to try an RS256 -> HS256 confusion attack
+	pubAsSecret := key.PublicKey.N.Bytes()
+
+	hsOpts := JWTOptions{Secret: secret, Issuer: "idp", Audience: "api"}
+	rsOpts := JWTOptions{PublicKey: &key.PublicKey, Issuer: "idp", Audience: "api"}
+
+	var tests = []struct {
+		name           string
+		opts           JWTOptions
+		token          string
+		expectedStatus int
+		expectedMsg    string
+	}{
+		{name: "missing token", opts: hsOpts, token: "", expectedStatus: http.StatusUnauthorized, expectedMsg: "bearer token required"},
+		{name: "garbage token", opts: hsOpts, token: "not-a-token", expectedStatus: http.StatusUnauthorized, expectedMsg: "invalid token"},
+		{name: "valid hs256", opts: hsOpts, token: signTestJWT(t, hs, good, secret, nil), expectedStatus: http.StatusOK},
+		{name: "valid rs256", opts: rsOpts, token: signTestJWT(t, rs, good, nil, key), expectedStatus: http.StatusOK},
+		{name: "audience in list", opts: hsOpts, token: signTestJWT(t, hs, listAud, secret, nil), expectedStatus: http.StatusOK},
+		{name: "expired", opts: hsOpts, token: signTestJWT(t, hs, expired, secret, nil), expectedStatus: http.StatusUnauthorized, expectedMsg: "token has expired"},
+		{name: "expired within leeway", opts: JWTOptions{Secret: secret, Leeway: 2 * time.Minute}, token: signTestJWT(t, hs, expired, secret, nil), expectedStatus: http.StatusOK},
+		{name: "expires this second", opts: hsOpts, token: signTestJWT(t, hs, expiresNow, secret, nil), expectedStatus: http.StatusUnauthorized, expectedMsg: "token has expired"},
+		{name: "no exp", opts: hsOpts, token: signTestJWT(t, hs, noExp, secret, nil), expectedStatus: http.StatusOK},
+		{name: "no exp when required", opts: JWTOptions{Secret: secret, RequireExp: true}, token: signTestJWT(t, hs, noExp, secret, nil), expectedStatus: http.StatusUnauthorized, expectedMsg: "invalid token: exp is required"},
+		{name: "exp when required", opts: JWTOptions{Secret: secret, RequireExp: true}, token: signTestJWT(t, hs, good, secret, nil), expectedStatus: http.StatusOK},
+		{name: "not yet valid", opts: hsOpts, token: signTestJWT(t, hs, future, secret, nil), expectedStatus: http.StatusUnauthorized, expectedMsg: "invalid token"},
+		{name: "wrong audience", opts: hsOpts, token: signTestJWT(t, hs, wrongAud, secret, nil), expectedStatus: http.StatusUnauthorized, expectedMsg: "invalid token: wrong audience"},
+		{name: "wrong issuer", opts: hsOpts, token: signTestJWT(t, hs, wrongIss, secret, nil), expectedStatus: http.StatusUnauthorized, expectedMsg: "invalid token: wrong issuer"},
+		{name: "bad hmac signature", opts: hsOpts, token: signTestJWT(t, hs, good, []byte("other"), nil), expectedStatus: http.StatusUnauthorized, expectedMsg: "invalid token: signature mismatch"},
+		{name: "bad rsa signature", opts: rsOpts, token: signTestJWT(t, rs, good, nil, otherKey), expectedStatus: http.StatusUnauthorized, expectedMsg: "invalid token: signature mismatch"},
+		{name: "alg none", opts: hsOpts, token: signTestJWT(t, none, good, nil, nil), expectedStatus: http.StatusUnauthorized, expectedMsg: "invalid token: unexpected signing algorithm"},
+		{name: "alg confusion", opts: rsOpts, token: signTestJWT(t, hs, good, pubAsSecret, nil), expectedStatus: http.StatusUnauthorized, expectedMsg: "invalid token: unexpected signing algorithm"},
+	}
+
+	var testTools Tools
+
+	for _, e := range tests {
+		var gotClaims Claims
+		h := testTools.RequireJWT(e.opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotClaims, _ = ClaimsFromRequest(r)
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		if e.token != "" {
+			req.Header.Set("Authorization", "Bearer "+e.token)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if e.expectedStatus == http.StatusOK {
+			if gotClaims["sub"] != "user-1" {
+				t.Errorf("%s: expected claims in context, got %v", e.name, gotClaims)
+			}
+			continue
+		}
+
+		var payload JSONResponse
+		if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+			t.Errorf("%s: could not decode JSON error: %s", e.name, err)
+		}
+		if !strings.HasPrefix(payload.Message, e.expectedMsg) {
+			t.Errorf("%s: expected message starting with %q but got %q", e.name, e.expectedMsg, payload.Message)
+		}
+	}
+}
+
+// testJWKS returns a key set holding the public part of key as k1
+func testJWKS(key *rsa.PrivateKey) []byte {
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}},
+	})
+	return jwks
+}
+
+func TestTools_RequireJWTWithJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := testJWKS(key)
+
+	fetches := 0
+	testTools := Tools{
+		HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+			fetches++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader(jwks)),
+				Header:     make(http.Header),
+			}
+		}),
+	}
+
+	h := testTools.RequireJWT(JWTOptions{JWKSURL: "http://idp.example.com/jwks.json"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	claims := map[string]interface{}{"sub": "user-1", "exp": time.Now().Unix() + 60}
+	for _, kid := range []string{"k1", "k1", "unknown"} {
+		token := signTestJWT(t, map[string]interface{}{"alg": "RS256", "kid": kid}, claims, nil, key)
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if kid == "k1" && rr.Code != http.StatusOK {
+			t.Errorf("expected status 200 for known key but got %d", rr.Code)
+		}
+		if kid == "unknown" && rr.Code != http.StatusUnauthorized {
+			t.Errorf("expected status 401 for unknown key but got %d", rr.Code)
+		}
+	}
+
+	if fetches != 1 {
+		t.Errorf("expected key set to be fetched once, but it was fetched %d times", fetches)
+	}
+}
+
+func TestTools_RequireJWTSharesJWKSFetch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := testJWKS(key)
+
+	var fetches int32
+	started, release := make(chan struct{}), make(chan struct{})
+	testTools := Tools{
+		HTTPClient: NewTestClient(func(req *http.Request) *http.Response {
+			if atomic.AddInt32(&fetches, 1) == 1 {
+				close(started)
+			}
+			<-release
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(jwks)), Header: make(http.Header)}
+		}),
+	}
+	h := testTools.RequireJWT(JWTOptions{JWKSURL: "http://idp.example.com/jwks.json"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	token := signTestJWT(t, map[string]interface{}{"alg": "RS256", "kid": "k1"}, map[string]interface{}{"exp": time.Now().Unix() + 60}, nil, key)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			codes[i] = rr.Code
+		}(i)
+	}
+	<-started
+
+	// while the key set is being fetched, a request that gives up doesn't wait for it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for a cancelled request but got %d", rr.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: expected status 200 but got %d", i, code)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected the requests to share one fetch of the key set, got %d", n)
+	}
+}
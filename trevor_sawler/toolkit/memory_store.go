@@ -0,0 +1,113 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a FileStore that keeps files in memory, for tests. The zero value is ready to use, and it is safe
+// for concurrent use
+type MemoryStore struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// Save implements FileStore. Nothing is stored when reading r fails
+func (s *MemoryStore) Save(ctx context.Context, name string, r io.Reader) (int64, error) {
+	if !storedPath(name) {
+		return 0, ErrInvalidFileName
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, r)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = make(map[string][]byte)
+	}
+	s.files[name] = buf.Bytes()
+	return n, nil
+}
+
+// SaveNew implements ExclusiveFileStore
+func (s *MemoryStore) SaveNew(ctx context.Context, r io.Reader, next func() string) (string, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := next(); name != ""; name = next() {
+		if !storedPath(name) {
+			return "", 0, ErrInvalidFileName
+		}
+		if _, taken := s.files[name]; taken {
+			continue
+		}
+		if s.files == nil {
+			s.files = make(map[string][]byte)
+		}
+		s.files[name] = buf.Bytes()
+		return name, n, nil
+	}
+	return "", 0, fs.ErrExist
+}
+
+// Exists implements FileStore
+func (s *MemoryStore) Exists(ctx context.Context, name string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.files[name]
+	return ok, nil
+}
+
+// Remove implements FileStore. Removing a file that isn't stored fails with fs.ErrNotExist, as it does for DiskStore
+func (s *MemoryStore) Remove(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(s.files, name)
+	return nil
+}
+
+// File returns a copy of the content stored under name, and whether there is a file of that name
+func (s *MemoryStore) File(name string) ([]byte, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.files[name]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), data...), true
+}
+
+// Names returns the names of the stored files, sorted
+func (s *MemoryStore) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
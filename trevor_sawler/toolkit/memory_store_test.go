@@ -0,0 +1,130 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	var store MemoryStore
+
+	if names := store.Names(); len(names) != 0 {
+		t.Errorf("expected a zero value store to be empty, got %v", names)
+	}
+
+	n, err := store.Save(ctx, "a.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("expected 5 bytes to be saved, got %d", n)
+	}
+
+	content, ok := store.File("a.txt")
+	if !ok || string(content) != "hello" {
+		t.Errorf("expected a.txt to hold %q, got %q, %t", "hello", content, ok)
+	}
+	// the content returned is a copy, so changing it leaves the store as it was
+	content[0] = 'j'
+	if content, _ := store.File("a.txt"); string(content) != "hello" {
+		t.Errorf("expected a.txt to be unchanged, got %q", content)
+	}
+	if _, ok := store.File("missing.txt"); ok {
+		t.Error("expected missing.txt not to be found")
+	}
+
+	// a failed save stores nothing
+	failing := &failingReader{r: strings.NewReader("hello world"), after: 5, check: func() {}}
+	if _, err := store.Save(ctx, "b.txt", failing); err == nil {
+		t.Error("expected the failing reader's error, got none")
+	}
+
+	for _, name := range []string{"../escape.txt", "sub/../b.txt", "/abs.txt", "sub//b.txt", `sub\b.txt`, "", ".."} {
+		if _, err := store.Save(ctx, name, strings.NewReader("x")); !errors.Is(err, ErrInvalidFileName) {
+			t.Errorf("%q: expected ErrInvalidFileName, got %v", name, err)
+		}
+	}
+
+	if names := store.Names(); !reflect.DeepEqual(names, []string{"a.txt"}) {
+		t.Errorf("expected only a.txt to be stored, got %v", names)
+	}
+
+	if err := store.Remove(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := store.Exists(ctx, "a.txt"); err != nil || exists {
+		t.Errorf("expected a.txt to be gone, got %t, %v", exists, err)
+	}
+	if err := store.Remove(ctx, "a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected removing a missing file to fail with fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestMemoryStore_ContentHash(t *testing.T) {
+	testTools := Tools{NamingStrategy: NamingContentHash}
+	img := readTestFile(t, "img.png")
+
+	var store MemoryStore
+	for i, expected := range []bool{false, true} {
+		req := newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: img})
+		uploadedFiles, err := testTools.UploadFilesTo(req, &store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uploadedFiles[0].Deduplicated != expected {
+			t.Errorf("upload %d: expected Deduplicated to be %t", i+1, expected)
+		}
+	}
+
+	if names := store.Names(); len(names) != 1 {
+		t.Errorf("expected the file to be stored once, got %v", names)
+	}
+}
+
+// TestMemoryStore_ConcurrentUploads shares one MemoryStore between uploads running at once; run it with -race
+func TestMemoryStore_ConcurrentUploads(t *testing.T) {
+	var testTools Tools
+	var store MemoryStore
+	img := readTestFile(t, "img.png")
+
+	const uploads = 8
+	type result struct {
+		req   *http.Request
+		files []*UploadedFile
+		err   error
+	}
+	reqs := make([]*result, uploads)
+	for i := range reqs {
+		reqs[i] = &result{req: newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: img})}
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range reqs {
+		wg.Add(1)
+		go func(r *result) {
+			defer wg.Done()
+			r.files, r.err = testTools.UploadFilesTo(r.req, &store)
+		}(r)
+	}
+	wg.Wait()
+
+	for _, r := range reqs {
+		if r.err != nil {
+			t.Error(r.err)
+			continue
+		}
+		if content, ok := store.File(r.files[0].NewFileName); !ok || len(content) != len(img) {
+			t.Errorf("expected %s to hold the uploaded file", r.files[0].NewFileName)
+		}
+	}
+	if names := store.Names(); len(names) != uploads {
+		t.Errorf("expected %d files to be stored, got %d", uploads, len(names))
+	}
+}
@@ -0,0 +1,91 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strings"
+	"time"
+)
+
+// metadataSuffix is added to the name of an uploaded file to name its metadata sidecar, as in img.png.meta.json
+const metadataSuffix = ".meta.json"
+
+// maxMetadataFormValues is the most bytes of form values StreamUploadFiles and UploadFiles keep, for the metadata
+// sidecars and r.Form, as ParseMultipartForm would
+const maxMetadataFormValues = 10 << 20
+
+// UploadMetadata is what the metadata sidecar of an uploaded file, written with WriteMetadata, holds as JSON
+type UploadMetadata struct {
+	OriginalFileName string                 `json:"original_file_name"`
+	StoredPath       string                 `json:"stored_path"`
+	FieldName        string                 `json:"field_name,omitempty"`
+	FileSize         int64                  `json:"file_size"`
+	ContentType      string                 `json:"content_type"`
+	SHA256           string                 `json:"sha256"`
+	FormValues       map[string][]string    `json:"form_values,omitempty"`
+	Extra            map[string]interface{} `json:"extra,omitempty"`
+	UploadedAt       time.Time              `json:"uploaded_at"`
+}
+
+// isMetadataSidecar reports whether the file called name is the metadata sidecar of an uploaded file
+func isMetadataSidecar(name string) bool {
+	return strings.HasSuffix(name, metadataSuffix)
+}
+
+// writeMetadata saves the metadata sidecar of each of uploadedFiles next to it, with the form's text fields
+func (t *Tools) writeMetadata(ctx context.Context, store FileStore, uploadedFiles []*UploadedFile, formValues map[string][]string) error {
+	if !t.WriteMetadata {
+		return nil
+	}
+
+	uploadedAt := time.Now().UTC()
+	for _, f := range uploadedFiles {
+		if f.Deduplicated {
+			continue
+		}
+
+		metadata := UploadMetadata{
+			OriginalFileName: f.OriginalFileName,
+			StoredPath:       f.StoredPath,
+			FieldName:        f.FieldName,
+			FileSize:         f.FileSize,
+			ContentType:      f.ContentType,
+			SHA256:           f.SHA256,
+			FormValues:       formValues,
+			UploadedAt:       uploadedAt,
+		}
+		if t.MetadataFunc != nil {
+			metadata.Extra = t.MetadataFunc(f)
+		}
+
+		data, err := json.MarshalIndent(metadata, "", "  ")
+		if err != nil {
+			return fmt.Errorf("could not encode metadata of uploaded file %q: %w", f.OriginalFileName, err)
+		}
+		name := f.StoredPath + metadataSuffix
+		if _, err := store.Save(ctx, name, bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("could not save metadata of uploaded file %q: %w", f.OriginalFileName, err)
+		}
+		f.MetadataPath = name
+	}
+	return nil
+}
+
+// readFormValue adds the value of the text field part to values, as StreamUploadFiles reads them for the metadata
+// sidecars, and returns the bytes of form values left to read
+func readFormValue(part *multipart.Part, values map[string][]string, left int64) (int64, error) {
+	var value bytes.Buffer
+	n, err := io.Copy(&value, io.LimitReader(part, left+1))
+	if err != nil {
+		return left, err
+	}
+	if n > left {
+		return left, fmt.Errorf("form values are larger than %d bytes", maxMetadataFormValues)
+	}
+	values[part.FormName()] = append(values[part.FormName()], value.String())
+	return left - n, nil
+}
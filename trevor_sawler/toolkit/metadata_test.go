@@ -0,0 +1,132 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sidecarFailingStore is a MemoryStore that fails with err to save the metadata sidecar of the file fail
+type sidecarFailingStore struct {
+	MemoryStore
+	fail string
+	err  error
+}
+
+func (s *sidecarFailingStore) Save(ctx context.Context, name string, r io.Reader) (int64, error) {
+	if name == s.fail+".meta.json" {
+		return 0, s.err
+	}
+	return s.MemoryStore.Save(ctx, name, r)
+}
+
+func TestTools_UploadFilesMetadata(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	sum := sha256.Sum256(img)
+
+	testTools := Tools{
+		SubdirStrategy: SubdirDate,
+		WriteMetadata:  true,
+		MetadataFunc: func(f *UploadedFile) map[string]interface{} {
+			return map[string]interface{}{"uploader": "alice", "name": f.OriginalFileName}
+		},
+	}
+
+	for name, upload := range uploadFuncs {
+		dir := t.TempDir()
+		req := newUploadRequest(t, testUpload{field: "album", content: []byte("holiday")}, testUpload{"photo", "img.png", img}, testUpload{"photo", "copy.png", img})
+		files, err := upload(&testTools, req, dir)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+
+		for _, f := range files {
+			if f.MetadataPath != f.StoredPath+".meta.json" {
+				t.Errorf("%s: expected the metadata path %s.meta.json, got %q", name, f.StoredPath, f.MetadataPath)
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(f.MetadataPath)))
+			if err != nil {
+				t.Errorf("%s: expected the metadata sidecar to be written: %s", name, err)
+				continue
+			}
+			var metadata UploadMetadata
+			if err := json.Unmarshal(data, &metadata); err != nil {
+				t.Errorf("%s: could not decode the metadata sidecar: %s", name, err)
+				continue
+			}
+
+			if metadata.OriginalFileName != f.OriginalFileName || metadata.StoredPath != f.StoredPath || metadata.FieldName != "photo" ||
+				metadata.FileSize != int64(len(img)) || metadata.ContentType != "image/png" || metadata.UploadedAt.IsZero() {
+				t.Errorf("%s: unexpected metadata %+v for %+v", name, metadata, f)
+			}
+			if metadata.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("%s: expected the checksum %x, got %q", name, sum, metadata.SHA256)
+			}
+			if !reflect.DeepEqual(metadata.FormValues, map[string][]string{"album": {"holiday"}}) {
+				t.Errorf("%s: expected the form values to be recorded, got %v", name, metadata.FormValues)
+			}
+			if metadata.Extra["uploader"] != "alice" || metadata.Extra["name"] != f.OriginalFileName {
+				t.Errorf("%s: expected the fields from MetadataFunc, got %v", name, metadata.Extra)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesMetadataRollback(t *testing.T) {
+	img := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		uploads  []testUpload
+		expected error
+	}{
+		{
+			name:     "later file refused",
+			tools:    Tools{WriteMetadata: true, AllowedFileTypes: []string{"image/png"}},
+			uploads:  []testUpload{{"file", "img.png", img}, {"file", "notes.txt", []byte("not an image")}},
+			expected: ErrFileTypeNotPermitted,
+		},
+		{
+			name:     "name of a sidecar",
+			tools:    Tools{WriteMetadata: true},
+			uploads:  []testUpload{{"file", "img.png", img}, {"file", "img.png.meta.json", []byte(`{}`)}},
+			expected: ErrInvalidFileName,
+		},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			_, err := upload(&e.tools, newUploadRequest(t, e.uploads...), dir, false)
+			if !errors.Is(err, e.expected) {
+				t.Errorf("%s, %s: expected %v, got %v", e.name, name, e.expected, err)
+			}
+			if remaining := remainingFiles(t, dir); remaining != nil {
+				t.Errorf("%s, %s: expected no files or sidecars to be left, got %v", e.name, name, remaining)
+			}
+		}
+	}
+
+	// a sidecar that can't be written undoes the upload, the sidecars already written included
+	testTools := Tools{WriteMetadata: true, NamingStrategy: NamingOriginal}
+	saveErr := errors.New("disk full")
+	store := sidecarFailingStore{fail: "pic.png", err: saveErr}
+	req := newUploadRequest(t, testUpload{"file", "img.png", img}, testUpload{"file", "pic.png", img})
+	_, err := testTools.UploadFilesTo(req, &store)
+	if !errors.Is(err, saveErr) {
+		t.Errorf("expected the error saving the sidecar, got %v", err)
+	}
+	if names := store.Names(); len(names) != 0 {
+		t.Errorf("expected nothing to be left in the store, got %v", names)
+	}
+}
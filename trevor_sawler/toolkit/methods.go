@@ -0,0 +1,56 @@
+package toolkit
+
+import (
+	"net/http"
+	"strings"
+)
+
+// AllowMethods wraps h so that it is only called for the given HTTP methods, and sends a 405 with an Allow header
+// for any other. HEAD is permitted whenever GET is
+func (t *Tools) AllowMethods(h http.HandlerFunc, methods ...string) http.HandlerFunc {
+	return t.AllowMethodsMiddleware(methods...)(h).ServeHTTP
+}
+
+// AllowMethodsMiddleware is the middleware form of AllowMethods
+func (t *Tools) AllowMethodsMiddleware(methods ...string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	var list []string
+
+	add := func(m string) {
+		if !allowed[m] {
+			allowed[m] = true
+			list = append(list, m)
+		}
+	}
+
+	for _, m := range methods {
+		m = strings.ToUpper(m)
+		add(m)
+		if m == http.MethodGet {
+			add(http.MethodHead)
+		}
+	}
+
+	handleOptions := !allowed[http.MethodOptions]
+	add(http.MethodOptions)
+
+	allow := strings.Join(list, ", ")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions && handleOptions {
+				w.Header().Set("Allow", allow)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if !allowed[r.Method] {
+				w.Header().Set("Allow", allow)
+				_ = t.LocalizedErrorJSON(w, r, newMessageError("request.method_not_allowed", r.Method), http.StatusMethodNotAllowed)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
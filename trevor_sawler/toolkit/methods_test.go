@@ -0,0 +1,62 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var allowMethodsTests = []struct {
+	name           string
+	methods        []string
+	method         string
+	expectedStatus int
+	expectedAllow  string
+	handlerCalled  bool
+}{
+	{name: "allowed post", methods: []string{"POST"}, method: "POST", expectedStatus: http.StatusOK, handlerCalled: true},
+	{name: "lowercase method list", methods: []string{"post"}, method: "POST", expectedStatus: http.StatusOK, handlerCalled: true},
+	{name: "get not allowed", methods: []string{"POST"}, method: "GET", expectedStatus: http.StatusMethodNotAllowed, expectedAllow: "POST, OPTIONS"},
+	{name: "head implied by get", methods: []string{"GET"}, method: "HEAD", expectedStatus: http.StatusOK, handlerCalled: true},
+	{name: "head not implied by post", methods: []string{"POST"}, method: "HEAD", expectedStatus: http.StatusMethodNotAllowed, expectedAllow: "POST, OPTIONS"},
+	{name: "several methods", methods: []string{"GET", "PUT"}, method: "DELETE", expectedStatus: http.StatusMethodNotAllowed, expectedAllow: "GET, HEAD, PUT, OPTIONS"},
+	{name: "automatic options", methods: []string{"GET", "POST"}, method: "OPTIONS", expectedStatus: http.StatusNoContent, expectedAllow: "GET, HEAD, POST, OPTIONS"},
+	{name: "explicit options", methods: []string{"OPTIONS", "POST"}, method: "OPTIONS", expectedStatus: http.StatusOK, handlerCalled: true},
+}
+
+func TestTools_AllowMethods(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range allowMethodsTests {
+		called := false
+		h := testTools.AllowMethods(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}, e.methods...)
+
+		rr := httptest.NewRecorder()
+		h(rr, httptest.NewRequest(e.method, "/", nil))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+
+		if called != e.handlerCalled {
+			t.Errorf("%s: expected handler called to be %t", e.name, e.handlerCalled)
+		}
+
+		if rr.Header().Get("Allow") != e.expectedAllow {
+			t.Errorf("%s: expected Allow header %q but got %q", e.name, e.expectedAllow, rr.Header().Get("Allow"))
+		}
+
+		if e.expectedStatus == http.StatusMethodNotAllowed {
+			var payload JSONResponse
+			if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+				t.Errorf("%s: could not decode JSON error: %s", e.name, err)
+			}
+			if !payload.Error || payload.Message != "method "+e.method+" not allowed" {
+				t.Errorf("%s: wrong JSON error: %+v", e.name, payload)
+			}
+		}
+	}
+}
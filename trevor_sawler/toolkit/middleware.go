@@ -0,0 +1,175 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+)
+
+const requestIDKey contextKey = "request_id"
+
+// validRequestID matches request IDs we are willing to accept from a client or upstream proxy
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Chain composes middlewares into a single middleware. The first one listed is the outermost, so
+// Chain(a, b, c)(h) is the same as a(b(c(h))): a request passes through a, then b, then c, before reaching h
+func Chain(middlewares ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](h)
+		}
+		return h
+	}
+}
+
+// DefaultMiddleware returns the recommended chain for an API: RequestID, then LogRequests, then Recoverer,
+// then SecureHeaders with its default options
+func (t *Tools) DefaultMiddleware() func(http.Handler) http.Handler {
+	return Chain(
+		t.RequestID,
+		t.LogRequests,
+		t.Recoverer,
+		t.SecureHeaders(SecureHeaderOptions{}),
+	)
+}
+
+// RequestID is middleware that reuses a well-formed X-Request-ID header, or generates one, and echoes it. It is
+// available to downstream handlers via RequestIDFromContext
+func (t *Tools) RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequestIDFromContext returns the request ID stored by RequestID, or an empty string if there is none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// newRequestID returns 16 random bytes, hex encoded
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LogRequests is middleware that writes one line per request to the Logger, with the method, path, status,
+// response size, duration and request ID
+func (t *Tools) LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+
+		defer func() {
+			status := sw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			t.logger().Printf("%s %s %d %dB %s request_id=%s", r.Method, r.URL.Path, status, sw.bytes, time.Since(start), RequestIDFromContext(r.Context()))
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// Recoverer is middleware that logs a panic in the next handler and sends a 500, or aborts the response when it
+// has already started. http.ErrAbortHandler is re-panicked
+func (t *Tools) Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if err, ok := rec.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(rec)
+			}
+
+			t.logger().Printf("panic: %v request_id=%s\n%s", rec, RequestIDFromContext(r.Context()), debug.Stack())
+			if sw.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			_ = t.LocalizedErrorJSON(w, r, newMessageError("request.internal_error"), http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// statusWriter records the status code and number of bytes written, for logging, and so that Recoverer knows whether
+// a response has been started
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(b)
+	sw.bytes += n
+	return n, err
+}
+
+// Flush implements http.Flusher when the underlying writer does
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Mux is a http.ServeMux that wraps every handler registered on it with the same middleware chain
+type Mux struct {
+	mux   *http.ServeMux
+	chain func(http.Handler) http.Handler
+}
+
+// NewMux returns a Mux applying middlewares, in Chain order, to every route. With no middlewares, the chain
+// from DefaultMiddleware is used
+func (t *Tools) NewMux(middlewares ...func(http.Handler) http.Handler) *Mux {
+	chain := t.DefaultMiddleware()
+	if len(middlewares) > 0 {
+		chain = Chain(middlewares...)
+	}
+
+	return &Mux{mux: http.NewServeMux(), chain: chain}
+}
+
+// Handle registers h for pattern, wrapped in the Mux's middleware chain
+func (m *Mux) Handle(pattern string, h http.Handler) {
+	m.mux.Handle(pattern, m.chain(h))
+}
+
+// HandleFunc registers h for pattern, wrapped in the Mux's middleware chain
+func (m *Mux) HandleFunc(pattern string, h func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(h))
+}
+
+// ServeHTTP implements http.Handler
+func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,165 @@
+package toolkit
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recordingMiddleware returns a middleware that appends name to order when a request passes through it
+func recordingMiddleware(name string, order *[]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			*order = append(*order, name+":before")
+			next.ServeHTTP(w, r)
+			*order = append(*order, name+":after")
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+
+	h := Chain(
+		recordingMiddleware("first", &order),
+		recordingMiddleware("second", &order),
+		recordingMiddleware("third", &order),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	expected := []string{
+		"first:before", "second:before", "third:before",
+		"handler",
+		"third:after", "second:after", "first:after",
+	}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("wrong execution order: %v", order)
+	}
+}
+
+func TestTools_RequestID(t *testing.T) {
+	var testTools Tools
+
+	var seen string
+	h := testTools.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+	}))
+
+	var requestIDTests = []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{name: "generated", incoming: "", reused: false},
+		{name: "reused", incoming: "abc-123", reused: true},
+		{name: "rejected", incoming: "bad id\nwith newline", reused: false},
+		{name: "too long", incoming: strings.Repeat("a", 65), reused: false},
+	}
+
+	for _, e := range requestIDTests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if e.incoming != "" {
+			req.Header.Set("X-Request-ID", e.incoming)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if seen == "" || rr.Header().Get("X-Request-ID") != seen {
+			t.Errorf("%s: expected request id in context and header, got %q and %q", e.name, seen, rr.Header().Get("X-Request-ID"))
+		}
+		if e.reused != (seen == e.incoming) {
+			t.Errorf("%s: expected reused to be %t, got id %q", e.name, e.reused, seen)
+		}
+	}
+}
+
+func TestTools_DefaultMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	testTools := Tools{Logger: log.New(&buf, "", 0)}
+
+	mux := testTools.NewMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/ok", nil))
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "ok" {
+		t.Errorf("unexpected response %d %s", rr.Code, rr.Body.String())
+	}
+	id := rr.Header().Get("X-Request-ID")
+	if id == "" {
+		t.Error("expected a request id header")
+	}
+	if rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("expected security headers")
+	}
+	if !strings.Contains(buf.String(), "GET /ok 200 2B") || !strings.Contains(buf.String(), "request_id="+id) {
+		t.Errorf("unexpected log output: %s", buf.String())
+	}
+
+	buf.Reset()
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/panic", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected status 500 after panic, got %d", rr.Code)
+	}
+	if !strings.Contains(buf.String(), "panic: boom") || !strings.Contains(buf.String(), "GET /panic 500") {
+		t.Errorf("expected panic and request to be logged, got: %s", buf.String())
+	}
+}
+
+func TestTools_NewMuxCustomChain(t *testing.T) {
+	var testTools Tools
+	var order []string
+
+	mux := testTools.NewMux(recordingMiddleware("outer", &order), recordingMiddleware("inner", &order))
+	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	}))
+
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/anything", nil))
+
+	expected := []string{"outer:before", "inner:before", "handler", "inner:after", "outer:after"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("wrong execution order: %v", order)
+	}
+}
+
+func TestTools_RecovererAfterResponseStarted(t *testing.T) {
+	var buf bytes.Buffer
+	testTools := Tools{Logger: log.New(&buf, "", 0)}
+	h := testTools.Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("partial"))
+		panic("boom")
+	}))
+
+	rr := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if rec := recover(); rec != http.ErrAbortHandler {
+				t.Errorf("expected the response to be aborted with http.ErrAbortHandler, got %v", rec)
+			}
+		}()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	}()
+
+	if rr.Code != http.StatusOK || rr.Body.String() != "partial" {
+		t.Errorf("expected nothing to be added to the response, got %d %q", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(buf.String(), "panic: boom") {
+		t.Errorf("expected the panic to be logged, got: %s", buf.String())
+	}
+}
@@ -0,0 +1,277 @@
+package toolkit
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// extendedFileName finds the filename* parameter of a Content-Disposition header, whose value is never quoted by
+// RFC 5987 but is by some clients, and can't hold a semicolon or a quote
+var extendedFileName = regexp.MustCompile(`(?i);\s*filename\*\s*=\s*"?([^";\s]*)`)
+
+// uploadForm is the multipart form of an upload: its files, in the order they were posted, and its other fields
+type uploadForm struct {
+	files  []formFile
+	values map[string][]string
+	// spooled holds the temporary files in TempDir that readUploadForm spooled files to
+	spooled []string
+	// skipped is how many files readUploadForm counted past the limit it was given but didn't read
+	skipped int
+}
+
+// release removes the temporary files that readUploadForm spooled the files of the form to
+func (f *uploadForm) release() {
+	for _, path := range f.spooled {
+		os.Remove(path)
+	}
+}
+
+// formFile is a file of a multipart form, with the form field it was posted under, its name, as formFileName or
+// partFileName finds it, and its size. open opens its content
+type formFile struct {
+	field string
+	name  string
+	size  int64
+	open  func() (multipart.File, error)
+}
+
+// headerFormFile returns the file of a form ParseMultipartForm read, posted under field and described by hdr
+func headerFormFile(field string, hdr *multipart.FileHeader) formFile {
+	return formFile{field: field, name: formFileName(hdr), size: hdr.Size, open: hdr.Open}
+}
+
+// memoryFormFile is a file of a multipart form held in memory, as a multipart.File
+type memoryFormFile struct {
+	*bytes.Reader
+}
+
+func (memoryFormFile) Close() error {
+	return nil
+}
+
+// openMemoryFormFile returns the open function of a formFile whose content, data, is held in memory
+func openMemoryFormFile(data []byte) func() (multipart.File, error) {
+	return func() (multipart.File, error) {
+		return memoryFormFile{bytes.NewReader(data)}, nil
+	}
+}
+
+// newUploadForm returns the files and values of form, a form the caller parsed before the upload
+func newUploadForm(form *multipart.Form) *uploadForm {
+	upload := &uploadForm{values: form.Value}
+	for _, field := range sortedFormFields(form) {
+		for _, hdr := range form.File[field] {
+			upload.files = append(upload.files, headerFormFile(field, hdr))
+		}
+	}
+	return upload
+}
+
+// maxFormParts is the most parts of a multipart form readUploadForm reads, as ParseMultipartForm allows
+const maxFormParts = 1000
+
+// formFileLimit bounds the files readUploadForm reads of a form
+type formFileLimit struct {
+	// accept reports whether a file posted under field is read; the others are skipped without being read
+	accept func(field string) bool
+	// max is the most files that are read, or zero for no limit
+	max int
+	// count, when set, has the accepted files past max counted in uploadForm.skipped but skipped, rather than
+	// refused with a *TooManyFilesError
+	count bool
+}
+
+// readUploadForm reads the multipart form of r like ParseMultipartForm, but spools to TempDir only the files limit
+// accepts, in the order they were posted. The values are added to r.Form and r.PostForm
+func (t *Tools) readUploadForm(r *http.Request, maxMemory int64, limit formFileLimit) (*uploadForm, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := &uploadForm{values: make(map[string][]string)}
+	valuesLeft := int64(maxMetadataFormValues)
+	memoryLeft := maxMemory
+	for parts := 0; ; parts++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err == nil && parts == maxFormParts {
+			err = multipart.ErrMessageTooLarge
+		}
+		if err != nil {
+			form.release()
+			return nil, err
+		}
+
+		switch {
+		case part.FormName() == "":
+			err = nil
+		case partFileName(part) == "":
+			valuesLeft, err = readFormValue(part, form.values, valuesLeft)
+		case limit.accept != nil && !limit.accept(part.FormName()):
+			err = nil
+		case limit.max > 0 && len(form.files) == limit.max && limit.count:
+			form.skipped++
+		case limit.max > 0 && len(form.files) == limit.max:
+			err = &TooManyFilesError{Limit: limit.max}
+		default:
+			var file formFile
+			if file, err = t.spoolFormFile(part, &memoryLeft, form); err == nil {
+				form.files = append(form.files, file)
+			}
+		}
+		part.Close()
+		if err != nil {
+			form.release()
+			return nil, err
+		}
+	}
+
+	if r.Form == nil {
+		// for a multipart request, ParseForm only parses the query string
+		if err := r.ParseForm(); err != nil {
+			form.release()
+			return nil, err
+		}
+	}
+	if r.PostForm == nil {
+		r.PostForm = make(map[string][]string)
+	}
+	for name, values := range form.values {
+		r.Form[name] = append(r.Form[name], values...)
+		r.PostForm[name] = append(r.PostForm[name], values...)
+	}
+	return form, nil
+}
+
+// spoolFormFile reads the file part of a multipart form, and keeps it in memory when it fits in what is left of it,
+// or else in a temporary file in TempDir, which form.release removes
+func (t *Tools) spoolFormFile(part *multipart.Part, memoryLeft *int64, form *uploadForm) (formFile, error) {
+	file := formFile{field: part.FormName(), name: partFileName(part)}
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, part, *memoryLeft+1)
+	if err != nil && err != io.EOF {
+		return file, err
+	}
+	if n <= *memoryLeft {
+		*memoryLeft -= n
+		file.size = n
+		file.open = openMemoryFormFile(buf.Bytes())
+		return file, nil
+	}
+
+	f, err := os.CreateTemp(t.TempDir, "multipart-")
+	if err != nil {
+		return file, err
+	}
+	form.spooled = append(form.spooled, f.Name())
+	size, err := io.Copy(f, io.MultiReader(&buf, part))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return file, err
+	}
+
+	path := f.Name()
+	file.size = size
+	file.open = func() (multipart.File, error) {
+		return os.Open(path)
+	}
+	return file, nil
+}
+
+// multipartMemoryLimit returns how many bytes of a form's files are held in memory, never more than maxSize
+func (t *Tools) multipartMemoryLimit(maxSize int64) int64 {
+	limit := int64(uploadMaxMemory)
+	if t.MultipartMemoryLimit > 0 {
+		limit = t.MultipartMemoryLimit
+	}
+	if maxSize < limit {
+		limit = maxSize
+	}
+	return limit
+}
+
+// partFileName returns the name of the file sent in part, or "" when it is not a file
+func partFileName(part *multipart.Part) string {
+	return dispositionFileName(part.Header.Get("Content-Disposition"))
+}
+
+// formFileName returns the name of the file of a multipart form described by hdr: the name dispositionFileName finds
+// in its Content-Disposition, which ParseMultipartForm may have left out, or else hdr.Filename
+func formFileName(hdr *multipart.FileHeader) string {
+	if filename := dispositionFileName(hdr.Header.Get("Content-Disposition")); filename != "" {
+		return filename
+	}
+	return hdr.Filename
+}
+
+// dispositionFileName returns the file name of the Content-Disposition header disposition, from its filename*
+// parameter when it can be decoded, or else its filename parameter
+func dispositionFileName(disposition string) string {
+	var filename string
+	if _, params, err := mime.ParseMediaType(disposition); err == nil {
+		filename = params["filename"]
+	}
+	if m := extendedFileName.FindStringSubmatch(disposition); m != nil {
+		if decoded, ok := decodeExtendedValue(m[1]); ok {
+			filename = decoded
+		}
+	}
+
+	if filename == "" {
+		return ""
+	}
+	return filepath.Base(filename)
+}
+
+// decodeExtendedValue decodes value, an RFC 5987 ext-value such as UTF-8 followed by two quotes and
+// %E2%82%ACrates.pdf, reporting false when it is malformed or in a charset other than UTF-8, US-ASCII and ISO-8859-1
+func decodeExtendedValue(value string) (string, bool) {
+	parts := strings.SplitN(value, "'", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	decoded, err := url.PathUnescape(parts[2])
+	if err != nil || decoded == "" {
+		return "", false
+	}
+
+	switch strings.ToLower(parts[0]) {
+	case "utf-8", "us-ascii":
+		return decoded, utf8.ValidString(decoded)
+	case "iso-8859-1", "latin1":
+		// every byte of ISO-8859-1 is the code point of the same value
+		runes := make([]rune, len(decoded))
+		for i := 0; i < len(decoded); i++ {
+			runes[i] = rune(decoded[i])
+		}
+		return string(runes), true
+	default:
+		return "", false
+	}
+}
+
+// sortedFormFields returns the names of the fields of form that files were posted under, sorted
+func sortedFormFields(form *multipart.Form) []string {
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
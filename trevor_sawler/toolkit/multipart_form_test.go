@@ -0,0 +1,331 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// spooledNames returns the names of the files in dir
+func spooledNames(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestTools_UploadFilesMultipartMemory(t *testing.T) {
+	png := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		name         string
+		limit        int64
+		tempDir      bool
+		inDefaultDir int
+		inTempDir    int
+	}{
+		{name: "default limit"},
+		{name: "over the limit", limit: 1024, inDefaultDir: 1},
+		{name: "default limit with a temp dir", tempDir: true},
+		{name: "over the limit with a temp dir", limit: 1024, tempDir: true, inTempDir: 1},
+	}
+
+	uploads := map[string]uploadFunc{
+		"UploadFiles": uploadFilesRename,
+		"UploadOneFile": func(t *Tools, r *http.Request, dir string, rename ...bool) ([]*UploadedFile, error) {
+			f, err := t.UploadOneFile(r, dir, rename...)
+			if err != nil {
+				return nil, err
+			}
+			return []*UploadedFile{f}, nil
+		},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploads {
+			defaultDir := t.TempDir()
+			t.Setenv("TMPDIR", defaultDir)
+			tempDir := t.TempDir()
+
+			testTools := Tools{MultipartMemoryLimit: e.limit}
+			if e.tempDir {
+				testTools.TempDir = tempDir
+			}
+			var checked bool
+			testTools.OnProgress = func(string, int64, int64) {
+				if checked {
+					return
+				}
+				checked = true
+				// while the file is saved, the part of the form over the memory limit is spooled to a temporary file
+				if n := len(spooledNames(t, defaultDir)); n != e.inDefaultDir {
+					t.Errorf("%s, %s: expected %d files in the default temp dir while saving, got %d", e.name, name, e.inDefaultDir, n)
+				}
+				if n := len(spooledNames(t, tempDir)); n != e.inTempDir {
+					t.Errorf("%s, %s: expected %d files in TempDir while saving, got %d", e.name, name, e.inTempDir, n)
+				}
+			}
+
+			dir := t.TempDir()
+			r := newUploadRequest(t, testUpload{field: "album", content: []byte("holiday")}, testUpload{"file", "img.png", png})
+			files, err := upload(&testTools, r, dir, false)
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+			if !checked {
+				t.Errorf("%s, %s: expected progress to be reported", e.name, name)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, files[0].NewFileName))
+			if err != nil || !bytes.Equal(data, png) {
+				t.Errorf("%s, %s: expected the file to be saved as it was uploaded: %v", e.name, name, err)
+			}
+			if names := spooledNames(t, tempDir); names != nil {
+				t.Errorf("%s, %s: expected TempDir to be emptied after the upload, got %v", e.name, name, names)
+			}
+			// the values of the form can still be read, whichever way it was read
+			if album := r.FormValue("album"); album != "holiday" {
+				t.Errorf("%s, %s: expected the album field to be kept, got %q", e.name, name, album)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesPartOrder(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	// the parts are posted in an order the names of their fields don't sort in
+	uploads := []testUpload{{"b", "first.png", png}, {"a", "second.png", png}, {"c", "third.png", png}, {"a", "fourth.png", png}}
+	expected := []string{"first.png", "second.png", "third.png", "fourth.png"}
+
+	var tests = []struct {
+		name  string
+		tools Tools
+	}{
+		{name: "in memory"},
+		{name: "spooled", tools: Tools{MultipartMemoryLimit: 1024}},
+		{name: "temp dir", tools: Tools{MultipartMemoryLimit: 1024, TempDir: t.TempDir()}},
+		{name: "concurrently", tools: Tools{Concurrency: 4}},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			files, err := upload(&e.tools, newUploadRequest(t, uploads...), t.TempDir(), false)
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+			var names []string
+			for _, f := range files {
+				names = append(names, f.NewFileName)
+			}
+			if !reflect.DeepEqual(names, expected) {
+				t.Errorf("%s, %s: expected the files in the order they were posted, %v, got %v", e.name, name, expected, names)
+			}
+		}
+	}
+
+	// with TakeFirstFile, UploadOneFile saves the file posted first, whatever the name of its field
+	for _, testTools := range []Tools{{TakeFirstFile: true}, {TakeFirstFile: true, TempDir: t.TempDir()}} {
+		f, err := testTools.UploadOneFile(newUploadRequest(t, uploads...), t.TempDir(), false)
+		if err != nil || f.NewFileName != "first.png" {
+			t.Errorf("expected first.png to be saved, got %v and %v", f, err)
+		}
+	}
+}
+
+func TestTools_UploadFilesCallerParsedForm(t *testing.T) {
+	png := readTestFile(t, "img.png")
+
+	for _, limit := range []int64{0, 1024} {
+		testTools := Tools{MultipartMemoryLimit: limit}
+
+		// UploadFiles reads the form with r.MultipartReader, so its values are in r.Form, and a second upload from the
+		// same request gets the error ParseMultipartForm gives rather than finding no files
+		r := newUploadRequest(t, testUpload{field: "album", content: []byte("holiday")}, testUpload{"file", "img.png", png})
+		if _, err := testTools.UploadFiles(r, t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		if album := r.FormValue("album"); album != "holiday" {
+			t.Errorf("limit %d: expected the album field to be kept, got %q", limit, album)
+		}
+		if _, err := testTools.UploadFiles(r, t.TempDir()); err == nil || errors.Is(err, ErrNoFiles) {
+			t.Errorf("limit %d: expected a second upload of a form read by UploadFiles to fail, got %v", limit, err)
+		}
+
+		// a form the caller parsed first is used as it is, and its files can still be read
+		r = newUploadRequest(t, testUpload{"file", "img.png", png})
+		if err := r.ParseMultipartForm(limit + 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := testTools.UploadFiles(r, t.TempDir()); err != nil {
+			t.Fatal(err)
+		}
+		f, hdr, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("limit %d: expected FormFile to open the uploaded file, got %v", limit, err)
+			continue
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil || !bytes.Equal(data, png) || hdr.Filename != "img.png" {
+			t.Errorf("limit %d: expected FormFile to read img.png as it was uploaded: %v", limit, err)
+		}
+		if _, err := testTools.UploadFiles(r, t.TempDir()); err != nil {
+			t.Errorf("limit %d: expected a second upload from the same request to succeed, got %v", limit, err)
+		}
+		r.MultipartForm.RemoveAll()
+	}
+}
+
+func TestTools_UploadFilesPartLimits(t *testing.T) {
+	// each file is larger than the memory limit, so every file read is spooled to TempDir
+	var many []testUpload
+	for i := 0; i < 50; i++ {
+		many = append(many, testUpload{"file", fmt.Sprintf("%d.txt", i), []byte("some text")})
+	}
+	tempDir := t.TempDir()
+	testTools := Tools{MaxFiles: 1, MultipartMemoryLimit: 1, TempDir: tempDir}
+
+	// past MaxFiles, the form is refused before the rest of its files are spooled
+	_, err := testTools.readUploadForm(newUploadRequest(t, many...), 1, formFileLimit{max: 1})
+	var tooMany *TooManyFilesError
+	if !errors.As(err, &tooMany) || tooMany.Limit != 1 {
+		t.Errorf("expected a TooManyFilesError with limit 1, got %v", err)
+	}
+	if names := spooledNames(t, tempDir); names != nil {
+		t.Errorf("expected the spooled files to be removed, got %v", names)
+	}
+	if _, err := testTools.UploadFiles(newUploadRequest(t, many...), t.TempDir()); !errors.Is(err, ErrTooManyFiles) {
+		t.Errorf("expected ErrTooManyFiles, got %v", err)
+	}
+
+	// the files past the first, for UploadOneFile, are counted but not read, and those under a field that isn't
+	// used are skipped
+	uploads := append(many, testUpload{"other", "other.txt", []byte("some text")})
+	form, err := testTools.readUploadForm(newUploadRequest(t, uploads...), 1, formFileLimit{
+		accept: func(field string) bool { return field == "file" },
+		max:    1,
+		count:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer form.release()
+	if len(form.files) != 1 || len(form.spooled) != 1 || form.skipped != len(many)-1 {
+		t.Errorf("expected one file read and %d counted, got %d read, %d spooled and %d counted", len(many)-1, len(form.files), len(form.spooled), form.skipped)
+	}
+
+	// as with ParseMultipartForm, a form of more than maxFormParts parts is refused
+	var parts []testUpload
+	for i := 0; i <= maxFormParts; i++ {
+		parts = append(parts, testUpload{field: fmt.Sprintf("f%d", i), content: []byte("x")})
+	}
+	if _, err := (&Tools{}).UploadFiles(newUploadRequest(t, parts...), t.TempDir()); !errors.Is(err, ErrFileTooBig) {
+		t.Errorf("expected a form of %d parts to be refused, got %v", len(parts), err)
+	}
+}
+
+func TestTools_StreamUploadFilesTempDir(t *testing.T) {
+	png := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		name         string
+		tempDir      bool
+		inTempDir    int
+		inDefaultDir int
+	}{
+		{name: "default temp dir", inDefaultDir: 1},
+		{name: "temp dir", tempDir: true, inTempDir: 1},
+	}
+
+	for _, e := range tests {
+		defaultDir := t.TempDir()
+		t.Setenv("TMPDIR", defaultDir)
+		tempDir := t.TempDir()
+
+		// a file named by its hash is read through once to hash it, from a temporary copy, before it is saved
+		testTools := Tools{NamingStrategy: NamingContentHash}
+		if e.tempDir {
+			testTools.TempDir = tempDir
+		}
+		var checked bool
+		testTools.OnProgress = func(string, int64, int64) {
+			if checked {
+				return
+			}
+			checked = true
+			if n := len(spooledNames(t, tempDir)); n != e.inTempDir {
+				t.Errorf("%s: expected %d files in TempDir while saving, got %d", e.name, e.inTempDir, n)
+			}
+			if n := len(spooledNames(t, defaultDir)); n != e.inDefaultDir {
+				t.Errorf("%s: expected %d files in the default temp dir while saving, got %d", e.name, e.inDefaultDir, n)
+			}
+		}
+
+		dir := t.TempDir()
+		files, err := testTools.StreamUploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), dir)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+		if !checked {
+			t.Errorf("%s: expected progress to be reported", e.name)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, files[0].NewFileName))
+		if err != nil || !bytes.Equal(data, png) {
+			t.Errorf("%s: expected the file to be saved as it was uploaded: %v", e.name, err)
+		}
+		if names := spooledNames(t, tempDir); names != nil {
+			t.Errorf("%s: expected TempDir to be emptied after the upload, got %v", e.name, names)
+		}
+		if names := spooledNames(t, defaultDir); names != nil {
+			t.Errorf("%s: expected the default temp dir to be emptied after the upload, got %v", e.name, names)
+		}
+	}
+}
+
+func TestTools_UploadFilesEncodedNames(t *testing.T) {
+	content := []byte("some text")
+
+	var tests = []struct {
+		name        string
+		disposition string
+		expected    string
+	}{
+		{name: "plain", disposition: `form-data; name="file"; filename="plain.txt"`, expected: "plain.txt"},
+		{name: "utf-8", disposition: `form-data; name="file"; filename*=UTF-8''%E2%82%ACrates.pdf`, expected: "€rates.pdf"},
+		{name: "latin-1", disposition: `form-data; name="file"; filename*=ISO-8859-1''r%E9sum%E9.txt`, expected: "résumé.txt"},
+		{name: "utf-8 and plain", disposition: `form-data; name="file"; filename="EUR rates.pdf"; filename*=UTF-8''%E2%82%AC%20rates.pdf`, expected: "€ rates.pdf"},
+		{name: "latin-1 and plain", disposition: `form-data; name="file"; filename="resume.txt"; filename*=iso-8859-1'fr'r%E9sum%E9.txt`, expected: "résumé.txt"},
+		{name: "quoted", disposition: `form-data; name="file"; filename*="UTF-8''na%C3%AFve.txt"`, expected: "naïve.txt"},
+		{name: "unknown charset", disposition: `form-data; name="file"; filename="fallback.txt"; filename*=KOI8-R''%C1.txt`, expected: "fallback.txt"},
+		{name: "directory", disposition: `form-data; name="file"; filename*=UTF-8''..%2F..%2Fd%C3%A9j%C3%A0.txt`, expected: "déjà.txt"},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			var testTools Tools
+			files, err := upload(&testTools, encodedNameRequest(t, e.disposition, content), t.TempDir(), false)
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+			if files[0].OriginalFileName != e.expected || files[0].NewFileName != e.expected {
+				t.Errorf("%s, %s: expected %q, got %q saved as %q", e.name, name, e.expected, files[0].OriginalFileName, files[0].NewFileName)
+			}
+		}
+	}
+}
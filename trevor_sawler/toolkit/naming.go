@@ -0,0 +1,423 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NamingStrategy decides the names that UploadFiles and StreamUploadFiles save uploaded files under
+type NamingStrategy int
+
+const (
+	// NamingRename, the zero value, gives files random names or keeps their original names, as the rename
+	// argument of UploadFiles says
+	NamingRename NamingStrategy = iota
+	// NamingRandom always gives files a random name with the original extension
+	NamingRandom
+	// NamingOriginal always keeps the original file name
+	NamingOriginal
+	// NamingContentHash names files by the hex encoded SHA-256 hash of their content and the original extension,
+	// so that a file that is uploaded again is stored only once
+	NamingContentHash
+	// NamingSlug names files by the slugified original name and extension, so Süßes Foto (1).JPG becomes
+	// suesses-foto-1.jpg
+	NamingSlug
+	// NamingUUID names files by a random version 4 UUID from UUIDv4 and the original extension
+	NamingUUID
+	// NamingULID names files by a ULID from ULID and the original extension, so that they sort by the time they were
+	// uploaded
+	NamingULID
+)
+
+// SubdirStrategy decides the subdirectories of the upload directory that UploadFiles and StreamUploadFiles save
+// uploaded files in, so that no one directory ends up holding every file
+type SubdirStrategy int
+
+const (
+	// SubdirNone, the zero value, saves every file directly in the upload directory
+	SubdirNone SubdirStrategy = iota
+	// SubdirDate saves files under the UTC date they are uploaded on, as in 2024/05/01/img.png. With
+	// NamingContentHash, a file is only found to be stored already when it was uploaded on the same day
+	SubdirDate
+	// SubdirHashPrefix saves files under the first two pairs of hex characters of the SHA-256 hash of their
+	// content, as in ab/cd/abcdef….png, which spreads them evenly
+	SubdirHashPrefix
+)
+
+// namesByHash reports whether files are named by the hash of their content: with NamingContentHash, or with
+// Deduplicate when there is no ExistsByHash to find files with the same content by
+func (t *Tools) namesByHash() bool {
+	return t.NamingStrategy == NamingContentHash || (t.Deduplicate && t.ExistsByHash == nil)
+}
+
+// uploadSubdir returns the subdirectory, ending in a slash, that a file with the hex encoded hash sha is saved in
+// under SubdirStrategy, or "" for SubdirNone
+func (t *Tools) uploadSubdir(sha string) string {
+	switch t.SubdirStrategy {
+	case SubdirDate:
+		return time.Now().UTC().Format("2006/01/02") + "/"
+	case SubdirHashPrefix:
+		return sha[:2] + "/" + sha[2:4] + "/"
+	default:
+		return ""
+	}
+}
+
+// ExistsPolicy decides what happens when an uploaded file is to be saved under a name that is already taken
+type ExistsPolicy int
+
+const (
+	// ExistsOverwrite, the zero value, replaces the file already there. A name from RenameFunc that is taken is
+	// still replaced with a random one, and a name from NamingSlug gets a random suffix
+	ExistsOverwrite ExistsPolicy = iota
+	// ExistsError refuses the file with a *FileExistsError
+	ExistsError
+	// ExistsAutoRename saves the file under the first free name made by putting -1, -2 and so on before its
+	// extension, so that report.pdf becomes report-1.pdf
+	ExistsAutoRename
+)
+
+// maxAutoRename is the number of names ExistsAutoRename tries after the file's own name, before giving up with a
+// *FileExistsError
+const maxAutoRename = 1000
+
+// candidateNames returns the function that gives the names to try for name under policy, name-1, name-2 and so on
+// with ExistsAutoRename, cut to maxLength bytes when it is set, and then ""
+func candidateNames(name string, policy ExistsPolicy, compound []string, maxLength int) func() string {
+	dir, file := path.Split(name)
+	ext := fileExt(file, compound)
+	stem := strings.TrimSuffix(file, ext)
+	if file == ext {
+		// a name such as ".env" is all extension, so the number goes at the end
+		stem, ext = file, ""
+	}
+
+	i := -1
+	return func() string {
+		i++
+		switch {
+		case i == 0:
+			return name
+		case policy != ExistsAutoRename || i > maxAutoRename:
+			return ""
+		default:
+			suffix := fmt.Sprintf("-%d", i)
+			if maxLength > 0 {
+				return dir + truncateUTF8(stem, maxLength-len(suffix)-len(ext)) + suffix + ext
+			}
+			return dir + stem + suffix + ext
+		}
+	}
+}
+
+// ErrHiddenFile is matched, via errors.Is, by the *HiddenFileError returned by UploadFiles for a file to be saved
+// under its original name when that name begins with a dot
+var ErrHiddenFile = errors.New("uploaded file name is hidden")
+
+// HiddenFileError is returned when a file kept its original name and it begins with a dot, such as .htaccess,
+// unless AllowHiddenFiles is set. It also matches ErrInvalidFileName
+type HiddenFileError struct {
+	FileName string
+}
+
+// Error implements the error interface
+func (e *HiddenFileError) Error() string {
+	return englishMessage("upload.hidden_name", e.FileName)
+}
+
+// Is reports whether target is ErrHiddenFile or ErrInvalidFileName
+func (e *HiddenFileError) Is(target error) bool {
+	return target == ErrHiddenFile || target == ErrInvalidFileName
+}
+
+func (e *HiddenFileError) messageKey() (string, []interface{}) {
+	return "upload.hidden_name", []interface{}{e.FileName}
+}
+
+// uploadFileName returns the name an uploaded file is saved under, by RenameFunc, NamingStrategy or renameFile.
+// A hidden original name gets a *HiddenFileError unless AllowHiddenFiles is set
+func (t *Tools) uploadFileName(filename string, renameFile bool) (string, error) {
+	switch {
+	case t.RenameFunc != nil:
+		name := t.RenameFunc(filename)
+		if !plainFileName(name) {
+			name = t.randomFileName(filename)
+		}
+		return name, nil
+	case t.NamingStrategy == NamingRandom, t.NamingStrategy == NamingRename && renameFile:
+		return t.randomFileName(filename), nil
+	case t.NamingStrategy == NamingUUID:
+		return t.UUIDv4() + fileExt(filename, t.compoundExtensions()), nil
+	case t.NamingStrategy == NamingULID:
+		return t.ULID() + fileExt(filename, t.compoundExtensions()), nil
+	case t.NamingStrategy == NamingSlug:
+		name, err := t.slugFileName(filename)
+		return t.refuseHiddenName(filename, name, err)
+	default:
+		name, err := safeFileName(filename)
+		return t.refuseHiddenName(filename, name, err)
+	}
+}
+
+// refuseHiddenName passes on name and err, unless name begins with a dot and AllowHiddenFiles is not set
+func (t *Tools) refuseHiddenName(filename, name string, err error) (string, error) {
+	if err == nil && !t.AllowHiddenFiles && strings.HasPrefix(name, ".") {
+		return "", &HiddenFileError{FileName: filename}
+	}
+	return name, err
+}
+
+// safeFileName drops any directory from a client's file name and makes the rest safe with SanitizeFileName. An empty
+// result gets ErrInvalidFileName
+func safeFileName(filename string) (string, error) {
+	if i := strings.LastIndexAny(filename, "/\\"); i >= 0 {
+		filename = filename[i+1:]
+	}
+	filename = SanitizeFileName(filename)
+
+	if !plainFileName(filename) {
+		return "", ErrInvalidFileName
+	}
+	return filename, nil
+}
+
+// maxFileNameLength is the most bytes SanitizeFileName leaves in a name, the limit of common file systems
+const maxFileNameLength = 255
+
+// windowsReservedChars are the characters that Windows doesn't allow in a file name
+const windowsReservedChars = `<>:"/\|?*`
+
+// windowsReservedNames are the names of devices, which Windows doesn't allow as a file name, with any extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true,
+	"COM8": true, "COM9": true, "COM¹": true, "COM²": true, "COM³": true,
+	"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true,
+	"LPT8": true, "LPT9": true, "LPT¹": true, "LPT²": true, "LPT³": true,
+}
+
+// SanitizeFileName makes a file name safe on Linux, macOS and Windows alike, replacing reserved characters and
+// device names and cutting it to 255 bytes. A name of only dots and spaces comes back empty
+func SanitizeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(windowsReservedChars, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(name, ". ")
+
+	stem := name
+	if i := strings.IndexByte(stem, '.'); i >= 0 {
+		stem = stem[:i]
+	}
+	if windowsReservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		name = "_" + name
+	}
+
+	if len(name) > maxFileNameLength {
+		ext := filepath.Ext(name)
+		if len(ext) > maxFileNameLength/2 {
+			ext = ""
+		}
+		name = strings.TrimRight(truncateUTF8(name, maxFileNameLength-len(ext)), ". ") + ext
+	}
+	return name
+}
+
+// joinUploadPath joins name, a stored path, to uploadDir, and makes sure that the result is a file inside uploadDir
+func joinUploadPath(uploadDir, name string) (string, error) {
+	if !storedPath(name) {
+		return "", ErrInvalidFileName
+	}
+	return filepath.Join(uploadDir, filepath.FromSlash(name)), nil
+}
+
+// storedPath reports whether name can be used as the name of a file in a store: a plain file name, or plain names
+// of directories and of a file joined with forward slashes, such as 2024/05/01/img.png
+func storedPath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if !plainFileName(part) {
+			return false
+		}
+	}
+	return true
+}
+
+// randomFileName returns a random name with the extension of filename
+func (t *Tools) randomFileName(filename string) string {
+	return fmt.Sprintf("%s%s", t.RandomString(25), fileExt(filename, t.compoundExtensions()))
+}
+
+// defaultCompoundExtensions are the extensions of several parts that are kept whole when CompoundExtensions is nil
+var defaultCompoundExtensions = []string{".tar.gz", ".tar.bz2", ".tar.xz"}
+
+// compoundExtensions returns CompoundExtensions, or defaultCompoundExtensions when it is nil
+func (t *Tools) compoundExtensions() []string {
+	if t.CompoundExtensions == nil {
+		return defaultCompoundExtensions
+	}
+	return t.CompoundExtensions
+}
+
+// fileExt returns the extension of filename as filepath.Ext does, but returns one of compound, such as .tar.gz,
+// whole when filename ends in it, ignoring case. The extension keeps the case it has in filename
+func fileExt(filename string, compound []string) string {
+	ext := filepath.Ext(filename)
+	for _, x := range compound {
+		x = "." + strings.TrimPrefix(x, ".")
+		if len(filename) >= len(x) && strings.EqualFold(filename[len(filename)-len(x):], x) {
+			return filename[len(filename)-len(x):]
+		}
+	}
+	return ext
+}
+
+// slugExtension matches the extensions that NamingSlug keeps: dots each followed by lowercase letters and digits
+var slugExtension = regexp.MustCompile(`^(\.[a-z\d]+)+$`)
+
+// letterSpellings spells out the accented and other letters that Slugify would otherwise drop, after lowercasing
+var letterSpellings = strings.NewReplacer(
+	"ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "å", "a", "ð", "d", "þ", "th",
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e", "ì", "i", "í", "i",
+	"î", "i", "ï", "i", "ñ", "n", "ò", "o", "ó", "o", "ô", "o", "õ", "o", "ù", "u", "ú", "u", "û", "u", "ý", "y",
+	"ÿ", "y", "ł", "l", "ś", "s", "š", "s", "ž", "z", "ź", "z", "ż", "z", "č", "c", "ć", "c", "ř", "r", "ň", "n",
+	"ě", "e", "ę", "e", "ą", "a", "ı", "i", "ğ", "g", "ş", "s",
+)
+
+// slugFileName returns the name NamingSlug gives filename
+func (t *Tools) slugFileName(filename string) (string, error) {
+	filename, err := safeFileName(filename)
+	if err != nil {
+		return "", err
+	}
+
+	base, ext := filename, strings.ToLower(fileExt(filename, t.compoundExtensions()))
+	if slugExtension.MatchString(ext) {
+		base = filename[:len(filename)-len(ext)]
+	} else {
+		ext = ""
+	}
+
+	slug, err := t.Slugify(letterSpellings.Replace(strings.ToLower(base)))
+	if err != nil {
+		slug = t.RandomString(6)
+	}
+	// a slug such as con.txt is still a device name on Windows
+	return SanitizeFileName(slug + ext), nil
+}
+
+// ErrFileNameTooLong is matched, via errors.Is, by the *FileNameTooLongError returned by UploadFiles for a file whose
+// name can't be shortened to MaxFileNameLength
+var ErrFileNameTooLong = errors.New("uploaded file name is too long")
+
+// FileNameTooLongError is returned when the name a file is to be saved under can't be cut to Limit bytes while
+// keeping its extension. It also matches ErrInvalidFileName
+type FileNameTooLongError struct {
+	FileName string
+	Limit    int
+}
+
+// Error implements the error interface
+func (e *FileNameTooLongError) Error() string {
+	return englishMessage("upload.name_too_long", e.FileName, e.Limit)
+}
+
+// Is reports whether target is ErrFileNameTooLong or ErrInvalidFileName
+func (e *FileNameTooLongError) Is(target error) bool {
+	return target == ErrFileNameTooLong || target == ErrInvalidFileName
+}
+
+func (e *FileNameTooLongError) messageKey() (string, []interface{}) {
+	return "upload.name_too_long", []interface{}{e.FileName, e.Limit}
+}
+
+// fileNameLimit returns the most bytes the name of a stored file may have: MaxFileNameLength, or 255 when that is
+// not set
+func (t *Tools) fileNameLimit() int {
+	if t.MaxFileNameLength > 0 {
+		return t.MaxFileNameLength
+	}
+	return maxFileNameLength
+}
+
+// shortenFileName cuts name short before its extension so that it is at most fileNameLimit bytes
+func (t *Tools) shortenFileName(filename, name string) (string, error) {
+	limit := t.fileNameLimit()
+	if len(name) <= limit {
+		return name, nil
+	}
+
+	ext := fileExt(name, t.compoundExtensions())
+	if ext == name {
+		ext = ""
+	}
+	stem := strings.TrimRight(truncateUTF8(strings.TrimSuffix(name, ext), limit-len(ext)), ". ")
+	if stem == "" {
+		return "", &FileNameTooLongError{FileName: filename, Limit: limit}
+	}
+	return stem + ext, nil
+}
+
+// truncateUTF8 returns s cut to at most n bytes, without splitting a character, or "" when n is not positive
+func truncateUTF8(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// suffixedName puts suffix before the extension of name, cutting name so the result is at most maxLength bytes
+func suffixedName(name, suffix string, compound []string, maxLength int) string {
+	ext := fileExt(path.Base(name), compound)
+	stem := strings.TrimSuffix(name, ext)
+	if maxLength > 0 {
+		stem = truncateUTF8(stem, maxLength-len(suffix)-len(ext))
+	}
+	return stem + suffix + ext
+}
+
+// plainFileName reports whether name can be used as is for a file in the upload directory
+func plainFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// undoUpload removes the files, with their thumbnails and sidecars, that a failed upload saved to store, unless
+// KeepPartialUploads is set, and returns err. Deduplicated files are never removed
+func (t *Tools) undoUpload(store FileStore, uploadedFiles []*UploadedFile, err error) ([]*UploadedFile, error) {
+	if t.KeepPartialUploads {
+		return uploadedFiles, err
+	}
+
+	for _, f := range uploadedFiles {
+		if !f.Deduplicated {
+			removeUploadedFile(store, f)
+		}
+	}
+	return nil, err
+}
+
+// removeUploadedFile removes the file saved to store as uploadedFile, with its thumbnails, the files extracted from it
+// and its metadata sidecar
+func removeUploadedFile(store FileStore, uploadedFile *UploadedFile) {
+	store.Remove(context.Background(), uploadedFile.StoredPath)
+	removeVariants(store, uploadedFile)
+	removeExtracted(store, uploadedFile)
+	if uploadedFile.MetadataPath != "" {
+		store.Remove(context.Background(), uploadedFile.MetadataPath)
+	}
+}
@@ -0,0 +1,864 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestTools_UploadFileNaming(t *testing.T) {
+	png := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		name     string
+		strategy NamingStrategy
+		rename   bool
+		random   bool
+	}{
+		{name: "rename", strategy: NamingRename, rename: true, random: true},
+		{name: "keep name", strategy: NamingRename, rename: false, random: false},
+		{name: "random overrides rename", strategy: NamingRandom, rename: false, random: true},
+		{name: "original overrides rename", strategy: NamingOriginal, rename: true, random: false},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{NamingStrategy: e.strategy}
+		files, err := testTools.UploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), t.TempDir(), WithRename(e.rename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.random != (files[0].NewFileName != "img.png") || filepath.Ext(files[0].NewFileName) != ".png" {
+			t.Errorf("%s: unexpected new name %q", e.name, files[0].NewFileName)
+		}
+	}
+}
+
+func TestTools_UploadFilesSlugNames(t *testing.T) {
+	png := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		name     string
+		filename string
+		expected *regexp.Regexp
+	}{
+		{name: "unicode", filename: "Süßes Foto (1).JPG", expected: regexp.MustCompile(`^suesses-foto-1\.jpg$`)},
+		{name: "accents", filename: "Crème Brûlée.png", expected: regexp.MustCompile(`^creme-brulee\.png$`)},
+		{name: "slugifies to nothing", filename: "写真.png", expected: regexp.MustCompile(`^[^.-]{6}\.png$`)},
+		{name: "all extension", filename: ".png", expected: regexp.MustCompile(`^[^.-]{6}\.png$`)},
+		{name: "extension that is not a slug", filename: "My File.p_n g", expected: regexp.MustCompile(`^my-file-p-n-g$`)},
+	}
+
+	testTools := Tools{NamingStrategy: NamingSlug}
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			files, err := upload(&testTools, newUploadRequest(t, testUpload{"file", e.filename, png}), t.TempDir())
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+			if !e.expected.MatchString(files[0].NewFileName) {
+				t.Errorf("%s, %s: expected a name matching %s, got %q", e.name, name, e.expected, files[0].NewFileName)
+			}
+		}
+	}
+
+	// names that are taken, by a file stored already or by another file of the request, get a random suffix
+	suffixed := regexp.MustCompile(`^suesses-foto-1-[^.]{6}\.jpg$`)
+	for name, upload := range uploadFuncs {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "suesses-foto-1.jpg"), []byte("stored already"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		req := newUploadRequest(t, testUpload{"file", "Süßes Foto (1).JPG", png}, testUpload{"file", "süßes foto 1.jpg", png})
+		files, err := upload(&testTools, req, dir)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		if len(files) != 2 || !suffixed.MatchString(files[0].NewFileName) || !suffixed.MatchString(files[1].NewFileName) || files[0].NewFileName == files[1].NewFileName {
+			t.Errorf("%s: expected both files to get a different suffixed name, got %+v", name, files)
+		}
+		if data, _ := os.ReadFile(filepath.Join(dir, "suesses-foto-1.jpg")); string(data) != "stored already" {
+			t.Errorf("%s: expected the stored file not to be overwritten, got %q", name, data)
+		}
+	}
+
+	// with ExistsAutoRename the policy names the file instead
+	testTools.ExistsPolicy = ExistsAutoRename
+	dir := t.TempDir()
+	req := newUploadRequest(t, testUpload{"file", "Süßes Foto (1).JPG", png}, testUpload{"file", "süßes foto 1.jpg", png})
+	files, err := testTools.UploadFiles(req, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].NewFileName != "suesses-foto-1.jpg" || files[1].NewFileName != "suesses-foto-1-1.jpg" {
+		t.Errorf("expected the names suesses-foto-1.jpg and suesses-foto-1-1.jpg, got %q and %q", files[0].NewFileName, files[1].NewFileName)
+	}
+}
+
+func TestTools_UploadFilesCompoundExtensions(t *testing.T) {
+	archive := []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\x03not really a tarball")
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		rename   bool
+		expected *regexp.Regexp
+	}{
+		{name: "rename", tools: Tools{AllowedFileExtensions: []string{"tar.gz"}}, rename: true, expected: regexp.MustCompile(`^[^.]{25}\.tar\.gz$`)},
+		{name: "keep name", tools: Tools{AllowedFileExtensions: []string{"tar.gz"}}, expected: regexp.MustCompile(`^archive\.tar\.gz$`)},
+		{name: "slug", tools: Tools{NamingStrategy: NamingSlug}, expected: regexp.MustCompile(`^archive\.tar\.gz$`)},
+		{name: "content hash", tools: Tools{NamingStrategy: NamingContentHash}, expected: regexp.MustCompile(`^[0-9a-f]{64}\.tar\.gz$`)},
+		{name: "no compound extensions", tools: Tools{CompoundExtensions: []string{}}, rename: true, expected: regexp.MustCompile(`^[^.]{25}\.gz$`)},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			files, err := upload(&e.tools, newUploadRequest(t, testUpload{"file", "archive.tar.gz", archive}), t.TempDir(), e.rename)
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+			if !e.expected.MatchString(files[0].NewFileName) {
+				t.Errorf("%s, %s: expected a name matching %s, got %q", e.name, name, e.expected, files[0].NewFileName)
+			}
+		}
+	}
+
+	// the extension is allowed whole, not by its outer layer
+	testTools := Tools{AllowedFileExtensions: []string{"gz"}}
+	for name, upload := range uploadFuncs {
+		_, err := upload(&testTools, newUploadRequest(t, testUpload{"file", "archive.tar.gz", archive}), t.TempDir())
+		if !errors.Is(err, ErrFileExtensionNotPermitted) {
+			t.Errorf("%s: expected ErrFileExtensionNotPermitted, got %v", name, err)
+		}
+	}
+}
+
+func TestTools_UploadFilesContentHash(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	hashedName := "80babf09b223f1049a5b8216fb5de60ae6dd956e6872e76f838a1c57a5e34147.png"
+
+	testTools := Tools{NamingStrategy: NamingContentHash}
+	for name, upload := range uploadFuncs {
+		dir := t.TempDir()
+
+		// the same content twice in one request is stored once
+		req := newUploadRequest(t, testUpload{"file", "img.png", png}, testUpload{"other", "copy.png", png})
+		files, err := upload(&testTools, req, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 2 || files[0].NewFileName != hashedName || files[1].NewFileName != hashedName {
+			t.Fatalf("%s: expected both files to be named %s, got %+v", name, hashedName, files)
+		}
+		if files[0].Deduplicated == files[1].Deduplicated {
+			t.Errorf("%s: expected one of the files to be deduplicated, got %v and %v", name, files[0].Deduplicated, files[1].Deduplicated)
+		}
+		if got := remainingFiles(t, dir); len(got) != 1 || got[0] != hashedName {
+			t.Errorf("%s: expected only %s to be stored, got %v", name, hashedName, got)
+		}
+
+		// a later upload of the same content is not rewritten
+		stored := filepath.Join(dir, hashedName)
+		before, _ := os.Stat(stored)
+		files, err = upload(&testTools, newUploadRequest(t, testUpload{"file", "again.png", png}), dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		after, _ := os.Stat(stored)
+		if !files[0].Deduplicated || files[0].FileSize != int64(len(png)) || !os.SameFile(before, after) || !after.ModTime().Equal(before.ModTime()) {
+			t.Errorf("%s: expected the upload to be deduplicated against the stored file, got %+v", name, files[0])
+		}
+
+		// a failed upload leaves the stored file alone
+		pngOnly := Tools{NamingStrategy: NamingContentHash, AllowedFileTypes: []string{"image/png"}}
+		req = newUploadRequest(t, testUpload{"file", "img.png", png}, testUpload{"file", "pic.jpg", jpg})
+		if _, err := upload(&pngOnly, req, dir); !errors.Is(err, ErrFileTypeNotPermitted) {
+			t.Errorf("%s: expected %v, got %v", name, ErrFileTypeNotPermitted, err)
+		}
+		if got := remainingFiles(t, dir); len(got) != 1 || got[0] != hashedName {
+			t.Errorf("%s: expected the stored file to be kept after a failed upload, got %v", name, got)
+		}
+	}
+}
+
+func TestTools_UploadFilesDeduplicate(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	hash := "80babf09b223f1049a5b8216fb5de60ae6dd956e6872e76f838a1c57a5e34147"
+
+	for name, upload := range uploadFuncs {
+		// without a lookup, files are named by their hash
+		dir := t.TempDir()
+		byName := Tools{Deduplicate: true}
+		upload(&byName, newUploadRequest(t, testUpload{"file", "img.png", png}), dir)
+		files, err := upload(&byName, newUploadRequest(t, testUpload{"file", "copy.png", png}), dir)
+		if err != nil || !files[0].Deduplicated || files[0].StoredPath != hash+".png" {
+			t.Errorf("%s: expected the file to be found by its hash, got %+v, %v", name, files, err)
+		}
+
+		// with a lookup, files keep their names, and one that is looked up isn't written again, whatever
+		// ExistsPolicy says
+		dir = t.TempDir()
+		var mu sync.Mutex
+		index := map[string]string{"0000": "gone.png"}
+		lookup := Tools{Deduplicate: true, ExistsPolicy: ExistsError, ExistsByHash: func(hash string) (string, bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			stored, ok := index[hash]
+			return stored, ok
+		}}
+		files, err = upload(&lookup, newUploadRequest(t, testUpload{"file", "img.png", png}), dir, false)
+		if err != nil || files[0].Deduplicated || files[0].StoredPath != "img.png" || files[0].SHA256 != hash {
+			t.Fatalf("%s: expected img.png to be saved with its hash, got %+v, %v", name, files, err)
+		}
+		index[files[0].SHA256] = files[0].StoredPath
+
+		before, _ := os.Stat(filepath.Join(dir, "img.png"))
+		for _, filename := range []string{"copy.png", "img.png"} {
+			files, err = upload(&lookup, newUploadRequest(t, testUpload{"file", filename, png}), dir, false)
+			if err != nil || !files[0].Deduplicated || files[0].StoredPath != "img.png" || files[0].NewFileName != "img.png" {
+				t.Errorf("%s: expected %s to be deduplicated against img.png, got %+v, %v", name, filename, files, err)
+			}
+		}
+		after, _ := os.Stat(filepath.Join(dir, "img.png"))
+		if !os.SameFile(before, after) || !after.ModTime().Equal(before.ModTime()) {
+			t.Errorf("%s: expected img.png not to be rewritten", name)
+		}
+
+		// a file the lookup knows of that is no longer stored is saved again
+		index[hash] = "gone.png"
+		files, err = upload(&lookup, newUploadRequest(t, testUpload{"file", "copy.png", png}), dir, false)
+		if err != nil || files[0].Deduplicated || files[0].StoredPath != "copy.png" {
+			t.Errorf("%s: expected copy.png to be saved, got %+v, %v", name, files, err)
+		}
+		index[hash] = "img.png"
+
+		// a failed upload leaves the file it was deduplicated against alone
+		lookup.AllowedFileTypes = []string{"image/png"}
+		req := newUploadRequest(t, testUpload{"file", "again.png", png}, testUpload{"file", "pic.jpg", jpg})
+		if _, err := upload(&lookup, req, dir, false); !errors.Is(err, ErrFileTypeNotPermitted) {
+			t.Errorf("%s: expected %v, got %v", name, ErrFileTypeNotPermitted, err)
+		}
+		if got := remainingFiles(t, dir); !reflect.DeepEqual(got, []string{"copy.png", "img.png"}) {
+			t.Errorf("%s: expected the stored files to be kept after a failed upload, got %v", name, got)
+		}
+	}
+}
+
+func TestTools_UploadFilesRenameFunc(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+
+	var tests = []struct {
+		name   string
+		rename func(string) string
+		named  int
+	}{
+		{name: "user name", rename: func(original string) string { return "42-" + original }, named: 2},
+		{name: "same name twice", rename: func(string) string { return "avatar.img" }, named: 1},
+		{name: "empty name", rename: func(string) string { return "" }},
+		{name: "path", rename: func(original string) string { return "../" + original }},
+		{name: "dot dot", rename: func(string) string { return ".." }},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{RenameFunc: e.rename}
+		for name, upload := range uploadFuncs {
+			root := t.TempDir()
+			dir := filepath.Join(root, "uploads")
+
+			// the rename argument is overridden by RenameFunc
+			req := newUploadRequest(t, testUpload{"file", "img.png", png}, testUpload{"file", "pic.jpg", jpg})
+			files, err := upload(&testTools, req, dir, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			named := 0
+			for _, f := range files {
+				if f.NewFileName == e.rename(f.OriginalFileName) {
+					named++
+				} else if len(f.NewFileName) != 25+len(filepath.Ext(f.OriginalFileName)) {
+					t.Errorf("%s, %s: expected a random name in place of %q, got %q", e.name, name, e.rename(f.OriginalFileName), f.NewFileName)
+				}
+
+				saved, err := os.ReadFile(filepath.Join(dir, f.NewFileName))
+				if err != nil || len(saved) != int(f.FileSize) {
+					t.Errorf("%s, %s: expected %s to be saved as %s", e.name, name, f.OriginalFileName, f.NewFileName)
+				}
+			}
+			if named != e.named {
+				t.Errorf("%s, %s: expected %d files to take the name from RenameFunc, got %d", e.name, name, e.named, named)
+			}
+			if got := remainingFiles(t, root); len(got) != 2 {
+				t.Errorf("%s, %s: expected two files in the upload directory, got %v", e.name, name, got)
+			}
+		}
+	}
+}
+
+var safeFileNameTests = []struct {
+	name     string
+	filename string
+	expected string
+	err      error
+}{
+	{name: "plain", filename: "img.png", expected: "img.png"},
+	{name: "unicode", filename: "résumé 2024.pdf", expected: "résumé 2024.pdf"},
+	{name: "parent directories", filename: "../../etc/cron.d/evil", expected: "evil"},
+	{name: "absolute path", filename: "/etc/passwd", expected: "passwd"},
+	{name: "windows path", filename: `..\..\windows\system32\evil.dll`, expected: "evil.dll"},
+	{name: "mixed slashes", filename: `a/b\..\c.png`, expected: "c.png"},
+	{name: "nul byte", filename: "evil.png\x00.txt", expected: "evil.png_.txt"},
+	{name: "control characters", filename: "a\r\nb\tc\x7f.png", expected: "a__b_c_.png"},
+	{name: "empty", filename: "", err: ErrInvalidFileName},
+	{name: "dot dot", filename: "..", err: ErrInvalidFileName},
+	{name: "trailing dot dot", filename: "uploads/..", err: ErrInvalidFileName},
+	{name: "trailing slash", filename: "../", err: ErrInvalidFileName},
+	{name: "dot", filename: `dir\.`, err: ErrInvalidFileName},
+}
+
+func TestTools_SafeFileName(t *testing.T) {
+	for _, e := range safeFileNameTests {
+		got, err := safeFileName(e.filename)
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: expected error %v, got %v", e.name, e.err, err)
+		}
+		if got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+var sanitizeFileNameTests = []struct {
+	name     string
+	filename string
+	expected string
+}{
+	{name: "plain", filename: "report.pdf", expected: "report.pdf"},
+	{name: "unicode", filename: "résumé 2024.pdf", expected: "résumé 2024.pdf"},
+	{name: "reserved characters", filename: `a<b>c:d"e/f\g|h?i*j.txt`, expected: "a_b_c_d_e_f_g_h_i_j.txt"},
+	{name: "control characters", filename: "a\x00b\x1fc.txt", expected: "a_b_c.txt"},
+	{name: "trailing dots and spaces", filename: "notes.txt. . ", expected: "notes.txt"},
+	{name: "device name", filename: "CON", expected: "_CON"},
+	{name: "device name with extension", filename: "aux.png", expected: "_aux.png"},
+	{name: "device name with extensions", filename: "nul.tar.gz", expected: "_nul.tar.gz"},
+	{name: "device name with trailing space", filename: "prn .txt", expected: "_prn .txt"},
+	{name: "device name in a longer name", filename: "console.log", expected: "console.log"},
+	{name: "numbered device beyond 9", filename: "com10.txt", expected: "com10.txt"},
+	{name: "superscript device", filename: "LPT¹.txt", expected: "_LPT¹.txt"},
+	{name: "only dots", filename: "..", expected: ""},
+	{name: "too long", filename: strings.Repeat("a", 300) + ".txt", expected: strings.Repeat("a", 251) + ".txt"},
+	{name: "too long on a character boundary", filename: strings.Repeat("é", 200) + ".txt", expected: strings.Repeat("é", 125) + ".txt"},
+	{name: "too long extension", filename: "a." + strings.Repeat("b", 300), expected: "a." + strings.Repeat("b", 253)},
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	for _, e := range sanitizeFileNameTests {
+		if got := SanitizeFileName(e.filename); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+
+	for _, device := range []string{"CON", "PRN", "AUX", "NUL", "COM0", "COM1", "COM2", "COM3", "COM4", "COM5", "COM6",
+		"COM7", "COM8", "COM9", "LPT0", "LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9"} {
+		for _, name := range []string{device, strings.ToLower(device), device + ".txt", strings.ToLower(device) + ".tar.gz"} {
+			if got := SanitizeFileName(name); got != "_"+name {
+				t.Errorf("expected the device name %q to get an underscore in front, got %q", name, got)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesWindowsNames(t *testing.T) {
+	content := []byte("some text")
+
+	var tests = []struct {
+		tools    Tools
+		filename string
+		expected string
+	}{
+		{filename: "CON.txt", expected: "_CON.txt"},
+		{filename: "a<b>c|d?.txt", expected: "a_b_c_d_.txt"},
+		{filename: "notes.txt.", expected: "notes.txt"},
+		{tools: Tools{NamingStrategy: NamingSlug}, filename: "Con.TXT", expected: "_con.txt"},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			files, err := upload(&e.tools, encodedNameRequest(t, encodedDisposition(e.filename), content), dir, false)
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.filename, name, err)
+				continue
+			}
+			if files[0].NewFileName != e.expected || files[0].OriginalFileName != e.filename {
+				t.Errorf("%s, %s: expected to be saved as %q, got %q", e.filename, name, e.expected, files[0].NewFileName)
+			}
+			if _, err := os.Stat(filepath.Join(dir, e.expected)); err != nil {
+				t.Errorf("%s, %s: expected the file to be saved: %s", e.filename, name, err)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesUUIDAndULIDNames(t *testing.T) {
+	png := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		strategy NamingStrategy
+		pattern  *regexp.Regexp
+	}{
+		{strategy: NamingUUID, pattern: regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.tar\.gz$`)},
+		{strategy: NamingULID, pattern: regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}\.tar\.gz$`)},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{NamingStrategy: e.strategy}
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			files, err := upload(&testTools, newUploadRequest(t, testUpload{"file", "backup.tar.gz", png}), dir, false)
+			if err != nil {
+				t.Errorf("%d, %s: unexpected error: %s", e.strategy, name, err)
+				continue
+			}
+			if !e.pattern.MatchString(files[0].NewFileName) {
+				t.Errorf("%d, %s: unexpected name %q", e.strategy, name, files[0].NewFileName)
+			}
+			if _, err := os.Stat(filepath.Join(dir, files[0].NewFileName)); err != nil {
+				t.Errorf("%d, %s: expected the file to be saved: %s", e.strategy, name, err)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesLongNames(t *testing.T) {
+	content := []byte("some text")
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		filename string
+		expected string
+		err      bool
+	}{
+		{name: "short enough", tools: Tools{MaxFileNameLength: 20}, filename: "notes.txt", expected: "notes.txt"},
+		{name: "cut before the extension", tools: Tools{MaxFileNameLength: 20}, filename: "résumé-of-a-very-long-name.pdf", expected: "résumé-of-a-ve.pdf"},
+		{name: "cut on a character boundary", tools: Tools{MaxFileNameLength: 7}, filename: "éééé.txt", expected: "é.txt"},
+		{name: "compound extension kept", tools: Tools{MaxFileNameLength: 12}, filename: "backup-of-today.tar.gz", expected: "backu.tar.gz"},
+		{name: "default", filename: strings.Repeat("ü", 300) + ".pdf", expected: strings.Repeat("ü", 125) + ".pdf"},
+		{name: "no room for the name", tools: Tools{MaxFileNameLength: 5}, filename: "éééé.txt", err: true},
+		{name: "extension too long", tools: Tools{MaxFileNameLength: 10}, filename: "a.verylongextension", err: true},
+		{name: "random name", tools: Tools{MaxFileNameLength: 10, NamingStrategy: NamingRandom}, filename: "a.txt"},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			files, err := upload(&e.tools, encodedNameRequest(t, encodedDisposition(e.filename), content), dir, false)
+			if e.err {
+				var tooLong *FileNameTooLongError
+				if !errors.As(err, &tooLong) || !errors.Is(err, ErrInvalidFileName) || tooLong.FileName != e.filename {
+					t.Errorf("%s, %s: expected a FileNameTooLongError, got %v", e.name, name, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+			got := files[0].NewFileName
+			if e.expected != "" && got != e.expected {
+				t.Errorf("%s, %s: expected to be saved as %q, got %q", e.name, name, e.expected, got)
+			}
+			if limit := e.tools.fileNameLimit(); len(got) > limit || !utf8.ValidString(got) {
+				t.Errorf("%s, %s: expected a valid name of at most %d bytes, got %q", e.name, name, limit, got)
+			}
+		}
+	}
+
+	// names made unique still fit
+	testTools := Tools{MaxFileNameLength: 12, ExistsPolicy: ExistsAutoRename}
+	dir := t.TempDir()
+	for i, expected := range []string{"abcdefgh.txt", "abcdef-1.txt", "abcdef-2.txt"} {
+		files, err := testTools.UploadFiles(newUploadRequest(t, testUpload{"file", "abcdefghij.txt", content}), dir, WithRename(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[0].NewFileName != expected {
+			t.Errorf("upload %d: expected %q, got %q", i, expected, files[0].NewFileName)
+		}
+	}
+	testTools = Tools{MaxFileNameLength: 12, NamingStrategy: NamingSlug}
+	dir = t.TempDir()
+	for i := 0; i < 2; i++ {
+		files, err := testTools.UploadFiles(newUploadRequest(t, testUpload{"file", "abcdefghij.txt", content}), dir, WithRename(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := files[0].NewFileName; len(got) > 12 {
+			t.Errorf("upload %d: expected a slug of at most 12 bytes, got %q", i, got)
+		}
+	}
+	if names := remainingFiles(t, dir); len(names) != 2 {
+		t.Errorf("expected two files to be saved, got %v", names)
+	}
+}
+
+func TestTools_UploadFilesHiddenNames(t *testing.T) {
+	content := []byte("some text")
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		filename string
+		rename   bool
+		expected string
+		err      bool
+	}{
+		{name: "dotfile", filename: ".htaccess", err: true},
+		{name: "dotfile in a directory", filename: "../.env", err: true},
+		{name: "file in a hidden directory", filename: "../.ssh/authorized_keys", expected: "authorized_keys"},
+		{name: "slug", tools: Tools{NamingStrategy: NamingSlug}, filename: ".env"},
+		{name: "allowed", tools: Tools{AllowHiddenFiles: true}, filename: ".env", expected: ".env"},
+		{name: "renamed", filename: ".htaccess", rename: true},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			files, err := upload(&e.tools, encodedNameRequest(t, encodedDisposition(e.filename), content), dir, e.rename)
+			if e.err {
+				var hidden *HiddenFileError
+				if !errors.As(err, &hidden) || !errors.Is(err, ErrHiddenFile) || !errors.Is(err, ErrInvalidFileName) {
+					t.Errorf("%s, %s: expected a HiddenFileError, got %v", e.name, name, err)
+				} else if !strings.Contains(err.Error(), hidden.FileName) || !strings.HasPrefix(hidden.FileName, ".") {
+					t.Errorf("%s, %s: expected the error to name the original file, got %q", e.name, name, err)
+				}
+				if remaining := remainingFiles(t, dir); remaining != nil {
+					t.Errorf("%s, %s: expected no files to be left, got %v", e.name, name, remaining)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+			if e.expected != "" && files[0].NewFileName != e.expected {
+				t.Errorf("%s, %s: expected to be saved as %q, got %q", e.name, name, e.expected, files[0].NewFileName)
+			}
+			if strings.HasPrefix(files[0].NewFileName, ".") != (e.expected == ".env") {
+				t.Errorf("%s, %s: unexpected hidden name %q", e.name, name, files[0].NewFileName)
+			}
+		}
+	}
+
+	// the handler refuses a hidden name with 400
+	rr := httptest.NewRecorder()
+	testTools := Tools{}
+	testTools.UploadHandler(t.TempDir(), WithUploadRename(false))(rr, encodedNameRequest(t, encodedDisposition(".htaccess"), content))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), ".htaccess") {
+		t.Errorf("expected a 400 naming .htaccess, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTools_UploadFilesMaliciousNames(t *testing.T) {
+	png := readTestFile(t, "img.png")
+
+	for _, e := range safeFileNameTests {
+		if e.filename == "" {
+			// a part without a file name is a plain form field, not a file
+			continue
+		}
+
+		for name, upload := range uploadFuncs {
+			root := t.TempDir()
+			dir := filepath.Join(root, "a", "b", "uploads")
+
+			var testTools Tools
+			files, err := upload(&testTools, encodedNameRequest(t, encodedDisposition(e.filename), png), dir, false)
+			if !errors.Is(err, e.err) {
+				t.Errorf("%s, %s: expected error %v, got %v", e.name, name, e.err, err)
+			}
+			if err == nil && (len(files) != 1 || files[0].NewFileName != e.expected) {
+				t.Errorf("%s, %s: expected the file to be saved as %q, got %+v", e.name, name, e.expected, files)
+			}
+
+			// whatever the name, nothing is written outside the upload directory
+			for _, f := range remainingFiles(t, root) {
+				if filepath.Dir(f) != "a/b/uploads" {
+					t.Errorf("%s, %s: expected every file to be saved in the upload directory, found %s", e.name, name, f)
+				}
+			}
+		}
+	}
+}
+
+func TestCandidateNames(t *testing.T) {
+	var tests = []struct {
+		name     string
+		policy   ExistsPolicy
+		compound []string
+		expected []string
+	}{
+		{name: "report.pdf", policy: ExistsError, expected: []string{"report.pdf", ""}},
+		{name: "report.pdf", policy: ExistsAutoRename, expected: []string{"report.pdf", "report-1.pdf", "report-2.pdf"}},
+		{name: "archive.tar.gz", policy: ExistsAutoRename, expected: []string{"archive.tar.gz", "archive.tar-1.gz"}},
+		{name: "archive.tar.gz", policy: ExistsAutoRename, compound: defaultCompoundExtensions, expected: []string{"archive.tar.gz", "archive-1.tar.gz"}},
+		{name: ".tar.gz", policy: ExistsAutoRename, compound: defaultCompoundExtensions, expected: []string{".tar.gz", ".tar.gz-1"}},
+		{name: "README", policy: ExistsAutoRename, expected: []string{"README", "README-1"}},
+		{name: ".env", policy: ExistsAutoRename, expected: []string{".env", ".env-1"}},
+	}
+
+	for _, e := range tests {
+		next := candidateNames(e.name, e.policy, e.compound, 0)
+		for i, expected := range e.expected {
+			if got := next(); got != expected {
+				t.Errorf("%s, policy %d: expected name %d to be %q, got %q", e.name, e.policy, i, expected, got)
+			}
+		}
+	}
+
+	next := candidateNames("report.pdf", ExistsAutoRename, nil, 0)
+	tried := 0
+	for next() != "" {
+		tried++
+	}
+	if tried != maxAutoRename+1 {
+		t.Errorf("expected %d names to be tried, got %d", maxAutoRename+1, tried)
+	}
+}
+
+func TestTools_UploadFilesExistsPolicy(t *testing.T) {
+	var tests = []struct {
+		name      string
+		policy    ExistsPolicy
+		taken     []string
+		expected  string
+		err       error
+		remaining []string
+	}{
+		{name: "overwrite", policy: ExistsOverwrite, taken: []string{"report.pdf"}, expected: "report.pdf", remaining: []string{"report.pdf"}},
+		{name: "error", policy: ExistsError, taken: []string{"report.pdf"}, err: ErrFileExists, remaining: []string{"report.pdf"}},
+		{name: "error, name free", policy: ExistsError, expected: "report.pdf", remaining: []string{"report.pdf"}},
+		{name: "auto rename", policy: ExistsAutoRename, taken: []string{"report.pdf"}, expected: "report-1.pdf", remaining: []string{"report-1.pdf", "report.pdf"}},
+		{name: "auto rename twice", policy: ExistsAutoRename, taken: []string{"report.pdf", "report-1.pdf"}, expected: "report-2.pdf", remaining: []string{"report-1.pdf", "report-2.pdf", "report.pdf"}},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{ExistsPolicy: e.policy}
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			for _, taken := range e.taken {
+				if err := os.WriteFile(filepath.Join(dir, taken), []byte("old report"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			files, err := upload(&testTools, newUploadRequest(t, testUpload{"file", "report.pdf", []byte("new report")}), dir, false)
+			if !errors.Is(err, e.err) {
+				t.Errorf("%s, %s: expected error %v, got %v", e.name, name, e.err, err)
+			}
+			var existsErr *FileExistsError
+			if errors.As(err, &existsErr) && existsErr.FileName != "report.pdf" {
+				t.Errorf("%s, %s: expected the error to name report.pdf, got %q", e.name, name, existsErr.FileName)
+			}
+
+			if err == nil {
+				if files[0].NewFileName != e.expected {
+					t.Errorf("%s, %s: expected the file to be saved as %s, got %s", e.name, name, e.expected, files[0].NewFileName)
+				}
+				if saved, _ := os.ReadFile(filepath.Join(dir, e.expected)); string(saved) != "new report" {
+					t.Errorf("%s, %s: expected %s to hold the new report, got %q", e.name, name, e.expected, saved)
+				}
+			}
+			if got := remainingFiles(t, dir); !reflect.DeepEqual(got, e.remaining) {
+				t.Errorf("%s, %s: expected %v to be left, got %v", e.name, name, e.remaining, got)
+			}
+		}
+	}
+}
+
+// TestTools_UploadFilesExistsPolicyConcurrent uploads the same name from many requests at once, so that only a
+// policy that checks and takes a name in one step gives each of them its own file
+func TestTools_UploadFilesExistsPolicyConcurrent(t *testing.T) {
+	const uploads = 10
+
+	var tests = []struct {
+		name      string
+		policy    ExistsPolicy
+		saved     int
+		remaining int
+	}{
+		{name: "overwrite", policy: ExistsOverwrite, saved: uploads, remaining: 1},
+		{name: "error", policy: ExistsError, saved: 1, remaining: 1},
+		{name: "auto rename", policy: ExistsAutoRename, saved: uploads, remaining: uploads},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{ExistsPolicy: e.policy}
+		for name, store := range map[string]func(dir string) FileStore{
+			"DiskStore":   func(dir string) FileStore { return &DiskStore{Dir: dir} },
+			"MemoryStore": func(string) FileStore { return &MemoryStore{} },
+		} {
+			dir := t.TempDir()
+			target := store(dir)
+
+			reqs := make([]*http.Request, uploads)
+			for i := range reqs {
+				reqs[i] = newUploadRequest(t, testUpload{"file", "report.pdf", []byte("report")})
+			}
+
+			var wg sync.WaitGroup
+			results := make(chan error, uploads)
+			names := make(chan string, uploads)
+			for _, req := range reqs {
+				wg.Add(1)
+				go func(req *http.Request) {
+					defer wg.Done()
+					files, err := testTools.UploadFilesTo(req, target, false)
+					if err == nil {
+						names <- files[0].NewFileName
+					}
+					results <- err
+				}(req)
+			}
+			wg.Wait()
+			close(results)
+			close(names)
+
+			saved := 0
+			for err := range results {
+				switch {
+				case err == nil:
+					saved++
+				case !errors.Is(err, ErrFileExists):
+					t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				}
+			}
+			distinct := map[string]bool{}
+			for n := range names {
+				distinct[n] = true
+			}
+
+			if saved != e.saved {
+				t.Errorf("%s, %s: expected %d uploads to be saved, got %d", e.name, name, e.saved, saved)
+			}
+			if len(distinct) != e.remaining {
+				t.Errorf("%s, %s: expected %d distinct names, got %v", e.name, name, e.remaining, distinct)
+			}
+			if ds, ok := target.(*DiskStore); ok {
+				if got := remainingFiles(t, ds.Dir); len(got) != e.remaining {
+					t.Errorf("%s, %s: expected %d files to be left, got %v", e.name, name, e.remaining, got)
+				}
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesSubdirStrategy(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	hash := "80babf09b223f1049a5b8216fb5de60ae6dd956e6872e76f838a1c57a5e34147"
+
+	// the date is taken either side of the upload, in case it runs over midnight
+	dates := func() []string {
+		return []string{time.Now().UTC().Format("2006/01/02") + "/"}
+	}
+
+	var tests = []struct {
+		name    string
+		tools   Tools
+		rename  bool
+		subdirs func() []string
+		stored  string
+	}{
+		{name: "none", rename: false, subdirs: func() []string { return []string{""} }, stored: "img.png"},
+		{name: "date", tools: Tools{SubdirStrategy: SubdirDate}, rename: false, subdirs: dates, stored: "img.png"},
+		{name: "date, random name", tools: Tools{SubdirStrategy: SubdirDate}, rename: true, subdirs: dates},
+		{name: "hash prefix", tools: Tools{SubdirStrategy: SubdirHashPrefix}, rename: false, subdirs: func() []string { return []string{"80/ba/"} }, stored: "img.png"},
+		{name: "hash prefix, content hash", tools: Tools{SubdirStrategy: SubdirHashPrefix, NamingStrategy: NamingContentHash}, subdirs: func() []string { return []string{"80/ba/"} }, stored: hash + ".png"},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			before := e.subdirs()
+			files, err := upload(&e.tools, newUploadRequest(t, testUpload{"file", "img.png", png}), dir, e.rename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			subdirs := append(before, e.subdirs()...)
+
+			f := files[0]
+			if e.stored != "" && f.NewFileName != e.stored {
+				t.Errorf("%s, %s: expected the file to be named %s, got %s", e.name, name, e.stored, f.NewFileName)
+			}
+			if f.StoredPath != subdirs[0]+f.NewFileName && f.StoredPath != subdirs[1]+f.NewFileName {
+				t.Errorf("%s, %s: expected the file to be stored in %s, got %s", e.name, name, subdirs[0], f.StoredPath)
+			}
+			if e.tools.SubdirStrategy == SubdirHashPrefix && f.SHA256 != hash {
+				t.Errorf("%s, %s: expected the hash to be set, got %q", e.name, name, f.SHA256)
+			}
+			if got := remainingFiles(t, dir); !reflect.DeepEqual(got, []string{f.StoredPath}) {
+				t.Errorf("%s, %s: expected only %s to be saved, got %v", e.name, name, f.StoredPath, got)
+			}
+		}
+	}
+
+	t.Run("content hash is deduplicated in its subdirectory", func(t *testing.T) {
+		testTools := Tools{SubdirStrategy: SubdirHashPrefix, NamingStrategy: NamingContentHash}
+		dir := t.TempDir()
+		for i, deduplicated := range []bool{false, true} {
+			files, err := testTools.UploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if files[0].Deduplicated != deduplicated || files[0].StoredPath != "80/ba/"+hash+".png" {
+				t.Errorf("upload %d: expected %s, deduplicated %t, got %+v", i+1, "80/ba/"+hash+".png", deduplicated, files[0])
+			}
+		}
+	})
+
+	t.Run("auto rename stays in the subdirectory", func(t *testing.T) {
+		testTools := Tools{SubdirStrategy: SubdirHashPrefix, ExistsPolicy: ExistsAutoRename}
+		dir := t.TempDir()
+		var stored []string
+		for i := 0; i < 2; i++ {
+			files, err := testTools.UploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), dir, WithRename(false))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored = append(stored, files[0].StoredPath)
+		}
+		if !reflect.DeepEqual(stored, []string{"80/ba/img.png", "80/ba/img-1.png"}) {
+			t.Errorf("expected the second file to be renamed beside the first, got %v", stored)
+		}
+	})
+
+	t.Run("failed upload removes files from subdirectories", func(t *testing.T) {
+		testTools := Tools{SubdirStrategy: SubdirHashPrefix, AllowedFileTypes: []string{"image/png"}}
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			req := newUploadRequest(t, testUpload{"file", "img.png", png}, testUpload{"file", "pic.jpg", jpg})
+			if _, err := upload(&testTools, req, dir); !errors.Is(err, ErrFileTypeNotPermitted) {
+				t.Errorf("%s: expected %v, got %v", name, ErrFileTypeNotPermitted, err)
+			}
+			if got := remainingFiles(t, dir); got != nil {
+				t.Errorf("%s: expected every saved file to be removed, got %v", name, got)
+			}
+		}
+	})
+}
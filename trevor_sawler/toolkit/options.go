@@ -0,0 +1,775 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Defaults used when the corresponding Tools field is left at its zero value. New fills these in up front; the
+// methods fall back to them so that a bare Tools{} literal keeps working
+const (
+	defaultMaxFileSize        = 1024 * 1024 * 1024 // 1 GB
+	defaultMaxJSONSize        = 1024 * 1024        // 1 MB
+	defaultETagMaxBufferSize  = 1024 * 1024        // 1 MB
+	defaultHealthCheckTimeout = 5 * time.Second
+	defaultHealthCacheTTL     = time.Second
+	defaultSlugMaxInputLength = 10000 // runes
+)
+
+// Option configures a Tools value created by New
+type Option func(*Tools) error
+
+// New returns a Tools configured by opts, with every unset limit given its default. Unlike a Tools literal, the
+// configuration is validated, and New returns a descriptive error for nonsense such as negative sizes
+func New(opts ...Option) (*Tools, error) {
+	t := &Tools{}
+
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	if t.MaxFileSize == 0 {
+		t.MaxFileSize = defaultMaxFileSize
+	}
+	if t.MaxJSONSize == 0 {
+		t.MaxJSONSize = defaultMaxJSONSize
+	}
+	if t.ETagMaxBufferSize == 0 {
+		t.ETagMaxBufferSize = defaultETagMaxBufferSize
+	}
+	if t.HealthCheckTimeout == 0 {
+		t.HealthCheckTimeout = defaultHealthCheckTimeout
+	}
+	if t.HealthCacheTTL == 0 {
+		t.HealthCacheTTL = defaultHealthCacheTTL
+	}
+	if t.SlugMaxInputLength == 0 {
+		t.SlugMaxInputLength = defaultSlugMaxInputLength
+	}
+
+	return t, nil
+}
+
+// validate checks the combination of settings, returning every problem found
+func (t *Tools) validate() error {
+	var problems []string
+
+	if t.MaxFileSize < 0 {
+		problems = append(problems, fmt.Sprintf("MaxFileSize must not be negative (got %d)", t.MaxFileSize))
+	}
+	if t.MaxFilePerSize < 0 {
+		problems = append(problems, fmt.Sprintf("MaxFilePerSize must not be negative (got %d)", t.MaxFilePerSize))
+	}
+	if t.MinFileSize < 0 {
+		problems = append(problems, fmt.Sprintf("MinFileSize must not be negative (got %d)", t.MinFileSize))
+	}
+	if t.MaxFilePerSize > 0 && t.MinFileSize > t.MaxFilePerSize {
+		problems = append(problems, fmt.Sprintf("MinFileSize (%d) must not be more than MaxFilePerSize (%d)", t.MinFileSize, t.MaxFilePerSize))
+	}
+	if t.MaxFiles < 0 {
+		problems = append(problems, fmt.Sprintf("MaxFiles must not be negative (got %d)", t.MaxFiles))
+	}
+	if t.SniffLength != 0 && (t.SniffLength < sniffLen || t.SniffLength > uploadBufferSize) {
+		problems = append(problems, fmt.Sprintf("SniffLength must be between %d and %d (got %d)", sniffLen, uploadBufferSize, t.SniffLength))
+	}
+	if t.MaxFileNameLength < 0 || t.MaxFileNameLength > maxFileNameLength {
+		problems = append(problems, fmt.Sprintf("MaxFileNameLength must be between 0 and %d (got %d)", maxFileNameLength, t.MaxFileNameLength))
+	}
+	if t.MultipartMemoryLimit < 0 {
+		problems = append(problems, fmt.Sprintf("MultipartMemoryLimit must not be negative (got %d)", t.MultipartMemoryLimit))
+	}
+	if t.MaxTotalUploadSize < 0 {
+		problems = append(problems, fmt.Sprintf("MaxTotalUploadSize must not be negative (got %d)", t.MaxTotalUploadSize))
+	}
+	if t.Concurrency < 0 {
+		problems = append(problems, fmt.Sprintf("Concurrency must not be negative (got %d)", t.Concurrency))
+	}
+	if t.MaxImageWidth < 0 {
+		problems = append(problems, fmt.Sprintf("MaxImageWidth must not be negative (got %d)", t.MaxImageWidth))
+	}
+	if t.MaxImageHeight < 0 {
+		problems = append(problems, fmt.Sprintf("MaxImageHeight must not be negative (got %d)", t.MaxImageHeight))
+	}
+	if t.MaxImagePixels < 0 {
+		problems = append(problems, fmt.Sprintf("MaxImagePixels must not be negative (got %d)", t.MaxImagePixels))
+	}
+	if _, ok := normalizedImageTypes[t.NormalizeImageFormat]; t.NormalizeImageFormat != "" && !ok {
+		problems = append(problems, fmt.Sprintf("NormalizeImageFormat %q is not one of \"jpeg\" and \"png\"", t.NormalizeImageFormat))
+	}
+	if t.NormalizeJPEGQuality < 0 || t.NormalizeJPEGQuality > 100 {
+		problems = append(problems, fmt.Sprintf("NormalizeJPEGQuality must be between 1 and 100 (got %d)", t.NormalizeJPEGQuality))
+	}
+	if t.URLUploadTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("URLUploadTimeout must not be negative (got %s)", t.URLUploadTimeout))
+	}
+	if t.MaxJSONSize < 0 {
+		problems = append(problems, fmt.Sprintf("MaxJSONSize must not be negative (got %d)", t.MaxJSONSize))
+	}
+	if t.ETagMaxBufferSize < 0 {
+		problems = append(problems, fmt.Sprintf("ETagMaxBufferSize must not be negative (got %d)", t.ETagMaxBufferSize))
+	}
+	if t.HealthCheckTimeout < 0 {
+		problems = append(problems, fmt.Sprintf("HealthCheckTimeout must not be negative (got %s)", t.HealthCheckTimeout))
+	}
+	if t.HealthCacheTTL < 0 {
+		problems = append(problems, fmt.Sprintf("HealthCacheTTL must not be negative (got %s)", t.HealthCacheTTL))
+	}
+	suffixes := make(map[string]bool, len(t.Thumbnails))
+	for _, spec := range t.Thumbnails {
+		switch {
+		case spec.Width < 0 || spec.Height < 0 || (spec.Width == 0 && spec.Height == 0):
+			problems = append(problems, fmt.Sprintf("thumbnail %q needs a positive Width or Height (got %dx%d)", spec.Suffix, spec.Width, spec.Height))
+		case spec.Suffix == "" || strings.ContainsAny(spec.Suffix, `/\`):
+			problems = append(problems, fmt.Sprintf("thumbnail Suffix %q must be set and must not hold a slash", spec.Suffix))
+		case suffixes[spec.Suffix]:
+			problems = append(problems, fmt.Sprintf("thumbnail Suffix %q is used more than once", spec.Suffix))
+		}
+		suffixes[spec.Suffix] = true
+	}
+	if t.ZipLimits != nil {
+		if t.ZipLimits.MaxEntries < 0 {
+			problems = append(problems, fmt.Sprintf("ZipLimits.MaxEntries must not be negative (got %d)", t.ZipLimits.MaxEntries))
+		}
+		if t.ZipLimits.MaxUncompressedSize < 0 {
+			problems = append(problems, fmt.Sprintf("ZipLimits.MaxUncompressedSize must not be negative (got %d)", t.ZipLimits.MaxUncompressedSize))
+		}
+		if t.ZipLimits.MaxCompressionRatio < 0 {
+			problems = append(problems, fmt.Sprintf("ZipLimits.MaxCompressionRatio must not be negative (got %g)", t.ZipLimits.MaxCompressionRatio))
+		}
+	}
+	if t.MaxDirBytes < 0 {
+		problems = append(problems, fmt.Sprintf("MaxDirBytes must not be negative (got %d)", t.MaxDirBytes))
+	}
+	if t.FileMode&^os.ModePerm != 0 {
+		problems = append(problems, fmt.Sprintf("FileMode %s must only hold permission bits", t.FileMode))
+	}
+	if t.DirMode&^(os.ModePerm|os.ModeSetgid|os.ModeSticky) != 0 {
+		problems = append(problems, fmt.Sprintf("DirMode %s must only hold permission, setgid and sticky bits", t.DirMode))
+	}
+	if t.FreeSpaceHeadroom < 0 {
+		problems = append(problems, fmt.Sprintf("FreeSpaceHeadroom must not be negative (got %g)", t.FreeSpaceHeadroom))
+	}
+	if t.NamingStrategy < NamingRename || t.NamingStrategy > NamingULID {
+		problems = append(problems, fmt.Sprintf("NamingStrategy %d is not a known strategy", t.NamingStrategy))
+	}
+	if t.SubdirStrategy < SubdirNone || t.SubdirStrategy > SubdirHashPrefix {
+		problems = append(problems, fmt.Sprintf("SubdirStrategy %d is not a known strategy", t.SubdirStrategy))
+	}
+	if t.ExistsPolicy < ExistsOverwrite || t.ExistsPolicy > ExistsAutoRename {
+		problems = append(problems, fmt.Sprintf("ExistsPolicy %d is not a known policy", t.ExistsPolicy))
+	}
+	if t.SVGPolicy < SVGAllow || t.SVGPolicy > SVGSanitize {
+		problems = append(problems, fmt.Sprintf("SVGPolicy %d is not a known policy", t.SVGPolicy))
+	}
+	if t.RenameFunc != nil && t.NamingStrategy == NamingContentHash {
+		problems = append(problems, "RenameFunc can't be used with NamingContentHash")
+	} else if t.RenameFunc != nil && t.namesByHash() {
+		problems = append(problems, "RenameFunc can't be used with Deduplicate unless ExistsByHash is set")
+	}
+	if t.ExistsByHash != nil && !t.Deduplicate {
+		problems = append(problems, "ExistsByHash is only used with Deduplicate")
+	}
+	if t.MetadataFunc != nil && !t.WriteMetadata {
+		problems = append(problems, "MetadataFunc is only used with WriteMetadata")
+	}
+	if t.SlugMaxInputLength < 0 {
+		problems = append(problems, fmt.Sprintf("SlugMaxInputLength must not be negative (got %d)", t.SlugMaxInputLength))
+	}
+
+	for _, ext := range t.AllowedFileExtensions {
+		if strings.TrimPrefix(ext, ".") == "" || strings.ContainsAny(ext, `/\`) {
+			problems = append(problems, fmt.Sprintf("AllowedFileExtensions entry %q is not a file extension", ext))
+		}
+	}
+	for _, ext := range t.CompoundExtensions {
+		parts := strings.Split(strings.TrimPrefix(ext, "."), ".")
+		if len(parts) < 2 || strings.ContainsAny(ext, `/\`) || strings.Contains(ext, "..") || parts[len(parts)-1] == "" {
+			problems = append(problems, fmt.Sprintf("CompoundExtensions entry %q is not an extension of several parts, such as .tar.gz", ext))
+		}
+	}
+
+	for _, name := range t.AllowedFormFields {
+		if name == "" {
+			problems = append(problems, "AllowedFormFields must not contain an empty field name")
+		}
+	}
+
+	sizeLimitTypes := make([]string, 0, len(t.SizeLimitsByType))
+	for ft := range t.SizeLimitsByType {
+		sizeLimitTypes = append(sizeLimitTypes, ft)
+	}
+	sort.Strings(sizeLimitTypes)
+	for _, ft := range sizeLimitTypes {
+		if limit := t.SizeLimitsByType[ft]; limit <= 0 {
+			problems = append(problems, fmt.Sprintf("SizeLimitsByType entry %q must be positive (got %d)", ft, limit))
+		}
+	}
+
+	for _, list := range []struct {
+		field string
+		types []string
+	}{{"AllowedFileTypes", t.AllowedFileTypes}, {"DeniedFileTypes", t.DeniedFileTypes}, {"SizeLimitsByType", sizeLimitTypes}} {
+		for _, ft := range list.types {
+			if i := strings.Index(ft, "/"); i <= 0 || i == len(ft)-1 {
+				problems = append(problems, fmt.Sprintf("%s entry %q is not a MIME type", list.field, ft))
+			} else if ft[:i] == "*" && ft != "*/*" {
+				problems = append(problems, fmt.Sprintf("%s entry %q can only use a wildcard for the subtype", list.field, ft))
+			}
+		}
+	}
+
+	for i, key := range t.CookieKeys {
+		if len(key) < 32 {
+			problems = append(problems, fmt.Sprintf("CookieKeys entry %d must be at least 32 bytes (got %d)", i, len(key)))
+		}
+	}
+
+	for i, key := range t.EncryptionKeys {
+		if len(key) != encryptionKeySize {
+			problems = append(problems, fmt.Sprintf("EncryptionKeys entry %d must be exactly %d bytes (got %d)", i, encryptionKeySize, len(key)))
+		}
+	}
+
+	if t.RandomStringSource != "" && utf8.RuneCountInString(t.RandomStringSource) < 2 {
+		problems = append(problems, "RandomStringSource must contain at least 2 characters")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid toolkit configuration: " + strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// WithMaxFileSize sets the maximum size in bytes of a whole upload request, however many files it holds
+func WithMaxFileSize(n int) Option {
+	return func(t *Tools) error {
+		t.MaxFileSize = n
+		return nil
+	}
+}
+
+// WithMaxFilePerSize sets the maximum size in bytes of each uploaded file. Zero means no limit other than
+// MaxFileSize
+func WithMaxFilePerSize(n int64) Option {
+	return func(t *Tools) error {
+		t.MaxFilePerSize = n
+		return nil
+	}
+}
+
+// WithSizeLimitsByType sets the maximum size of uploaded files by their type, such as "image/*", in place of
+// MaxFilePerSize
+func WithSizeLimitsByType(limits map[string]int64) Option {
+	return func(t *Tools) error {
+		t.SizeLimitsByType = limits
+		return nil
+	}
+}
+
+// WithMinFileSize sets the minimum size in bytes of each uploaded file. Zero means 1 byte: empty files are always
+// refused
+func WithMinFileSize(n int64) Option {
+	return func(t *Tools) error {
+		t.MinFileSize = n
+		return nil
+	}
+}
+
+// WithMaxFiles sets the most files UploadFiles accepts in one request. Zero means no limit
+func WithMaxFiles(n int) Option {
+	return func(t *Tools) error {
+		t.MaxFiles = n
+		return nil
+	}
+}
+
+// WithMaxTotalUploadSize sets the most bytes the files of one request may come to together, whatever MaxFilePerSize
+// allows each of them. Zero means no limit other than MaxFileSize
+func WithMaxTotalUploadSize(n int64) Option {
+	return func(t *Tools) error {
+		t.MaxTotalUploadSize = n
+		return nil
+	}
+}
+
+// WithMultipartMemory sets how many bytes of a form's files UploadFiles holds in memory, 32MB when zero, and the
+// directory the rest are spooled to, os.TempDir() when empty. r.MultipartForm is left without the files
+func WithMultipartMemory(memoryLimit int64, tempDir string) Option {
+	return func(t *Tools) error {
+		t.MultipartMemoryLimit = memoryLimit
+		t.TempDir = tempDir
+		return nil
+	}
+}
+
+// WithAllowedFileTypes sets the MIME types UploadFiles accepts. An entry such as "image/*" accepts every subtype
+func WithAllowedFileTypes(types ...string) Option {
+	return func(t *Tools) error {
+		t.AllowedFileTypes = types
+		return nil
+	}
+}
+
+// WithDetectContentType sets the function that finds the type of an uploaded file from its first sniffLength bytes,
+// at least 512, in place of http.DetectContentType, which is still used when fn returns ""
+func WithDetectContentType(fn func(head []byte, filename string) string, sniffLength int) Option {
+	return func(t *Tools) error {
+		t.DetectContentTypeFunc = fn
+		t.SniffLength = sniffLength
+		return nil
+	}
+}
+
+// WithDeniedFileTypes sets the MIME types, such as "text/*", that are refused even when AllowedFileTypes accepts them
+func WithDeniedFileTypes(types ...string) Option {
+	return func(t *Tools) error {
+		t.DeniedFileTypes = types
+		return nil
+	}
+}
+
+// WithSVGPolicy sets whether UploadFiles and StreamUploadFiles accept, refuse or sanitize SVG images
+func WithSVGPolicy(policy SVGPolicy) Option {
+	return func(t *Tools) error {
+		t.SVGPolicy = policy
+		return nil
+	}
+}
+
+// WithCompoundExtensions sets the extensions of several parts, such as ".tar.gz", that are kept whole
+func WithCompoundExtensions(exts ...string) Option {
+	return func(t *Tools) error {
+		t.CompoundExtensions = append([]string{}, exts...)
+		return nil
+	}
+}
+
+// WithAllowHiddenFiles sets whether files kept under their original names may be saved when those names begin with a
+// dot, such as .htaccess, which are refused by default
+func WithAllowHiddenFiles(allow bool) Option {
+	return func(t *Tools) error {
+		t.AllowHiddenFiles = allow
+		return nil
+	}
+}
+
+// WithMaxFileNameLength sets the most bytes the name of a saved file may have, 255 when n is zero. Longer names are
+// cut short before their extension
+func WithMaxFileNameLength(n int) Option {
+	return func(t *Tools) error {
+		t.MaxFileNameLength = n
+		return nil
+	}
+}
+
+// WithAllowedFileExtensions sets the file name extensions UploadFiles accepts, such as "csv" or ".csv". When
+// AllowedFileTypes is also set, a file must pass both checks
+func WithAllowedFileExtensions(exts ...string) Option {
+	return func(t *Tools) error {
+		t.AllowedFileExtensions = exts
+		return nil
+	}
+}
+
+// WithRejectExtensionMismatch sets whether UploadFiles and StreamUploadFiles refuse a file whose content is not of
+// the type its extension claims
+func WithRejectExtensionMismatch(reject bool) Option {
+	return func(t *Tools) error {
+		t.RejectExtensionMismatch = reject
+		return nil
+	}
+}
+
+// WithAllowedFormFields restricts UploadFiles to the files posted under the given form field names. Files under any
+// other field are ignored or, when strict is true, refuse the whole request with a *FormFieldError
+func WithAllowedFormFields(strict bool, names ...string) Option {
+	return func(t *Tools) error {
+		t.AllowedFormFields = names
+		t.StrictFormFields = strict
+		return nil
+	}
+}
+
+// WithMaxImageSize sets the largest width and height, in pixels, of a PNG, JPEG or GIF image that UploadFiles and
+// StreamUploadFiles accept. Zero means no limit
+func WithMaxImageSize(width, height int) Option {
+	return func(t *Tools) error {
+		t.MaxImageWidth = width
+		t.MaxImageHeight = height
+		return nil
+	}
+}
+
+// WithMaxImagePixels sets the most pixels, width times height, of a PNG, JPEG or GIF image that UploadFiles and
+// StreamUploadFiles accept. Zero means no limit
+func WithMaxImagePixels(n int64) Option {
+	return func(t *Tools) error {
+		t.MaxImagePixels = n
+		return nil
+	}
+}
+
+// WithNormalizeImageFormat saves every uploaded PNG, JPEG or GIF image as format, "jpeg" or "png", at jpegQuality.
+// Animated GIFs are refused with an *AnimatedImageError
+func WithNormalizeImageFormat(format string, jpegQuality int) Option {
+	return func(t *Tools) error {
+		t.NormalizeImageFormat = format
+		t.NormalizeJPEGQuality = jpegQuality
+		return nil
+	}
+}
+
+// WithThumbnails sets the thumbnails saved with each PNG, JPEG or GIF image uploaded. A thumbnail that can't be
+// made fails the upload when strict is true, and is only logged otherwise
+func WithThumbnails(strict bool, specs ...ThumbnailSpec) Option {
+	return func(t *Tools) error {
+		t.Thumbnails = specs
+		t.StrictThumbnails = strict
+		return nil
+	}
+}
+
+// WithZipLimits has UploadFiles and StreamUploadFiles check each ZIP archive uploaded against limits, and for entries
+// with unsafe paths, removing the archive again when it fails
+func WithZipLimits(limits ZipLimits) Option {
+	return func(t *Tools) error {
+		t.ZipLimits = &limits
+		return nil
+	}
+}
+
+// WithExtractArchives extracts each uploaded ZIP archive to a directory named after it
+func WithExtractArchives(extract bool) Option {
+	return func(t *Tools) error {
+		t.ExtractArchives = extract
+		return nil
+	}
+}
+
+// WithScanner scans each uploaded file with s, removing it when s rejects it, or fails when failOpen is false
+func WithScanner(s Scanner, failOpen bool) Option {
+	return func(t *Tools) error {
+		t.Scanner = s
+		t.ScanFailOpen = failOpen
+		return nil
+	}
+}
+
+// WithDeduplicate stores a file uploaded again only once. existsByHash finds the StoredPath of a file by its SHA-256;
+// when it is nil, files are named by their hash
+func WithDeduplicate(existsByHash func(hash string) (string, bool)) Option {
+	return func(t *Tools) error {
+		t.Deduplicate = true
+		t.ExistsByHash = existsByHash
+		return nil
+	}
+}
+
+// WithMetadata has UploadFiles and StreamUploadFiles write a metadata sidecar, <name>.meta.json, next to each
+// uploaded file once all of them have been saved. fn, when not nil, returns extra fields for the sidecar of a file
+func WithMetadata(fn func(uploadedFile *UploadedFile) map[string]interface{}) Option {
+	return func(t *Tools) error {
+		t.WriteMetadata = true
+		t.MetadataFunc = fn
+		return nil
+	}
+}
+
+// WithMaxDirBytes sets the most bytes UploadFiles lets an upload directory hold. Zero means no limit
+func WithMaxDirBytes(n int64) Option {
+	return func(t *Tools) error {
+		t.MaxDirBytes = n
+		return nil
+	}
+}
+
+// WithFileMode sets the permissions of the files UploadFiles and StreamUploadFiles save, such as 0640, in place of
+// 0666 less the umask
+func WithFileMode(mode os.FileMode) Option {
+	return func(t *Tools) error {
+		t.FileMode = mode
+		return nil
+	}
+}
+
+// WithDirMode sets the permissions of the directories CreateDirIfNotExist creates, in place of 0755 less the umask
+func WithDirMode(mode os.FileMode) Option {
+	return func(t *Tools) error {
+		t.DirMode = mode
+		return nil
+	}
+}
+
+// WithFreeSpaceCheck refuses an upload the upload directory hasn't room for, plus headroom: with 0.1, an upload of
+// up to 100MB needs 110MB free
+func WithFreeSpaceCheck(headroom float64) Option {
+	return func(t *Tools) error {
+		t.CheckFreeSpace = true
+		t.FreeSpaceHeadroom = headroom
+		return nil
+	}
+}
+
+// WithComputeChecksum makes UploadFiles and StreamUploadFiles set the SHA256 of each UploadedFile
+func WithComputeChecksum(compute bool) Option {
+	return func(t *Tools) error {
+		t.ComputeChecksum = compute
+		return nil
+	}
+}
+
+// WithNamingStrategy sets how UploadFiles and StreamUploadFiles name the files they save
+func WithNamingStrategy(strategy NamingStrategy) Option {
+	return func(t *Tools) error {
+		t.NamingStrategy = strategy
+		return nil
+	}
+}
+
+// WithSubdirStrategy sets the subdirectories of the upload directory that UploadFiles and StreamUploadFiles save
+// files in
+func WithSubdirStrategy(strategy SubdirStrategy) Option {
+	return func(t *Tools) error {
+		t.SubdirStrategy = strategy
+		return nil
+	}
+}
+
+// WithRenameFunc sets a function that UploadFiles and StreamUploadFiles call with the original name of each file to
+// get the name to save it under, in place of a random or the original name
+func WithRenameFunc(fn func(original string) string) Option {
+	return func(t *Tools) error {
+		t.RenameFunc = fn
+		return nil
+	}
+}
+
+// WithKeepPartialUploads makes UploadFiles and StreamUploadFiles keep the files they have already saved when a
+// later file of the same request fails, and return them along with the error, rather than removing them
+func WithKeepPartialUploads(keep bool) Option {
+	return func(t *Tools) error {
+		t.KeepPartialUploads = keep
+		return nil
+	}
+}
+
+// WithSaveHooks sets the functions called for each uploaded file before it is written and after the whole upload
+// is saved. An error from either refuses the file with a *HookError. Either may be nil
+func WithSaveHooks(before, after func(ctx context.Context, uploadedFile *UploadedFile) error) Option {
+	return func(t *Tools) error {
+		t.BeforeSave = before
+		t.AfterSave = after
+		return nil
+	}
+}
+
+// WithTakeFirstFile makes UploadOneFile save the file posted first in a request that holds several, and ignore the
+// rest, rather than refuse the request with a *MultipleFilesError
+func WithTakeFirstFile(take bool) Option {
+	return func(t *Tools) error {
+		t.TakeFirstFile = take
+		return nil
+	}
+}
+
+// WithContinueOnError makes UploadFiles and StreamUploadFiles save the other files of a request when one fails, and
+// return them along with a MultiError holding an *UploadFileError for each file that failed
+func WithContinueOnError(continueOnError bool) Option {
+	return func(t *Tools) error {
+		t.ContinueOnError = continueOnError
+		return nil
+	}
+}
+
+// WithExistsPolicy sets what UploadFiles and StreamUploadFiles do when a file's name is already taken
+func WithExistsPolicy(policy ExistsPolicy) Option {
+	return func(t *Tools) error {
+		t.ExistsPolicy = policy
+		return nil
+	}
+}
+
+// WithOnProgress sets a function called about once a megabyte as each file is saved, with the bytes saved so far
+// and the size of the file, or -1 when that isn't known
+func WithOnProgress(fn func(filename string, bytesWritten, totalBytes int64)) Option {
+	return func(t *Tools) error {
+		t.OnProgress = fn
+		return nil
+	}
+}
+
+// WithConcurrency makes UploadFiles and UploadFilesTo save up to n files of a request at once, rather than one after
+// the other. StreamUploadFiles reads the files as they arrive, so it always saves them one at a time
+func WithConcurrency(n int) Option {
+	return func(t *Tools) error {
+		t.Concurrency = n
+		return nil
+	}
+}
+
+// WithURLUpload sets how long UploadFromURL may take to fetch a file, and whether it may fetch from loopback,
+// private and other internal addresses, which only trusted deployments should allow
+func WithURLUpload(timeout time.Duration, allowPrivate bool) Option {
+	return func(t *Tools) error {
+		t.URLUploadTimeout = timeout
+		t.AllowPrivateURLs = allowPrivate
+		return nil
+	}
+}
+
+// WithMaxJSONSize sets the maximum size in bytes of a JSON body read by ReadJSON
+func WithMaxJSONSize(n int) Option {
+	return func(t *Tools) error {
+		t.MaxJSONSize = n
+		return nil
+	}
+}
+
+// WithAllowUnknownFields makes ReadJSON ignore JSON keys that have no matching struct field
+func WithAllowUnknownFields(allow bool) Option {
+	return func(t *Tools) error {
+		t.AllowUnknownFields = allow
+		return nil
+	}
+}
+
+// WithValidateJSON makes ReadJSON check the decoded value against its validate struct tags with ValidateStruct
+func WithValidateJSON(validate bool) Option {
+	return func(t *Tools) error {
+		t.ValidateJSON = validate
+		return nil
+	}
+}
+
+// WithSanitizeJSON makes ReadJSON clean the string fields tagged with a sanitize policy using SanitizeHTML
+func WithSanitizeJSON(sanitize bool) Option {
+	return func(t *Tools) error {
+		t.SanitizeJSON = sanitize
+		return nil
+	}
+}
+
+// WithTranslator sets the Translator used for client-facing error messages
+func WithTranslator(tr Translator) Option {
+	return func(t *Tools) error {
+		t.Translator = tr
+		return nil
+	}
+}
+
+// WithSlugMaxInputLength sets the longest input, in runes, that Slugify accepts
+func WithSlugMaxInputLength(n int) Option {
+	return func(t *Tools) error {
+		t.SlugMaxInputLength = n
+		return nil
+	}
+}
+
+// WithCursorSecret sets the key EncodeCursor signs cursors with, and DecodeCursor verifies them with
+func WithCursorSecret(secret []byte) Option {
+	return func(t *Tools) error {
+		t.CursorSecret = secret
+		return nil
+	}
+}
+
+// WithTemplates sets the TemplateSet RenderTemplate renders from
+func WithTemplates(set *TemplateSet) Option {
+	return func(t *Tools) error {
+		t.Templates = set
+		return nil
+	}
+}
+
+// WithCookieKeys sets the keys used by SetSignedCookie and GetSignedCookie. The first key signs new cookies, and
+// every key is accepted when verifying them
+func WithCookieKeys(keys ...[]byte) Option {
+	return func(t *Tools) error {
+		if len(keys) == 0 {
+			return errors.New("invalid toolkit configuration: at least one cookie key is required")
+		}
+		t.CookieKeys = keys
+		return nil
+	}
+}
+
+// WithEncryptionKeys sets the AES-256 keys used by Encrypt and Decrypt. The first key encrypts new values, and
+// every key is tried when decrypting
+func WithEncryptionKeys(keys ...[]byte) Option {
+	return func(t *Tools) error {
+		if len(keys) == 0 {
+			return errors.New("invalid toolkit configuration: at least one encryption key is required")
+		}
+		t.EncryptionKeys = keys
+		return nil
+	}
+}
+
+// WithEncryptionPassphrase derives a key from passphrase and salt with DeriveEncryptionKey and adds it to
+// EncryptionKeys. Used more than once, the first passphrase encrypts and the others are kept for decryption
+func WithEncryptionPassphrase(passphrase string, salt []byte) Option {
+	return func(t *Tools) error {
+		if passphrase == "" || len(salt) == 0 {
+			return errors.New("invalid toolkit configuration: encryption passphrase and salt must not be empty")
+		}
+		t.EncryptionKeys = append(t.EncryptionKeys, DeriveEncryptionKey(passphrase, salt))
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used for outgoing requests
+func WithHTTPClient(client *http.Client) Option {
+	return func(t *Tools) error {
+		if client == nil {
+			return errors.New("invalid toolkit configuration: HTTP client must not be nil")
+		}
+		t.HTTPClient = client
+		return nil
+	}
+}
+
+// WithLogger sets the logger used by the toolkit's middleware
+func WithLogger(l *log.Logger) Option {
+	return func(t *Tools) error {
+		if l == nil {
+			return errors.New("invalid toolkit configuration: logger must not be nil")
+		}
+		t.Logger = l
+		return nil
+	}
+}
+
+// WithRandomStringSource sets the characters RandomString picks from
+func WithRandomStringSource(source string) Option {
+	return func(t *Tools) error {
+		if source == "" {
+			return errors.New("invalid toolkit configuration: random string source must not be empty")
+		}
+		t.RandomStringSource = source
+		return nil
+	}
+}
+
+// WithHealthCheck sets the timeout and cache TTL used by HealthHandler
+func WithHealthCheck(timeout, ttl time.Duration) Option {
+	return func(t *Tools) error {
+		t.HealthCheckTimeout = timeout
+		t.HealthCacheTTL = ttl
+		return nil
+	}
+}
@@ -0,0 +1,312 @@
+package toolkit
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	testTools, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testTools.MaxFileSize != defaultMaxFileSize {
+		t.Errorf("expected default MaxFileSize, got %d", testTools.MaxFileSize)
+	}
+	if testTools.MaxJSONSize != defaultMaxJSONSize {
+		t.Errorf("expected default MaxJSONSize, got %d", testTools.MaxJSONSize)
+	}
+	if testTools.HealthCheckTimeout != defaultHealthCheckTimeout {
+		t.Errorf("expected default HealthCheckTimeout, got %s", testTools.HealthCheckTimeout)
+	}
+}
+
+var newTests = []struct {
+	name          string
+	opts          []Option
+	errorExpected bool
+	errorContains []string
+}{
+	{
+		name:          "valid options",
+		opts:          []Option{WithMaxFileSize(10), WithAllowedFileTypes("image/png", "image/jpeg"), WithMaxJSONSize(20), WithLogger(log.New(os.Stderr, "", 0))},
+		errorExpected: false,
+	},
+	{
+		name:          "negative max file size",
+		opts:          []Option{WithMaxFileSize(-1)},
+		errorExpected: true,
+		errorContains: []string{"MaxFileSize must not be negative (got -1)"},
+	},
+	{
+		name:          "empty charset",
+		opts:          []Option{WithRandomStringSource("")},
+		errorExpected: true,
+		errorContains: []string{"random string source must not be empty"},
+	},
+	{
+		name:          "single character charset",
+		opts:          []Option{WithRandomStringSource("a")},
+		errorExpected: true,
+		errorContains: []string{"RandomStringSource must contain at least 2 characters"},
+	},
+	{
+		name:          "bad mime type",
+		opts:          []Option{WithAllowedFileTypes("image/png", "png")},
+		errorExpected: true,
+		errorContains: []string{`"png" is not a MIME type`},
+	},
+	{
+		name:          "wildcard mime types",
+		opts:          []Option{WithAllowedFileTypes("image/*", "*/*")},
+		errorExpected: false,
+	},
+	{
+		name:          "wildcard main type",
+		opts:          []Option{WithAllowedFileTypes("*/png")},
+		errorExpected: true,
+		errorContains: []string{`"*/png" can only use a wildcard for the subtype`},
+	},
+	{
+		name:          "bad size limits by type",
+		opts:          []Option{WithSizeLimitsByType(map[string]int64{"image/*": 5 << 20, "video": 100 << 20, "text/plain": 0})},
+		errorExpected: true,
+		errorContains: []string{`SizeLimitsByType entry "video" is not a MIME type`, `SizeLimitsByType entry "text/plain" must be positive (got 0)`},
+	},
+	{
+		name:          "bad denied mime types",
+		opts:          []Option{WithDeniedFileTypes("text/html", "exe", "*/x-msdownload")},
+		errorExpected: true,
+		errorContains: []string{`DeniedFileTypes entry "exe" is not a MIME type`, `DeniedFileTypes entry "*/x-msdownload" can only use a wildcard`},
+	},
+	{
+		name:          "bad extension",
+		opts:          []Option{WithAllowedFileExtensions("csv", ".", "a/b")},
+		errorExpected: true,
+		errorContains: []string{`"." is not a file extension`, `"a/b" is not a file extension`},
+	},
+	{
+		name:          "bad compound extensions",
+		opts:          []Option{WithCompoundExtensions(".tar.gz", "tar.bz2", ".gz", ".tar.", "a/b.c")},
+		errorExpected: true,
+		errorContains: []string{`CompoundExtensions entry ".gz" is not an extension of several parts`, `CompoundExtensions entry ".tar." is not`, `CompoundExtensions entry "a/b.c" is not`},
+	},
+	{
+		name:          "negative max file per size",
+		opts:          []Option{WithMaxFilePerSize(-1)},
+		errorExpected: true,
+		errorContains: []string{"MaxFilePerSize must not be negative (got -1)"},
+	},
+	{
+		name:          "negative image limits",
+		opts:          []Option{WithMaxImageSize(-1, -2), WithMaxImagePixels(-3)},
+		errorExpected: true,
+		errorContains: []string{"MaxImageWidth must not be negative (got -1)", "MaxImageHeight must not be negative (got -2)", "MaxImagePixels must not be negative (got -3)"},
+	},
+	{
+		name:          "bad normalize image format",
+		opts:          []Option{WithNormalizeImageFormat("webp", 101)},
+		errorExpected: true,
+		errorContains: []string{`NormalizeImageFormat "webp" is not one of "jpeg" and "png"`, "NormalizeJPEGQuality must be between 1 and 100 (got 101)"},
+	},
+	{
+		name:          "invalid thumbnails",
+		opts:          []Option{WithThumbnails(false, ThumbnailSpec{Suffix: "_a"}, ThumbnailSpec{Width: 10}, ThumbnailSpec{Width: 10, Suffix: "/b"}, ThumbnailSpec{Width: 10, Suffix: "_c"}, ThumbnailSpec{Height: 10, Suffix: "_c"})},
+		errorExpected: true,
+		errorContains: []string{`thumbnail "_a" needs a positive Width or Height (got 0x0)`, `thumbnail Suffix "" must be set`, `thumbnail Suffix "/b" must be set`, `thumbnail Suffix "_c" is used more than once`},
+	},
+	{
+		name:          "deduplicate",
+		opts:          []Option{WithDeduplicate(func(string) (string, bool) { return "", false }), WithRenameFunc(strings.ToLower)},
+		errorExpected: false,
+	},
+	{
+		name:          "deduplicate by name with rename func",
+		opts:          []Option{WithDeduplicate(nil), WithRenameFunc(strings.ToLower)},
+		errorExpected: true,
+		errorContains: []string{"RenameFunc can't be used with Deduplicate unless ExistsByHash is set"},
+	},
+	{
+		name:          "exists by hash without deduplicate",
+		opts:          []Option{WithDeduplicate(func(string) (string, bool) { return "", false }), func(t *Tools) error { t.Deduplicate = false; return nil }},
+		errorExpected: true,
+		errorContains: []string{"ExistsByHash is only used with Deduplicate"},
+	},
+	{
+		name:          "metadata func without write metadata",
+		opts:          []Option{WithMetadata(func(*UploadedFile) map[string]interface{} { return nil }), func(t *Tools) error { t.WriteMetadata = false; return nil }},
+		errorExpected: true,
+		errorContains: []string{"MetadataFunc is only used with WriteMetadata"},
+	},
+	{
+		name:          "negative zip limits",
+		opts:          []Option{WithZipLimits(ZipLimits{MaxEntries: -1, MaxUncompressedSize: -2, MaxCompressionRatio: -0.5})},
+		errorExpected: true,
+		errorContains: []string{"ZipLimits.MaxEntries must not be negative (got -1)", "ZipLimits.MaxUncompressedSize must not be negative (got -2)", "ZipLimits.MaxCompressionRatio must not be negative (got -0.5)"},
+	},
+	{
+		name:          "negative url upload timeout",
+		opts:          []Option{WithURLUpload(-time.Second, false)},
+		errorExpected: true,
+		errorContains: []string{"URLUploadTimeout must not be negative (got -1s)"},
+	},
+	{
+		name:          "negative max files",
+		opts:          []Option{WithMaxFiles(-1)},
+		errorExpected: true,
+		errorContains: []string{"MaxFiles must not be negative (got -1)"},
+	},
+	{
+		name:          "bad sniff length",
+		opts:          []Option{WithDetectContentType(nil, 16)},
+		errorExpected: true,
+		errorContains: []string{"SniffLength must be between 512 and 131072 (got 16)"},
+	},
+	{
+		name:          "max file name length too long",
+		opts:          []Option{WithMaxFileNameLength(256)},
+		errorExpected: true,
+		errorContains: []string{"MaxFileNameLength must be between 0 and 255 (got 256)"},
+	},
+	{
+		name:          "negative multipart memory limit",
+		opts:          []Option{WithMultipartMemory(-1, "")},
+		errorExpected: true,
+		errorContains: []string{"MultipartMemoryLimit must not be negative (got -1)"},
+	},
+	{
+		name:          "negative max total upload size",
+		opts:          []Option{WithMaxTotalUploadSize(-1)},
+		errorExpected: true,
+		errorContains: []string{"MaxTotalUploadSize must not be negative (got -1)"},
+	},
+	{
+		name:          "unknown naming strategy",
+		opts:          []Option{WithNamingStrategy(NamingULID + 1)},
+		errorExpected: true,
+		errorContains: []string{"NamingStrategy 7 is not a known strategy"},
+	},
+	{
+		name:          "rename func with content hash",
+		opts:          []Option{WithNamingStrategy(NamingContentHash), WithRenameFunc(func(string) string { return "x" })},
+		errorExpected: true,
+		errorContains: []string{"RenameFunc can't be used with NamingContentHash"},
+	},
+	{
+		name:          "modes with other bits",
+		opts:          []Option{WithFileMode(os.ModeSetgid | 0640), WithDirMode(os.ModeDir | 0750)},
+		errorExpected: true,
+		errorContains: []string{"FileMode grw-r----- must only hold permission bits", "DirMode drwxr-x--- must only hold permission, setgid and sticky bits"},
+	},
+	{
+		name:          "negative free space headroom",
+		opts:          []Option{WithFreeSpaceCheck(-0.5)},
+		errorExpected: true,
+		errorContains: []string{"FreeSpaceHeadroom must not be negative (got -0.5)"},
+	},
+	{
+		name:          "unknown subdir strategy",
+		opts:          []Option{WithSubdirStrategy(SubdirHashPrefix + 1)},
+		errorExpected: true,
+		errorContains: []string{"SubdirStrategy 3 is not a known strategy"},
+	},
+	{
+		name:          "unknown exists policy",
+		opts:          []Option{WithExistsPolicy(ExistsAutoRename + 1)},
+		errorExpected: true,
+		errorContains: []string{"ExistsPolicy 3 is not a known policy"},
+	},
+	{
+		name:          "unknown svg policy",
+		opts:          []Option{WithSVGPolicy(SVGSanitize + 1)},
+		errorExpected: true,
+		errorContains: []string{"SVGPolicy 3 is not a known policy"},
+	},
+	{
+		name:          "empty form field",
+		opts:          []Option{WithAllowedFormFields(true, "avatar", "")},
+		errorExpected: true,
+		errorContains: []string{"AllowedFormFields must not contain an empty field name"},
+	},
+	{
+		name:          "negative min file size",
+		opts:          []Option{WithMinFileSize(-1)},
+		errorExpected: true,
+		errorContains: []string{"MinFileSize must not be negative (got -1)"},
+	},
+	{
+		name:          "min file size over max",
+		opts:          []Option{WithMinFileSize(100), WithMaxFilePerSize(10)},
+		errorExpected: true,
+		errorContains: []string{"MinFileSize (100) must not be more than MaxFilePerSize (10)"},
+	},
+	{
+		name:          "negative concurrency",
+		opts:          []Option{WithConcurrency(-1)},
+		errorExpected: true,
+		errorContains: []string{"Concurrency must not be negative (got -1)"},
+	},
+	{
+		name:          "nil client",
+		opts:          []Option{WithHTTPClient(nil)},
+		errorExpected: true,
+	},
+	{
+		name:          "short cookie key",
+		opts:          []Option{WithCookieKeys([]byte("0123456789abcdef0123456789abcdef"), []byte("short"))},
+		errorExpected: true,
+		errorContains: []string{"CookieKeys entry 1 must be at least 32 bytes (got 5)"},
+	},
+	{
+		name:          "no cookie keys",
+		opts:          []Option{WithCookieKeys()},
+		errorExpected: true,
+	},
+	{
+		name:          "several problems reported together",
+		opts:          []Option{WithMaxFileSize(-1), WithMaxJSONSize(-2)},
+		errorExpected: true,
+		errorContains: []string{"MaxFileSize must not be negative", "MaxJSONSize must not be negative"},
+	},
+}
+
+func TestNewValidation(t *testing.T) {
+	for _, e := range newTests {
+		_, err := New(e.opts...)
+		if e.errorExpected && err == nil {
+			t.Errorf("%s: error expected, but none received", e.name)
+			continue
+		}
+		if !e.errorExpected && err != nil {
+			t.Errorf("%s: error not expected, but one received: %s", e.name, err)
+			continue
+		}
+		for _, s := range e.errorContains {
+			if !strings.Contains(err.Error(), s) {
+				t.Errorf("%s: expected error to contain %q, got %q", e.name, s, err)
+			}
+		}
+	}
+}
+
+func TestNewOptionsApplied(t *testing.T) {
+	client := &http.Client{}
+	testTools, err := New(WithMaxFileSize(10), WithHTTPClient(client), WithRandomStringSource("ab"), WithAllowUnknownFields(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if testTools.MaxFileSize != 10 || testTools.HTTPClient != client || !testTools.AllowUnknownFields {
+		t.Errorf("options not applied: %+v", testTools)
+	}
+
+	s := testTools.RandomString(50)
+	if strings.Trim(s, "ab") != "" {
+		t.Errorf("random string %q uses characters outside of the configured source", s)
+	}
+}
@@ -0,0 +1,199 @@
+package toolkit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PaginationOptions configures ParsePagination. Zero values take the defaults noted on each field
+type PaginationOptions struct {
+	// PageParam, PerPageParam and SortParam name the query parameters to read. They default to "page", "per_page"
+	// and "sort"
+	PageParam    string
+	PerPageParam string
+	SortParam    string
+
+	// DefaultPerPage is used when the per page parameter is absent. Defaults to 20
+	DefaultPerPage int
+
+	// MaxPerPage is the largest page size a client may ask for; larger values are clamped to it. Defaults to 100
+	MaxPerPage int
+
+	// MaxPage, when set, is the highest page a client may ask for; larger values are clamped to it
+	MaxPage int
+
+	// SortFields lists the fields a client may sort by. A sort parameter naming any other field is rejected, as is
+	// any sort parameter at all when SortFields is empty
+	SortFields []string
+
+	// DefaultSort is used when the sort parameter is absent, e.g. "-created_at". It is not checked against SortFields
+	DefaultSort string
+}
+
+// SortField is one field of a sort order
+type SortField struct {
+	Field string
+	Desc  bool
+}
+
+// Pagination is the parsed page, page size and sort order of a list request
+type Pagination struct {
+	Page    int
+	PerPage int
+	Sort    []SortField
+}
+
+// Limit returns the number of rows to fetch, for use in a SQL LIMIT clause
+func (p Pagination) Limit() int {
+	return p.PerPage
+}
+
+// Offset returns the number of rows to skip, for use in a SQL OFFSET clause
+func (p Pagination) Offset() int {
+	if p.Page < 1 {
+		return 0
+	}
+	return (p.Page - 1) * p.PerPage
+}
+
+// ParsePagination reads the page, page size and sort order, such as "-created_at,name", from the query string of r.
+// Bad values are returned as ValidationErrors keyed by parameter name
+func (t *Tools) ParsePagination(r *http.Request, opts PaginationOptions) (Pagination, error) {
+	if opts.PageParam == "" {
+		opts.PageParam = "page"
+	}
+	if opts.PerPageParam == "" {
+		opts.PerPageParam = "per_page"
+	}
+	if opts.SortParam == "" {
+		opts.SortParam = "sort"
+	}
+	if opts.MaxPerPage <= 0 {
+		opts.MaxPerPage = 100
+	}
+	if opts.DefaultPerPage <= 0 {
+		opts.DefaultPerPage = 20
+	}
+	if opts.DefaultPerPage > opts.MaxPerPage {
+		opts.DefaultPerPage = opts.MaxPerPage
+	}
+
+	q := r.URL.Query()
+	errs := make(ValidationErrors)
+	p := Pagination{Page: 1, PerPage: opts.DefaultPerPage}
+
+	if v := q.Get(opts.PageParam); v != "" {
+		n, err := strconv.Atoi(v)
+		switch {
+		case err != nil || n < 1:
+			errs.add(opts.PageParam, "must be a positive whole number")
+		case opts.MaxPage > 0 && n > opts.MaxPage:
+			p.Page = opts.MaxPage
+		default:
+			p.Page = n
+		}
+	}
+
+	if v := q.Get(opts.PerPageParam); v != "" {
+		n, err := strconv.Atoi(v)
+		switch {
+		case err != nil || n < 1:
+			errs.add(opts.PerPageParam, "must be a positive whole number")
+		case n > opts.MaxPerPage:
+			p.PerPage = opts.MaxPerPage
+		default:
+			p.PerPage = n
+		}
+	}
+
+	sort := q.Get(opts.SortParam)
+	if sort == "" {
+		p.Sort = parseSort(opts.DefaultSort)
+	} else {
+		p.Sort = parseSort(sort)
+		for _, f := range p.Sort {
+			if !containsString(opts.SortFields, f.Field) {
+				errs.add(opts.SortParam, fmt.Sprintf("cannot sort by %q", f.Field))
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return Pagination{}, errs
+	}
+	return p, nil
+}
+
+// parseSort splits a sort parameter such as "-created_at,name" into its fields
+func parseSort(s string) []SortField {
+	var fields []SortField
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		desc := strings.HasPrefix(part, "-")
+		part = strings.TrimPrefix(part, "-")
+		if part == "" {
+			continue
+		}
+		fields = append(fields, SortField{Field: part, Desc: desc})
+	}
+	return fields
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PageInfo describes the page of results sent by WritePaginatedJSON
+type PageInfo struct {
+	Page       int    `json:"page"`
+	PerPage    int    `json:"per_page"`
+	Total      int    `json:"total"`
+	TotalPages int    `json:"total_pages"`
+	Sort       string `json:"sort,omitempty"`
+}
+
+// PaginatedResponse is the JSON envelope sent by WritePaginatedJSON
+type PaginatedResponse struct {
+	Error      bool        `json:"error"`
+	Message    string      `json:"message,omitempty"`
+	Data       interface{} `json:"data"`
+	Pagination PageInfo    `json:"pagination"`
+}
+
+// WritePaginatedJSON sends one page of results, data, with a status of 200, wrapped in a PaginatedResponse that
+// describes where the page sits among total results
+func (t *Tools) WritePaginatedJSON(w http.ResponseWriter, data interface{}, p Pagination, total int, headers ...http.Header) error {
+	info := PageInfo{
+		Page:    p.Page,
+		PerPage: p.PerPage,
+		Total:   total,
+		Sort:    formatSort(p.Sort),
+	}
+	if p.PerPage > 0 {
+		info.TotalPages = (total + p.PerPage - 1) / p.PerPage
+	}
+
+	return t.WriteJSON(w, http.StatusOK, PaginatedResponse{Data: data, Pagination: info}, headers...)
+}
+
+// formatSort turns sort fields back into the form accepted by ParsePagination
+func formatSort(fields []SortField) string {
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		if f.Desc {
+			parts[i] = "-" + f.Field
+		} else {
+			parts[i] = f.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,126 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var paginationOptions = PaginationOptions{
+	DefaultPerPage: 10,
+	MaxPerPage:     50,
+	MaxPage:        1000,
+	SortFields:     []string{"name", "created_at"},
+	DefaultSort:    "-created_at",
+}
+
+var paginationTests = []struct {
+	name     string
+	query    string
+	opts     *PaginationOptions
+	expected Pagination
+	errors   []string
+}{
+	{name: "defaults", query: "", expected: Pagination{Page: 1, PerPage: 10, Sort: []SortField{{Field: "created_at", Desc: true}}}},
+	{name: "explicit values", query: "page=3&per_page=25&sort=name", expected: Pagination{Page: 3, PerPage: 25, Sort: []SortField{{Field: "name"}}}},
+	{name: "multiple sort fields", query: "sort=-name,created_at", expected: Pagination{Page: 1, PerPage: 10, Sort: []SortField{{Field: "name", Desc: true}, {Field: "created_at"}}}},
+	{name: "per page clamped", query: "per_page=500", expected: Pagination{Page: 1, PerPage: 50, Sort: []SortField{{Field: "created_at", Desc: true}}}},
+	{name: "page clamped", query: "page=99999", expected: Pagination{Page: 1000, PerPage: 10, Sort: []SortField{{Field: "created_at", Desc: true}}}},
+	{name: "zero page", query: "page=0", errors: []string{"page"}},
+	{name: "negative page", query: "page=-2", errors: []string{"page"}},
+	{name: "negative per page", query: "per_page=-5", errors: []string{"per_page"}},
+	{name: "non-numeric page", query: "page=two", errors: []string{"page"}},
+	{name: "non-numeric per page", query: "per_page=1.5", errors: []string{"per_page"}},
+	{name: "unknown sort field", query: "sort=password", errors: []string{"sort"}},
+	{name: "several errors", query: "page=x&per_page=y&sort=-secret", errors: []string{"page", "per_page", "sort"}},
+	{
+		name:     "custom parameter names",
+		query:    "p=2&size=5&order=-name",
+		opts:     &PaginationOptions{PageParam: "p", PerPageParam: "size", SortParam: "order", SortFields: []string{"name"}},
+		expected: Pagination{Page: 2, PerPage: 5, Sort: []SortField{{Field: "name", Desc: true}}},
+	},
+	{name: "sorting not allowed", query: "sort=name", opts: &PaginationOptions{}, errors: []string{"sort"}},
+	{name: "zero options", query: "", opts: &PaginationOptions{}, expected: Pagination{Page: 1, PerPage: 20}},
+}
+
+func TestTools_ParsePagination(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range paginationTests {
+		opts := paginationOptions
+		if e.opts != nil {
+			opts = *e.opts
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "/items?"+e.query, nil)
+		p, err := testTools.ParsePagination(req, opts)
+
+		if len(e.errors) == 0 {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %s", e.name, err)
+			}
+			if !reflect.DeepEqual(p, e.expected) {
+				t.Errorf("%s: expected %+v, got %+v", e.name, e.expected, p)
+			}
+			continue
+		}
+
+		var errs ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("%s: expected ValidationErrors, got %v", e.name, err)
+			continue
+		}
+		if len(errs) != len(e.errors) {
+			t.Errorf("%s: expected errors for %v, got %v", e.name, e.errors, errs)
+		}
+		for _, field := range e.errors {
+			if errs[field] == "" {
+				t.Errorf("%s: expected an error for %s, got %v", e.name, field, errs)
+			}
+		}
+	}
+}
+
+func TestPagination_LimitOffset(t *testing.T) {
+	p := Pagination{Page: 3, PerPage: 25}
+	if p.Limit() != 25 || p.Offset() != 50 {
+		t.Errorf("expected limit 25 and offset 50, got %d and %d", p.Limit(), p.Offset())
+	}
+
+	if (Pagination{}).Offset() != 0 {
+		t.Error("expected zero offset for zero Pagination")
+	}
+}
+
+func TestTools_WritePaginatedJSON(t *testing.T) {
+	var testTools Tools
+
+	rr := httptest.NewRecorder()
+	p := Pagination{Page: 2, PerPage: 10, Sort: []SortField{{Field: "name", Desc: true}, {Field: "id"}}}
+	if err := testTools.WritePaginatedJSON(rr, []string{"a", "b"}, p, 21); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rr.Code)
+	}
+
+	var resp struct {
+		Data       []string `json:"data"`
+		Pagination PageInfo `json:"pagination"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := PageInfo{Page: 2, PerPage: 10, Total: 21, TotalPages: 3, Sort: "-name,id"}
+	if resp.Pagination != expected {
+		t.Errorf("expected %+v, got %+v", expected, resp.Pagination)
+	}
+	if len(resp.Data) != 2 {
+		t.Errorf("expected 2 items, got %v", resp.Data)
+	}
+}
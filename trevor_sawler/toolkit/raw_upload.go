@@ -0,0 +1,45 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// UploadRawBody saves a file sent as the whole body of r to uploadDir, with the same checks as UploadFiles. It is
+// named filename, or else after the X-Filename or Content-Disposition header
+func (t *Tools) UploadRawBody(r *http.Request, uploadDir string, filename string, rename ...bool) (*UploadedFile, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	if filename == "" {
+		filename = rawBodyFileName(r)
+	}
+
+	maxFileSize := int64(defaultMaxFileSize)
+	if t.MaxFileSize != 0 {
+		maxFileSize = int64(t.MaxFileSize)
+	}
+	if r.ContentLength > maxFileSize {
+		return nil, ErrFileTooBig
+	}
+
+	src := &maxSizeReader{r: r.Body, max: maxFileSize}
+	return t.uploadStreamToDir(r.Context(), uploadDir, r.ContentLength, filename, src, renameFile, func(err error) error {
+		if errors.Is(err, ErrFileTooBig) {
+			return err
+		}
+		return fmt.Errorf("could not read uploaded file %q: %w", filename, err)
+	})
+}
+
+// rawBodyFileName returns the name of the file sent as the body of r: its X-Filename header, or else the filename of
+// its Content-Disposition header
+func rawBodyFileName(r *http.Request) string {
+	if name := r.Header.Get("X-Filename"); name != "" {
+		return name
+	}
+	return contentDispositionFileName(r.Header)
+}
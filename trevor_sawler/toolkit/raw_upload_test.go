@@ -0,0 +1,80 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTools_UploadRawBody(t *testing.T) {
+	img := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		name          string
+		tools         Tools
+		filename      string
+		headers       map[string]string
+		unknownLength bool
+		expectedName  string
+		expected      error
+	}{
+		{name: "filename argument", filename: "avatar.png", headers: map[string]string{"X-Filename": "other.png"}, expectedName: "avatar.png"},
+		{name: "x-filename header", headers: map[string]string{"X-Filename": "../avatar.png"}, expectedName: "avatar.png"},
+		{name: "content-disposition header", headers: map[string]string{"Content-Disposition": `attachment; filename="avatar.png"`}, expectedName: "avatar.png"},
+		{name: "content-type ignored", filename: "avatar.png", headers: map[string]string{"Content-Type": "image/jpeg"}, tools: Tools{AllowedFileTypes: []string{"image/png"}}, expectedName: "avatar.png"},
+		{name: "no name", expected: ErrInvalidFileName},
+		{name: "type not allowed", filename: "avatar.png", tools: Tools{AllowedFileTypes: []string{"image/jpeg"}}, expected: ErrFileTypeNotPermitted},
+		{name: "over MaxFileSize", filename: "avatar.png", tools: Tools{MaxFileSize: 1000}, expected: ErrFileTooBig},
+		{name: "over MaxFileSize of unknown length", filename: "avatar.png", tools: Tools{MaxFileSize: 1000}, unknownLength: true, expected: ErrFileTooBig},
+	}
+
+	for _, e := range tests {
+		dir := t.TempDir()
+		req := httptest.NewRequest("PUT", "/files", bytes.NewReader(img))
+		for k, v := range e.headers {
+			req.Header.Set(k, v)
+		}
+		if e.unknownLength {
+			req.ContentLength = -1
+			req.Body = io.NopCloser(bytes.NewReader(img))
+		}
+
+		uploadedFile, err := e.tools.UploadRawBody(req, dir, e.filename, false)
+		if e.expected != nil {
+			if !errors.Is(err, e.expected) {
+				t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+			}
+			if names := remainingFiles(t, dir); names != nil {
+				t.Errorf("%s: expected nothing to be saved, got %v", e.name, names)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+
+		if uploadedFile.NewFileName != e.expectedName || uploadedFile.ContentType != "image/png" {
+			t.Errorf("%s: expected %s of type image/png, got %s of type %s", e.name, e.expectedName, uploadedFile.NewFileName, uploadedFile.ContentType)
+		}
+		if saved, _ := os.ReadFile(filepath.Join(dir, uploadedFile.NewFileName)); !bytes.Equal(saved, img) || uploadedFile.FileSize != int64(len(img)) {
+			t.Errorf("%s: expected the body to be saved", e.name)
+		}
+	}
+
+	// a random name keeps the extension of the name sent
+	var testTools Tools
+	req := httptest.NewRequest("PUT", "/files", bytes.NewReader(img))
+	req.Header.Set("X-Filename", "avatar.png")
+	uploadedFile, err := testTools.UploadRawBody(req, t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uploadedFile.NewFileName == "avatar.png" || filepath.Ext(uploadedFile.NewFileName) != ".png" || uploadedFile.OriginalFileName != "avatar.png" {
+		t.Errorf("expected a random name ending in .png, got %s", uploadedFile.NewFileName)
+	}
+}
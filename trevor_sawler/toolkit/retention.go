@@ -0,0 +1,334 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRetentionInterval is how often the retention worker runs when no interval is set
+const defaultRetentionInterval = time.Hour
+
+// RetentionRule says which files of a directory, subdirectories included, the retention worker deletes
+type RetentionRule struct {
+	// Dir is the directory to clean up
+	Dir string
+	// MaxAge is how long a file is kept after it was last modified. Zero keeps files regardless of age
+	MaxAge time.Duration
+	// MaxTotalBytes, when not zero, caps the size of the matching files; the oldest are deleted until they fit
+	MaxTotalBytes int64
+	// Pattern, when set, limits the rule to files whose name matches it, as in filepath.Match
+	Pattern string
+}
+
+// RetentionResult describes one pass of a RetentionRule
+type RetentionResult struct {
+	Rule     RetentionRule
+	Scanned  int
+	Deleted  int
+	Freed    int64
+	Duration time.Duration
+	// Err is the first error of the pass. The pass carries on past errors deleting single files
+	Err error
+	// Skipped is set when the pass did not run because an earlier pass over the same directory was still running
+	Skipped bool
+}
+
+// RetentionOption configures StartRetentionWorker
+type RetentionOption func(*retentionConfig)
+
+type retentionConfig struct {
+	interval time.Duration
+	metrics  func(RetentionResult)
+}
+
+// WithRetentionInterval sets how often the retention worker runs. The default is an hour
+func WithRetentionInterval(d time.Duration) RetentionOption {
+	return func(c *retentionConfig) { c.interval = d }
+}
+
+// WithRetentionMetrics registers a function that is called with the result of every pass of every rule, for
+// example to export the number of deleted files
+func WithRetentionMetrics(fn func(RetentionResult)) RetentionOption {
+	return func(c *retentionConfig) { c.metrics = fn }
+}
+
+// retentionBusy holds the directories that a retention pass is working on, across all workers, so that passes over
+// the same directory never overlap
+var retentionBusy = struct {
+	sync.Mutex
+	dirs map[string]bool
+}{dirs: make(map[string]bool)}
+
+// StartRetentionWorker applies rules now and then on every interval until ctx is cancelled or stop is called. stop
+// waits for a running pass to finish. Invalid rules get an error and nothing is started
+func (t *Tools) StartRetentionWorker(ctx context.Context, rules []RetentionRule, opts ...RetentionOption) (stop func(), err error) {
+	cfg := retentionConfig{interval: defaultRetentionInterval}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := validateRetentionRules(rules, cfg.interval); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(cfg.interval)
+		defer ticker.Stop()
+
+		for {
+			for _, rule := range rules {
+				if ctx.Err() != nil {
+					return
+				}
+				result := t.applyRetentionRule(ctx, rule)
+				t.logRetentionResult(result)
+				if cfg.metrics != nil {
+					cfg.metrics(result)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(cancel)
+		<-done
+	}, nil
+}
+
+// validateRetentionRules reports every problem with rules and interval
+func validateRetentionRules(rules []RetentionRule, interval time.Duration) error {
+	var problems []string
+
+	if interval <= 0 {
+		problems = append(problems, fmt.Sprintf("interval must be positive (got %s)", interval))
+	}
+	for i, rule := range rules {
+		if rule.Dir == "" {
+			problems = append(problems, fmt.Sprintf("rule %d has no Dir", i))
+		}
+		if rule.MaxAge < 0 {
+			problems = append(problems, fmt.Sprintf("rule %d MaxAge must not be negative (got %s)", i, rule.MaxAge))
+		}
+		if rule.MaxTotalBytes < 0 {
+			problems = append(problems, fmt.Sprintf("rule %d MaxTotalBytes must not be negative (got %d)", i, rule.MaxTotalBytes))
+		}
+		if rule.MaxAge == 0 && rule.MaxTotalBytes == 0 {
+			problems = append(problems, fmt.Sprintf("rule %d needs a MaxAge or MaxTotalBytes", i))
+		}
+		if _, err := filepath.Match(rule.Pattern, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("rule %d Pattern %q is invalid", i, rule.Pattern))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid retention rules: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// retentionFile is a file found by a retention pass
+type retentionFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// applyRetentionRule runs one pass of rule, unless another pass over the same directory is running
+func (t *Tools) applyRetentionRule(ctx context.Context, rule RetentionRule) (result RetentionResult) {
+	result.Rule = rule
+	start := time.Now()
+	defer func() { result.Duration = time.Since(start) }()
+
+	dir := filepath.Clean(rule.Dir)
+	retentionBusy.Lock()
+	if retentionBusy.dirs[dir] {
+		retentionBusy.Unlock()
+		result.Skipped = true
+		return result
+	}
+	retentionBusy.dirs[dir] = true
+	retentionBusy.Unlock()
+
+	defer func() {
+		retentionBusy.Lock()
+		delete(retentionBusy.dirs, dir)
+		retentionBusy.Unlock()
+	}()
+
+	files, err := listRetentionFiles(ctx, dir, rule.Pattern, true)
+	result.Scanned = len(files)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	remove := func(f retentionFile) bool {
+		if ctx.Err() != nil {
+			if result.Err == nil {
+				result.Err = ctx.Err()
+			}
+			return false
+		}
+		if err := removeWithSidecar(f.path); err != nil {
+			if result.Err == nil {
+				result.Err = err
+			}
+			return false
+		}
+		result.Deleted++
+		result.Freed += f.size
+		return true
+	}
+
+	// oldest first, for the size limit
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	var kept []retentionFile
+	var total int64
+	for _, f := range files {
+		if rule.MaxAge > 0 && start.Sub(f.modTime) > rule.MaxAge && remove(f) {
+			continue
+		}
+		kept = append(kept, f)
+		total += f.size
+	}
+
+	if rule.MaxTotalBytes > 0 {
+		for _, f := range kept {
+			if total <= rule.MaxTotalBytes || ctx.Err() != nil {
+				break
+			}
+			if remove(f) {
+				total -= f.size
+			}
+		}
+	}
+
+	return result
+}
+
+// listRetentionFiles returns the regular files in dir whose name matches pattern, when it is set, without sidecars
+func listRetentionFiles(ctx context.Context, dir, pattern string, recursive bool) ([]retentionFile, error) {
+	var files []retentionFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() && path != dir && !recursive {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || (isMetadataSidecar(d.Name()) && !isMetadataSidecar(pattern)) {
+			return nil
+		}
+		if pattern != "" {
+			if ok, _ := filepath.Match(pattern, d.Name()); !ok {
+				return nil
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			// the file was removed since the directory was read
+			return nil
+		}
+		files = append(files, retentionFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) && len(files) == 0 {
+		// nothing has been written to the directory yet
+		return nil, nil
+	}
+	return files, err
+}
+
+// removeWithSidecar removes the file at path, and the metadata sidecar written with it if there is one. A file that
+// is already gone is not an error
+func removeWithSidecar(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(path + metadataSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// CleanOldFiles removes the files in dir, with their sidecars, modified more than olderThan ago whose name matches
+// pattern, or all when it is empty, and returns how many. OldFiles lists them for a dry run
+func (t *Tools) CleanOldFiles(dir string, olderThan time.Duration, pattern string, recursive ...bool) (removed int, err error) {
+	paths, err := t.OldFiles(dir, olderThan, pattern, recursive...)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, path := range paths {
+		if rmErr := removeWithSidecar(path); rmErr != nil {
+			if err == nil {
+				err = rmErr
+			}
+			continue
+		}
+		removed++
+	}
+	return removed, err
+}
+
+// OldFiles returns the paths of the files CleanOldFiles would remove, oldest first, without removing any
+func (t *Tools) OldFiles(dir string, olderThan time.Duration, pattern string, recursive ...bool) ([]string, error) {
+	if olderThan < 0 {
+		return nil, fmt.Errorf("olderThan must not be negative (got %s)", olderThan)
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("pattern %q is invalid: %w", pattern, err)
+	}
+
+	files, err := listRetentionFiles(context.Background(), dir, pattern, len(recursive) > 0 && recursive[0])
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	cutoff := time.Now().Add(-olderThan)
+	var paths []string
+	for _, f := range files {
+		if f.modTime.Before(cutoff) {
+			paths = append(paths, f.path)
+		}
+	}
+	return paths, nil
+}
+
+// logRetentionResult logs a summary of one pass
+func (t *Tools) logRetentionResult(result RetentionResult) {
+	switch {
+	case result.Skipped:
+		t.logger().Printf("retention: skipped %s, an earlier pass is still running", result.Rule.Dir)
+	case result.Err != nil:
+		t.logger().Printf("retention: %s: deleted %d of %d files, freeing %d bytes, in %s: %s", result.Rule.Dir, result.Deleted, result.Scanned, result.Freed, result.Duration, result.Err)
+	default:
+		t.logger().Printf("retention: %s: deleted %d of %d files, freeing %d bytes, in %s", result.Rule.Dir, result.Deleted, result.Scanned, result.Freed, result.Duration)
+	}
+}
@@ -0,0 +1,269 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// writeAgedFile writes size bytes to dir/name with a modification time of age ago
+func writeAgedFile(t *testing.T, dir, name string, size int, age time.Duration) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTools_StartRetentionWorker(t *testing.T) {
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+
+	var tests = []struct {
+		name     string
+		rule     RetentionRule
+		expected []string
+		deleted  int
+	}{
+		{name: "max age", rule: RetentionRule{MaxAge: time.Hour}, expected: []string{"new.png", "sub/new.txt"}, deleted: 3},
+		{name: "pattern", rule: RetentionRule{MaxAge: time.Hour, Pattern: "*.png"}, expected: []string{"new.png", "old.txt", "sub/new.txt", "sub/old.txt"}, deleted: 1},
+		{name: "max total size, oldest first", rule: RetentionRule{MaxTotalBytes: 250}, expected: []string{"new.png", "sub/new.txt"}, deleted: 3},
+		{name: "age and size", rule: RetentionRule{MaxAge: 90 * time.Minute, MaxTotalBytes: 150}, expected: []string{"new.png"}, deleted: 4},
+	}
+
+	for _, e := range tests {
+		dir := t.TempDir()
+		writeAgedFile(t, dir, "old.png", 100, 3*time.Hour)
+		writeAgedFile(t, dir, "old.txt", 100, 2*time.Hour)
+		writeAgedFile(t, dir, "sub/old.txt", 100, 80*time.Minute)
+		writeAgedFile(t, dir, "sub/new.txt", 100, 2*time.Minute)
+		writeAgedFile(t, dir, "new.png", 100, time.Minute)
+
+		e.rule.Dir = dir
+		results := make(chan RetentionResult, 10)
+		stop, err := testTools.StartRetentionWorker(context.Background(), []RetentionRule{e.rule},
+			WithRetentionInterval(time.Hour), WithRetentionMetrics(func(r RetentionResult) { results <- r }))
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+
+		result := <-results
+		stop()
+
+		if result.Err != nil || result.Deleted != e.deleted || result.Scanned == 0 || result.Freed != int64(100*e.deleted) {
+			t.Errorf("%s: unexpected result %+v", e.name, result)
+		}
+		if got := remainingFiles(t, dir); !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v to remain, got %v", e.name, e.expected, got)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "sub")); err != nil {
+			t.Errorf("%s: expected directories to be kept: %v", e.name, err)
+		}
+	}
+}
+
+func TestTools_RetentionMetadataSidecars(t *testing.T) {
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+
+	dir := t.TempDir()
+	writeAgedFile(t, dir, "old.png", 100, 3*time.Hour)
+	writeAgedFile(t, dir, "old.png.meta.json", 10, 3*time.Hour)
+	writeAgedFile(t, dir, "new.png", 100, time.Minute)
+	writeAgedFile(t, dir, "new.png.meta.json", 10, 3*time.Hour)
+
+	result := testTools.applyRetentionRule(context.Background(), RetentionRule{Dir: dir, MaxAge: time.Hour})
+	if result.Err != nil || result.Scanned != 2 || result.Deleted != 1 || result.Freed != 100 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	// a sidecar goes with its file, whatever its own age
+	expected := []string{"new.png", "new.png.meta.json"}
+	if got := remainingFiles(t, dir); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v to remain, got %v", expected, got)
+	}
+}
+
+func TestTools_CleanOldFiles(t *testing.T) {
+	var testTools Tools
+
+	var tests = []struct {
+		name      string
+		pattern   string
+		recursive bool
+		expected  []string
+		removed   int
+	}{
+		{name: "top level", expected: []string{"new.png", "sub/new.txt", "sub/old.txt"}, removed: 2},
+		{name: "recursive", recursive: true, expected: []string{"new.png", "sub/new.txt"}, removed: 3},
+		{name: "pattern", pattern: "*.txt", recursive: true, expected: []string{"new.png", "old.png", "old.png.meta.json", "sub/new.txt"}, removed: 2},
+		{name: "sidecars", pattern: "*.meta.json", expected: []string{"new.png", "old.png", "old.txt", "sub/new.txt", "sub/old.txt"}, removed: 1},
+	}
+
+	for _, e := range tests {
+		dir := t.TempDir()
+		writeAgedFile(t, dir, "old.png", 100, 3*time.Hour)
+		writeAgedFile(t, dir, "old.png.meta.json", 10, 3*time.Hour)
+		writeAgedFile(t, dir, "old.txt", 100, 2*time.Hour)
+		writeAgedFile(t, dir, "sub/old.txt", 100, 80*time.Minute)
+		writeAgedFile(t, dir, "sub/new.txt", 100, 2*time.Minute)
+		writeAgedFile(t, dir, "new.png", 100, time.Minute)
+
+		// a dry run lists the files, oldest first, without removing them
+		candidates, err := testTools.OldFiles(dir, time.Hour, e.pattern, e.recursive)
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		if len(candidates) != e.removed || !sort.SliceIsSorted(candidates, func(i, j int) bool {
+			a, _ := os.Stat(candidates[i])
+			b, _ := os.Stat(candidates[j])
+			return a.ModTime().Before(b.ModTime())
+		}) {
+			t.Errorf("%s: expected %d candidates, oldest first, got %v", e.name, e.removed, candidates)
+		}
+		if got := remainingFiles(t, dir); len(got) != 6 {
+			t.Errorf("%s: expected a dry run to remove nothing, got %v", e.name, got)
+		}
+
+		removed, err := testTools.CleanOldFiles(dir, time.Hour, e.pattern, e.recursive)
+		if err != nil || removed != e.removed {
+			t.Errorf("%s: expected %d files removed, got %d and %v", e.name, e.removed, removed, err)
+		}
+		if got := remainingFiles(t, dir); !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v to remain, got %v", e.name, e.expected, got)
+		}
+	}
+
+	if removed, err := testTools.CleanOldFiles(filepath.Join(t.TempDir(), "missing"), time.Hour, ""); err != nil || removed != 0 {
+		t.Errorf("expected nothing to be removed from a missing directory, got %d and %v", removed, err)
+	}
+	if _, err := testTools.CleanOldFiles(t.TempDir(), time.Hour, "["); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+	if _, err := testTools.CleanOldFiles(t.TempDir(), -time.Hour, ""); err == nil {
+		t.Error("expected an error for a negative age")
+	}
+}
+
+func TestTools_StartRetentionWorkerRepeats(t *testing.T) {
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+	dir := t.TempDir()
+
+	results := make(chan RetentionResult, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	stop, err := testTools.StartRetentionWorker(ctx, []RetentionRule{{Dir: dir, MaxAge: time.Hour}},
+		WithRetentionInterval(10*time.Millisecond), WithRetentionMetrics(func(r RetentionResult) { results <- r }))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-results
+	// a file that becomes too old between passes is picked up by a later one
+	writeAgedFile(t, dir, "late.txt", 10, 2*time.Hour)
+	deadline := time.After(5 * time.Second)
+	for len(remainingFiles(t, dir)) > 0 {
+		select {
+		case <-results:
+		case <-deadline:
+			t.Fatal("expected a later pass to delete the file")
+		}
+	}
+
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the worker to stop when the context is cancelled")
+	}
+}
+
+func TestTools_RetentionCancelledMidScan(t *testing.T) {
+	var testTools Tools
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		writeAgedFile(t, dir, name, 10, 2*time.Hour)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result := testTools.applyRetentionRule(ctx, RetentionRule{Dir: dir, MaxAge: time.Hour})
+	if !errors.Is(result.Err, context.Canceled) || result.Deleted != 0 {
+		t.Errorf("expected a cancelled pass to stop without deleting, got %+v", result)
+	}
+	if got := remainingFiles(t, dir); len(got) != 3 {
+		t.Errorf("expected all files to remain, got %v", got)
+	}
+}
+
+func TestTools_RetentionNoOverlap(t *testing.T) {
+	var testTools Tools
+	dir := t.TempDir()
+	writeAgedFile(t, dir, "old", 10, 2*time.Hour)
+
+	// a pass over the same directory is already running
+	retentionBusy.Lock()
+	retentionBusy.dirs[filepath.Clean(dir)] = true
+	retentionBusy.Unlock()
+
+	result := testTools.applyRetentionRule(context.Background(), RetentionRule{Dir: dir + "/", MaxAge: time.Hour})
+	if !result.Skipped || result.Deleted != 0 {
+		t.Errorf("expected the pass to be skipped, got %+v", result)
+	}
+
+	retentionBusy.Lock()
+	delete(retentionBusy.dirs, filepath.Clean(dir))
+	retentionBusy.Unlock()
+
+	result = testTools.applyRetentionRule(context.Background(), RetentionRule{Dir: dir, MaxAge: time.Hour})
+	if result.Skipped || result.Deleted != 1 {
+		t.Errorf("expected the pass to run once the directory is free, got %+v", result)
+	}
+
+	// a directory that doesn't exist yet has nothing to clean up
+	result = testTools.applyRetentionRule(context.Background(), RetentionRule{Dir: filepath.Join(dir, "missing"), MaxAge: time.Hour})
+	if result.Err != nil {
+		t.Errorf("expected a missing directory not to be an error, got %v", result.Err)
+	}
+}
+
+func TestTools_StartRetentionWorkerInvalid(t *testing.T) {
+	var testTools Tools
+
+	var tests = []struct {
+		name  string
+		rules []RetentionRule
+		opts  []RetentionOption
+	}{
+		{name: "no dir", rules: []RetentionRule{{MaxAge: time.Hour}}},
+		{name: "no limits", rules: []RetentionRule{{Dir: "x"}}},
+		{name: "negative age", rules: []RetentionRule{{Dir: "x", MaxAge: -time.Hour}}},
+		{name: "negative size", rules: []RetentionRule{{Dir: "x", MaxTotalBytes: -1}}},
+		{name: "bad pattern", rules: []RetentionRule{{Dir: "x", MaxAge: time.Hour, Pattern: "["}}},
+		{name: "bad interval", rules: []RetentionRule{{Dir: "x", MaxAge: time.Hour}}, opts: []RetentionOption{WithRetentionInterval(0)}},
+	}
+
+	for _, e := range tests {
+		stop, err := testTools.StartRetentionWorker(context.Background(), e.rules, e.opts...)
+		if err == nil || stop != nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package toolkit
+
+import (
+	"fmt"
+	"html"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// SanitizePolicy says which HTML SanitizeHTML keeps. AllowedTags maps each permitted element to its permitted
+// attributes; URL attributes must be relative or use one of AllowedURLSchemes
+type SanitizePolicy struct {
+	AllowedTags       map[string][]string
+	AllowedURLSchemes []string
+}
+
+// StripAll returns a policy that removes every tag, leaving only text
+func StripAll() SanitizePolicy {
+	return SanitizePolicy{}
+}
+
+// BasicFormatting returns a policy for simple rich text: bold, italic, paragraphs, lists, line breaks and links
+// using http, https or mailto
+func BasicFormatting() SanitizePolicy {
+	return SanitizePolicy{
+		AllowedTags: map[string][]string{
+			"a":      {"href", "title"},
+			"b":      nil,
+			"br":     nil,
+			"em":     nil,
+			"i":      nil,
+			"li":     nil,
+			"ol":     nil,
+			"p":      nil,
+			"strong": nil,
+			"ul":     nil,
+		},
+		AllowedURLSchemes: []string{"http", "https", "mailto"},
+	}
+}
+
+// sanitizePolicies are the policies that can be named in a `sanitize` struct tag
+var sanitizePolicies = map[string]func() SanitizePolicy{
+	"strip": StripAll,
+	"basic": BasicFormatting,
+}
+
+// rawTextElements hold content that is not parsed as HTML, up to their end tag. The content of those in
+// droppedContentElements is removed along with them, rather than being kept as text
+var rawTextElements = map[string]bool{
+	"script": true, "style": true, "textarea": true, "title": true, "xmp": true,
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true,
+}
+
+var droppedContentElements = map[string]bool{
+	"script": true, "style": true, "iframe": true, "noembed": true, "noframes": true, "noscript": true,
+	"template": true, "object": true, "svg": true, "math": true,
+}
+
+// voidElements never have content or an end tag
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// urlAttributes are attributes whose value is loaded or followed as a URL
+var urlAttributes = map[string]bool{
+	"href": true, "src": true, "action": true, "formaction": true, "cite": true, "poster": true,
+	"background": true, "longdesc": true, "xlink:href": true, "srcset": true,
+}
+
+// SanitizeHTML returns input with everything not allowed by policy removed, tokenized as a browser would and
+// rebuilt well formed
+func (t *Tools) SanitizeHTML(input string, policy SanitizePolicy) string {
+	var out strings.Builder
+	// open holds the allowed elements written but not yet closed, and dropping the elements, like script, whose
+	// content is being removed
+	var open, dropping []string
+
+	z := htmlTokenizer{s: input}
+	for {
+		tok, ok := z.next()
+		if !ok {
+			break
+		}
+
+		switch tok.kind {
+		case htmlText:
+			if len(dropping) == 0 {
+				out.WriteString(html.EscapeString(html.UnescapeString(tok.data)))
+			}
+
+		case htmlStartTag:
+			if droppedContentElements[tok.name] && !tok.selfClosing {
+				dropping = append(dropping, tok.name)
+				continue
+			}
+			attrs, allowed := policy.AllowedTags[tok.name]
+			if !allowed || len(dropping) > 0 {
+				continue
+			}
+
+			out.WriteString("<" + tok.name)
+			for _, a := range tok.attrs {
+				if value, ok := policy.allowAttr(attrs, a); ok {
+					out.WriteString(" " + a.name + `="` + html.EscapeString(value) + `"`)
+				}
+			}
+			out.WriteString(">")
+
+			if !voidElements[tok.name] {
+				open = append(open, tok.name)
+			}
+
+		case htmlEndTag:
+			if droppedContentElements[tok.name] {
+				for i := len(dropping) - 1; i >= 0; i-- {
+					if dropping[i] == tok.name {
+						dropping = dropping[:i]
+						break
+					}
+				}
+				continue
+			}
+			if len(dropping) > 0 {
+				continue
+			}
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] == tok.name {
+					for j := len(open) - 1; j >= i; j-- {
+						out.WriteString("</" + open[j] + ">")
+					}
+					open = open[:i]
+					break
+				}
+			}
+		}
+	}
+
+	for i := len(open) - 1; i >= 0; i-- {
+		out.WriteString("</" + open[i] + ">")
+	}
+
+	return out.String()
+}
+
+// allowAttr reports whether a is allowed by the list of attribute names permitted on its element, returning the
+// value to write
+func (p SanitizePolicy) allowAttr(permitted []string, a htmlAttr) (string, bool) {
+	if strings.HasPrefix(a.name, "on") || a.name == "style" || !containsString(permitted, a.name) {
+		return "", false
+	}
+	if !urlAttributes[a.name] {
+		return a.value, true
+	}
+
+	// browsers ignore tabs and newlines anywhere in a URL, and leading control characters and spaces, so
+	// "java\tscript:" is still a javascript: URL
+	value := strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, a.value)
+	value = strings.TrimLeft(value, "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x0b\x0c\x0e\x0f\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f ")
+	value = strings.TrimRight(value, " ")
+
+	if i := strings.IndexAny(value, ":/?#"); i >= 0 && value[i] == ':' {
+		scheme := strings.ToLower(value[:i])
+		if !containsString(p.AllowedURLSchemes, scheme) {
+			return "", false
+		}
+	}
+
+	return value, true
+}
+
+// htmlTokenKind is the kind of an htmlToken
+type htmlTokenKind int
+
+const (
+	htmlText htmlTokenKind = iota
+	htmlStartTag
+	htmlEndTag
+	htmlComment
+)
+
+type htmlAttr struct {
+	name  string
+	value string
+}
+
+type htmlToken struct {
+	kind        htmlTokenKind
+	data        string // text, still escaped, for htmlText
+	name        string // lower case element name for tags
+	attrs       []htmlAttr
+	selfClosing bool
+}
+
+// htmlTokenizer splits HTML into tokens as the HTML standard does, closely enough that its text and attributes are
+// what a browser would see
+type htmlTokenizer struct {
+	s       string
+	pos     int
+	rawText string // set after the start tag of a raw text element, until its end tag
+}
+
+// next returns the next token, or false at the end of the input. A tag cut off by the end of the input is
+// dropped, as browsers do
+func (z *htmlTokenizer) next() (htmlToken, bool) {
+	if z.pos >= len(z.s) {
+		return htmlToken{}, false
+	}
+
+	if z.rawText != "" {
+		return z.nextRawText(), true
+	}
+
+	if z.s[z.pos] != '<' || z.pos+1 >= len(z.s) {
+		return z.nextText(), true
+	}
+
+	c := z.s[z.pos+1]
+	switch {
+	case isASCIILetter(c):
+		return z.nextTag(htmlStartTag, z.pos+1)
+	case c == '/' && z.pos+2 < len(z.s) && isASCIILetter(z.s[z.pos+2]):
+		return z.nextTag(htmlEndTag, z.pos+2)
+	case c == '/' && z.pos+2 < len(z.s) && z.s[z.pos+2] == '>':
+		z.pos += 3
+		return htmlToken{kind: htmlComment}, true
+	case c == '!' && strings.HasPrefix(z.s[z.pos:], "<!--"):
+		end := strings.Index(z.s[z.pos+4:], "-->")
+		switch {
+		case strings.HasPrefix(z.s[z.pos+4:], ">"):
+			z.pos += 5
+		case strings.HasPrefix(z.s[z.pos+4:], "->"):
+			z.pos += 6
+		case end < 0:
+			z.pos = len(z.s)
+		default:
+			z.pos += 4 + end + 3
+		}
+		return htmlToken{kind: htmlComment}, true
+	case c == '!' || c == '?' || c == '/':
+		end := strings.IndexByte(z.s[z.pos:], '>')
+		if end < 0 {
+			z.pos = len(z.s)
+		} else {
+			z.pos += end + 1
+		}
+		return htmlToken{kind: htmlComment}, true
+	}
+
+	return z.nextText(), true
+}
+
+// nextText returns the text up to the next '<' that could start a tag
+func (z *htmlTokenizer) nextText() htmlToken {
+	start := z.pos
+	z.pos++
+	for z.pos < len(z.s) && z.s[z.pos] != '<' {
+		z.pos++
+	}
+	return htmlToken{kind: htmlText, data: z.s[start:z.pos]}
+}
+
+// nextRawText returns the content of a raw text element, or its end tag
+func (z *htmlTokenizer) nextRawText() htmlToken {
+	name := z.rawText
+	for i := z.pos; i < len(z.s); i++ {
+		if z.s[i] != '<' || !isEndTagFor(z.s[i:], name) {
+			continue
+		}
+		if i > z.pos {
+			tok := htmlToken{kind: htmlText, data: z.s[z.pos:i]}
+			z.pos = i
+			return tok
+		}
+		z.rawText = ""
+		tok, ok := z.nextTag(htmlEndTag, i+2)
+		if !ok {
+			return htmlToken{kind: htmlComment}
+		}
+		return tok
+	}
+
+	tok := htmlToken{kind: htmlText, data: z.s[z.pos:]}
+	z.pos = len(z.s)
+	return tok
+}
+
+// isEndTagFor reports whether s starts with an end tag for the element name
+func isEndTagFor(s, name string) bool {
+	if len(s) < len(name)+3 || s[1] != '/' || !strings.EqualFold(s[2:2+len(name)], name) {
+		return false
+	}
+	switch s[2+len(name)] {
+	case ' ', '\t', '\n', '\f', '\r', '/', '>':
+		return true
+	}
+	return false
+}
+
+// nextTag reads a tag whose name starts at i
+func (z *htmlTokenizer) nextTag(kind htmlTokenKind, i int) (htmlToken, bool) {
+	s := z.s
+	tok := htmlToken{kind: kind}
+
+	start := i
+	for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '/' && s[i] != '>' {
+		i++
+	}
+	tok.name = strings.ToLower(s[start:i])
+
+	for {
+		for i < len(s) && (isHTMLSpace(s[i]) || s[i] == '/') {
+			if s[i] == '/' && i+1 < len(s) && s[i+1] == '>' {
+				tok.selfClosing = true
+			}
+			i++
+		}
+		if i >= len(s) {
+			z.pos = len(s)
+			return htmlToken{}, false
+		}
+		if s[i] == '>' {
+			i++
+			break
+		}
+
+		// the first character of a name may be '=', anything else up to a separator is part of it
+		start = i
+		i++
+		for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '/' && s[i] != '>' && s[i] != '=' {
+			i++
+		}
+		attr := htmlAttr{name: strings.ToLower(s[start:i])}
+
+		j := i
+		for j < len(s) && isHTMLSpace(s[j]) {
+			j++
+		}
+		if j < len(s) && s[j] == '=' {
+			i = j + 1
+			for i < len(s) && isHTMLSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				end := strings.IndexByte(s[i+1:], s[i])
+				if end < 0 {
+					z.pos = len(s)
+					return htmlToken{}, false
+				}
+				attr.value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start = i
+				for i < len(s) && !isHTMLSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				attr.value = s[start:i]
+			}
+			attr.value = html.UnescapeString(attr.value)
+		}
+
+		if kind == htmlStartTag && !hasAttr(tok.attrs, attr.name) {
+			tok.attrs = append(tok.attrs, attr)
+		}
+	}
+
+	z.pos = i
+	if kind == htmlStartTag && rawTextElements[tok.name] && !tok.selfClosing {
+		z.rawText = tok.name
+	}
+	return tok, true
+}
+
+func hasAttr(attrs []htmlAttr, name string) bool {
+	for _, a := range attrs {
+		if a.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// sanitizeFieldCache maps a struct type to its []sanitizeField, so that the tags of each type are only read once
+var sanitizeFieldCache sync.Map
+
+// sanitizeField is a field of a struct type that is sanitized itself, or may contain fields that are
+type sanitizeField struct {
+	index  int
+	policy string // empty for fields that are only descended into
+}
+
+// sanitizeStruct applies SanitizeHTML to every string, *string and []string field of v tagged
+// `sanitize:"basic"` or `sanitize:"strip"`, descending into nested structs, pointers and slices
+func (t *Tools) sanitizeStruct(v interface{}) error {
+	return t.sanitizeValue(reflect.ValueOf(v))
+}
+
+func (t *Tools) sanitizeValue(rv reflect.Value) error {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := t.sanitizeValue(rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Struct:
+	default:
+		return nil
+	}
+
+	fields, err := sanitizeFieldsFor(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		fv := rv.Field(f.index)
+		if f.policy == "" {
+			if err := t.sanitizeValue(fv); err != nil {
+				return err
+			}
+			continue
+		}
+		t.sanitizeStrings(fv, sanitizePolicies[f.policy]())
+	}
+
+	return nil
+}
+
+// sanitizeStrings sanitizes fv, a settable string, pointer to string, or slice of strings
+func (t *Tools) sanitizeStrings(fv reflect.Value, policy SanitizePolicy) {
+	switch fv.Kind() {
+	case reflect.Ptr:
+		if !fv.IsNil() {
+			t.sanitizeStrings(fv.Elem(), policy)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < fv.Len(); i++ {
+			t.sanitizeStrings(fv.Index(i), policy)
+		}
+	case reflect.String:
+		if fv.CanSet() {
+			fv.SetString(t.SanitizeHTML(fv.String(), policy))
+		}
+	}
+}
+
+type cachedSanitizeFields struct {
+	fields []sanitizeField
+	err    error
+}
+
+// sanitizeFieldsFor returns the cached sanitize fields of typ, reading its tags the first time it is seen
+func sanitizeFieldsFor(typ reflect.Type) ([]sanitizeField, error) {
+	if cached, ok := sanitizeFieldCache.Load(typ); ok {
+		c := cached.(cachedSanitizeFields)
+		return c.fields, c.err
+	}
+
+	var fields []sanitizeField
+	var err error
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		policy, tagged := sf.Tag.Lookup("sanitize")
+		if !tagged {
+			switch sf.Type.Kind() {
+			case reflect.Struct, reflect.Ptr, reflect.Slice, reflect.Array, reflect.Interface:
+				fields = append(fields, sanitizeField{index: i})
+			}
+			continue
+		}
+		if _, ok := sanitizePolicies[policy]; !ok {
+			err = fmt.Errorf("field %s has unknown sanitize policy %q", sf.Name, policy)
+			break
+		}
+		fields = append(fields, sanitizeField{index: i, policy: policy})
+	}
+
+	sanitizeFieldCache.Store(typ, cachedSanitizeFields{fields: fields, err: err})
+	return fields, err
+}
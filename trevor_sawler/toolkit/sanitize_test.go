@@ -0,0 +1,193 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var sanitizeTests = []struct {
+	name     string
+	policy   SanitizePolicy
+	input    string
+	expected string
+}{
+	{name: "plain text", policy: BasicFormatting(), input: "fish & chips", expected: "fish &amp; chips"},
+	{name: "entities normalised", policy: BasicFormatting(), input: "a &lt;b&gt; &amp;amp; &#39;", expected: "a &lt;b&gt; &amp;amp; &#39;"},
+	{name: "basic formatting kept", policy: BasicFormatting(), input: "<p>Hello <b>bold</b> <i>it</i></p><ul><li>one</li></ul>", expected: "<p>Hello <b>bold</b> <i>it</i></p><ul><li>one</li></ul>"},
+	{name: "tag case normalised", policy: BasicFormatting(), input: "<P><B>x</B></P>", expected: "<p><b>x</b></p>"},
+	{name: "link kept", policy: BasicFormatting(), input: `<a href="https://example.com/?a=1&amp;b=2" title='t"q'>x</a>`, expected: `<a href="https://example.com/?a=1&amp;b=2" title="t&#34;q">x</a>`},
+	{name: "relative link kept", policy: BasicFormatting(), input: `<a href="/docs#top">x</a>`, expected: `<a href="/docs#top">x</a>`},
+	{name: "disallowed attribute", policy: BasicFormatting(), input: `<p class="x" id=y>x</p>`, expected: "<p>x</p>"},
+	{name: "disallowed tag keeps text", policy: BasicFormatting(), input: "<div><span>text</span></div>", expected: "text"},
+	{name: "unclosed tags closed", policy: BasicFormatting(), input: "<p><b>bold", expected: "<p><b>bold</b></p>"},
+	{name: "misnested tags", policy: BasicFormatting(), input: "<b><i>x</b>y</i>", expected: "<b><i>x</i></b>y"},
+	{name: "stray end tag", policy: BasicFormatting(), input: "x</p></b>y", expected: "xy"},
+	{name: "void element", policy: BasicFormatting(), input: "a<br>b<br/>c", expected: "a<br>b<br>c"},
+	{name: "strip all", policy: StripAll(), input: "<p>Hello <b>world</b></p>", expected: "Hello world"},
+	{name: "comments removed", policy: BasicFormatting(), input: "a<!-- <b>x</b> -->b<!-->c", expected: "abc"},
+	{name: "lone less than", policy: BasicFormatting(), input: "1 < 2 and 3 <4", expected: "1 &lt; 2 and 3 &lt;4"},
+	{name: "custom allowlist", policy: SanitizePolicy{AllowedTags: map[string][]string{"img": {"src", "alt", "onerror"}}, AllowedURLSchemes: []string{"https"}}, input: `<img src="https://x/a.png" alt="a" onerror="alert(1)"><b>x</b>`, expected: `<img src="https://x/a.png" alt="a">x`},
+	{name: "textarea content is text", policy: BasicFormatting(), input: "<textarea><b>x</b></textarea>", expected: "&lt;b&gt;x&lt;/b&gt;"},
+}
+
+func TestTools_SanitizeHTML(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range sanitizeTests {
+		if got := testTools.SanitizeHTML(e.input, e.policy); got != e.expected {
+			t.Errorf("%s: expected %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+// xssCorpus holds attacks that must not survive SanitizeHTML with the BasicFormatting policy
+var xssCorpus = []string{
+	`<script>alert(1)</script>`,
+	`<SCRIPT SRC=//evil.example/x.js></SCRIPT>`,
+	`<script>document.write("</scr" + "ipt>")</script>`,
+	`<scr<script>ipt>alert(1)</scr</script>ipt>`,
+	`<<script>script>alert(1)<</script>/script>`,
+	`<script/xss src="//evil.example/x.js"></script>`,
+	`<script >alert(1)</script >`,
+	`<script>alert(1)`,
+	`<img src=x onerror=alert(1)>`,
+	`<img src="x" ONERROR="alert(1)">`,
+	`<b onmouseover="alert(1)">hover</b>`,
+	`<b/onmouseover=alert(1)>hover</b>`,
+	`<p style="background:url(javascript:alert(1))">x</p>`,
+	`<svg onload=alert(1)>`,
+	`<svg><script>alert(1)</script></svg>`,
+	`<math><mtext><script>alert(1)</script></mtext></math>`,
+	`<iframe src="javascript:alert(1)"></iframe>`,
+	`<body onload=alert(1)>`,
+	`<a href="javascript:alert(1)">x</a>`,
+	`<a href="JaVaScRiPt:alert(1)">x</a>`,
+	`<a href="  javascript:alert(1)">x</a>`,
+	`<a href="java&#x09;script:alert(1)">x</a>`,
+	`<a href="java	script:alert(1)">x</a>`,
+	`<a href="&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;alert(1)">x</a>`,
+	`<a href="&#x6A;avascript&colon;alert(1)">x</a>`,
+	"<a href=\"\x01javascript:alert(1)\">x</a>",
+	`<a href="vbscript:msgbox(1)">x</a>`,
+	`<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`,
+	`<a href=javascript:alert(1)>x</a>`,
+	`<a href="https://ok" href="javascript:alert(1)">x</a>`,
+	`<a href='x' onclick='alert(1)'>x</a>`,
+	`<a href="x"onclick="alert(1)">x</a>`,
+	`<style>@import "javascript:alert(1)";</style>`,
+	`<noscript><p title="</noscript><img src=x onerror=alert(1)>"></noscript>`,
+	`<!--<img src=x onerror=alert(1)>-->`,
+	`<!--[if gte IE 4]><script>alert(1)</script><![endif]-->`,
+	`<object data="javascript:alert(1)"></object>`,
+	`<embed src="javascript:alert(1)">`,
+	`<form action="javascript:alert(1)"><input type=submit></form>`,
+	`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+	`<base href="javascript:alert(1)//">`,
+	`<template><script>alert(1)</script></template>`,
+	`<p title="&quot;><script>alert(1)</script>">x</p>`,
+	`&lt;script&gt;alert(1)&lt;/script&gt;`,
+	`<a href="https://ok">x</a"><script>alert(1)</script>`,
+	`<p <script>alert(1)</script>>x</p>`,
+	`<img src=x onerror=alert(1)`,
+}
+
+func TestTools_SanitizeHTMLXSSCorpus(t *testing.T) {
+	var testTools Tools
+
+	for _, input := range xssCorpus {
+		got := testTools.SanitizeHTML(input, BasicFormatting())
+
+		// tokenize the output as a browser would: the only markup left must be allowed tags with allowed
+		// attributes, and everything else inert text
+		z := htmlTokenizer{s: got}
+		for {
+			tok, ok := z.next()
+			if !ok {
+				break
+			}
+			if tok.kind == htmlComment {
+				t.Errorf("%q: comment survived in %q", input, got)
+			}
+			if tok.kind != htmlStartTag {
+				continue
+			}
+			permitted, allowed := BasicFormatting().AllowedTags[tok.name]
+			if !allowed {
+				t.Errorf("%q: disallowed tag %s survived in %q", input, tok.name, got)
+			}
+			for _, a := range tok.attrs {
+				if !containsString(permitted, a.name) {
+					t.Errorf("%q: disallowed attribute %s survived in %q", input, a.name, got)
+				}
+				if strings.Contains(strings.ToLower(a.value), "script:") {
+					t.Errorf("%q: script URL survived in %q", input, got)
+				}
+			}
+		}
+	}
+}
+
+func TestTools_SanitizeHTMLDropsScriptContent(t *testing.T) {
+	var testTools Tools
+
+	got := testTools.SanitizeHTML(`a<script>var x = "<b>";</script>b<style>p{}</style>c<svg><text>t</text></svg>d`, StripAll())
+	if got != "abcd" {
+		t.Errorf("expected the content of script, style and svg to be removed, got %q", got)
+	}
+}
+
+type sanitizeComment struct {
+	Author string   `json:"author" sanitize:"strip"`
+	Body   string   `json:"body" sanitize:"basic"`
+	Tags   []string `json:"tags" sanitize:"strip"`
+	Raw    string   `json:"raw"`
+}
+
+type sanitizePost struct {
+	Title    *string           `json:"title" sanitize:"strip"`
+	Comments []sanitizeComment `json:"comments"`
+	Lead     *sanitizeComment  `json:"lead"`
+}
+
+func TestTools_ReadJSONSanitize(t *testing.T) {
+	body := `{
+		"title": "<b>Hi</b>",
+		"comments": [{"author": "<i>bob</i>", "body": "<p onclick=x>ok</p><script>x</script>", "tags": ["<u>a</u>"], "raw": "<b>raw</b>"}],
+		"lead": {"body": "<a href=\"javascript:x\">link</a>"}
+	}`
+
+	testTools := Tools{SanitizeJSON: true}
+	var post sanitizePost
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	if err := testTools.ReadJSON(httptest.NewRecorder(), req, &post); err != nil {
+		t.Fatal(err)
+	}
+
+	c := post.Comments[0]
+	if *post.Title != "Hi" || c.Author != "bob" || c.Body != "<p>ok</p>" || c.Tags[0] != "a" || post.Lead.Body != "<a>link</a>" {
+		t.Errorf("fields not sanitized: %q %+v %+v", *post.Title, c, post.Lead)
+	}
+	if c.Raw != "<b>raw</b>" {
+		t.Errorf("untagged field should be left alone, got %q", c.Raw)
+	}
+
+	var untouched sanitizePost
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	var plain Tools
+	if err := plain.ReadJSON(httptest.NewRecorder(), req, &untouched); err != nil {
+		t.Fatal(err)
+	}
+	if *untouched.Title != "<b>Hi</b>" {
+		t.Errorf("expected no sanitizing unless SanitizeJSON is set, got %q", *untouched.Title)
+	}
+
+	var bad struct {
+		Name string `json:"name" sanitize:"fancy"`
+	}
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": "x"}`))
+	if err := testTools.ReadJSON(httptest.NewRecorder(), req, &bad); err == nil {
+		t.Error("expected an error for an unknown sanitize policy")
+	}
+}
@@ -0,0 +1,160 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Scanner checks uploaded files for malware. Scan returns nil when the file read from r is clean, an error matching
+// ErrInfected when it is rejected, and any other error when it couldn't be scanned
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) error
+}
+
+// ErrInfected is what a Scanner's error matches, via errors.Is, when it rejects a file; it may wrap it with the name
+// of what it found. It is matched by the *ScanError that UploadFiles returns then
+var ErrInfected = errors.New("file is infected")
+
+// ErrScannerUnavailable is matched, via errors.Is, by the *ScanError returned by UploadFiles when Scanner fails
+// without rejecting the file, so that callers can tell an unscanned file from an infected one
+var ErrScannerUnavailable = errors.New("virus scanner unavailable")
+
+// ScanError is returned when Scanner rejects an uploaded file, matching ErrInfected, or fails to scan it, matching
+// ErrScannerUnavailable
+type ScanError struct {
+	FileName string
+	Err      error
+}
+
+// Error implements the error interface
+func (e *ScanError) Error() string {
+	key, args := e.messageKey()
+	return englishMessage(key, args...)
+}
+
+// Is reports whether target is ErrScannerUnavailable, for an error from the Scanner that doesn't match ErrInfected
+func (e *ScanError) Is(target error) bool {
+	return target == ErrScannerUnavailable && !errors.Is(e.Err, ErrInfected)
+}
+
+// Unwrap returns the error from the Scanner
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ScanError) messageKey() (string, []interface{}) {
+	if errors.Is(e.Err, ErrInfected) {
+		return "upload.infected", []interface{}{e.FileName}
+	}
+	return "upload.scan_failed", []interface{}{e.FileName}
+}
+
+// NopScanner is a Scanner that passes every file, for development machines without a scanner
+type NopScanner struct{}
+
+// Scan implements Scanner
+func (NopScanner) Scan(ctx context.Context, r io.Reader) error {
+	return nil
+}
+
+// FakeScanner is a Scanner for tests. It rejects a file holding any of Signatures, and fails every scan with Err
+// when that is set
+type FakeScanner struct {
+	Signatures [][]byte
+	Err        error
+}
+
+// Scan implements Scanner
+func (s FakeScanner) Scan(ctx context.Context, r io.Reader) error {
+	if s.Err != nil {
+		return s.Err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	for _, signature := range s.Signatures {
+		if bytes.Contains(data, signature) {
+			return fmt.Errorf("%w: signature %q found", ErrInfected, signature)
+		}
+	}
+	return nil
+}
+
+// errScanAborted stops the scan of a file whose upload failed
+var errScanAborted = errors.New("upload aborted")
+
+// uploadScan runs Scanner on the bytes written to it, failing the write once the file is rejected. A nil
+// *uploadScan does nothing
+type uploadScan struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	err  error
+}
+
+// newUploadScan starts the scan of an uploaded file with Scanner, or returns nil when it isn't set
+func (t *Tools) newUploadScan(ctx context.Context) *uploadScan {
+	if t.Scanner == nil {
+		return nil
+	}
+
+	pr, pw := io.Pipe()
+	s := &uploadScan{pw: pw, done: make(chan struct{})}
+	go func() {
+		err := t.Scanner.Scan(ctx, pr)
+		if err == nil || (t.ScanFailOpen && !errors.Is(err, ErrInfected)) {
+			io.Copy(io.Discard, pr)
+		}
+		s.err = err
+		close(s.done)
+		pr.CloseWithError(err)
+	}()
+	return s
+}
+
+func (s *uploadScan) Write(p []byte) (int, error) {
+	return s.pw.Write(p)
+}
+
+// wait waits for the scan to finish, once everything has been written, and returns the scanner's error
+func (s *uploadScan) wait() error {
+	if s == nil {
+		return nil
+	}
+	s.pw.Close()
+	<-s.done
+	return s.err
+}
+
+// stop stops the scan of a file whose save failed, if it hasn't finished, and waits for it. It returns the scanner's
+// error when the scanner had already finished, as the save may have failed because the scanner rejected the file
+func (s *uploadScan) stop() error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case <-s.done:
+		return s.err
+	default:
+	}
+	s.pw.CloseWithError(errScanAborted)
+	<-s.done
+	return nil
+}
+
+// scanResult turns the error from Scanner for the uploaded file filename into the error returned for the upload. A
+// file that couldn't be scanned is let through, and the error logged, when ScanFailOpen is set
+func (t *Tools) scanResult(filename string, err error) error {
+	if err == nil {
+		return nil
+	}
+	scanErr := &ScanError{FileName: filename, Err: err}
+	if t.ScanFailOpen && !errors.Is(err, ErrInfected) {
+		t.logger().Printf("upload: %s: %s", scanErr, err)
+		return nil
+	}
+	return scanErr
+}
@@ -0,0 +1,182 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// eicar is the EICAR test string, which virus scanners report as infected
+var eicar = []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`)
+
+// recordingScanner is a Scanner that keeps what it reads
+type recordingScanner struct {
+	mu      sync.Mutex
+	scanned [][]byte
+}
+
+func (s *recordingScanner) Scan(ctx context.Context, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanned = append(s.scanned, data)
+	return err
+}
+
+// earlyScanner is a Scanner that rejects a file after reading its first bytes
+type earlyScanner struct{}
+
+func (earlyScanner) Scan(ctx context.Context, r io.Reader) error {
+	if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+		return err
+	}
+	return ErrInfected
+}
+
+func TestTools_UploadFilesScanner(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	infected := append([]byte("some text "), eicar...)
+	unavailable := errors.New("dial tcp 127.0.0.1:3310: connection refused")
+
+	var tests = []struct {
+		name        string
+		scanner     Scanner
+		failOpen    bool
+		content     []byte
+		infected    bool
+		unavailable bool
+		failed      string
+	}{
+		{name: "clean", scanner: FakeScanner{Signatures: [][]byte{eicar}}, content: []byte("some text")},
+		{name: "infected", scanner: FakeScanner{Signatures: [][]byte{eicar}}, content: infected, infected: true},
+		{name: "infected, failing open", scanner: FakeScanner{Signatures: [][]byte{eicar}}, failOpen: true, content: infected, infected: true},
+		{name: "rejected early", scanner: earlyScanner{}, content: img, infected: true, failed: "first.png"},
+		{name: "unavailable", scanner: FakeScanner{Err: unavailable}, content: img, unavailable: true, failed: "first.png"},
+		{name: "unavailable, failing open", scanner: FakeScanner{Err: unavailable}, failOpen: true, content: img},
+		{name: "nop", scanner: NopScanner{}, content: img},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			testTools := Tools{Scanner: e.scanner, ScanFailOpen: e.failOpen, Logger: log.New(io.Discard, "", 0)}
+			dir := t.TempDir()
+			req := newUploadRequest(t,
+				testUpload{field: "file", filename: "first.png", content: img},
+				testUpload{field: "file", filename: "second.txt", content: e.content},
+			)
+			_, err := upload(&testTools, req, dir)
+
+			if !e.infected && !e.unavailable {
+				if err != nil {
+					t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				} else if names := remainingFiles(t, dir); len(names) != 2 {
+					t.Errorf("%s, %s: expected both files to be saved, got %v", e.name, name, names)
+				}
+				continue
+			}
+
+			failed := e.failed
+			if failed == "" {
+				failed = "second.txt"
+			}
+			var scanErr *ScanError
+			if !errors.As(err, &scanErr) || scanErr.FileName != failed {
+				t.Errorf("%s, %s: expected a *ScanError for %s, got %v", e.name, name, failed, err)
+				continue
+			}
+			if errors.Is(err, ErrInfected) != e.infected || errors.Is(err, ErrScannerUnavailable) != e.unavailable {
+				t.Errorf("%s, %s: expected infected %t and unavailable %t, got %v", e.name, name, e.infected, e.unavailable, err)
+			}
+			if names := remainingFiles(t, dir); names != nil {
+				t.Errorf("%s, %s: expected the upload to be removed, got %v", e.name, name, names)
+			}
+		}
+	}
+}
+
+// listingScanner is a Scanner that rejects every file, recording what is in dir when it has read the whole file
+type listingScanner struct {
+	t      *testing.T
+	dir    string
+	listed []string
+}
+
+func (s *listingScanner) Scan(ctx context.Context, r io.Reader) error {
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	s.listed = remainingFiles(s.t, s.dir)
+	return ErrInfected
+}
+
+func TestTools_UploadFilesScannedBeforeSave(t *testing.T) {
+	for name, upload := range uploadFuncs {
+		dir := t.TempDir()
+		scanner := &listingScanner{t: t, dir: dir}
+		testTools := Tools{Scanner: scanner}
+		req := newUploadRequest(t, testUpload{field: "file", filename: "file.txt", content: eicar})
+
+		if _, err := upload(&testTools, req, dir); !errors.Is(err, ErrInfected) {
+			t.Errorf("%s: expected ErrInfected, got %v", name, err)
+		}
+		if scanner.listed != nil {
+			t.Errorf("%s: expected nothing in the upload directory while the file was scanned, got %v", name, scanner.listed)
+		}
+		if names := remainingFiles(t, dir); names != nil {
+			t.Errorf("%s: expected nothing to be saved, got %v", name, names)
+		}
+	}
+}
+
+func TestTools_UploadFilesScannerReadsFile(t *testing.T) {
+	img := readTestFile(t, "img.png")
+
+	// the scanner reads what is saved, and a file refused for its size isn't reported as a scan failure
+	scanner := &recordingScanner{}
+	testTools := Tools{Scanner: scanner, MaxFilePerSize: 1000}
+	req := newUploadRequest(t, testUpload{field: "file", filename: "small.txt", content: []byte("some text")})
+	if _, err := testTools.StreamUploadFiles(req, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	req = newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: img})
+	if _, err := testTools.StreamUploadFiles(req, t.TempDir()); !errors.Is(err, ErrFileTooBig) || errors.Is(err, ErrScannerUnavailable) {
+		t.Errorf("expected ErrFileTooBig, got %v", err)
+	}
+	if len(scanner.scanned) != 2 || string(scanner.scanned[0]) != "some text" {
+		t.Errorf("expected the scanner to read the saved file, got %q", scanner.scanned)
+	}
+}
+
+func TestScanError(t *testing.T) {
+	var tests = []struct {
+		err     *ScanError
+		message string
+		status  int
+	}{
+		{err: &ScanError{FileName: "a.txt", Err: ErrInfected}, message: `the uploaded file "a.txt" was rejected by the virus scan`, status: http.StatusUnprocessableEntity},
+		{err: &ScanError{FileName: "a.txt", Err: errors.New("timeout")}, message: `the uploaded file "a.txt" could not be scanned for viruses`, status: http.StatusServiceUnavailable},
+	}
+
+	for _, e := range tests {
+		if e.err.Error() != e.message {
+			t.Errorf("%v: unexpected message %q", e.err.Err, e.err.Error())
+		}
+		if status := uploadErrorStatus(e.err); status != e.status {
+			t.Errorf("%v: expected status %d, got %d", e.err.Err, e.status, status)
+		}
+	}
+
+	err := FakeScanner{Signatures: [][]byte{eicar}}.Scan(context.Background(), strings.NewReader("x"+string(eicar)))
+	if !errors.Is(err, ErrInfected) {
+		t.Errorf("expected FakeScanner to find the EICAR test string, got %v", err)
+	}
+	if err := (FakeScanner{Signatures: [][]byte{eicar}}).Scan(context.Background(), bytes.NewReader([]byte("clean"))); err != nil {
+		t.Errorf("expected FakeScanner to pass a clean file, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package toolkit
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// SecureHeaderOptions configures SecureHeaders. Empty string fields use the default shown; set a field to "-"
+// to leave that header out entirely
+type SecureHeaderOptions struct {
+	// ContentTypeOptions is the X-Content-Type-Options value. Defaults to "nosniff"
+	ContentTypeOptions string
+
+	// FrameOptions is the X-Frame-Options value. Defaults to "DENY"
+	FrameOptions string
+
+	// ReferrerPolicy is the Referrer-Policy value. Defaults to "no-referrer"
+	ReferrerPolicy string
+
+	// ContentSecurityPolicy is the Content-Security-Policy value. It is not sent unless set
+	ContentSecurityPolicy string
+
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header. Defaults to one year
+	HSTSMaxAge time.Duration
+
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// ForceHSTS sends Strict-Transport-Security on plain HTTP requests too, for servers behind a TLS terminating proxy.
+	// Otherwise it is only sent when the request arrived over TLS
+	ForceHSTS bool
+}
+
+// SecureHeaders is middleware that adds common security headers to every response. Any header the next handler
+// sets itself is left alone
+func (t *Tools) SecureHeaders(opts SecureHeaderOptions) func(http.Handler) http.Handler {
+	headers := make(http.Header)
+
+	set := func(key, value, def string) {
+		if value == "" {
+			value = def
+		}
+		if value != "" && value != "-" {
+			headers.Set(key, value)
+		}
+	}
+
+	set("X-Content-Type-Options", opts.ContentTypeOptions, "nosniff")
+	set("X-Frame-Options", opts.FrameOptions, "DENY")
+	set("Referrer-Policy", opts.ReferrerPolicy, "no-referrer")
+	set("Content-Security-Policy", opts.ContentSecurityPolicy, "")
+
+	maxAge := opts.HSTSMaxAge
+	if maxAge == 0 {
+		maxAge = 365 * 24 * time.Hour
+	}
+	hsts := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	if opts.HSTSIncludeSubdomains {
+		hsts += "; includeSubDomains"
+	}
+	if opts.HSTSPreload {
+		hsts += "; preload"
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &secureHeadersWriter{ResponseWriter: w, headers: headers}
+			if r.TLS != nil || opts.ForceHSTS {
+				sw.hsts = hsts
+			}
+
+			next.ServeHTTP(sw, r)
+
+			// a handler that writes nothing still gets a response, so make sure it carries the headers
+			sw.apply()
+		})
+	}
+}
+
+// secureHeadersWriter adds the security headers just before the response headers are sent, which is the
+// last point at which we can tell whether the handler set any of them itself
+type secureHeadersWriter struct {
+	http.ResponseWriter
+	headers http.Header
+	hsts    string
+	applied bool
+}
+
+func (sw *secureHeadersWriter) apply() {
+	if sw.applied {
+		return
+	}
+	sw.applied = true
+
+	dst := sw.ResponseWriter.Header()
+	for key, value := range sw.headers {
+		if _, ok := dst[key]; !ok {
+			dst[key] = value
+		}
+	}
+	if sw.hsts != "" && dst.Get("Strict-Transport-Security") == "" {
+		dst.Set("Strict-Transport-Security", sw.hsts)
+	}
+}
+
+func (sw *secureHeadersWriter) WriteHeader(status int) {
+	sw.apply()
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *secureHeadersWriter) Write(b []byte) (int, error) {
+	sw.apply()
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher when the underlying writer does
+func (sw *secureHeadersWriter) Flush() {
+	sw.apply()
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
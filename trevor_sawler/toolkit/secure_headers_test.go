@@ -0,0 +1,102 @@
+package toolkit
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var secureHeaderTests = []struct {
+	name           string
+	opts           SecureHeaderOptions
+	tls            bool
+	handlerHeaders map[string]string
+	expected       map[string]string
+}{
+	{
+		name: "defaults over http",
+		opts: SecureHeaderOptions{},
+		expected: map[string]string{
+			"X-Content-Type-Options":    "nosniff",
+			"X-Frame-Options":           "DENY",
+			"Referrer-Policy":           "no-referrer",
+			"Content-Security-Policy":   "",
+			"Strict-Transport-Security": "",
+		},
+	},
+	{
+		name: "defaults over tls",
+		opts: SecureHeaderOptions{},
+		tls:  true,
+		expected: map[string]string{
+			"X-Content-Type-Options":    "nosniff",
+			"Strict-Transport-Security": "max-age=31536000",
+		},
+	},
+	{
+		name: "forced hsts with options",
+		opts: SecureHeaderOptions{ForceHSTS: true, HSTSMaxAge: time.Hour, HSTSIncludeSubdomains: true, HSTSPreload: true},
+		expected: map[string]string{
+			"Strict-Transport-Security": "max-age=3600; includeSubDomains; preload",
+		},
+	},
+	{
+		name: "customized",
+		opts: SecureHeaderOptions{
+			FrameOptions:          "SAMEORIGIN",
+			ReferrerPolicy:        "strict-origin-when-cross-origin",
+			ContentSecurityPolicy: "default-src 'self'",
+			ContentTypeOptions:    "-",
+		},
+		expected: map[string]string{
+			"X-Content-Type-Options":  "",
+			"X-Frame-Options":         "SAMEORIGIN",
+			"Referrer-Policy":         "strict-origin-when-cross-origin",
+			"Content-Security-Policy": "default-src 'self'",
+		},
+	},
+	{
+		name:           "handler headers win",
+		opts:           SecureHeaderOptions{ContentSecurityPolicy: "default-src 'none'"},
+		tls:            true,
+		handlerHeaders: map[string]string{"X-Frame-Options": "SAMEORIGIN", "Content-Security-Policy": "img-src *", "Strict-Transport-Security": "max-age=10"},
+		expected: map[string]string{
+			"X-Frame-Options":           "SAMEORIGIN",
+			"Content-Security-Policy":   "img-src *",
+			"Strict-Transport-Security": "max-age=10",
+			"Referrer-Policy":           "no-referrer",
+		},
+	},
+}
+
+func TestTools_SecureHeaders(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range secureHeaderTests {
+		for _, write := range []bool{true, false} {
+			h := testTools.SecureHeaders(e.opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range e.handlerHeaders {
+					w.Header().Set(k, v)
+				}
+				if write {
+					_, _ = w.Write([]byte("ok"))
+				}
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if e.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			for k, v := range e.expected {
+				if got := rr.Header().Get(k); got != v {
+					t.Errorf("%s (write %t): expected %s to be %q but got %q", e.name, write, k, v, got)
+				}
+			}
+		}
+	}
+}
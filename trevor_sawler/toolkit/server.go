@@ -0,0 +1,143 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ShutdownError is returned by Serve and ServeTLS when the server failed to shut down gracefully
+type ShutdownError struct {
+	Err error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("graceful shutdown failed: %s", e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// ServerOption configures the server started by Serve and ServeTLS
+type ServerOption func(*serverConfig)
+
+type serverConfig struct {
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	shutdownTimeout   time.Duration
+	onListen          func(addr net.Addr)
+}
+
+// WithReadTimeout sets the maximum time allowed to read a request, including the body. Defaults to 30 seconds;
+// increase it for servers accepting large uploads
+func WithReadTimeout(d time.Duration) ServerOption {
+	return func(c *serverConfig) { c.readTimeout = d }
+}
+
+// WithWriteTimeout sets the maximum time allowed to write a response. Defaults to 60 seconds
+func WithWriteTimeout(d time.Duration) ServerOption {
+	return func(c *serverConfig) { c.writeTimeout = d }
+}
+
+// WithIdleTimeout sets how long keep-alive connections are kept open while idle. Defaults to 120 seconds
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(c *serverConfig) { c.idleTimeout = d }
+}
+
+// WithShutdownTimeout sets the grace period in which in-flight requests may finish once shutdown has
+// started. Defaults to 30 seconds
+func WithShutdownTimeout(d time.Duration) ServerOption {
+	return func(c *serverConfig) { c.shutdownTimeout = d }
+}
+
+// WithListenCallback registers a function that is called with the listening address once the server is
+// accepting connections. It is mostly useful when addr uses port 0
+func WithListenCallback(fn func(addr net.Addr)) ServerOption {
+	return func(c *serverConfig) { c.onListen = fn }
+}
+
+// Serve listens on addr and serves h until ctx is cancelled or the process receives SIGINT or SIGTERM, and
+// then shuts down gracefully
+func (t *Tools) Serve(ctx context.Context, addr string, h http.Handler, opts ...ServerOption) error {
+	return t.serve(ctx, addr, h, "", "", opts)
+}
+
+// ServeTLS is the same as Serve, but serves HTTPS using the given certificate and key files
+func (t *Tools) ServeTLS(ctx context.Context, addr, certFile, keyFile string, h http.Handler, opts ...ServerOption) error {
+	return t.serve(ctx, addr, h, certFile, keyFile, opts)
+}
+
+func (t *Tools) serve(ctx context.Context, addr string, h http.Handler, certFile, keyFile string, opts []ServerOption) error {
+	cfg := serverConfig{
+		readHeaderTimeout: 10 * time.Second,
+		readTimeout:       30 * time.Second,
+		writeTimeout:      60 * time.Second,
+		idleTimeout:       120 * time.Second,
+		shutdownTimeout:   30 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	// register for signals before listening, so that there is no window in which a signal kills the process
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: cfg.readHeaderTimeout,
+		ReadTimeout:       cfg.readTimeout,
+		WriteTimeout:      cfg.writeTimeout,
+		IdleTimeout:       cfg.idleTimeout,
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if cfg.onListen != nil {
+		cfg.onListen(ln.Addr())
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if certFile != "" || keyFile != "" {
+			serveErr <- srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			serveErr <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		_ = srv.Close()
+		return &ShutdownError{Err: err}
+	}
+
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
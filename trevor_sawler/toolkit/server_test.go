@@ -0,0 +1,162 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// startTestServer runs Serve in the background and returns the address it is listening on, and a channel
+// that receives its return value
+func startTestServer(t *testing.T, ctx context.Context, h http.Handler, opts ...ServerOption) (string, chan error) {
+	var testTools Tools
+
+	addrCh := make(chan string, 1)
+	done := make(chan error, 1)
+
+	opts = append(opts, WithListenCallback(func(addr net.Addr) {
+		addrCh <- addr.String()
+	}))
+
+	go func() {
+		done <- testTools.Serve(ctx, "127.0.0.1:0", h, opts...)
+	}()
+
+	select {
+	case addr := <-addrCh:
+		return addr, done
+	case err := <-done:
+		t.Fatal("server failed to start:", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for server to start")
+	}
+	return "", nil
+}
+
+func TestTools_ServeSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sending interrupts to the current process is not supported on windows")
+	}
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	addr, done := startTestServer(t, context.Background(), h)
+
+	res, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "hello" {
+		t.Errorf("wrong body returned: %s", body)
+	}
+
+	p, _ := os.FindProcess(os.Getpid())
+	if err := p.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error("expected clean exit but got", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down after interrupt")
+	}
+}
+
+func TestTools_ServeDrainsInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte("finished"))
+	})
+
+	addr, done := startTestServer(t, ctx, h)
+
+	resCh := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			resCh <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		resCh <- string(body)
+	}()
+
+	<-started
+	cancel()
+
+	if body := <-resCh; body != "finished" {
+		t.Errorf("expected in-flight request to finish, got %s", body)
+	}
+
+	if err := <-done; err != nil {
+		t.Error("expected clean exit but got", err)
+	}
+}
+
+func TestTools_ServeShutdownTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	addr, done := startTestServer(t, ctx, h, WithShutdownTimeout(50*time.Millisecond))
+
+	go func() {
+		res, err := http.Get("http://" + addr + "/")
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+
+	<-started
+	cancel()
+
+	err := <-done
+	var shutdownErr *ShutdownError
+	if !errors.As(err, &shutdownErr) {
+		t.Errorf("expected a ShutdownError but got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected shutdown error to wrap context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestTools_ServeListenError(t *testing.T) {
+	var testTools Tools
+
+	err := testTools.Serve(context.Background(), "127.0.0.1:-1", http.NotFoundHandler())
+	if err == nil {
+		t.Fatal("expected listen error")
+	}
+
+	var shutdownErr *ShutdownError
+	if errors.As(err, &shutdownErr) {
+		t.Error("listen error should not be a ShutdownError")
+	}
+}
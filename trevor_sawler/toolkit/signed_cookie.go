@@ -0,0 +1,147 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrCookieInvalid is returned by GetSignedCookie when a cookie is malformed or its signature does not match any
+// of the CookieKeys
+var ErrCookieInvalid = errors.New("cookie is invalid")
+
+// ErrCookieExpired is returned by GetSignedCookie when a correctly signed cookie is past its expiry time
+var ErrCookieExpired = errors.New("cookie has expired")
+
+// ErrCookieTooLarge is returned by SetSignedCookie when the encoded cookie would be larger than browsers accept
+var ErrCookieTooLarge = errors.New("cookie is too large")
+
+// maxCookieSize is the largest cookie, name and value together, that every major browser accepts
+const maxCookieSize = 4096
+
+// CookieOptions configures SetSignedCookie. The zero value gives a session cookie for the whole site which is
+// Secure, HttpOnly and SameSite=Lax
+type CookieOptions struct {
+	Path   string
+	Domain string
+
+	// MaxAge is how long the cookie lasts. The expiry is also signed into the value, so GetSignedCookie rejects
+	// the cookie once it has passed even if the browser keeps sending it. Zero means a session cookie
+	MaxAge time.Duration
+
+	// SameSite defaults to http.SameSiteLaxMode
+	SameSite http.SameSite
+
+	// AllowInsecure drops the Secure attribute, for local development over plain HTTP
+	AllowInsecure bool
+
+	// AllowScriptAccess drops the HttpOnly attribute, so that JavaScript can read the cookie
+	AllowScriptAccess bool
+}
+
+// SetSignedCookie sets a cookie whose value is signed with the first of CookieKeys, so that GetSignedCookie can
+// detect any tampering. The value is not encrypted, so it must not hold secrets
+func (t *Tools) SetSignedCookie(w http.ResponseWriter, name, value string, opts CookieOptions) error {
+	if len(t.CookieKeys) == 0 {
+		return errors.New("no cookie keys configured")
+	}
+
+	var expires time.Time
+	if opts.MaxAge > 0 {
+		expires = time.Now().Add(opts.MaxAge)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString([]byte(value))
+	exp := "0"
+	if !expires.IsZero() {
+		exp = strconv.FormatInt(expires.Unix(), 10)
+	}
+	sig := base64.RawURLEncoding.EncodeToString(cookieSignature(t.CookieKeys[0], name, payload, exp))
+
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    payload + "." + exp + "." + sig,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		Secure:   !opts.AllowInsecure,
+		HttpOnly: !opts.AllowScriptAccess,
+		SameSite: opts.SameSite,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	if !expires.IsZero() {
+		cookie.Expires = expires
+		cookie.MaxAge = int(opts.MaxAge / time.Second)
+	}
+
+	if len(cookie.Name)+len(cookie.Value) > maxCookieSize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d", ErrCookieTooLarge, len(cookie.Name)+len(cookie.Value), maxCookieSize)
+	}
+
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// GetSignedCookie returns the value of a cookie set by SetSignedCookie and signed with any of CookieKeys. It returns
+// http.ErrNoCookie, ErrCookieInvalid or ErrCookieExpired when it can't be used
+func (t *Tools) GetSignedCookie(r *http.Request, name string) (string, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return "", ErrCookieInvalid
+	}
+	payload, exp := parts[0], parts[1]
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrCookieInvalid
+	}
+
+	valid := false
+	for _, key := range t.CookieKeys {
+		if hmac.Equal(sig, cookieSignature(key, name, payload, exp)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return "", ErrCookieInvalid
+	}
+
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", ErrCookieInvalid
+	}
+	if unix != 0 && time.Now().After(time.Unix(unix, 0)) {
+		return "", ErrCookieExpired
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrCookieInvalid
+	}
+
+	return string(value), nil
+}
+
+// cookieSignature signs the cookie name along with its value and expiry, so that a signed value can't be moved
+// into a different cookie
+func cookieSignature(key []byte, name, payload, exp string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "|" + payload + "|" + exp))
+	return mac.Sum(nil)
+}
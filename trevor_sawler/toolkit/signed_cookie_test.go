@@ -0,0 +1,147 @@
+package toolkit
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	cookieKeyOld = []byte("0123456789abcdef0123456789abcdef")
+	cookieKeyNew = []byte("fedcba9876543210fedcba9876543210")
+)
+
+// cookieRequest returns a request carrying the cookies set on rr
+func cookieRequest(rr *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestTools_SignedCookieRoundTrip(t *testing.T) {
+	testTools := Tools{CookieKeys: [][]byte{cookieKeyNew}}
+
+	rr := httptest.NewRecorder()
+	value := "user=42; role=admin ünïcode"
+	if err := testTools.SetSignedCookie(rr, "session", value, CookieOptions{MaxAge: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("expected one cookie, got %d", len(cookies))
+	}
+	c := cookies[0]
+	if !c.Secure || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode || c.Path != "/" || c.MaxAge != 3600 {
+		t.Errorf("expected safe defaults, got %+v", c)
+	}
+
+	got, err := testTools.GetSignedCookie(cookieRequest(rr), "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != value {
+		t.Errorf("expected %q, got %q", value, got)
+	}
+}
+
+func TestTools_SignedCookieOptions(t *testing.T) {
+	testTools := Tools{CookieKeys: [][]byte{cookieKeyNew}}
+
+	rr := httptest.NewRecorder()
+	opts := CookieOptions{Path: "/app", Domain: "example.com", SameSite: http.SameSiteStrictMode, AllowInsecure: true, AllowScriptAccess: true}
+	if err := testTools.SetSignedCookie(rr, "prefs", "dark", opts); err != nil {
+		t.Fatal(err)
+	}
+
+	c := rr.Result().Cookies()[0]
+	if c.Secure || c.HttpOnly || c.SameSite != http.SameSiteStrictMode || c.Path != "/app" || c.Domain != "example.com" || c.MaxAge != 0 {
+		t.Errorf("options not applied: %+v", c)
+	}
+}
+
+func TestTools_SignedCookieKeyRotation(t *testing.T) {
+	oldTools := Tools{CookieKeys: [][]byte{cookieKeyOld}}
+	rotated := Tools{CookieKeys: [][]byte{cookieKeyNew, cookieKeyOld}}
+	newOnly := Tools{CookieKeys: [][]byte{cookieKeyNew}}
+
+	rr := httptest.NewRecorder()
+	if err := oldTools.SetSignedCookie(rr, "session", "hello", CookieOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := rotated.GetSignedCookie(cookieRequest(rr), "session"); err != nil || got != "hello" {
+		t.Errorf("expected a cookie signed with the old key to be accepted during rotation, got %q, %v", got, err)
+	}
+	if _, err := newOnly.GetSignedCookie(cookieRequest(rr), "session"); !errors.Is(err, ErrCookieInvalid) {
+		t.Errorf("expected a cookie signed with a retired key to be rejected, got %v", err)
+	}
+}
+
+func TestTools_SignedCookieRejected(t *testing.T) {
+	testTools := Tools{CookieKeys: [][]byte{cookieKeyNew}}
+
+	rr := httptest.NewRecorder()
+	if err := testTools.SetSignedCookie(rr, "session", "user=42", CookieOptions{MaxAge: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	good := rr.Result().Cookies()[0].Value
+	parts := strings.Split(good, ".")
+
+	forgedPayload := base64.RawURLEncoding.EncodeToString([]byte("user=1"))
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	expiredSig := base64.RawURLEncoding.EncodeToString(cookieSignature(cookieKeyNew, "session", parts[0], past))
+
+	var tests = []struct {
+		name     string
+		cookie   string
+		value    string
+		expected error
+	}{
+		{name: "missing", expected: http.ErrNoCookie},
+		{name: "forged value", cookie: "session", value: forgedPayload + "." + parts[1] + "." + parts[2], expected: ErrCookieInvalid},
+		{name: "extended expiry", cookie: "session", value: parts[0] + ".0." + parts[2], expected: ErrCookieInvalid},
+		{name: "truncated signature", cookie: "session", value: good[:len(good)-5], expected: ErrCookieInvalid},
+		{name: "no signature", cookie: "session", value: parts[0], expected: ErrCookieInvalid},
+		{name: "garbage", cookie: "session", value: "not-a-signed-cookie", expected: ErrCookieInvalid},
+		{name: "moved to another cookie", cookie: "other", value: good, expected: ErrCookieInvalid},
+		{name: "expired", cookie: "session", value: parts[0] + "." + past + "." + expiredSig, expected: ErrCookieExpired},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		name := "session"
+		if e.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: e.cookie, Value: e.value})
+			name = e.cookie
+		}
+
+		if _, err := testTools.GetSignedCookie(req, name); !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestTools_SetSignedCookieErrors(t *testing.T) {
+	var noKeys Tools
+	if err := noKeys.SetSignedCookie(httptest.NewRecorder(), "session", "x", CookieOptions{}); err == nil {
+		t.Error("expected an error without cookie keys")
+	}
+
+	testTools := Tools{CookieKeys: [][]byte{cookieKeyNew}}
+	rr := httptest.NewRecorder()
+	err := testTools.SetSignedCookie(rr, "session", strings.Repeat("x", 3500), CookieOptions{})
+	if !errors.Is(err, ErrCookieTooLarge) {
+		t.Errorf("expected ErrCookieTooLarge, got %v", err)
+	}
+	if len(rr.Result().Cookies()) != 0 {
+		t.Error("no cookie should be set when it is too large")
+	}
+}
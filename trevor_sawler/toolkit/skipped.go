@@ -0,0 +1,78 @@
+package toolkit
+
+import (
+	"errors"
+)
+
+// SkipReason is why a file of an upload with ContinueOnError was skipped, as reported by SkippedFiles
+type SkipReason string
+
+const (
+	// SkipType is the reason for a file whose type or extension is not permitted, or doesn't match its content
+	SkipType SkipReason = "type"
+	// SkipSize is the reason for a file that is too big or too small, or an image or archive too large once opened
+	SkipSize SkipReason = "size"
+	// SkipName is the reason for a file whose name can't be used, or is already taken
+	SkipName SkipReason = "name"
+	// SkipOther is the reason for a file that failed any other way, such as the virus scan or the store
+	SkipOther SkipReason = "other"
+)
+
+// SkippedFile describes a file of an upload with ContinueOnError that was skipped rather than saved. DetectedType is
+// the type detected from its content, when the error it failed with tells it, and Err that error
+type SkippedFile struct {
+	OriginalFileName string     `json:"original_file_name"`
+	Reason           SkipReason `json:"reason"`
+	DetectedType     string     `json:"detected_type,omitempty"`
+	Err              error      `json:"-"`
+}
+
+// SkippedFiles returns the files an upload skipped with ContinueOnError, from the MultiError it returned as err,
+// or nil for any other error
+func SkippedFiles(err error) []SkippedFile {
+	failures, ok := err.(MultiError)
+	if !ok {
+		failures = MultiError{err}
+	}
+
+	var skipped []SkippedFile
+	for _, failure := range failures {
+		var fileErr *UploadFileError
+		if !errors.As(failure, &fileErr) {
+			continue
+		}
+		reason, detected := skipReason(fileErr.Err)
+		skipped = append(skipped, SkippedFile{
+			OriginalFileName: fileErr.FileName,
+			Reason:           reason,
+			DetectedType:     detected,
+			Err:              fileErr.Err,
+		})
+	}
+	return skipped
+}
+
+// skipReason returns the SkipReason for a file that failed with err, and the type detected from its content when err
+// holds it
+func skipReason(err error) (SkipReason, string) {
+	var typeErr *FileTypeError
+	var mismatchErr *ExtensionMismatchError
+	var tooBigErr *FileTooBigError
+	switch {
+	case errors.As(err, &typeErr):
+		return SkipType, typeErr.ContentType
+	case errors.As(err, &mismatchErr):
+		return SkipType, mismatchErr.ContentType
+	case errors.Is(err, ErrFileExtensionNotPermitted), errors.Is(err, ErrAnimatedImage):
+		return SkipType, ""
+	case errors.As(err, &tooBigErr):
+		return SkipSize, tooBigErr.ContentType
+	case errors.Is(err, ErrFileTooBig), errors.Is(err, ErrFileTooSmall), errors.Is(err, ErrImageTooLarge),
+		errors.Is(err, ErrZipTooManyEntries), errors.Is(err, ErrZipTooLarge), errors.Is(err, ErrZipCompressionRatio):
+		return SkipSize, ""
+	case errors.Is(err, ErrInvalidFileName), errors.Is(err, ErrFileExists):
+		return SkipName, ""
+	default:
+		return SkipOther, ""
+	}
+}
@@ -0,0 +1,54 @@
+package toolkit
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSkippedFiles(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	pic := readTestFile(t, "pic.jpg")
+
+	expected := []SkippedFile{
+		{OriginalFileName: "notes.txt", Reason: SkipType, DetectedType: "text/plain; charset=utf-8"},
+		{OriginalFileName: "img.png", Reason: SkipSize},
+		{OriginalFileName: ".htaccess", Reason: SkipName},
+	}
+
+	testTools := Tools{ContinueOnError: true, AllowedFileTypes: []string{"image/png", "image/jpeg"}, MaxFilePerSize: 200000}
+	for name, upload := range uploadFuncs {
+		req := newUploadRequest(t,
+			testUpload{"file", "pic.jpg", pic},
+			testUpload{"file", "notes.txt", []byte("some text")},
+			testUpload{"file", "img.png", img},
+			testUpload{"file", ".htaccess", pic},
+		)
+		files, err := upload(&testTools, req, t.TempDir(), false)
+		if len(files) != 1 || files[0].OriginalFileName != "pic.jpg" {
+			t.Errorf("%s: expected pic.jpg alone to be saved, got %v", name, files)
+		}
+
+		skipped := SkippedFiles(err)
+		for i := range skipped {
+			if skipped[i].Err == nil {
+				t.Errorf("%s: expected the error of %s", name, skipped[i].OriginalFileName)
+			}
+			skipped[i].Err = nil
+		}
+		if !reflect.DeepEqual(skipped, expected) {
+			t.Errorf("%s: expected the skipped files %+v, got %+v", name, expected, skipped)
+		}
+	}
+
+	// an error that failed the whole upload skipped no files in particular
+	for _, err := range []error{nil, ErrNoFiles, errors.New("boom")} {
+		if skipped := SkippedFiles(err); skipped != nil {
+			t.Errorf("%v: expected no skipped files, got %+v", err, skipped)
+		}
+	}
+	single := &UploadFileError{FileName: "a.exe", Err: &FileTypeError{FileName: "a.exe", ContentType: "application/octet-stream"}}
+	if skipped := SkippedFiles(single); len(skipped) != 1 || skipped[0].Reason != SkipType || skipped[0].DetectedType != "application/octet-stream" {
+		t.Errorf("expected a single file skipped for its type, got %+v", skipped)
+	}
+}
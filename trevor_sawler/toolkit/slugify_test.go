@@ -0,0 +1,144 @@
+package toolkit
+
+import (
+	"errors"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+var slugMaxLengthTests = []struct {
+	name      string
+	s         string
+	maxLength int
+	errorMax  int
+}{
+	{name: "at limit", s: strings.Repeat("a", 10), maxLength: 10},
+	{name: "over limit", s: strings.Repeat("a", 11), maxLength: 10, errorMax: 10},
+	{name: "multibyte at limit", s: strings.Repeat("é", 9) + "a", maxLength: 10},
+	{name: "multibyte over limit", s: strings.Repeat("é", 10) + "a", maxLength: 10, errorMax: 10},
+	{name: "default limit", s: strings.Repeat("a", defaultSlugMaxInputLength)},
+	{name: "over default limit", s: strings.Repeat("a", defaultSlugMaxInputLength+1), errorMax: defaultSlugMaxInputLength},
+}
+
+func TestTools_SlugifyMaxInputLength(t *testing.T) {
+	for _, e := range slugMaxLengthTests {
+		testTools := Tools{SlugMaxInputLength: e.maxLength}
+
+		_, err := testTools.Slugify(e.s)
+		if e.errorMax == 0 {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %s", e.name, err)
+			}
+			continue
+		}
+
+		var tooLong *InputTooLongError
+		if !errors.As(err, &tooLong) || !errors.Is(err, ErrInputTooLong) {
+			t.Errorf("%s: expected *InputTooLongError, got %v", e.name, err)
+			continue
+		}
+		if tooLong.Max != e.errorMax {
+			t.Errorf("%s: expected Max of %d, got %d", e.name, e.errorMax, tooLong.Max)
+		}
+	}
+}
+
+// randomUnicode returns a string of n random runes drawn from across the Unicode range, with a bias towards ASCII,
+// combining marks and characters whose case mapping changes their length
+func randomUnicode(rng *rand.Rand, n int) string {
+	special := []rune{'İ', 'ẞ', 'ǅ', '\u2126', '\u212a', '\u0301', '\u0308', '\u200d', '\ufeff', utf8.RuneError}
+
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		switch rng.Intn(4) {
+		case 0:
+			b.WriteRune(rune(rng.Intn(128)))
+		case 1:
+			b.WriteRune(special[rng.Intn(len(special))])
+		default:
+			b.WriteRune(rune(rng.Intn(utf8.MaxRune + 1)))
+		}
+	}
+	return b.String()
+}
+
+// checkSlug asserts the invariants every slug must satisfy, whatever its input
+func checkSlug(t *testing.T, input, slug string) {
+	t.Helper()
+
+	if len(slug) > len(input)*2 {
+		t.Errorf("slug of %d bytes is out of proportion to its %d byte input", len(slug), len(input))
+	}
+	if strings.HasPrefix(slug, "-") || strings.HasSuffix(slug, "-") || strings.Contains(slug, "--") {
+		t.Errorf("slug %q has stray hyphens", slug)
+	}
+	for _, r := range slug {
+		if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') && r != '-' {
+			t.Errorf("slug %q contains disallowed character %q", slug, r)
+			return
+		}
+	}
+}
+
+func TestTools_SlugifyRandomUnicode(t *testing.T) {
+	testTools := Tools{SlugMaxInputLength: 500}
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 2000; i++ {
+		input := randomUnicode(rng, rng.Intn(600))
+
+		slug, err := testTools.Slugify(input)
+		switch {
+		case err == nil:
+			checkSlug(t, input, slug)
+		case errors.Is(err, ErrInputTooLong):
+			if utf8.RuneCountInString(input) <= 500 {
+				t.Errorf("input of %d runes wrongly rejected as too long", utf8.RuneCountInString(input))
+			}
+		case errors.Is(err, ErrEmptyString), errors.Is(err, ErrEmptySlug):
+		default:
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+}
+
+func FuzzSlugify(f *testing.F) {
+	for _, seed := range []string{"Now is the time", "日本語", "İstanbul", "á́́", "--a--", ""} {
+		f.Add(seed)
+	}
+
+	testTools := Tools{SlugMaxInputLength: 1000}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		slug, err := testTools.Slugify(input)
+		if err == nil {
+			checkSlug(t, input, slug)
+		}
+	})
+}
+
+// slugifyCompilingEachCall is Slugify as it was before the pattern was compiled once, kept as a benchmark baseline
+func slugifyCompilingEachCall(s string) string {
+	var re = regexp.MustCompile(`[^a-z\d]+`)
+	return strings.Trim(re.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+func BenchmarkTools_Slugify(b *testing.B) {
+	var testTools Tools
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_, _ = testTools.Slugify("Now is the time for all GOOD men! + fish & such &^123")
+	}
+}
+
+func BenchmarkSlugifyCompilingEachCall(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_ = slugifyCompilingEachCall("Now is the time for all GOOD men! + fish & such &^123")
+	}
+}
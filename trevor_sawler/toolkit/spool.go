@@ -0,0 +1,268 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults for the spool, used when the corresponding RemoteOption is not given
+const (
+	defaultSpoolMaxAttempts = 10
+	spoolBaseBackoff        = time.Second
+	spoolMaxBackoff         = time.Hour
+	spoolStaleTempAge       = time.Hour
+)
+
+// spoolDeadLetterDir is the subdirectory of a spool that deliveries are moved to once they run out of attempts
+const spoolDeadLetterDir = "dead"
+
+// RemoteOption configures a delivery pushed to a remote URL
+type RemoteOption func(*remoteConfig)
+
+type remoteConfig struct {
+	headers     http.Header
+	maxAttempts int
+}
+
+// WithRemoteHeader adds a header to the request sent to the remote URL, such as an Authorization header
+func WithRemoteHeader(key, value string) RemoteOption {
+	return func(c *remoteConfig) { c.headers.Add(key, value) }
+}
+
+// WithRemoteMaxAttempts sets how many times a spooled delivery is tried before it is moved to the dead-letter
+// directory. Defaults to 10
+func WithRemoteMaxAttempts(n int) RemoteOption {
+	return func(c *remoteConfig) { c.maxAttempts = n }
+}
+
+// SpoolEntry is a pending delivery, as stored in a spool directory by SpoolPush
+type SpoolEntry struct {
+	URI         string          `json:"uri"`
+	Payload     json.RawMessage `json:"payload"`
+	Headers     http.Header     `json:"headers,omitempty"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	CreatedAt   time.Time       `json:"created_at"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// SpoolPush queues data, as JSON, in a file in dir for ProcessSpool to deliver to uri
+func (t *Tools) SpoolPush(dir, uri string, data interface{}, opts ...RemoteOption) error {
+	cfg := remoteConfig{headers: make(http.Header), maxAttempts: defaultSpoolMaxAttempts}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("could not encode JSON: %w", err)
+	}
+
+	now := time.Now()
+	entry := SpoolEntry{
+		URI:         uri,
+		Payload:     payload,
+		Headers:     cfg.headers,
+		MaxAttempts: cfg.maxAttempts,
+		NextAttempt: now,
+		CreatedAt:   now,
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create spool directory: %w", err)
+	}
+
+	// the name sorts by creation time, so that entries are delivered in order
+	name := strconv.FormatInt(now.UnixNano(), 10) + "-" + t.RandomString(8) + ".json"
+	return writeSpoolEntry(filepath.Join(dir, name), entry)
+}
+
+// writeSpoolEntry atomically replaces the file at path with entry
+func writeSpoolEntry(path string, entry SpoolEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("could not encode spool entry: %w", err)
+	}
+
+	if err := WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("could not write spool entry: %w", err)
+	}
+	return nil
+}
+
+// ProcessSpool delivers the entries of SpoolPush in dir every interval until ctx is done, retrying with backoff and
+// then moving them to the "dead" subdirectory. Entries are locked, so several processors may share dir
+func (t *Tools) ProcessSpool(ctx context.Context, dir string, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		t.processSpoolOnce(ctx, dir)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// processSpoolOnce makes one pass over dir, delivering every entry that is due
+func (t *Tools) processSpoolOnce(ctx context.Context, dir string) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			t.logger().Printf("spool: could not read %s: %s", dir, err)
+		}
+		return
+	}
+
+	var names []string
+	for _, f := range files {
+		switch {
+		case f.IsDir():
+		case strings.HasSuffix(f.Name(), ".json"):
+			names = append(names, f.Name())
+		case strings.HasSuffix(f.Name(), ".tmp"):
+			// left behind by a crash part way through writing; never renamed, so never an entry
+			if info, err := f.Info(); err == nil && time.Since(info.ModTime()) > spoolStaleTempAge {
+				os.Remove(filepath.Join(dir, f.Name()))
+			}
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
+		}
+		t.processSpoolEntry(ctx, dir, name)
+	}
+}
+
+// processSpoolEntry delivers the entry dir/name if it is due and no other processor has it locked
+func (t *Tools) processSpoolEntry(ctx context.Context, dir, name string) {
+	path := filepath.Join(dir, name)
+
+	f, err := os.Open(path)
+	if err != nil {
+		// delivered, or moved, by another processor since the directory was read
+		return
+	}
+	defer f.Close()
+
+	locked, err := tryLockFile(f)
+	if err != nil {
+		t.logger().Printf("spool: could not lock %s: %s", path, err)
+		return
+	}
+	if !locked {
+		return
+	}
+
+	// another processor may have finished with the entry between our open and lock, replacing or removing it
+	opened, err := f.Stat()
+	if err != nil {
+		return
+	}
+	if current, err := os.Stat(path); err != nil || !os.SameFile(opened, current) {
+		return
+	}
+
+	var entry SpoolEntry
+	if err := json.NewDecoder(f).Decode(&entry); err != nil {
+		t.logger().Printf("spool: %s is not a valid entry, moving it to %s: %s", path, spoolDeadLetterDir, err)
+		t.deadLetterSpoolEntry(dir, name)
+		return
+	}
+
+	if time.Now().Before(entry.NextAttempt) {
+		return
+	}
+
+	err = t.deliverSpoolEntry(ctx, entry)
+	if err == nil {
+		if err := os.Remove(path); err != nil {
+			t.logger().Printf("spool: delivered %s but could not remove it: %s", path, err)
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		// shutting down; the attempt doesn't count
+		return
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+	if entry.MaxAttempts > 0 && entry.Attempts >= entry.MaxAttempts {
+		t.logger().Printf("spool: giving up on %s after %d attempts: %s", path, entry.Attempts, err)
+		if err := writeSpoolEntry(path, entry); err == nil {
+			t.deadLetterSpoolEntry(dir, name)
+		}
+		return
+	}
+
+	entry.NextAttempt = time.Now().Add(spoolBackoff(entry.Attempts))
+	if err := writeSpoolEntry(path, entry); err != nil {
+		t.logger().Printf("spool: could not update %s: %s", path, err)
+	}
+}
+
+// deliverSpoolEntry posts entry to its URI, succeeding only on a 2xx response
+func (t *Tools) deliverSpoolEntry(ctx context.Context, entry SpoolEntry) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, entry.URI, bytes.NewReader(entry.Payload))
+	if err != nil {
+		return fmt.Errorf("could not create request: %w", err)
+	}
+	for key, values := range entry.Headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("could not push JSON to remote: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("remote responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deadLetterSpoolEntry moves dir/name into the dead-letter subdirectory
+func (t *Tools) deadLetterSpoolEntry(dir, name string) {
+	dead := filepath.Join(dir, spoolDeadLetterDir)
+	if err := os.MkdirAll(dead, 0755); err != nil {
+		t.logger().Printf("spool: could not create %s: %s", dead, err)
+		return
+	}
+	if err := os.Rename(filepath.Join(dir, name), filepath.Join(dead, name)); err != nil {
+		t.logger().Printf("spool: could not move %s to %s: %s", name, dead, err)
+	}
+}
+
+// spoolBackoff returns how long to wait before the next attempt, after attempts failures
+func spoolBackoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return spoolMaxBackoff
+	}
+	backoff := spoolBaseBackoff << (attempts - 1)
+	if backoff > spoolMaxBackoff {
+		return spoolMaxBackoff
+	}
+	return backoff
+}
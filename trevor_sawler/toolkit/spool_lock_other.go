@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package toolkit
+
+import "os"
+
+// tryLockFile is not supported on this platform, so only one ProcessSpool may run per spool directory
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd
+
+package toolkit
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// tryLockFile takes an exclusive lock on f without waiting, reporting false if another processor holds it. The
+// lock is released when f is closed
+func tryLockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return false, nil
+	}
+	return err == nil, err
+}
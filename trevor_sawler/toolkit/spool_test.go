@@ -0,0 +1,258 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// spoolFiles returns the names of the entries in dir
+func spoolFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+// readSpoolEntry reads the single entry in dir
+func readSpoolEntry(t *testing.T, dir string) (string, SpoolEntry) {
+	t.Helper()
+	files := spoolFiles(t, dir)
+	if len(files) != 1 {
+		t.Fatalf("expected one spool entry, got %d", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry SpoolEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatal(err)
+	}
+	return files[0], entry
+}
+
+func TestTools_SpoolPushAndDeliver(t *testing.T) {
+	var received []byte
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		auth = r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+	dir := filepath.Join(t.TempDir(), "spool")
+
+	err := testTools.SpoolPush(dir, srv.URL, map[string]string{"event": "created"}, WithRemoteHeader("Authorization", "Bearer x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, entry := readSpoolEntry(t, dir)
+	if entry.URI != srv.URL || string(entry.Payload) != `{"event":"created"}` || entry.Attempts != 0 || entry.MaxAttempts != defaultSpoolMaxAttempts {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(tmp) != 0 {
+		t.Errorf("temporary files left behind: %v", tmp)
+	}
+
+	testTools.processSpoolOnce(context.Background(), dir)
+
+	if string(received) != `{"event":"created"}` || auth != "Bearer x" {
+		t.Errorf("expected the payload and headers to be delivered, got %q %q", received, auth)
+	}
+	if files := spoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("expected the delivered entry to be removed, got %v", files)
+	}
+}
+
+func TestTools_SpoolRetryAndDeadLetter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+	dir := t.TempDir()
+
+	if err := testTools.SpoolPush(dir, srv.URL, "payload", WithRemoteMaxAttempts(2)); err != nil {
+		t.Fatal(err)
+	}
+
+	testTools.processSpoolOnce(context.Background(), dir)
+	path, entry := readSpoolEntry(t, dir)
+	if entry.Attempts != 1 || entry.LastError == "" || !entry.NextAttempt.After(time.Now()) {
+		t.Errorf("expected a failed attempt to be recorded with a backoff, got %+v", entry)
+	}
+
+	testTools.processSpoolOnce(context.Background(), dir)
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected an entry that is not due to be skipped, got %d calls", calls)
+	}
+
+	entry.NextAttempt = time.Now().Add(-time.Second)
+	if err := writeSpoolEntry(path, entry); err != nil {
+		t.Fatal(err)
+	}
+	testTools.processSpoolOnce(context.Background(), dir)
+
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("expected a second attempt, got %d calls", calls)
+	}
+	if files := spoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("expected the entry to leave the spool, got %v", files)
+	}
+	_, dead := readSpoolEntry(t, filepath.Join(dir, spoolDeadLetterDir))
+	if dead.Attempts != 2 {
+		t.Errorf("expected the dead letter to record 2 attempts, got %d", dead.Attempts)
+	}
+}
+
+func TestTools_SpoolCrashSafety(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer srv.Close()
+
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+	dir := t.TempDir()
+
+	fresh := filepath.Join(dir, ".spool-1.tmp")
+	stale := filepath.Join(dir, ".spool-2.tmp")
+	for _, name := range []string{fresh, stale} {
+		if err := os.WriteFile(name, []byte(`{"uri": "`+srv.URL+`", "payl`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * spoolStaleTempAge)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "1-corrupt.json"), []byte(`{"uri": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testTools.processSpoolOnce(context.Background(), dir)
+
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Errorf("partial entries must never be delivered, got %d calls", calls)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Errorf("a recent temporary file may still be being written and must be left alone: %v", err)
+	}
+	if _, err := os.Stat(stale); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a stale temporary file to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, spoolDeadLetterDir, "1-corrupt.json")); err != nil {
+		t.Errorf("expected a corrupt entry to be dead-lettered: %v", err)
+	}
+}
+
+func TestTools_SpoolConcurrentProcessors(t *testing.T) {
+	var mu sync.Mutex
+	delivered := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		delivered[string(body)]++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+	dir := t.TempDir()
+
+	const entries = 30
+	for i := 0; i < entries; i++ {
+		if err := testTools.SpoolPush(dir, srv.URL, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			testTools.processSpoolOnce(context.Background(), dir)
+		}()
+	}
+	wg.Wait()
+
+	if len(delivered) != entries {
+		t.Errorf("expected %d entries delivered, got %d", entries, len(delivered))
+	}
+	for body, n := range delivered {
+		if n != 1 {
+			t.Errorf("entry %s delivered %d times", body, n)
+		}
+	}
+	if files := spoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("expected the spool to be empty, got %d entries", len(files))
+	}
+}
+
+func TestTools_ProcessSpoolStops(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	testTools := Tools{Logger: log.New(io.Discard, "", 0)}
+	dir := t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- testTools.ProcessSpool(ctx, dir, 10*time.Millisecond)
+	}()
+
+	if err := testTools.SpoolPush(dir, srv.URL, "later"); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(spoolFiles(t, dir)) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if files := spoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("expected the loop to deliver an entry pushed while it runs, got %v", files)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ProcessSpool did not stop when its context was cancelled")
+	}
+}
+
+func TestSpoolBackoff(t *testing.T) {
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}
+	for i, d := range expected {
+		if got := spoolBackoff(i + 1); got != d {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, d, got)
+		}
+	}
+	if got := spoolBackoff(100); got != spoolMaxBackoff {
+		t.Errorf("expected the backoff to be capped at %s, got %s", spoolMaxBackoff, got)
+	}
+}
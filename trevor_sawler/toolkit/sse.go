@@ -0,0 +1,164 @@
+package toolkit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrStreamingUnsupported is returned by WriteEventStream when the ResponseWriter can't flush, so events would
+// sit in a buffer instead of reaching the client
+var ErrStreamingUnsupported = errors.New("response writer does not support streaming")
+
+// defaultSSEKeepAlive is how often WriteEventStream sends a comment when no event has been sent, so that proxies
+// don't close an idle connection
+const defaultSSEKeepAlive = 15 * time.Second
+
+// SSEEvent is a single Server-Sent Event. Data is sent as it is when it is a string or []byte, and marshaled to
+// JSON otherwise. ID, Event and Retry are optional
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  interface{}
+	Retry time.Duration
+}
+
+// SSEOption configures a single call to WriteEventStream
+type SSEOption func(*sseConfig)
+
+type sseConfig struct {
+	keepAlive time.Duration
+	resume    func(lastEventID string)
+}
+
+// WithSSEKeepAlive sets how often a keep-alive comment is sent while no events are. Defaults to 15 seconds; zero or
+// less turns keep-alives off
+func WithSSEKeepAlive(d time.Duration) SSEOption {
+	return func(c *sseConfig) { c.keepAlive = d }
+}
+
+// WithSSEResume calls fn, before any event is read, with the ID of the last event a reconnecting client received
+// (see LastEventID). fn is not called for a first connection. Use it to queue the events the client missed
+func WithSSEResume(fn func(lastEventID string)) SSEOption {
+	return func(c *sseConfig) { c.resume = fn }
+}
+
+// LastEventID returns the ID of the last event a reconnecting EventSource received, from the Last-Event-ID header,
+// or the lastEventId query parameter used by polyfills. It is empty on a first connection
+func LastEventID(r *http.Request) string {
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	return r.URL.Query().Get("lastEventId")
+}
+
+// WriteEventStream sends each event from events as a Server-Sent Event until events is closed, or returns the
+// context's error when the client disconnects
+func (t *Tools) WriteEventStream(w http.ResponseWriter, r *http.Request, events <-chan SSEEvent, opts ...SSEOption) error {
+	cfg := sseConfig{keepAlive: defaultSSEKeepAlive}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return ErrStreamingUnsupported
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if id := LastEventID(r); id != "" && cfg.resume != nil {
+		cfg.resume(id)
+	}
+
+	var keepAlive <-chan time.Time
+	if cfg.keepAlive > 0 {
+		ticker := time.NewTicker(cfg.keepAlive)
+		defer ticker.Stop()
+		keepAlive = ticker.C
+	}
+
+	bw := bufio.NewWriter(w)
+	send := func() error {
+		if err := bw.Flush(); err != nil {
+			return fmt.Errorf("could not write event to client: %w", err)
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return r.Context().Err()
+
+		case <-keepAlive:
+			bw.WriteString(": keep-alive\n\n")
+			if err := send(); err != nil {
+				return err
+			}
+
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := writeSSEEvent(bw, event); err != nil {
+				return err
+			}
+			if err := send(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// writeSSEEvent writes event in the text/event-stream format. Data containing newlines is split over several data
+// lines, which the client joins back together
+func writeSSEEvent(bw *bufio.Writer, event SSEEvent) error {
+	var data string
+	switch d := event.Data.(type) {
+	case string:
+		data = d
+	case []byte:
+		data = string(d)
+	default:
+		b, err := json.Marshal(d)
+		if err != nil {
+			return fmt.Errorf("could not marshal event data: %w", err)
+		}
+		data = string(b)
+	}
+
+	if strings.ContainsAny(event.ID, "\r\n\x00") || strings.ContainsAny(event.Event, "\r\n") {
+		return fmt.Errorf("event ID and name must not contain line breaks: %q %q", event.ID, event.Event)
+	}
+
+	if event.ID != "" {
+		bw.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		bw.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		bw.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		bw.WriteString("data: " + line + "\n")
+	}
+
+	_, err := bw.WriteString("\n")
+	return err
+}
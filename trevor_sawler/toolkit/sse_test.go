@@ -0,0 +1,216 @@
+package toolkit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseSSE reads a text/event-stream body back into events, as an EventSource would, counting comment lines
+func parseSSE(t *testing.T, body string) (events []SSEEvent, comments int) {
+	t.Helper()
+
+	var current SSEEvent
+	var data []string
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if data != nil {
+				current.Data = strings.Join(data, "\n")
+				events = append(events, current)
+			}
+			current, data = SSEEvent{}, nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			comments++
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ": ")
+		switch field {
+		case "id":
+			current.ID = value
+		case "event":
+			current.Event = value
+		case "retry":
+			ms, err := strconv.Atoi(value)
+			if err != nil {
+				t.Fatalf("bad retry line %q", line)
+			}
+			current.Retry = time.Duration(ms) * time.Millisecond
+		case "data":
+			data = append(data, value)
+		default:
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+
+	return events, comments
+}
+
+func TestTools_WriteEventStream(t *testing.T) {
+	var testTools Tools
+
+	events := make(chan SSEEvent, 4)
+	events <- SSEEvent{ID: "1", Event: "progress", Data: map[string]int{"percent": 50}, Retry: 3 * time.Second}
+	events <- SSEEvent{ID: "2", Data: "line one\nline two"}
+	events <- SSEEvent{Data: []byte("raw")}
+	events <- SSEEvent{Event: "done", Data: ""}
+	close(events)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	if err := testTools.WriteEventStream(rr, req, events); err != nil {
+		t.Fatal(err)
+	}
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("wrong content type: %s", ct)
+	}
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("wrong cache control: %s", cc)
+	}
+	if !rr.Flushed {
+		t.Error("expected the stream to be flushed")
+	}
+
+	got, _ := parseSSE(t, rr.Body.String())
+	expected := []SSEEvent{
+		{ID: "1", Event: "progress", Data: `{"percent":50}`, Retry: 3 * time.Second},
+		{ID: "2", Data: "line one\nline two"},
+		{Data: "raw"},
+		{Event: "done", Data: ""},
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %q", len(expected), len(got), rr.Body.String())
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestTools_WriteEventStreamKeepAlive(t *testing.T) {
+	var testTools Tools
+
+	events := make(chan SSEEvent)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		events <- SSEEvent{Data: "hello"}
+		close(events)
+	}()
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	if err := testTools.WriteEventStream(rr, req, events, WithSSEKeepAlive(10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, comments := parseSSE(t, rr.Body.String())
+	if comments == 0 {
+		t.Error("expected keep-alive comments while no events were sent")
+	}
+	if len(got) != 1 || got[0].Data != "hello" {
+		t.Errorf("unexpected events: %+v", got)
+	}
+}
+
+func TestTools_WriteEventStreamDisconnect(t *testing.T) {
+	var testTools Tools
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+
+	events := make(chan SSEEvent)
+	done := make(chan error)
+	go func() {
+		done <- testTools.WriteEventStream(httptest.NewRecorder(), req, events)
+	}()
+
+	events <- SSEEvent{Data: "first"}
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WriteEventStream did not return after the client disconnected")
+	}
+}
+
+func TestTools_WriteEventStreamResume(t *testing.T) {
+	var testTools Tools
+
+	var resumedFrom string
+	resume := WithSSEResume(func(id string) { resumedFrom = id })
+
+	events := make(chan SSEEvent)
+	close(events)
+
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	if err := testTools.WriteEventStream(httptest.NewRecorder(), req, events, resume); err != nil {
+		t.Fatal(err)
+	}
+	if resumedFrom != "" {
+		t.Errorf("resume should not be called on a first connection, got %q", resumedFrom)
+	}
+
+	req.Header.Set("Last-Event-ID", "42")
+	if err := testTools.WriteEventStream(httptest.NewRecorder(), req, events, resume); err != nil {
+		t.Fatal(err)
+	}
+	if resumedFrom != "42" {
+		t.Errorf("expected to resume from 42, got %q", resumedFrom)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/events?lastEventId=7", nil)
+	if id := LastEventID(req); id != "7" {
+		t.Errorf("expected the query parameter to be used, got %q", id)
+	}
+}
+
+// plainWriter hides every optional interface of the ResponseWriter it wraps, including http.Flusher
+type plainWriter struct {
+	http.ResponseWriter
+}
+
+func TestTools_WriteEventStreamErrors(t *testing.T) {
+	var testTools Tools
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+
+	rr := httptest.NewRecorder()
+	if err := testTools.WriteEventStream(plainWriter{rr}, req, nil); !errors.Is(err, ErrStreamingUnsupported) {
+		t.Errorf("expected ErrStreamingUnsupported, got %v", err)
+	}
+	if rr.Body.Len() > 0 {
+		t.Error("nothing should be written when streaming is unsupported")
+	}
+
+	var tests = []struct {
+		name  string
+		event SSEEvent
+	}{
+		{name: "newline in ID", event: SSEEvent{ID: "1\ndata: injected"}},
+		{name: "newline in event name", event: SSEEvent{Event: "a\rb"}},
+		{name: "unmarshalable data", event: SSEEvent{Data: make(chan int)}},
+	}
+
+	for _, e := range tests {
+		events := make(chan SSEEvent, 1)
+		events <- e.event
+		if err := testTools.WriteEventStream(httptest.NewRecorder(), req, events); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
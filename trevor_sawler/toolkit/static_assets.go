@@ -0,0 +1,184 @@
+package toolkit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// AssetHandler serves fingerprinted static files. It is created by StaticAssetHandler, or by StaticAssets, which
+// returns it as an http.Handler
+type AssetHandler struct {
+	tools  *Tools
+	dir    string
+	prefix string
+
+	mu     sync.RWMutex
+	hashes map[string]string
+}
+
+// StaticAssets returns a handler for the files in dir under urlPrefix, with immutable caching, and a helper that
+// maps a file name to its fingerprinted URL, e.g. "app.css" to "/static/app.<hash>.css"
+func (t *Tools) StaticAssets(dir string, urlPrefix string) (http.Handler, func(name string) string) {
+	h := t.StaticAssetHandler(dir, urlPrefix)
+	return h, h.URL
+}
+
+// StaticAssetHandler returns the handler StaticAssets does, as an *AssetHandler, whose URL method is the template
+// helper and whose Refresh method recomputes the content hashes
+func (t *Tools) StaticAssetHandler(dir string, urlPrefix string) *AssetHandler {
+	prefix := strings.Trim(urlPrefix, "/")
+	if prefix != "" {
+		prefix = "/" + prefix
+	}
+
+	h := &AssetHandler{
+		tools:  t,
+		dir:    dir,
+		prefix: prefix,
+	}
+	_ = h.Refresh()
+
+	return h
+}
+
+// Refresh recomputes the content hash of every file under the asset directory
+func (h *AssetHandler) Refresh() error {
+	hashes := make(map[string]string)
+
+	err := filepath.WalkDir(h.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(h.dir, p)
+		if err != nil {
+			return err
+		}
+
+		sum, err := hashAsset(p)
+		if err != nil {
+			return err
+		}
+		hashes[filepath.ToSlash(rel)] = sum
+		return nil
+	})
+
+	h.mu.Lock()
+	h.hashes = hashes
+	h.mu.Unlock()
+
+	return err
+}
+
+// URL returns the fingerprinted URL for the asset name, relative to the asset directory. A name that is not a
+// known file is returned under the prefix without a hash
+func (h *AssetHandler) URL(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	h.mu.RLock()
+	sum, ok := h.hashes[name]
+	h.mu.RUnlock()
+
+	if !ok {
+		return h.prefix + "/" + name
+	}
+
+	ext := path.Ext(name)
+	return h.prefix + "/" + strings.TrimSuffix(name, ext) + "." + sum + ext
+}
+
+// ServeHTTP implements http.Handler
+func (h *AssetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		_ = h.tools.LocalizedErrorJSON(w, r, newMessageError("request.method_not_allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	// path.Clean on a rooted path removes every "..", so the name can't climb out of the asset directory. The prefix
+	// must be a whole path segment, so that /staticfoo/app.css is not taken for foo/app.css under /static
+	name := path.Clean("/" + r.URL.Path)
+	if name != h.prefix && !strings.HasPrefix(name, h.prefix+"/") {
+		_ = h.tools.LocalizedErrorJSON(w, r, newMessageError("download.not_found"), http.StatusNotFound)
+		return
+	}
+	name = strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(name, h.prefix)), "/")
+
+	cacheControl := "no-cache"
+	if logical, sum, ok := splitAssetHash(name); ok {
+		h.mu.RLock()
+		current, known := h.hashes[logical]
+		h.mu.RUnlock()
+
+		if known {
+			name = logical
+			if current == sum {
+				cacheControl = "public, max-age=31536000, immutable"
+			}
+		}
+	}
+
+	f, err := http.Dir(h.dir).Open(name)
+	if err != nil {
+		_ = h.tools.LocalizedErrorJSON(w, r, newMessageError("download.not_found"), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		_ = h.tools.LocalizedErrorJSON(w, r, newMessageError("download.not_found"), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", cacheControl)
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// assetHashLength is the number of hex characters of the content hash used in asset URLs
+const assetHashLength = 16
+
+// splitAssetHash splits "css/app.<hash>.css" into "css/app.css" and the hash
+func splitAssetHash(name string) (string, string, bool) {
+	ext := path.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	i := strings.LastIndex(stem, ".")
+	if i < 0 || len(stem)-i-1 != assetHashLength {
+		return "", "", false
+	}
+
+	sum := stem[i+1:]
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", "", false
+	}
+
+	return stem[:i] + ext, sum, true
+}
+
+// hashAsset returns the truncated, hex encoded SHA-256 of the file at p
+func hashAsset(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:assetHashLength], nil
+}
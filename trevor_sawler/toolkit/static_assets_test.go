@@ -0,0 +1,106 @@
+package toolkit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestTools_StaticAssets(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "assets")
+
+	_ = os.MkdirAll(filepath.Join(dir, "js"), 0755)
+	_ = os.WriteFile(filepath.Join(dir, "app.css"), []byte("body { color: red; }"), 0644)
+	_ = os.WriteFile(filepath.Join(dir, "js", "app.min.js"), []byte("console.log('hi');"), 0644)
+	_ = os.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0644)
+
+	var testTools Tools
+	h := testTools.StaticAssetHandler(dir, "/static/")
+	assetURL := h.URL
+
+	cssURL := assetURL("app.css")
+	if !regexp.MustCompile(`^/static/app\.[0-9a-f]{16}\.css$`).MatchString(cssURL) {
+		t.Fatalf("unexpected asset url %s", cssURL)
+	}
+
+	jsURL := assetURL("js/app.min.js")
+	if !regexp.MustCompile(`^/static/js/app\.min\.[0-9a-f]{16}\.js$`).MatchString(jsURL) {
+		t.Fatalf("unexpected asset url %s", jsURL)
+	}
+
+	if u := assetURL("missing.css"); u != "/static/missing.css" {
+		t.Errorf("expected unknown asset to be returned without a hash, got %s", u)
+	}
+
+	var assetTests = []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedCache  string
+		expectedBody   string
+	}{
+		{name: "hashed css", url: cssURL, expectedStatus: http.StatusOK, expectedCache: "public, max-age=31536000, immutable", expectedBody: "body { color: red; }"},
+		{name: "hashed nested js", url: jsURL, expectedStatus: http.StatusOK, expectedCache: "public, max-age=31536000, immutable", expectedBody: "console.log('hi');"},
+		{name: "stale hash", url: "/static/app.0123456789abcdef.css", expectedStatus: http.StatusOK, expectedCache: "no-cache", expectedBody: "body { color: red; }"},
+		{name: "no hash", url: "/static/app.css", expectedStatus: http.StatusOK, expectedCache: "no-cache", expectedBody: "body { color: red; }"},
+		{name: "missing", url: "/static/nope.css", expectedStatus: http.StatusNotFound},
+		{name: "directory", url: "/static/js", expectedStatus: http.StatusNotFound},
+		{name: "traversal", url: "/static/../secret.txt", expectedStatus: http.StatusNotFound},
+		{name: "encoded traversal", url: "/static/%2e%2e/secret.txt", expectedStatus: http.StatusNotFound},
+		{name: "prefix not a segment", url: "/staticjs/app.min.js", expectedStatus: http.StatusNotFound},
+		{name: "deep traversal", url: "/static/js/../../secret.txt", expectedStatus: http.StatusNotFound},
+	}
+
+	for _, e := range assetTests {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", e.url, nil))
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedStatus != http.StatusOK {
+			if strings.Contains(rr.Body.String(), "secret") && !strings.Contains(rr.Body.String(), "not found") {
+				t.Errorf("%s: leaked file outside of asset directory", e.name)
+			}
+			continue
+		}
+		if rr.Header().Get("Cache-Control") != e.expectedCache {
+			t.Errorf("%s: expected Cache-Control %q but got %q", e.name, e.expectedCache, rr.Header().Get("Cache-Control"))
+		}
+		if rr.Body.String() != e.expectedBody {
+			t.Errorf("%s: wrong body returned: %s", e.name, rr.Body.String())
+		}
+	}
+
+	// StaticAssets serves the same files, with the same helper
+	handler, helper := testTools.StaticAssets(dir, "/static")
+	if u := helper("app.css"); u != cssURL {
+		t.Errorf("expected StaticAssets to map app.css to %s, got %s", cssURL, u)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", cssURL, nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "body { color: red; }" {
+		t.Errorf("expected StaticAssets to serve app.css, got %d %q", rr.Code, rr.Body.String())
+	}
+
+	// changing the file makes the old URL stale once hashes are refreshed
+	_ = os.WriteFile(filepath.Join(dir, "app.css"), []byte("body { color: blue; }"), 0644)
+	if err := h.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	if assetURL("app.css") == cssURL {
+		t.Error("expected asset url to change after refresh")
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", cssURL, nil))
+	if rr.Header().Get("Cache-Control") != "no-cache" {
+		t.Errorf("expected stale url to be served with no-cache, got %q", rr.Header().Get("Cache-Control"))
+	}
+}
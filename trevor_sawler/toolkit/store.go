@@ -0,0 +1,727 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore is where UploadFilesTo saves uploaded files, such as a directory on disk (DiskStore) or a bucket
+type FileStore interface {
+	// Save stores everything read from r under name, such as 2024/05/01/img.png, replacing any file of that name,
+	// and leaves nothing under name when it fails
+	Save(ctx context.Context, name string, r io.Reader) (int64, error)
+	// Exists reports whether a file called name is stored
+	Exists(ctx context.Context, name string) (bool, error)
+	// Remove deletes the file called name
+	Remove(ctx context.Context, name string) error
+}
+
+// ExclusiveFileStore is a FileStore that can check that a name is free and take it in one step
+type ExclusiveFileStore interface {
+	FileStore
+	// SaveNew stores everything read from r under the first free name given by next, until it returns "", and fails
+	// with an error matching fs.ErrExist when there is none
+	SaveNew(ctx context.Context, r io.Reader, next func() string) (string, int64, error)
+}
+
+// ErrUploadPathEscape is matched, via errors.Is, by the *UploadPathError returned when a file would be written
+// outside the directory of a DiskStore
+var ErrUploadPathEscape = errors.New("uploaded file would be written outside the upload directory")
+
+// UploadPathError is returned by the Save and SaveNew of a DiskStore, and so by UploadFiles, when the directory a
+// file named Name is to be written in resolves to Path, outside Dir, through a symlink. It also matches ErrUnsafePath
+type UploadPathError struct {
+	Name string
+	Dir  string
+	Path string
+}
+
+// Error implements the error interface
+func (e *UploadPathError) Error() string {
+	return englishMessage("upload.path_escape", e.Name)
+}
+
+// Is reports whether target is ErrUploadPathEscape or ErrUnsafePath
+func (e *UploadPathError) Is(target error) bool {
+	return target == ErrUploadPathEscape || target == ErrUnsafePath
+}
+
+func (e *UploadPathError) messageKey() (string, []interface{}) {
+	return "upload.path_escape", []interface{}{e.Name}
+}
+
+// DiskStore is the FileStore of UploadFiles, which keeps files in Dir. Each file is written to a hidden temporary
+// file and only renamed once complete. Zero FileMode and DirMode give 0666 and 0755, less the umask
+type DiskStore struct {
+	Dir      string
+	FileMode os.FileMode
+	DirMode  os.FileMode
+}
+
+// diskStore returns the DiskStore that UploadFiles and StreamUploadFiles save the files of uploadDir to
+func (t *Tools) diskStore(uploadDir string) *DiskStore {
+	return &DiskStore{Dir: uploadDir, FileMode: t.FileMode, DirMode: t.DirMode}
+}
+
+// Save implements FileStore
+func (s *DiskStore) Save(ctx context.Context, name string, r io.Reader) (int64, error) {
+	path, err := joinUploadPath(s.Dir, name)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, n, err := s.writeTemp(ctx, r)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.makeParent(name, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// SaveNew implements ExclusiveFileStore by linking each name to a complete temporary file with os.Link
+func (s *DiskStore) SaveNew(ctx context.Context, r io.Reader, next func() string) (string, int64, error) {
+	tmp, n, err := s.writeTemp(ctx, r)
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp)
+
+	for name := next(); name != ""; name = next() {
+		path, err := joinUploadPath(s.Dir, name)
+		if err == nil {
+			err = s.makeParent(name, path)
+		}
+		if err != nil {
+			return "", 0, err
+		}
+
+		err = os.Link(tmp, path)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		return name, n, nil
+	}
+
+	return "", 0, fs.ErrExist
+}
+
+// makeParent creates the subdirectory of Dir that the file at path goes in, checking it with checkInside before and
+// after
+func (s *DiskStore) makeParent(name, path string) error {
+	if err := s.checkInside(name, path); err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if dir == filepath.Clean(s.Dir) {
+		return nil
+	}
+	t := Tools{DirMode: s.DirMode}
+	if err := t.CreateDirIfNotExist(dir); err != nil {
+		return err
+	}
+	return s.checkInside(name, path)
+}
+
+// checkInside returns an *UploadPathError when the directory of path resolves outside Dir, as through a symlink
+func (s *DiskStore) checkInside(name, path string) error {
+	root, err := resolvedDir(s.Dir)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	for {
+		resolved, err := resolvedDir(dir)
+		if err == nil {
+			if rel, err := filepath.Rel(root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return &UploadPathError{Name: name, Dir: s.Dir, Path: resolved}
+			}
+			return nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if _, lerr := os.Lstat(dir); lerr == nil || filepath.Dir(dir) == dir {
+			// a symlink that points nowhere is not followed
+			return err
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// resolvedDir returns dir made absolute and clean, with every symlink in it resolved
+func resolvedDir(dir string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// writeTemp writes everything read from r to a new hidden temporary file, flushed to disk, and returns its path with
+// the number of bytes written. The temporary file is removed if anything fails
+func (s *DiskStore) writeTemp(ctx context.Context, r io.Reader) (string, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return "", 0, err
+	}
+
+	f, err := s.createTemp()
+	if errors.Is(err, fs.ErrNotExist) {
+		if err := makeDir(s.Dir, s.DirMode); err != nil {
+			return "", 0, err
+		}
+		f, err = s.createTemp()
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	fail := func(err error) (string, int64, error) {
+		f.Close()
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+
+	if s.FileMode != 0 {
+		if err := f.Chmod(s.FileMode); err != nil {
+			return fail(err)
+		}
+	}
+
+	// A file the multipart reader spooled to disk is copied by the kernel via (*os.File).ReadFrom; otherwise
+	// ReadFrom is hidden so that io.CopyBuffer uses a pooled buffer instead of allocating its own
+	var dst io.Writer = f
+	if _, onDisk := r.(*os.File); !onDisk {
+		dst = struct{ io.Writer }{f}
+	}
+	bufp := uploadBufferPool.Get().(*[]byte)
+	defer uploadBufferPool.Put(bufp)
+
+	n, err := io.CopyBuffer(dst, r, *bufp)
+	if err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		return fail(err)
+	}
+
+	return f.Name(), n, nil
+}
+
+// createTemp creates a hidden temporary file in the store's directory
+func (s *DiskStore) createTemp() (*os.File, error) {
+	var t Tools
+	return os.OpenFile(filepath.Join(s.Dir, ".tmp-"+t.RandomString(25)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+}
+
+// Exists implements FileStore
+func (s *DiskStore) Exists(ctx context.Context, name string) (bool, error) {
+	path, err := joinUploadPath(s.Dir, name)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Remove implements FileStore
+func (s *DiskStore) Remove(ctx context.Context, name string) error {
+	path, err := joinUploadPath(s.Dir, name)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// UploadFilesTo is like UploadFiles, but saves the files to store rather than to a directory
+func (t *Tools) UploadFilesTo(r *http.Request, store FileStore, rename ...bool) ([]*UploadedFile, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	uploadedFiles, err := t.uploadFilesTo(r.Context(), r, store, renameFile, false, nil)
+	if err != nil && !t.partialUpload(uploadedFiles, err) {
+		return uploadedFiles, err
+	}
+	return t.afterSaveAll(r.Context(), store, uploadedFiles, err)
+}
+
+// requestStore wraps the store of one request whose files are saved concurrently, and remembers the names they have
+// claimed and those given by content that are still being saved
+type requestStore struct {
+	FileStore
+	mu      sync.Mutex
+	claimed map[string]bool
+	saving  map[string]chan struct{}
+}
+
+// setStoredPath records that uploadedFile is stored in store as name, and where that is on disk when store is a
+// DiskStore
+func setStoredPath(store FileStore, uploadedFile *UploadedFile, name string) {
+	uploadedFile.NewFileName = path.Base(name)
+	uploadedFile.StoredPath = name
+
+	if rs, ok := store.(*requestStore); ok {
+		store = rs.FileStore
+	}
+	if ds, ok := store.(*DiskStore); ok {
+		saved := filepath.Join(ds.Dir, filepath.FromSlash(name))
+		if abs, err := filepath.Abs(saved); err == nil {
+			saved = abs
+		}
+		uploadedFile.SavedPath = saved
+	}
+}
+
+// claimName reports whether no file called name is in store, and when store is a requestStore, claims name for the
+// file about to be saved under it, so that no other file of the request finds it free
+func claimName(ctx context.Context, store FileStore, name string) (bool, error) {
+	rs, ok := store.(*requestStore)
+	if !ok {
+		exists, err := store.Exists(ctx, name)
+		return !exists, err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.claimed[name] {
+		return false, nil
+	}
+	exists, err := rs.FileStore.Exists(ctx, name)
+	if err != nil || exists {
+		return false, err
+	}
+	if rs.claimed == nil {
+		rs.claimed = make(map[string]bool)
+	}
+	rs.claimed[name] = true
+	return true, nil
+}
+
+// claimContentName is claimName for a name given by content, waiting first for a save of it still in progress.
+// done must be called once the file is saved, or has failed
+func claimContentName(ctx context.Context, store FileStore, name string) (free bool, done func(), err error) {
+	rs, ok := store.(*requestStore)
+	if !ok {
+		free, err := claimName(ctx, store, name)
+		return free, func() {}, err
+	}
+
+	for {
+		rs.mu.Lock()
+		saving, ok := rs.saving[name]
+		if !ok {
+			break
+		}
+		rs.mu.Unlock()
+		select {
+		case <-saving:
+		case <-ctx.Done():
+			return false, func() {}, ctx.Err()
+		}
+	}
+	defer rs.mu.Unlock()
+
+	exists, err := rs.FileStore.Exists(ctx, name)
+	if err != nil || exists {
+		return false, func() {}, err
+	}
+	if rs.saving == nil {
+		rs.saving = make(map[string]chan struct{})
+	}
+	saving := make(chan struct{})
+	rs.saving[name] = saving
+	return true, func() {
+		rs.mu.Lock()
+		delete(rs.saving, name)
+		rs.mu.Unlock()
+		close(saving)
+	}, nil
+}
+
+// saveNew saves r to store under the first free name given by next, with SaveNew when store is an
+// ExclusiveFileStore, and returns the name used
+func saveNew(ctx context.Context, store FileStore, r io.Reader, next func() string) (string, int64, error) {
+	inner := store
+	if rs, ok := store.(*requestStore); ok {
+		inner = rs.FileStore
+	}
+	if es, ok := inner.(ExclusiveFileStore); ok {
+		return es.SaveNew(ctx, r, next)
+	}
+
+	for name := next(); name != ""; name = next() {
+		free, err := claimName(ctx, store, name)
+		if err != nil {
+			return "", 0, err
+		}
+		if free {
+			n, err := store.Save(ctx, name, r)
+			return name, n, err
+		}
+	}
+	return "", 0, fs.ErrExist
+}
+
+// checkedReader reads an uploaded file for a FileStore, and fails when ctx is done or the file is over max or
+// under min bytes. It feeds hash and progress when they are set
+type checkedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	filename string
+	min, max int64
+	tooBig   error
+	hash     hash.Hash
+	progress *uploadProgress
+	n        int64
+}
+
+func (c *checkedReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.max > 0 && c.n > c.max {
+		return n, c.tooBig
+	}
+	if c.hash != nil {
+		c.hash.Write(p[:n])
+	}
+	c.progress.update(c.n)
+	if err == io.EOF && c.n < c.min {
+		return n, &FileTooSmallError{FileName: c.filename, Limit: c.min}
+	}
+	return n, err
+}
+
+// saveToStore checks and saves the uploaded file read from src to store, and fills in the rest of uploadedFile.
+// size is -1 when it isn't known. AfterSave is left to the caller
+func (t *Tools) saveToStore(ctx context.Context, store FileStore, src io.Reader, size int64, uploadedFile *UploadedFile, renameFile bool, minSize int64) error {
+	filename := uploadedFile.OriginalFileName
+	// How long the file takes to save is recorded however that ends
+	start := time.Now()
+	defer recordUploadTiming(uploadedFile, start)
+	progress := t.uploadProgress(filename, size)
+	maxSize, tooBig := t.fileSizeLimit(filename, uploadedFile.ContentType)
+	checked := func(r io.Reader, h hash.Hash, progress *uploadProgress) io.Reader {
+		if ctx.Done() == nil && maxSize == 0 && minSize == 0 && h == nil && progress == nil {
+			return r
+		}
+		return &checkedReader{ctx: ctx, r: r, filename: filename, min: minSize, max: maxSize, tooBig: tooBig, hash: h, progress: progress}
+	}
+
+	var hasher hash.Hash
+	var zr *zip.Reader
+	hashFirst := t.namesByHash() || t.SubdirStrategy == SubdirHashPrefix || t.Deduplicate
+	checksZip := t.checksZip(uploadedFile.ContentType)
+	if hashFirst || t.Scanner != nil || checksZip {
+		// The file is read through once before it is saved, to hash, scan and check it, so that a file that fails
+		// is never stored under its name
+		var h hash.Hash
+		if hashFirst || t.ComputeChecksum || t.WriteMetadata {
+			h = sha256.New()
+		}
+		scan := t.newUploadScan(ctx)
+		defer scan.stop()
+		check := checked(src, h, nil)
+		if scan != nil {
+			check = io.TeeReader(check, scan)
+		}
+		again, n, release, err := rereadable(src, check, t.TempDir)
+		if err != nil {
+			if scanErr := t.scanResult(filename, scan.stop()); scanErr != nil {
+				return scanErr
+			}
+			return uploadStoreError(ctx, filename, err)
+		}
+		defer release()
+		if err := t.scanResult(filename, scan.wait()); err != nil {
+			return err
+		}
+		if checksZip {
+			if zr, err = checkZip(filename, again, n, t.zipLimits()); err != nil {
+				return err
+			}
+		}
+		src = checked(again, nil, progress)
+		if h != nil {
+			uploadedFile.SHA256 = hex.EncodeToString(h.Sum(nil))
+		}
+		uploadedFile.FileSize = n
+	} else {
+		if t.ComputeChecksum || t.WriteMetadata {
+			hasher = sha256.New()
+		}
+		src = checked(src, hasher, progress)
+	}
+	subdir := t.uploadSubdir(uploadedFile.SHA256)
+
+	// An image to make thumbnails of is decoded as it is saved
+	var decoder *imageDecoder
+	if t.makesThumbnails(uploadedFile.ContentType) {
+		decoder = newImageDecoder()
+		defer decoder.close()
+	}
+
+	// A file with the same content that ExistsByHash knows of is used in place of the upload, as long as it is still
+	// in the store
+	if t.Deduplicate && t.ExistsByHash != nil {
+		if existing, ok := t.ExistsByHash(uploadedFile.SHA256); ok {
+			exists, err := store.Exists(ctx, existing)
+			if err != nil {
+				return fmt.Errorf("could not save uploaded file %q: %w", filename, err)
+			}
+			if exists {
+				setStoredPath(store, uploadedFile, existing)
+				uploadedFile.Deduplicated = true
+				if decoder != nil {
+					t.findThumbnails(ctx, store, uploadedFile)
+				}
+				progress.done(uploadedFile.FileSize)
+				return nil
+			}
+		}
+	}
+
+	// An image converted to NormalizeImageFormat is named with the extension of its new format
+	storedName := t.normalizedImageName(filename, uploadedFile.ContentType)
+	var name string
+	if t.namesByHash() {
+		name = subdir + uploadedFile.SHA256 + fileExt(storedName, t.compoundExtensions())
+
+		free, done, err := claimContentName(ctx, store, name)
+		if err != nil {
+			return fmt.Errorf("could not save uploaded file %q: %w", filename, err)
+		}
+		defer done()
+		if !free {
+			// a file of that name has the same content, so it is left as it is
+			setStoredPath(store, uploadedFile, name)
+			uploadedFile.Deduplicated = true
+			if decoder != nil {
+				t.findThumbnails(ctx, store, uploadedFile)
+			}
+			progress.done(uploadedFile.FileSize)
+			return nil
+		}
+	} else {
+		fileName, err := t.uploadFileName(storedName, renameFile)
+		if err == nil {
+			fileName, err = t.shortenFileName(filename, fileName)
+		}
+		if err != nil {
+			return err
+		}
+		// With WriteMetadata, a file isn't saved under a name that another file's metadata sidecar could have
+		if t.WriteMetadata && isMetadataSidecar(fileName) {
+			return ErrInvalidFileName
+		}
+		name = subdir + fileName
+		if (t.RenameFunc != nil || t.NamingStrategy == NamingSlug) && t.ExistsPolicy == ExistsOverwrite {
+			// A name from RenameFunc that is already taken, by another file of the same request say, is replaced
+			// with a random one, and a slug gets a random suffix, so that no file is overwritten
+			free, err := claimName(ctx, store, name)
+			if err != nil {
+				return fmt.Errorf("could not save uploaded file %q: %w", filename, err)
+			}
+			if !free {
+				if t.RenameFunc != nil {
+					name = subdir + t.randomFileName(storedName)
+				} else {
+					name = subdir + suffixedName(fileName, "-"+t.RandomString(6), t.compoundExtensions(), t.fileNameLimit())
+				}
+			}
+		}
+	}
+
+	// BeforeSave sees the file as it is to be saved, with the name it is saved under, unless ExistsAutoRename has to
+	// number it, and its size when that is known
+	setStoredPath(store, uploadedFile, name)
+	if size >= 0 {
+		uploadedFile.FileSize = size
+	}
+	if err := t.beforeSave(ctx, uploadedFile); err != nil {
+		return err
+	}
+
+	if decoder != nil {
+		src = io.TeeReader(src, decoder)
+	}
+
+	var n int64
+	var err error
+	if !t.namesByHash() && t.ExistsPolicy != ExistsOverwrite {
+		var saved string
+		saved, n, err = saveNew(ctx, store, src, candidateNames(name, t.ExistsPolicy, t.compoundExtensions(), t.fileNameLimit()))
+		if errors.Is(err, fs.ErrExist) && ctx.Err() == nil {
+			return &FileExistsError{FileName: path.Base(name)}
+		}
+		name = saved
+	} else {
+		n, err = store.Save(ctx, name, src)
+	}
+	if err != nil {
+		return uploadStoreError(ctx, filename, err)
+	}
+
+	setStoredPath(store, uploadedFile, name)
+	uploadedFile.FileSize = n
+	if hasher != nil {
+		uploadedFile.SHA256 = hex.EncodeToString(hasher.Sum(nil))
+	}
+	if t.ExtractArchives && zr != nil {
+		if err := t.extractZip(ctx, store, uploadedFile, zr, t.zipLimits().MaxUncompressedSize); err != nil {
+			store.Remove(context.Background(), name)
+			return err
+		}
+	}
+	if decoder != nil {
+		if err := t.saveThumbnails(ctx, store, uploadedFile, decoder); err != nil {
+			store.Remove(context.Background(), name)
+			return err
+		}
+	}
+	progress.done(n)
+	return nil
+}
+
+// recordUploadTiming sets the Duration of uploadedFile to the time since start, and its BytesPerSecond from that
+func recordUploadTiming(uploadedFile *UploadedFile, start time.Time) {
+	uploadedFile.Duration = time.Since(start)
+	if seconds := uploadedFile.Duration.Seconds(); seconds > 0 {
+		uploadedFile.BytesPerSecond = float64(uploadedFile.FileSize) / seconds
+	}
+}
+
+// progressInterval is how many bytes of a file are saved between two calls of OnProgress
+const progressInterval = 1 << 20
+
+// uploadProgress calls OnProgress for one file as it is saved. A nil *uploadProgress, for when OnProgress isn't set,
+// does nothing
+type uploadProgress struct {
+	onProgress func(filename string, bytesWritten, totalBytes int64)
+	filename   string
+	total      int64
+	reported   int64
+}
+
+// uploadProgress returns the uploadProgress for a file of size bytes, or -1 when that isn't known
+func (t *Tools) uploadProgress(filename string, size int64) *uploadProgress {
+	if t.OnProgress == nil {
+		return nil
+	}
+	return &uploadProgress{onProgress: t.OnProgress, filename: filename, total: size}
+}
+
+// update reports that n bytes have been saved, when at least progressInterval bytes were saved since the last report
+func (p *uploadProgress) update(n int64) {
+	if p != nil && n-p.reported >= progressInterval {
+		p.report(n)
+	}
+}
+
+// done reports that the file is complete at n bytes, unless that was the last report already
+func (p *uploadProgress) done(n int64) {
+	if p != nil && (n != p.reported || n == 0) {
+		p.report(n)
+	}
+}
+
+func (p *uploadProgress) report(n int64) {
+	p.reported = n
+	p.onProgress(p.filename, n, p.total)
+}
+
+// rereadReader is what rereadable returns: a reader of bytes read once already, which can also be read at any offset,
+// as an archive is checked
+type rereadReader interface {
+	io.Reader
+	io.ReaderAt
+}
+
+// rereadable reads all of r through check, and returns a reader of the same bytes from the start, r itself when it
+// can seek or else a copy in tempDir, which release removes
+func rereadable(r io.Reader, check io.Reader, tempDir string) (rereadReader, int64, func(), error) {
+	if seeker, ok := r.(interface {
+		rereadReader
+		io.Seeker
+	}); ok {
+		n, err := io.Copy(io.Discard, check)
+		if err == nil {
+			_, err = seeker.Seek(0, io.SeekStart)
+		}
+		return seeker, n, func() {}, err
+	}
+
+	tmp, err := os.CreateTemp(tempDir, "upload-*")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	release := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+
+	n, err := io.Copy(struct{ io.Writer }{tmp}, check)
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		release()
+		return nil, 0, nil, err
+	}
+	return tmp, n, release, nil
+}
+
+// uploadStoreError turns an error from reading an uploaded file to a store into the error returned for the upload
+func uploadStoreError(ctx context.Context, filename string, err error) error {
+	var tooSmall *FileTooSmallError
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case errors.Is(err, ErrFileTooBig), errors.As(err, &tooSmall), errors.Is(err, ErrInvalidFileName), errors.Is(err, ErrInvalidBase64),
+		errors.Is(err, ErrRequestTooLarge):
+		return err
+	case errors.As(err, &maxBytesError):
+		return ErrFileTooBig
+	default:
+		return fmt.Errorf("could not save uploaded file %q: %w", filename, err)
+	}
+}
@@ -0,0 +1,482 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingStore is a MemoryStore whose Save always fails with err
+type failingStore struct {
+	MemoryStore
+	err error
+}
+
+func (s *failingStore) Save(ctx context.Context, name string, r io.Reader) (int64, error) {
+	return 0, s.err
+}
+
+// unreachableStore is a MemoryStore whose Exists always fails with err
+type unreachableStore struct {
+	MemoryStore
+	err error
+}
+
+func (s *unreachableStore) Exists(ctx context.Context, name string) (bool, error) {
+	return false, s.err
+}
+
+func TestTools_UploadFilesTo(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	pic := readTestFile(t, "pic.jpg")
+
+	var tests = []struct {
+		name          string
+		tools         Tools
+		rename        bool
+		expectedNames []string
+	}{
+		{name: "renamed", rename: true},
+		{name: "original names", rename: false, expectedNames: []string{"img.png", "pic.jpg"}},
+		{name: "checksums", tools: Tools{ComputeChecksum: true}, rename: true},
+		{name: "content hash", tools: Tools{NamingStrategy: NamingContentHash}, rename: true},
+	}
+
+	for _, e := range tests {
+		var store MemoryStore
+		req := newUploadRequest(t,
+			testUpload{field: "file", filename: "img.png", content: img},
+			testUpload{field: "file", filename: "pic.jpg", content: pic},
+		)
+
+		uploadedFiles, err := e.tools.UploadFilesTo(req, &store, e.rename)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+
+		var names []string
+		for _, f := range uploadedFiles {
+			names = append(names, f.NewFileName)
+
+			expected := img
+			if f.OriginalFileName == "pic.jpg" {
+				expected = pic
+			}
+			if content, _ := store.File(f.NewFileName); !bytes.Equal(content, expected) {
+				t.Errorf("%s: %s was not stored under %q", e.name, f.OriginalFileName, f.NewFileName)
+			}
+			if f.FileSize != int64(len(expected)) {
+				t.Errorf("%s: expected size %d for %s, got %d", e.name, len(expected), f.OriginalFileName, f.FileSize)
+			}
+			if f.SavedPath != "" {
+				t.Errorf("%s: expected no saved path for a file in memory, got %q", e.name, f.SavedPath)
+			}
+			if (e.tools.ComputeChecksum || e.tools.NamingStrategy == NamingContentHash) && len(f.SHA256) != 64 {
+				t.Errorf("%s: expected a checksum for %s, got %q", e.name, f.OriginalFileName, f.SHA256)
+			}
+		}
+		sort.Strings(names)
+
+		if !reflect.DeepEqual(names, store.Names()) {
+			t.Errorf("%s: expected the returned names %v to be the stored names %v", e.name, names, store.Names())
+		}
+		if e.expectedNames != nil && !reflect.DeepEqual(names, e.expectedNames) {
+			t.Errorf("%s: expected names %v, got %v", e.name, e.expectedNames, names)
+		}
+	}
+}
+
+func TestTools_UploadFilesSavedPath(t *testing.T) {
+	img := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		name  string
+		tools Tools
+	}{
+		{name: "renamed on conflict", tools: Tools{ExistsPolicy: ExistsAutoRename}},
+		{name: "date subdirectories", tools: Tools{ExistsPolicy: ExistsAutoRename, SubdirStrategy: SubdirDate}},
+		{name: "concurrently", tools: Tools{ExistsPolicy: ExistsAutoRename, SubdirStrategy: SubdirHashPrefix, Concurrency: 2}},
+		{name: "deduplicated", tools: Tools{NamingStrategy: NamingContentHash}},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			req := newUploadRequest(t, testUpload{"file", "img.png", img}, testUpload{"file", "img.png", img})
+			files, err := upload(&e.tools, req, dir+"/./uploads/../uploads", false)
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+
+			for _, f := range files {
+				expected := filepath.Join(dir, "uploads", filepath.FromSlash(f.StoredPath))
+				if f.SavedPath != expected {
+					t.Errorf("%s, %s: expected the saved path %s, got %s", e.name, name, expected, f.SavedPath)
+				}
+				if content, err := os.ReadFile(f.SavedPath); err != nil || !bytes.Equal(content, img) {
+					t.Errorf("%s, %s: expected the file at %s, got %v", e.name, name, f.SavedPath, err)
+				}
+			}
+			if len(files) == 2 && files[0].SavedPath == files[1].SavedPath && !files[0].Deduplicated && !files[1].Deduplicated {
+				t.Errorf("%s, %s: expected the files to be saved apart, got %s twice", e.name, name, files[0].SavedPath)
+			}
+		}
+	}
+
+	// a relative upload directory gives an absolute path
+	var testTools Tools
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadDir, err := filepath.Rel(wd, t.TempDir())
+	if err != nil {
+		t.Skip(err)
+	}
+	uploadedFile, err := testTools.UploadBase64File("aGVsbG8=", "hello.txt", uploadDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filepath.IsAbs(uploadedFile.SavedPath) || filepath.Base(uploadedFile.SavedPath) != "hello.txt" {
+		t.Errorf("expected an absolute saved path, got %s", uploadedFile.SavedPath)
+	}
+}
+
+func TestTools_UploadFilesToRollback(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	testTools := Tools{AllowedFileTypes: []string{"image/png"}}
+
+	var store MemoryStore
+	req := newUploadRequest(t,
+		testUpload{field: "file", filename: "img.png", content: img},
+		testUpload{field: "file", filename: "notes.txt", content: []byte("not an image")},
+	)
+
+	_, err := testTools.UploadFilesTo(req, &store)
+	if !errors.Is(err, ErrFileTypeNotPermitted) {
+		t.Errorf("expected ErrFileTypeNotPermitted, got %v", err)
+	}
+	if names := store.Names(); len(names) != 0 {
+		t.Errorf("expected nothing to be left in the store, got %v", names)
+	}
+}
+
+func TestTools_UploadFilesToLimits(t *testing.T) {
+	var tests = []struct {
+		name     string
+		tools    Tools
+		content  []byte
+		expected error
+	}{
+		{name: "too big", tools: Tools{MaxFilePerSize: 4}, content: []byte("hello world"), expected: ErrFileTooBig},
+		{name: "too small", tools: Tools{MinFileSize: 20}, content: []byte("hello world"), expected: ErrFileTooSmall},
+		{name: "empty", content: []byte{}, expected: ErrFileTooSmall},
+	}
+
+	for _, e := range tests {
+		for method, upload := range map[string]func(*Tools, *MemoryStore, []byte) error{
+			"UploadFilesTo": func(tools *Tools, store *MemoryStore, content []byte) error {
+				_, err := tools.UploadFilesTo(newUploadRequest(t, testUpload{field: "file", filename: "a.txt", content: content}), store)
+				return err
+			},
+			"saveToStore": func(tools *Tools, store *MemoryStore, content []byte) error {
+				// the size of a streamed part is only known once it has been read
+				uploadedFile := UploadedFile{OriginalFileName: "a.txt"}
+				return tools.saveToStore(context.Background(), store, oneByteReader{bytes.NewReader(content)}, -1, &uploadedFile, true, tools.minFileSize())
+			},
+		} {
+			var store MemoryStore
+			err := upload(&e.tools, &store, e.content)
+			if !errors.Is(err, e.expected) {
+				t.Errorf("%s, %s: expected %v, got %v", e.name, method, e.expected, err)
+			}
+			if names := store.Names(); len(names) != 0 {
+				t.Errorf("%s, %s: expected nothing to be stored, got %v", e.name, method, names)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesToSaveError(t *testing.T) {
+	var testTools Tools
+	saveErr := errors.New("bucket unavailable")
+	store := failingStore{err: saveErr}
+
+	req := newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: readTestFile(t, "img.png")})
+	_, err := testTools.UploadFilesTo(req, &store)
+	if !errors.Is(err, saveErr) {
+		t.Errorf("expected the store's error to be wrapped, got %v", err)
+	}
+	if err != nil && !strings.Contains(err.Error(), "img.png") {
+		t.Errorf("expected the error to name the file, got %q", err)
+	}
+}
+
+func TestTools_UploadFilesToExistsError(t *testing.T) {
+	existsErr := errors.New("bucket unavailable")
+
+	// a name from RenameFunc, or a slug, that can't be checked is never saved without being claimed
+	for _, testTools := range []Tools{{RenameFunc: func(string) string { return "avatar.png" }}, {NamingStrategy: NamingSlug}} {
+		store := unreachableStore{err: existsErr}
+		req := newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: readTestFile(t, "img.png")})
+		files, err := testTools.UploadFilesTo(req, &store)
+		if !errors.Is(err, existsErr) || files != nil {
+			t.Errorf("expected the store's error to be wrapped, got %v and %v", files, err)
+		}
+		if names := store.Names(); len(names) != 0 {
+			t.Errorf("expected nothing to be saved, got %v", names)
+		}
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	ctx := context.Background()
+	store := DiskStore{Dir: t.TempDir() + "/created"}
+
+	n, err := store.Save(ctx, "a.txt", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5 {
+		t.Errorf("expected 5 bytes to be saved, got %d", n)
+	}
+	if exists, err := store.Exists(ctx, "a.txt"); err != nil || !exists {
+		t.Errorf("expected a.txt to exist, got %t, %v", exists, err)
+	}
+
+	// a failed save leaves neither the file nor its temporary file behind
+	failing := &failingReader{r: strings.NewReader("hello world"), after: 5, check: func() {}}
+	if _, err := store.Save(ctx, "b.txt", failing); err == nil {
+		t.Error("expected the failing reader's error, got none")
+	}
+	if names := remainingFiles(t, store.Dir); !reflect.DeepEqual(names, []string{"a.txt"}) {
+		t.Errorf("expected only a.txt to be left, got %v", names)
+	}
+
+	for _, name := range []string{"../escape.txt", "sub/../b.txt", "/abs.txt", "sub//b.txt", `sub\b.txt`, ""} {
+		if _, err := store.Save(ctx, name, strings.NewReader("x")); !errors.Is(err, ErrInvalidFileName) {
+			t.Errorf("%q: expected ErrInvalidFileName, got %v", name, err)
+		}
+	}
+
+	// a name with directories is saved in them, and they are created as needed
+	if _, err := store.Save(ctx, "2024/05/01/c.txt", strings.NewReader("dated")); err != nil {
+		t.Fatal(err)
+	}
+	if names := remainingFiles(t, store.Dir); !reflect.DeepEqual(names, []string{"2024/05/01/c.txt", "a.txt"}) {
+		t.Errorf("expected c.txt to be saved in 2024/05/01, got %v", names)
+	}
+
+	if err := store.Remove(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := store.Exists(ctx, "a.txt"); err != nil || exists {
+		t.Errorf("expected a.txt to be gone, got %t, %v", exists, err)
+	}
+	if err := store.Remove(ctx, "a.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected removing a missing file to fail with os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestDiskStore_SaveNew(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("taken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := &DiskStore{Dir: dir, FileMode: 0600}
+	name, n, err := store.SaveNew(ctx, strings.NewReader("hello"), candidateNames("a.txt", ExistsAutoRename, nil, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "a-1.txt" || n != 5 {
+		t.Errorf("expected 5 bytes saved as a-1.txt, got %d as %s", n, name)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(data) != "taken" {
+		t.Errorf("expected the file under the taken name to be left as it was, got %q", data)
+	}
+	info, err := os.Stat(filepath.Join(dir, "a-1.txt"))
+	if err != nil || info.Mode().Perm() != 0600 || info.Size() != 5 {
+		t.Errorf("expected a-1.txt to be complete with mode 0600, got %v, %v", info, err)
+	}
+
+	// with every name taken, nothing is saved and the temporary file is removed
+	if _, _, err := store.SaveNew(ctx, strings.NewReader("hello"), candidateNames("a.txt", ExistsError, nil, 0)); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected fs.ErrExist, got %v", err)
+	}
+	if names := remainingFiles(t, dir); !reflect.DeepEqual(names, []string{"a-1.txt", "a.txt"}) {
+		t.Errorf("expected only a.txt and a-1.txt to be left, got %v", names)
+	}
+}
+
+func TestDiskStoreSymlinks(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "2024")); err != nil {
+		t.Skipf("symlinks are not supported: %s", err)
+	}
+	if err := os.Mkdir(filepath.Join(root, "inside"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "inside"), filepath.Join(root, "alias")); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		file     string
+		expected bool
+	}{
+		{name: "file in the directory", file: "a.txt", expected: true},
+		{name: "file in a subdirectory", file: "2023/01/a.txt", expected: true},
+		{name: "symlink to a subdirectory", file: "alias/a.txt", expected: true},
+		{name: "symlinked subdirectory", file: "2024/a.txt"},
+		{name: "directory under a symlinked subdirectory", file: "2024/05/01/a.txt"},
+	}
+
+	for _, e := range tests {
+		for _, policy := range []ExistsPolicy{ExistsOverwrite, ExistsAutoRename} {
+			testTools := Tools{ExistsPolicy: policy}
+			store := testTools.diskStore(root)
+			var err error
+			if policy == ExistsOverwrite {
+				_, err = store.Save(ctx, e.file, strings.NewReader("hello"))
+			} else {
+				_, _, err = store.SaveNew(ctx, strings.NewReader("hello"), candidateNames(e.file, policy, nil, 0))
+			}
+			if e.expected {
+				if err != nil {
+					t.Errorf("%s, %d: unexpected error: %s", e.name, policy, err)
+				}
+				continue
+			}
+
+			var pathErr *UploadPathError
+			if !errors.As(err, &pathErr) || !errors.Is(err, ErrUploadPathEscape) || pathErr.Name != e.file {
+				t.Errorf("%s, %d: expected an UploadPathError for %s, got %v", e.name, policy, e.file, err)
+			}
+		}
+	}
+
+	// nothing was written, nor any directory made, through the symlink
+	if names := remainingFiles(t, outside); names != nil {
+		t.Errorf("expected nothing to be written outside the directory, got %v", names)
+	}
+	if entries, err := os.ReadDir(outside); err != nil || len(entries) != 0 {
+		t.Errorf("expected nothing to be created outside the directory, got %v, %v", entries, err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(root, ".tmp-*"))
+	if len(matches) != 0 {
+		t.Errorf("expected no temporary files to be left, got %v", matches)
+	}
+
+	// an upload directory that is itself a symlink is followed, but a subdirectory it makes is not
+	link := filepath.Join(t.TempDir(), "uploads")
+	if err := os.Symlink(root, link); err != nil {
+		t.Fatal(err)
+	}
+	png := readTestFile(t, "img.png")
+	testTools := Tools{}
+	if _, err := testTools.UploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), link, WithRename(false)); err != nil {
+		t.Errorf("expected an upload to a symlinked directory to be saved, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "img.png")); err != nil {
+		t.Errorf("expected the file to be saved in the linked directory: %s", err)
+	}
+
+	year := time.Now().UTC().Format("2006")
+	if err := os.Symlink(outside, filepath.Join(root, year)); err != nil && !os.IsExist(err) {
+		t.Fatal(err)
+	}
+	testTools = Tools{SubdirStrategy: SubdirDate}
+	for name, upload := range uploadFuncs {
+		_, err := upload(&testTools, newUploadRequest(t, testUpload{"file", "img.png", png}), link, true)
+		if !errors.Is(err, ErrUploadPathEscape) {
+			t.Errorf("%s: expected ErrUploadPathEscape for a symlinked date directory, got %v", name, err)
+		}
+	}
+	if entries, err := os.ReadDir(outside); err != nil || len(entries) != 0 {
+		t.Errorf("expected nothing to be created outside the directory, got %v, %v", entries, err)
+	}
+}
+
+func TestTools_OnProgress(t *testing.T) {
+	content := bytes.Repeat([]byte("progress "), 5<<20/9)
+	size := int64(len(content))
+
+	for _, strategy := range []NamingStrategy{NamingRename, NamingContentHash} {
+		for name, upload := range uploadFuncs {
+			type call struct {
+				filename            string
+				bytesWritten, total int64
+			}
+			var calls []call
+			testTools := Tools{NamingStrategy: strategy, OnProgress: func(filename string, bytesWritten, totalBytes int64) {
+				calls = append(calls, call{filename, bytesWritten, totalBytes})
+			}}
+
+			req := newUploadRequest(t, testUpload{field: "file", filename: "big.txt", content: content})
+			if _, err := upload(&testTools, req, t.TempDir()); err != nil {
+				t.Errorf("%s, strategy %d: unexpected error: %s", name, strategy, err)
+				continue
+			}
+
+			expectedTotal := size
+			if name == "StreamUploadFiles" {
+				expectedTotal = -1
+			}
+			if len(calls) < 5 {
+				t.Errorf("%s, strategy %d: expected about one call a megabyte, got %d", name, strategy, len(calls))
+			}
+			var last int64
+			for _, c := range calls {
+				if c.filename != "big.txt" || c.total != expectedTotal {
+					t.Errorf("%s, strategy %d: expected big.txt of %d bytes, got %s of %d", name, strategy, expectedTotal, c.filename, c.total)
+				}
+				if c.bytesWritten <= last {
+					t.Errorf("%s, strategy %d: expected bytesWritten to grow, got %d after %d", name, strategy, c.bytesWritten, last)
+				}
+				last = c.bytesWritten
+			}
+			if last != size {
+				t.Errorf("%s, strategy %d: expected the last call to report all %d bytes, got %d", name, strategy, size, last)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesTiming(t *testing.T) {
+	content := bytes.Repeat([]byte("timing "), 2<<20/7)
+
+	var testTools Tools
+	for name, upload := range uploadFuncs {
+		req := newUploadRequest(t, testUpload{field: "file", filename: "big.txt", content: content})
+		files, err := upload(&testTools, req, t.TempDir())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+
+		f := files[0]
+		if f.Duration <= 0 || f.BytesPerSecond <= 0 {
+			t.Errorf("%s: expected the duration and throughput to be recorded, got %s and %f", name, f.Duration, f.BytesPerSecond)
+			continue
+		}
+		if expected := float64(f.FileSize) / f.Duration.Seconds(); f.BytesPerSecond != expected {
+			t.Errorf("%s: expected %f bytes a second, got %f", name, expected, f.BytesPerSecond)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// StreamUploadFiles is like UploadFiles, but copies each file straight into uploadDir as the body arrives, so none of
+// it is held in memory or spooled first. Limits are checked as the files are read
+func (t *Tools) StreamUploadFiles(r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+	ctx := r.Context()
+
+	maxFileSize := defaultMaxFileSize
+	if t.MaxFileSize != 0 {
+		maxFileSize = t.MaxFileSize
+	}
+
+	if err := t.CreateDirIfNotExist(uploadDir); err != nil {
+		return nil, fmt.Errorf("could not create upload directory: %w", err)
+	}
+	if err := t.checkDirQuota(uploadDir, false); err != nil {
+		return nil, err
+	}
+	if err := t.checkFreeSpace(r.ContentLength, uploadDir); err != nil {
+		return nil, err
+	}
+
+	store := t.diskStore(uploadDir)
+	r.Body = http.MaxBytesReader(nil, r.Body, int64(maxFileSize))
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, &messageError{key: "upload.malformed_form", err: err}
+	}
+
+	var uploadedFiles []*UploadedFile
+	var failures MultiError
+	// With MaxTotalUploadSize, the files are counted against it as they are read
+	totalLeft := t.MaxTotalUploadSize
+	// With WriteMetadata, the text fields of the form are kept for the metadata sidecars
+	var formValues map[string][]string
+	formValuesLeft := int64(maxMetadataFormValues)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return t.undoUpload(store, uploadedFiles, err)
+		}
+
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return t.undoUpload(store, uploadedFiles, streamUploadError(err))
+		}
+		filename := partFileName(part)
+		if filename == "" {
+			if t.WriteMetadata {
+				if formValues == nil {
+					formValues = make(map[string][]string)
+				}
+				formValuesLeft, err = readFormValue(part, formValues, formValuesLeft)
+				if err != nil {
+					part.Close()
+					return t.undoUpload(store, uploadedFiles, streamUploadError(err))
+				}
+			}
+			part.Close()
+			continue
+		}
+		if !formFieldAllowed(part.FormName(), t.AllowedFormFields) {
+			part.Close()
+			if t.StrictFormFields {
+				return t.undoUpload(store, uploadedFiles, &FormFieldError{Field: part.FormName()})
+			}
+			continue
+		}
+
+		if t.MaxFiles > 0 && len(uploadedFiles)+len(failures) >= t.MaxFiles {
+			part.Close()
+			return t.undoUpload(store, uploadedFiles, &TooManyFilesError{Limit: t.MaxFiles})
+		}
+
+		var src io.Reader = part
+		if t.MaxTotalUploadSize > 0 {
+			src = &totalSizeReader{r: part, left: &totalLeft, limit: t.MaxTotalUploadSize}
+		}
+		uploadedFile, err := t.streamUploadedFile(ctx, filename, src, store, renameFile, streamUploadError)
+		part.Close()
+		if err != nil && t.ContinueOnError && !errors.Is(err, ErrRequestTooLarge) {
+			// the rest of the part has been skipped, so the next one can be read; when the body itself failed,
+			// reading it fails the whole upload
+			failures = append(failures, &UploadFileError{FileName: filename, Err: err})
+			continue
+		}
+		if err != nil {
+			return t.undoUpload(store, uploadedFiles, err)
+		}
+		uploadedFile.FieldName = part.FormName()
+		uploadedFiles = append(uploadedFiles, uploadedFile)
+	}
+
+	if len(uploadedFiles) == 0 {
+		if len(failures) > 0 {
+			return nil, failures
+		}
+		return nil, ErrNoFiles
+	}
+
+	if err := t.writeMetadata(ctx, store, uploadedFiles, formValues); err != nil {
+		return t.undoUpload(store, uploadedFiles, err)
+	}
+	if err := t.checkDirQuota(uploadDir, true); err != nil {
+		return t.undoUpload(store, uploadedFiles, err)
+	}
+
+	if len(failures) > 0 {
+		return t.afterSaveAll(ctx, store, uploadedFiles, failures)
+	}
+	return t.afterSaveAll(ctx, store, uploadedFiles, nil)
+}
+
+// streamUploadedFile checks the type of the file filename, read from r, and saves it to store. readError turns an
+// error reading r into the error returned
+func (t *Tools) streamUploadedFile(ctx context.Context, filename string, r io.Reader, store FileStore, renameFile bool, readError func(error) error) (*UploadedFile, error) {
+	if err := t.checkFileExtension(filename); err != nil {
+		return nil, err
+	}
+
+	// Read the first sniffLength bytes of the file to determine its type. A part may arrive in pieces, so ReadFull is
+	// used rather than a single Read
+	sniff := make([]byte, t.sniffLength())
+	n, err := io.ReadFull(r, sniff)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		// the whole file fitted in the sniff buffer, so a file that is too small is refused before it is saved
+		if minSize := t.minFileSize(); int64(n) < minSize {
+			return nil, &FileTooSmallError{FileName: filename, Limit: minSize}
+		}
+	} else if err != nil {
+		return nil, readError(err)
+	}
+
+	fileType := t.detectContentType(sniff[:n], filename)
+	if t.svgRefused(fileType) || !fileTypeAllowed(fileType, t.AllowedFileTypes) || fileTypeDenied(fileType, t.DeniedFileTypes) {
+		return nil, &FileTypeError{FileName: filename, ContentType: fileType}
+	}
+	if err := t.checkExtensionMatches(filename, fileType); err != nil {
+		return nil, err
+	}
+	if limit, tooBig := t.fileSizeLimit(filename, fileType); limit > 0 && int64(n) > limit {
+		return nil, tooBig
+	}
+
+	uploadedFile := UploadedFile{
+		OriginalFileName: filename,
+		ContentType:      fileType,
+	}
+
+	// The sniffed bytes are saved ahead of the rest of the part. Its size isn't known until it has all been read,
+	// so MinFileSize is checked as it is saved. An image that is too large is refused from its header first
+	src, err := t.checkStreamedImageSize(filename, fileType, io.MultiReader(bytes.NewReader(sniff[:n]), r))
+	if err != nil {
+		if errors.Is(err, ErrInvalidImage) || errors.Is(err, ErrImageTooLarge) {
+			return nil, err
+		}
+		return nil, readError(err)
+	}
+	if t.sanitizesSVG(fileType) {
+		clean, err := t.sanitizeUploadedSVG(filename, fileType, src)
+		if err != nil {
+			if errors.Is(err, ErrInvalidImage) || errors.Is(err, ErrFileTooBig) {
+				return nil, err
+			}
+			return nil, readError(err)
+		}
+		src = clean
+	}
+	if t.normalizesImage(fileType) {
+		converted, err := t.normalizeUploadedImage(filename, fileType, src)
+		if err != nil {
+			if errors.Is(err, ErrInvalidImage) || errors.Is(err, ErrAnimatedImage) || errors.Is(err, ErrFileTooBig) {
+				return nil, err
+			}
+			return nil, readError(err)
+		}
+		src = converted
+		uploadedFile.ContentType = normalizedImageTypes[t.NormalizeImageFormat]
+	}
+	if err := t.saveToStore(ctx, store, src, -1, &uploadedFile, renameFile, t.minFileSize()); err != nil {
+		return nil, err
+	}
+
+	return &uploadedFile, nil
+}
+
+// uploadStreamToDir saves the file filename, read from r, to uploadDir, as StreamUploadFiles saves a part
+func (t *Tools) uploadStreamToDir(ctx context.Context, uploadDir string, size int64, filename string, r io.Reader, renameFile bool, readError func(error) error) (*UploadedFile, error) {
+	if err := t.CreateDirIfNotExist(uploadDir); err != nil {
+		return nil, fmt.Errorf("could not create upload directory: %w", err)
+	}
+	if err := t.checkDirQuota(uploadDir, false); err != nil {
+		return nil, err
+	}
+	if err := t.checkFreeSpace(size, uploadDir); err != nil {
+		return nil, err
+	}
+
+	store := t.diskStore(uploadDir)
+	uploadedFile, err := t.streamUploadedFile(ctx, filename, r, store, renameFile, readError)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.writeMetadata(ctx, store, []*UploadedFile{uploadedFile}, nil); err != nil {
+		_, err = t.undoUpload(store, []*UploadedFile{uploadedFile}, err)
+		return nil, err
+	}
+
+	if err := t.checkDirQuota(uploadDir, true); err != nil {
+		_, err = t.undoUpload(store, []*UploadedFile{uploadedFile}, err)
+		return nil, err
+	}
+	if _, err := t.afterSaveAll(ctx, store, []*UploadedFile{uploadedFile}, nil); err != nil {
+		return nil, err
+	}
+	return uploadedFile, nil
+}
+
+// totalSizeReader reads one of the files of a request from r, and fails with a *RequestTooLargeError as soon as the
+// files read so far come to more than limit bytes. left is what is left of limit, shared by the files of the request
+type totalSizeReader struct {
+	r     io.Reader
+	left  *int64
+	limit int64
+}
+
+func (t *totalSizeReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	*t.left -= int64(n)
+	if *t.left < 0 {
+		return n, &RequestTooLargeError{Limit: t.limit}
+	}
+	return n, err
+}
+
+// maxSizeReader reads a file from r, and fails with ErrFileTooBig as soon as more than max bytes have been read, so
+// that a file of unknown size is refused before it has all been read
+type maxSizeReader struct {
+	r   io.Reader
+	max int64
+	n   int64
+}
+
+func (m *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	m.n += int64(n)
+	if m.n > m.max {
+		return n, ErrFileTooBig
+	}
+	return n, err
+}
+
+// streamUploadError reports a request body that went over MaxFileSize as ErrFileTooBig, and any other failure to
+// read it, other than going over MaxTotalUploadSize, as a malformed form
+func streamUploadError(err error) error {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		return ErrFileTooBig
+	}
+	if errors.Is(err, ErrRequestTooLarge) {
+		return err
+	}
+	return &messageError{key: "upload.malformed_form", err: err}
+}
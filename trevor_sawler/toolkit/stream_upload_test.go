@@ -0,0 +1,184 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// oneByteReader returns at most one byte per Read, as a slow client would
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	if len(p) > 1 {
+		p = p[:1]
+	}
+	return o.r.Read(p)
+}
+
+func TestTools_StreamUploadFiles(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		uploads  []testUpload
+		rename   bool
+		expected error
+	}{
+		{name: "one file", uploads: []testUpload{{"file", "img.png", png}}, rename: true},
+		{name: "keep names", uploads: []testUpload{{"file", "img.png", png}, {"other", "pic.jpg", jpg}}},
+		{name: "type not permitted", tools: Tools{AllowedFileTypes: []string{"image/png"}}, uploads: []testUpload{{"file", "img.png", png}, {"file", "pic.jpg", jpg}}, expected: ErrFileTypeNotPermitted},
+		{name: "extension not permitted", tools: Tools{AllowedFileExtensions: []string{"jpg"}}, uploads: []testUpload{{"file", "img.png", png}}, expected: ErrFileExtensionNotPermitted},
+		{name: "file too big", tools: Tools{MaxFilePerSize: int64(len(png) - 1)}, uploads: []testUpload{{"file", "img.png", png}}, expected: ErrFileTooBig},
+		{name: "request too big", tools: Tools{MaxFileSize: len(png) / 2}, uploads: []testUpload{{"file", "img.png", png}}, expected: ErrFileTooBig},
+		{name: "too many files", tools: Tools{MaxFiles: 1}, uploads: []testUpload{{"file", "img.png", png}, {"file", "pic.jpg", jpg}}, expected: ErrTooManyFiles},
+	}
+
+	for _, e := range tests {
+		dir := t.TempDir()
+		files, err := e.tools.StreamUploadFiles(newUploadRequest(t, e.uploads...), dir, e.rename)
+
+		if e.expected != nil {
+			if !errors.Is(err, e.expected) {
+				t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%s: expected a failed upload to leave nothing behind, got %d files", e.name, len(entries))
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+			continue
+		}
+
+		// the result matches what UploadFiles returns for the same request
+		formFiles, err := e.tools.UploadFiles(newUploadRequest(t, e.uploads...), t.TempDir(), WithRename(e.rename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != len(formFiles) {
+			t.Fatalf("%s: expected %d files, got %d", e.name, len(formFiles), len(files))
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].OriginalFileName < files[j].OriginalFileName })
+		sort.Slice(formFiles, func(i, j int) bool { return formFiles[i].OriginalFileName < formFiles[j].OriginalFileName })
+
+		for i, f := range files {
+			want := formFiles[i]
+			if f.OriginalFileName != want.OriginalFileName || f.FileSize != want.FileSize || f.ContentType != want.ContentType {
+				t.Errorf("%s: expected %+v, got %+v", e.name, want, f)
+			}
+			if e.rename == (f.NewFileName == f.OriginalFileName) || filepath.Ext(f.NewFileName) != filepath.Ext(f.OriginalFileName) {
+				t.Errorf("%s: unexpected new name %q for %q", e.name, f.NewFileName, f.OriginalFileName)
+			}
+
+			saved, err := os.ReadFile(filepath.Join(dir, f.NewFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, u := range e.uploads {
+				if u.filename == f.OriginalFileName && !bytes.Equal(saved, u.content) {
+					t.Errorf("%s: saved contents of %s do not match the upload", e.name, u.filename)
+				}
+			}
+		}
+	}
+}
+
+func TestTools_StreamUploadFilesFieldsAndSlowClients(t *testing.T) {
+	png := readTestFile(t, "img.png")
+
+	body, contentType := newUploadBody(t, testUpload{"title", "", []byte("holiday")}, testUpload{"file", "img.png", png}, testUpload{"tags", "", []byte("beach")})
+	req := httptest.NewRequest("POST", "/", oneByteReader{bytes.NewReader(body.Bytes())})
+	req.Header.Set("Content-Type", contentType)
+
+	var testTools Tools
+	dir := t.TempDir()
+	files, err := testTools.StreamUploadFiles(req, dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].FileSize != int64(len(png)) {
+		t.Fatalf("expected only the file to be saved, got %+v", files)
+	}
+	if saved, _ := os.ReadFile(filepath.Join(dir, "img.png")); !bytes.Equal(saved, png) {
+		t.Error("expected the file to arrive intact")
+	}
+
+	if _, err := testTools.StreamUploadFiles(httptest.NewRequest("POST", "/", nil), dir); err == nil {
+		t.Error("expected an error for a request that is not multipart")
+	}
+}
+
+// failingReader reads from r until after bytes have been read, then calls check and fails, as a dropped connection
+// would
+type failingReader struct {
+	r     io.Reader
+	after int
+	check func()
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.after <= 0 {
+		f.check()
+		return 0, errors.New("connection reset")
+	}
+	if len(p) > f.after {
+		p = p[:f.after]
+	}
+	n, err := f.r.Read(p)
+	f.after -= n
+	return n, err
+}
+
+func TestTools_UploadFilesAtomic(t *testing.T) {
+	big := make([]byte, 4*uploadBufferSize)
+	copy(big, readTestFile(t, "img.png"))
+
+	body, contentType := newUploadBody(t, testUpload{"file", "big.png", big})
+
+	var testTools Tools
+	dir := t.TempDir()
+	checked := false
+	req := httptest.NewRequest("POST", "/", &failingReader{r: bytes.NewReader(body.Bytes()), after: body.Len() / 2, check: func() {
+		// part way through the copy the file is only there under a temporary name
+		checked = true
+		names := remainingFiles(t, dir)
+		if len(names) != 1 || !strings.HasPrefix(names[0], ".tmp-") {
+			t.Errorf("expected only a temporary file while the upload is copied, got %v", names)
+		}
+	}})
+	req.Header.Set("Content-Type", contentType)
+
+	if _, err := testTools.StreamUploadFiles(req, dir, false); err == nil {
+		t.Error("expected the failed copy to be reported")
+	}
+	if !checked {
+		t.Fatal("expected the copy to fail part way through")
+	}
+	if names := remainingFiles(t, dir); len(names) != 0 {
+		t.Errorf("expected no file to be left after a failed copy, got %v", names)
+	}
+
+	// a complete upload leaves no temporary file behind
+	for name, upload := range uploadFuncs {
+		files, err := upload(&testTools, newUploadRequest(t, testUpload{"file", "big.png", big}), dir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := remainingFiles(t, dir); len(names) != 1 || names[0] != files[0].NewFileName {
+			t.Errorf("%s: expected only %s to be saved, got %v", name, files[0].NewFileName, names)
+		}
+	}
+}
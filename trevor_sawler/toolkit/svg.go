@@ -0,0 +1,260 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// SVGPolicy decides what UploadFiles and StreamUploadFiles do with SVG images, which can carry scripts that run when
+// the image is served back and opened in a browser
+type SVGPolicy int
+
+const (
+	// SVGAllow, the zero value, treats SVG images like any other file. http.DetectContentType finds them to be XML,
+	// text or HTML, and they are checked against AllowedFileTypes as that
+	SVGAllow SVGPolicy = iota
+	// SVGReject refuses SVG images with a *FileTypeError, whatever AllowedFileTypes says
+	SVGReject
+	// SVGSanitize saves SVG images with their scripts, event handler attributes and external references removed.
+	// An SVG image that isn't well-formed XML is refused with an *InvalidImageError
+	SVGSanitize
+)
+
+// errMalformedSVG is the error in the *InvalidImageError for an SVG image without exactly one root element, or
+// with an element that isn't closed
+var errMalformedSVG = errors.New("malformed SVG document")
+
+// svgContentType is the ContentType of an SVG image when SVGPolicy is SVGReject or SVGSanitize
+const svgContentType = "image/svg+xml"
+
+// svgRemovedElements are the elements removed, with everything inside them, by SVGSanitize. foreignObject can hold
+// HTML, style sheets can import others, and the rest run scripts or load other documents
+var svgRemovedElements = map[string]bool{
+	"script":        true,
+	"style":         true,
+	"foreignobject": true,
+	"handler":       true,
+	"listener":      true,
+	"iframe":        true,
+	"embed":         true,
+	"object":        true,
+}
+
+// uploadContentType returns the type of an uploaded file, svgContentType for an SVG image under SVGReject and
+// SVGSanitize
+func (t *Tools) uploadContentType(fileType string, sniff []byte) string {
+	if t.SVGPolicy != SVGAllow && looksLikeSVG(fileType, sniff) {
+		return svgContentType
+	}
+	return fileType
+}
+
+// svgRefused reports whether a file of type fileType, as uploadContentType found it, is refused by SVGReject
+func (t *Tools) svgRefused(fileType string) bool {
+	return t.SVGPolicy == SVGReject && fileType == svgContentType
+}
+
+// sanitizesSVG reports whether a file of type fileType, as uploadContentType found it, is sanitized by SVGSanitize
+func (t *Tools) sanitizesSVG(fileType string) bool {
+	return t.SVGPolicy == SVGSanitize && fileType == svgContentType
+}
+
+// sanitizeUploadedSVG reads the SVG image filename from r and returns it sanitized
+func (t *Tools) sanitizeUploadedSVG(filename, fileType string, r io.Reader) (*bytes.Buffer, error) {
+	limit, tooBig := t.fileSizeLimit(filename, fileType)
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, tooBig
+	}
+
+	var clean bytes.Buffer
+	if err := sanitizeSVG(&clean, bytes.NewReader(data)); err != nil {
+		return nil, &InvalidImageError{FileName: filename, Err: err}
+	}
+	return &clean, nil
+}
+
+// looksLikeSVG reports whether a file whose first bytes are sniff may be an SVG image
+func looksLikeSVG(fileType string, sniff []byte) bool {
+	mediaType := fileType
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = strings.TrimSpace(mediaType[:i])
+	}
+	switch mediaType {
+	case "text/xml", "text/plain", "text/html":
+	default:
+		return false
+	}
+
+	b := bytes.TrimPrefix(sniff, []byte("\xef\xbb\xbf"))
+	for {
+		b = bytes.TrimLeft(b, " \t\r\n")
+		var end []byte
+		switch {
+		case len(b) == 0:
+			// the prolog went on past the sniffed bytes, or there was nothing but it
+			return len(sniff) == sniffLen
+		case bytes.HasPrefix(b, []byte("<?")):
+			end = []byte("?>")
+		case bytes.HasPrefix(b, []byte("<!--")):
+			end = []byte("-->")
+		case bytes.HasPrefix(b, []byte("<!")):
+			// a DOCTYPE may hold declarations in brackets, which have their own closing brackets
+			i := declarationEnd(b)
+			if i < 0 {
+				return len(sniff) == sniffLen
+			}
+			b = b[i+1:]
+			continue
+		default:
+			if len(b) < 5 {
+				return len(sniff) == sniffLen && bytes.HasPrefix([]byte("<svg"), bytes.ToLower(b))
+			}
+			return bytes.EqualFold(b[:4], []byte("<svg")) && strings.IndexByte(" \t\r\n>/", b[4]) >= 0
+		}
+
+		i := bytes.Index(b, end)
+		if i < 0 {
+			return len(sniff) == sniffLen
+		}
+		b = b[i+len(end):]
+	}
+}
+
+// declarationEnd returns the index of the > closing the declaration at the start of b, outside any brackets, or -1
+// when b ends first
+func declarationEnd(b []byte) int {
+	depth := 0
+	for i, c := range b {
+		switch {
+		case c == '[':
+			depth++
+		case c == ']' && depth > 0:
+			depth--
+		case c == '>' && depth == 0:
+			return i
+		}
+	}
+	return -1
+}
+
+// sanitizeSVG copies the SVG image from r to w without its scripts, event handlers, foreignObjects, external
+// references, comments and DOCTYPE. Reading anything but well-formed XML fails
+func sanitizeSVG(w io.Writer, r io.Reader) error {
+	dec := xml.NewDecoder(r)
+	enc := xml.NewEncoder(w)
+
+	// depth counts the elements open, and skip those open inside a removed element
+	depth, skip, roots := 0, 0, 0
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if depth == 0 {
+				roots++
+			}
+			depth++
+			if skip > 0 || svgRemovedElements[strings.ToLower(tok.Name.Local)] || !safeSVGAnimation(tok.Attr) {
+				skip++
+				continue
+			}
+			tok.Name = flatName(tok.Name)
+			attrs := tok.Attr[:0]
+			for _, attr := range tok.Attr {
+				if safeSVGAttr(attr) {
+					attr.Name = flatName(attr.Name)
+					attrs = append(attrs, attr)
+				}
+			}
+			tok.Attr = attrs
+			if err := enc.EncodeToken(tok); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			depth--
+			if skip > 0 {
+				skip--
+				continue
+			}
+			tok.Name = flatName(tok.Name)
+			if err := enc.EncodeToken(tok); err != nil {
+				return err
+			}
+		case xml.CharData:
+			if skip > 0 {
+				continue
+			}
+			if err := enc.EncodeToken(tok); err != nil {
+				return err
+			}
+		case xml.ProcInst:
+			if tok.Target == "xml" {
+				if err := enc.EncodeToken(tok); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// RawToken doesn't check that there is one root element, and that it is closed. The encoder has already checked
+	// that each end tag matches its start tag
+	if roots != 1 || depth != 0 {
+		return errMalformedSVG
+	}
+	return enc.Flush()
+}
+
+// flatName returns name with its namespace prefix, which RawToken leaves in Space, as part of Local, so that the
+// encoder writes it as it was read rather than declaring a namespace of its own
+func flatName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+// safeSVGAttr reports whether SVGSanitize keeps attr: event handlers are removed, as are links to anything but a
+// fragment of the image itself, and styles that load from a URL
+func safeSVGAttr(attr xml.Attr) bool {
+	local := strings.ToLower(attr.Name.Local)
+	value := strings.ToLower(strings.TrimSpace(attr.Value))
+	switch {
+	case strings.HasPrefix(local, "on"):
+		return false
+	case local == "href" || local == "src":
+		return strings.HasPrefix(value, "#")
+	case strings.Contains(value, "javascript:"):
+		return false
+	case strings.Contains(value, "url("):
+		return strings.Count(value, "url(") == strings.Count(value, "url(#")
+	}
+	return true
+}
+
+// safeSVGAnimation reports whether an element with attrs, when it is an animation, animates neither an event
+// handler nor a link; SVGSanitize removes one that does
+func safeSVGAnimation(attrs []xml.Attr) bool {
+	for _, attr := range attrs {
+		if strings.EqualFold(attr.Name.Local, "attributeName") {
+			value := strings.ToLower(strings.TrimSpace(attr.Value))
+			return !strings.HasPrefix(value, "on") && !strings.HasSuffix(value, "href")
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTools_UploadFilesSVGPolicy(t *testing.T) {
+	hostile := readTestFile(t, "hostile.svg")
+	malformed := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><rect width="1"></svg>`)
+
+	var tests = []struct {
+		name        string
+		tools       Tools
+		filename    string
+		content     []byte
+		expected    error
+		contentType string
+	}{
+		{name: "allow", filename: "avatar.svg", content: hostile, contentType: "text/xml; charset=utf-8"},
+		{name: "reject", tools: Tools{SVGPolicy: SVGReject}, filename: "avatar.svg", content: hostile, expected: ErrFileTypeNotPermitted},
+		{name: "reject whatever is allowed", tools: Tools{SVGPolicy: SVGReject, AllowedFileTypes: []string{"*/*"}}, filename: "avatar.svg", content: hostile, expected: ErrFileTypeNotPermitted},
+		{name: "reject leaves text", tools: Tools{SVGPolicy: SVGReject}, filename: "notes.txt", content: []byte("just some text"), contentType: "text/plain; charset=utf-8"},
+		{name: "reject leaves png", tools: Tools{SVGPolicy: SVGReject}, filename: "img.png", content: readTestFile(t, "img.png"), contentType: "image/png"},
+		{name: "sanitize", tools: Tools{SVGPolicy: SVGSanitize}, filename: "avatar.svg", content: hostile, contentType: svgContentType},
+		{name: "sanitize allowed as svg", tools: Tools{SVGPolicy: SVGSanitize, AllowedFileTypes: []string{"image/svg+xml"}}, filename: "avatar.svg", content: hostile, contentType: svgContentType},
+		{name: "sanitize malformed", tools: Tools{SVGPolicy: SVGSanitize}, filename: "avatar.svg", content: malformed, expected: ErrInvalidImage},
+		{name: "sanitize too big", tools: Tools{SVGPolicy: SVGSanitize, MaxFilePerSize: 600}, filename: "avatar.svg", content: hostile, expected: ErrFileTooBig},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			req := newUploadRequest(t, testUpload{field: "file", filename: e.filename, content: e.content})
+			uploadedFiles, err := upload(&e.tools, req, dir)
+
+			if e.expected != nil {
+				if !errors.Is(err, e.expected) {
+					t.Errorf("%s, %s: expected %v, got %v", e.name, name, e.expected, err)
+				}
+				if names := remainingFiles(t, dir); names != nil {
+					t.Errorf("%s, %s: expected nothing to be saved, got %v", e.name, name, names)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+
+			f := uploadedFiles[0]
+			if f.ContentType != e.contentType {
+				t.Errorf("%s, %s: expected content type %q, got %q", e.name, name, e.contentType, f.ContentType)
+			}
+			saved, err := os.ReadFile(filepath.Join(dir, f.StoredPath))
+			if err != nil {
+				t.Errorf("%s, %s: %s", e.name, name, err)
+				continue
+			}
+			if f.FileSize != int64(len(saved)) {
+				t.Errorf("%s, %s: expected FileSize %d, got %d", e.name, name, len(saved), f.FileSize)
+			}
+			if e.tools.SVGPolicy != SVGSanitize && !bytes.Equal(saved, e.content) {
+				t.Errorf("%s, %s: expected the file to be saved as it was", e.name, name)
+			}
+			if e.tools.SVGPolicy == SVGSanitize {
+				checkInertSVG(t, e.name+", "+name, saved)
+			}
+		}
+	}
+}
+
+// checkInertSVG checks that the sanitized hostile.svg kept its drawing and lost everything that could run or load
+func checkInertSVG(t *testing.T, name string, svg []byte) {
+	t.Helper()
+
+	// the result must still be well-formed XML
+	dec := xml.NewDecoder(bytes.NewReader(svg))
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Errorf("%s: expected well-formed XML, got %s", name, err)
+			return
+		}
+	}
+
+	content := strings.ToLower(string(svg))
+	for _, bad := range []string{"script", "alert", "onload", "onclick", "javascript", "evil.example", "foreignobject", "iframe", "@import", "entity", "stylesheet"} {
+		if strings.Contains(content, bad) {
+			t.Errorf("%s: expected %q to be removed, got %s", name, bad, svg)
+		}
+	}
+	for _, good := range []string{`<?xml version="1.0" encoding="UTF-8"?>`, `xmlns:xlink="http://www.w3.org/1999/xlink"`, `<rect width="64" height="64" fill="url(#fade)">`, `<use xlink:href="#fade">`, `<text x="4" y="60">click</text>`, `stop-color="#fff"`} {
+		if !strings.Contains(string(svg), good) {
+			t.Errorf("%s: expected %s to be kept, got %s", name, good, svg)
+		}
+	}
+}
+
+func TestLooksLikeSVG(t *testing.T) {
+	var tests = []struct {
+		name     string
+		fileType string
+		content  string
+		expected bool
+	}{
+		{name: "bare", fileType: "text/plain; charset=utf-8", content: `<svg width="1"/>`, expected: true},
+		{name: "xml declaration", fileType: "text/xml; charset=utf-8", content: "<?xml version=\"1.0\"?>\n<svg>", expected: true},
+		{name: "comment", fileType: "text/html; charset=utf-8", content: "<!-- hi -->\n<SVG>", expected: true},
+		{name: "doctype", fileType: "text/xml; charset=utf-8", content: "<?xml version=\"1.0\"?><!DOCTYPE svg><svg>", expected: true},
+		{name: "byte order mark", fileType: "text/plain; charset=utf-8", content: "\xef\xbb\xbf<svg>", expected: true},
+		{name: "long comment", fileType: "text/html; charset=utf-8", content: "<!--" + strings.Repeat("x", sniffLen), expected: true},
+		{name: "other root", fileType: "text/xml; charset=utf-8", content: "<?xml version=\"1.0\"?><note>", expected: false},
+		{name: "similar name", fileType: "text/plain; charset=utf-8", content: "<svgx>", expected: false},
+		{name: "text", fileType: "text/plain; charset=utf-8", content: "just some text", expected: false},
+		{name: "binary", fileType: "application/octet-stream", content: "<svg>", expected: false},
+	}
+
+	for _, e := range tests {
+		sniff := []byte(e.content)
+		if len(sniff) > sniffLen {
+			sniff = sniff[:sniffLen]
+		}
+		if got := looksLikeSVG(e.fileType, sniff); got != e.expected {
+			t.Errorf("%s: expected %t, got %t", e.name, e.expected, got)
+		}
+	}
+}
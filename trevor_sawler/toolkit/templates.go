@@ -0,0 +1,207 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"sync"
+)
+
+// ErrTemplateNotFound is returned (wrapped) by RenderTemplate when the named template does not exist
+var ErrTemplateNotFound = errors.New("template not found")
+
+// TemplateError is returned by RenderTemplate when a template exists but could not be parsed or executed
+type TemplateError struct {
+	Name string
+	Err  error
+}
+
+// Error implements the error interface
+func (e *TemplateError) Error() string {
+	return fmt.Sprintf("could not render template %s: %s", e.Name, e.Err)
+}
+
+// Unwrap returns the underlying parse or execution error
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// TemplateSet loads, and caches, the templates rendered by RenderTemplate. It is created by NewTemplateSet and is
+// safe for concurrent use
+type TemplateSet struct {
+	fsys     fs.FS
+	layout   string
+	partials []string
+	funcs    template.FuncMap
+	devMode  bool
+
+	mu    sync.RWMutex
+	cache map[string]*template.Template
+}
+
+// TemplateSetOption configures a TemplateSet
+type TemplateSetOption func(*TemplateSet)
+
+// WithTemplateLayout sets the base layout every page is rendered inside. The layout is executed in place of the
+// page, so it should include the page's content with something like {{template "content" .}}
+func WithTemplateLayout(name string) TemplateSetOption {
+	return func(s *TemplateSet) { s.layout = name }
+}
+
+// WithTemplatePartials adds glob patterns, such as "partials/*.html", matching templates parsed alongside every page
+func WithTemplatePartials(patterns ...string) TemplateSetOption {
+	return func(s *TemplateSet) { s.partials = append(s.partials, patterns...) }
+}
+
+// WithTemplateFuncs sets functions available to every template
+func WithTemplateFuncs(funcs template.FuncMap) TemplateSetOption {
+	return func(s *TemplateSet) { s.funcs = funcs }
+}
+
+// WithTemplateDevMode makes the set parse templates again on every render, so that edits show up without a
+// restart. It should not be used in production
+func WithTemplateDevMode(dev bool) TemplateSetOption {
+	return func(s *TemplateSet) { s.devMode = dev }
+}
+
+// NewTemplateSet returns a TemplateSet reading templates from fsys, such as os.DirFS or an embed.FS. Each page is
+// parsed the first time it is rendered
+func NewTemplateSet(fsys fs.FS, opts ...TemplateSetOption) *TemplateSet {
+	s := &TemplateSet{
+		fsys:  fsys,
+		cache: make(map[string]*template.Template),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// lookup returns the parsed template for the page name
+func (s *TemplateSet) lookup(name string) (*template.Template, error) {
+	if !s.devMode {
+		s.mu.RLock()
+		tmpl, ok := s.cache[name]
+		s.mu.RUnlock()
+		if ok {
+			return tmpl, nil
+		}
+	}
+
+	if !fs.ValidPath(name) {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+	if info, err := fs.Stat(s.fsys, name); err != nil || info.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+	}
+
+	files := []string{}
+	if s.layout != "" {
+		files = append(files, s.layout)
+	}
+	for _, pattern := range s.partials {
+		matches, err := fs.Glob(s.fsys, pattern)
+		if err != nil {
+			return nil, &TemplateError{Name: name, Err: err}
+		}
+		files = append(files, matches...)
+	}
+	files = append(files, name)
+
+	tmpl, err := template.New(path.Base(name)).Funcs(s.funcs).ParseFS(s.fsys, files...)
+	if err != nil {
+		return nil, &TemplateError{Name: name, Err: err}
+	}
+
+	if !s.devMode {
+		s.mu.Lock()
+		s.cache[name] = tmpl
+		s.mu.Unlock()
+	}
+
+	return tmpl, nil
+}
+
+// TemplateData is the value templates are executed with. The data passed to RenderTemplate is available as .Data
+type TemplateData struct {
+	Data      interface{}
+	RequestID string
+	CSRFToken string
+}
+
+// RenderOption configures a single call to RenderTemplate
+type RenderOption func(*renderConfig)
+
+type renderConfig struct {
+	status int
+	data   TemplateData
+}
+
+// WithRenderStatus sets the response status. Defaults to 200
+func WithRenderStatus(status int) RenderOption {
+	return func(c *renderConfig) { c.status = status }
+}
+
+// WithRenderRequest makes the request ID set by the RequestID middleware available to the template as .RequestID
+func WithRenderRequest(r *http.Request) RenderOption {
+	return func(c *renderConfig) { c.data.RequestID = RequestIDFromContext(r.Context()) }
+}
+
+// WithRenderCSRFToken makes token available to the template as .CSRFToken
+func WithRenderCSRFToken(token string) RenderOption {
+	return func(c *renderConfig) { c.data.CSRFToken = token }
+}
+
+// renderBufferPool holds the buffers pages are executed into before being sent
+var renderBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// maxPooledRenderBuffer is the largest buffer returned to renderBufferPool, so that one huge page doesn't pin
+// its memory for ever
+const maxPooledRenderBuffer = 1 << 20
+
+// RenderTemplate renders the page name from Templates as HTML, writing nothing when it fails. A missing page is
+// reported as ErrTemplateNotFound
+func (t *Tools) RenderTemplate(w http.ResponseWriter, name string, data interface{}, opts ...RenderOption) error {
+	if t.Templates == nil {
+		return errors.New("no templates configured")
+	}
+
+	cfg := renderConfig{status: http.StatusOK}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.data.Data = data
+
+	tmpl, err := t.Templates.lookup(name)
+	if err != nil {
+		return err
+	}
+
+	buf := renderBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer func() {
+		if buf.Cap() <= maxPooledRenderBuffer {
+			renderBufferPool.Put(buf)
+		}
+	}()
+
+	entry := path.Base(name)
+	if t.Templates.layout != "" {
+		entry = path.Base(t.Templates.layout)
+	}
+
+	if err := tmpl.ExecuteTemplate(buf, entry, cfg.data); err != nil {
+		return &TemplateError{Name: name, Err: err}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(cfg.status)
+	_, err = buf.WriteTo(w)
+	return err
+}
@@ -0,0 +1,151 @@
+package toolkit
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"flag"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+//go:embed testdata/templates
+var templateFixtures embed.FS
+
+// fixtureTemplateSet returns a TemplateSet over the embedded fixtures with the layout and partials configured
+func fixtureTemplateSet(t *testing.T, opts ...TemplateSetOption) *TemplateSet {
+	t.Helper()
+
+	fsys, err := fs.Sub(templateFixtures, "testdata/templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts = append([]TemplateSetOption{
+		WithTemplateLayout("layouts/base.html"),
+		WithTemplatePartials("partials/*.html"),
+		WithTemplateFuncs(template.FuncMap{"shout": strings.ToUpper}),
+	}, opts...)
+
+	return NewTemplateSet(fsys, opts...)
+}
+
+var homeData = map[string]interface{}{
+	"Title": "Hello <world>",
+	"Items": []string{"one", "two & three"},
+}
+
+func TestTools_RenderTemplateGolden(t *testing.T) {
+	testTools := Tools{Templates: fixtureTemplateSet(t)}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), requestIDKey, "req-123"))
+
+	rr := httptest.NewRecorder()
+	err := testTools.RenderTemplate(rr, "pages/home.html", homeData, WithRenderRequest(req), WithRenderCSRFToken("tok\"en"), WithRenderStatus(http.StatusCreated))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected status 201, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("wrong content type: %s", ct)
+	}
+
+	golden := filepath.Join("testdata", "templates", "golden", "home.html.golden")
+	if *updateGolden {
+		if err := os.WriteFile(golden, rr.Body.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr.Body.String() != string(expected) {
+		t.Errorf("output does not match %s\n--- got ---\n%s\n--- expected ---\n%s", golden, rr.Body.String(), expected)
+	}
+}
+
+func TestTools_RenderTemplateErrors(t *testing.T) {
+	testTools := Tools{Templates: fixtureTemplateSet(t)}
+
+	var tests = []struct {
+		name     string
+		page     string
+		notFound bool
+	}{
+		{name: "missing", page: "pages/missing.html", notFound: true},
+		{name: "directory", page: "pages", notFound: true},
+		{name: "escaping root", page: "../tools.go", notFound: true},
+		{name: "execution error", page: "pages/broken.html"},
+		{name: "parse error", page: "pages/unparsable.html"},
+	}
+
+	for _, e := range tests {
+		rr := httptest.NewRecorder()
+		err := testTools.RenderTemplate(rr, e.page, homeData)
+
+		var templateErr *TemplateError
+		switch {
+		case err == nil:
+			t.Errorf("%s: expected an error", e.name)
+		case e.notFound && !errors.Is(err, ErrTemplateNotFound):
+			t.Errorf("%s: expected ErrTemplateNotFound, got %v", e.name, err)
+		case !e.notFound && !errors.As(err, &templateErr):
+			t.Errorf("%s: expected *TemplateError, got %v", e.name, err)
+		}
+
+		if rr.Body.Len() > 0 || rr.Header().Get("Content-Type") != "" {
+			t.Errorf("%s: nothing should be written when rendering fails, got %q", e.name, rr.Body.String())
+		}
+	}
+
+	var bare Tools
+	if err := bare.RenderTemplate(httptest.NewRecorder(), "pages/home.html", nil); err == nil {
+		t.Error("expected an error when no templates are configured")
+	}
+}
+
+func TestTools_RenderTemplateCaching(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	write := func(content string) {
+		if err := os.WriteFile(page, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	render := func(testTools *Tools) string {
+		rr := httptest.NewRecorder()
+		if err := testTools.RenderTemplate(rr, "page.html", nil); err != nil {
+			t.Fatal(err)
+		}
+		return rr.Body.String()
+	}
+
+	write("first")
+	cached := Tools{Templates: NewTemplateSet(os.DirFS(dir))}
+	dev := Tools{Templates: NewTemplateSet(os.DirFS(dir), WithTemplateDevMode(true))}
+	render(&cached)
+	render(&dev)
+
+	write("second")
+	if got := render(&cached); got != "first" {
+		t.Errorf("expected the cached template to be used, got %q", got)
+	}
+	if got := render(&dev); got != "second" {
+		t.Errorf("expected dev mode to reparse the template, got %q", got)
+	}
+}
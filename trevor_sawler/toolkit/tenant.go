@@ -0,0 +1,227 @@
+package toolkit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const tenantKey contextKey = "tenant"
+
+// maxTenantDirName is the longest directory name TenantDirName builds from an ID before it hashes the ID instead,
+// keeping well inside the 255 byte limit of common file systems
+const maxTenantDirName = 128
+
+// TenantOverrides holds the limits of one tenant. Zero values fall back to the parent Tools
+type TenantOverrides struct {
+	MaxFileSize           int
+	MaxFilePerSize        int64
+	MinFileSize           int64
+	MaxFiles              int
+	AllowedFileTypes      []string
+	AllowedFileExtensions []string
+	MaxDirBytes           int64
+}
+
+// ScopedTools is a Tools confined to a single tenant, created by ForTenant. Its methods work in the tenant's own
+// subdirectory of the directory they are given
+type ScopedTools struct {
+	tools    Tools
+	TenantID string
+}
+
+// ForTenant returns a copy of t for the tenant id, with its limits replaced by any non-zero overrides
+func (t *Tools) ForTenant(id string, overrides TenantOverrides) *ScopedTools {
+	scoped := &ScopedTools{tools: *t, TenantID: id}
+
+	if overrides.MaxFileSize != 0 {
+		scoped.tools.MaxFileSize = overrides.MaxFileSize
+	}
+	if overrides.MaxFilePerSize != 0 {
+		scoped.tools.MaxFilePerSize = overrides.MaxFilePerSize
+	}
+	if overrides.MinFileSize != 0 {
+		scoped.tools.MinFileSize = overrides.MinFileSize
+	}
+	if overrides.MaxFiles != 0 {
+		scoped.tools.MaxFiles = overrides.MaxFiles
+	}
+	if overrides.AllowedFileTypes != nil {
+		scoped.tools.AllowedFileTypes = overrides.AllowedFileTypes
+	}
+	if overrides.AllowedFileExtensions != nil {
+		scoped.tools.AllowedFileExtensions = overrides.AllowedFileExtensions
+	}
+	if overrides.MaxDirBytes != 0 {
+		scoped.tools.MaxDirBytes = overrides.MaxDirBytes
+	}
+
+	return scoped
+}
+
+// TenantDirName returns the directory name used for the tenant id, a single, safe path element that no other ID
+// shares
+func TenantDirName(id string) string {
+	var b strings.Builder
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "_%02X", c)
+		}
+	}
+
+	switch {
+	case b.Len() == 0:
+		return "_"
+	case b.Len() > maxTenantDirName:
+		// '_' followed by a lower case letter never appears in an escaped name
+		sum := sha256.Sum256([]byte(id))
+		return "_h" + hex.EncodeToString(sum[:])
+	}
+	return b.String()
+}
+
+// TenantFromContext returns the tenant ID set by the handlers of a ScopedTools, so that upload callbacks and
+// download audit hooks can tell which tenant a request was for
+func TenantFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(tenantKey).(string)
+	return id, ok
+}
+
+// TenantDir returns the tenant's subdirectory of root
+func (s *ScopedTools) TenantDir(root string) string {
+	return filepath.Join(root, TenantDirName(s.TenantID))
+}
+
+// UploadFiles saves the uploaded files in the tenant's subdirectory of uploadDir, applying the tenant's limits
+func (s *ScopedTools) UploadFiles(r *http.Request, uploadDir string, opts ...UploadOption) ([]*UploadedFile, error) {
+	return s.tools.UploadFiles(r, s.TenantDir(uploadDir), opts...)
+}
+
+// UploadFilesContext is like UploadFiles, but stops when ctx is done
+func (s *ScopedTools) UploadFilesContext(ctx context.Context, r *http.Request, uploadDir string, opts ...UploadOption) ([]*UploadedFile, error) {
+	return s.tools.UploadFilesContext(ctx, r, s.TenantDir(uploadDir), opts...)
+}
+
+// UploadOneFile saves a single uploaded file in the tenant's subdirectory of uploadDir
+func (s *ScopedTools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	return s.tools.UploadOneFile(r, s.TenantDir(uploadDir), rename...)
+}
+
+// StreamUploadFiles streams the uploaded files to the tenant's subdirectory of uploadDir
+func (s *ScopedTools) StreamUploadFiles(r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
+	return s.tools.StreamUploadFiles(r, s.TenantDir(uploadDir), rename...)
+}
+
+// UploadBase64File saves a base64 encoded file in the tenant's subdirectory of uploadDir
+func (s *ScopedTools) UploadBase64File(data string, filename string, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	return s.tools.UploadBase64File(data, filename, s.TenantDir(uploadDir), rename...)
+}
+
+// UploadBase64FileContext is like UploadBase64File, but stops when ctx is done
+func (s *ScopedTools) UploadBase64FileContext(ctx context.Context, data string, filename string, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	return s.tools.UploadBase64FileContext(ctx, data, filename, s.TenantDir(uploadDir), rename...)
+}
+
+// UploadFromURL saves the file at rawURL in the tenant's subdirectory of uploadDir
+func (s *ScopedTools) UploadFromURL(ctx context.Context, rawURL string, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	return s.tools.UploadFromURL(ctx, rawURL, s.TenantDir(uploadDir), rename...)
+}
+
+// UploadRawBody saves the body of r in the tenant's subdirectory of uploadDir
+func (s *ScopedTools) UploadRawBody(r *http.Request, uploadDir string, filename string, rename ...bool) (*UploadedFile, error) {
+	return s.tools.UploadRawBody(r, s.TenantDir(uploadDir), filename, rename...)
+}
+
+// StartChunkedUpload begins a chunked upload kept in the tenant's subdirectory of dir
+func (s *ScopedTools) StartChunkedUpload(dir, filename string) (string, error) {
+	return s.tools.StartChunkedUpload(s.TenantDir(dir), filename)
+}
+
+// AppendChunk adds a chunk to the upload id in the tenant's subdirectory of dir
+func (s *ScopedTools) AppendChunk(dir, id string, offset int64, r io.Reader) (int64, error) {
+	return s.tools.AppendChunk(s.TenantDir(dir), id, offset, r)
+}
+
+// ChunkedUploadOffset returns the size so far of the upload id in the tenant's subdirectory of dir
+func (s *ScopedTools) ChunkedUploadOffset(dir, id string) (int64, error) {
+	return s.tools.ChunkedUploadOffset(s.TenantDir(dir), id)
+}
+
+// CompleteChunkedUpload saves the chunked upload id to the tenant's subdirectory of uploadDir
+func (s *ScopedTools) CompleteChunkedUpload(ctx context.Context, dir, id, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	return s.tools.CompleteChunkedUpload(ctx, s.TenantDir(dir), id, s.TenantDir(uploadDir), rename...)
+}
+
+// SafeJoin joins name onto the tenant's subdirectory of root, with the same checks as Tools.SafeJoin
+func (s *ScopedTools) SafeJoin(root, name string) (string, error) {
+	return s.tools.SafeJoin(s.TenantDir(root), name)
+}
+
+// UploadHandler returns a Tools.UploadHandler saving to the tenant's subdirectory of uploadDir. The tenant ID is
+// available to WithUploadCallback through TenantFromContext
+func (s *ScopedTools) UploadHandler(uploadDir string, opts ...UploadHandlerOption) http.HandlerFunc {
+	return s.withTenant(s.tools.UploadHandler(s.TenantDir(uploadDir), opts...))
+}
+
+// DownloadHandler returns a Tools.DownloadHandler serving from the tenant's subdirectory of rootDir. The tenant ID
+// is available to WithDownloadAuditHook through TenantFromContext
+func (s *ScopedTools) DownloadHandler(rootDir string, opts ...DownloadHandlerOption) http.HandlerFunc {
+	return s.withTenant(s.tools.DownloadHandler(s.TenantDir(rootDir), opts...))
+}
+
+// DownloadStaticFile sends file from the tenant's subdirectory of p. Unlike Tools.DownloadStaticFile the file
+// name is checked with SafeJoin, and a name that would leave the directory gets a 400
+func (s *ScopedTools) DownloadStaticFile(w http.ResponseWriter, r *http.Request, p, file, displayName string) {
+	fp, err := s.SafeJoin(p, file)
+	if err != nil {
+		_ = s.tools.LocalizedErrorJSON(w, r, err, http.StatusBadRequest)
+		return
+	}
+	s.tools.DownloadStaticFile(w, r, filepath.Dir(fp), filepath.Base(fp), displayName)
+}
+
+// StaticAssets serves the files in the tenant's subdirectory of dir under urlPrefix
+func (s *ScopedTools) StaticAssets(dir string, urlPrefix string) (http.Handler, func(name string) string) {
+	return s.tools.StaticAssets(s.TenantDir(dir), urlPrefix)
+}
+
+// StaticAssetHandler is StaticAssets returning an *AssetHandler
+func (s *ScopedTools) StaticAssetHandler(dir string, urlPrefix string) *AssetHandler {
+	return s.tools.StaticAssetHandler(s.TenantDir(dir), urlPrefix)
+}
+
+// CleanOldFiles removes the old files in the tenant's subdirectory of dir
+func (s *ScopedTools) CleanOldFiles(dir string, olderThan time.Duration, pattern string, recursive ...bool) (int, error) {
+	return s.tools.CleanOldFiles(s.TenantDir(dir), olderThan, pattern, recursive...)
+}
+
+// OldFiles lists the old files in the tenant's subdirectory of dir
+func (s *ScopedTools) OldFiles(dir string, olderThan time.Duration, pattern string, recursive ...bool) ([]string, error) {
+	return s.tools.OldFiles(s.TenantDir(dir), olderThan, pattern, recursive...)
+}
+
+// StartRetentionWorker runs rules over the tenant's subdirectories of their directories
+func (s *ScopedTools) StartRetentionWorker(ctx context.Context, rules []RetentionRule, opts ...RetentionOption) (stop func(), err error) {
+	scoped := make([]RetentionRule, len(rules))
+	for i, rule := range rules {
+		rule.Dir = s.TenantDir(rule.Dir)
+		scoped[i] = rule
+	}
+	return s.tools.StartRetentionWorker(ctx, scoped, opts...)
+}
+
+// withTenant adds the tenant ID to the request context before calling next
+func (s *ScopedTools) withTenant(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), tenantKey, s.TenantID)))
+	}
+}
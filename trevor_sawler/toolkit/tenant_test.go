@@ -0,0 +1,320 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTools_ForTenantIsolation(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	var testTools Tools
+	root := t.TempDir()
+
+	acme := testTools.ForTenant("acme", TenantOverrides{})
+	globex := testTools.ForTenant("globex", TenantOverrides{})
+
+	files, err := acme.UploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), root, WithRename(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "acme", files[0].NewFileName)); err != nil {
+		t.Errorf("expected the file in the tenant's directory: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	globex.DownloadHandler(root)(rr, httptest.NewRequest(http.MethodGet, "/download?file=img.png", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected another tenant not to see the file, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	globex.DownloadHandler(root)(rr, httptest.NewRequest(http.MethodGet, "/download?file=../acme/img.png", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected a path out of the tenant's directory to be refused, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	globex.DownloadStaticFile(rr, httptest.NewRequest(http.MethodGet, "/", nil), root, "../acme/img.png", "x.png")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected DownloadStaticFile to refuse a path out of the tenant's directory, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	acme.DownloadHandler(root)(rr, httptest.NewRequest(http.MethodGet, "/download?file=img.png", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the owning tenant to download the file, got %d", rr.Code)
+	}
+}
+
+func TestTools_ForTenantOverrides(t *testing.T) {
+	parent := Tools{MaxFileSize: 1000, AllowedFileTypes: []string{"image/png"}, MaxDirBytes: 5000}
+
+	var tests = []struct {
+		name      string
+		overrides TenantOverrides
+		expected  TenantOverrides
+	}{
+		{name: "inherits everything", expected: TenantOverrides{MaxFileSize: 1000, AllowedFileTypes: []string{"image/png"}, MaxDirBytes: 5000}},
+		{name: "overrides everything", overrides: TenantOverrides{MaxFileSize: 10, AllowedFileTypes: []string{"image/jpeg"}, MaxDirBytes: 20}, expected: TenantOverrides{MaxFileSize: 10, AllowedFileTypes: []string{"image/jpeg"}, MaxDirBytes: 20}},
+		{name: "overrides some", overrides: TenantOverrides{MaxDirBytes: 20}, expected: TenantOverrides{MaxFileSize: 1000, AllowedFileTypes: []string{"image/png"}, MaxDirBytes: 20}},
+	}
+
+	for _, e := range tests {
+		s := parent.ForTenant("t", e.overrides)
+		got := TenantOverrides{MaxFileSize: s.tools.MaxFileSize, AllowedFileTypes: s.tools.AllowedFileTypes, MaxDirBytes: s.tools.MaxDirBytes}
+		if got.MaxFileSize != e.expected.MaxFileSize || got.MaxDirBytes != e.expected.MaxDirBytes || strings.Join(got.AllowedFileTypes, ",") != strings.Join(e.expected.AllowedFileTypes, ",") {
+			t.Errorf("%s: expected %+v, got %+v", e.name, e.expected, got)
+		}
+	}
+
+	if parent.MaxFileSize != 1000 || parent.MaxDirBytes != 5000 {
+		t.Error("ForTenant must not change the parent")
+	}
+
+	// the overrides are what uploads are checked against
+	png := readTestFile(t, "img.png")
+	jpegOnly := parent.ForTenant("t", TenantOverrides{MaxFileSize: 10 * 1024 * 1024, AllowedFileTypes: []string{"image/jpeg"}})
+	if _, err := jpegOnly.UploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), t.TempDir()); !errors.Is(err, ErrFileTypeNotPermitted) {
+		t.Errorf("expected the tenant's file types to apply, got %v", err)
+	}
+}
+
+func TestTools_ForTenantQuota(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	root := t.TempDir()
+
+	testTools := Tools{MaxDirBytes: int64(len(png) + len(jpg)/2)}
+	tenant := testTools.ForTenant("acme", TenantOverrides{})
+
+	if _, err := tenant.UploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), root); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	tenant.UploadHandler(root)(rr, newUploadRequest(t, testUpload{"file", "pic.jpg", jpg}))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 once the quota is used up, got %d", rr.Code)
+	}
+	entries, _ := os.ReadDir(tenant.TenantDir(root))
+	if len(entries) != 1 {
+		t.Errorf("expected the upload over quota to be removed, got %d files", len(entries))
+	}
+
+	// another tenant has its own quota
+	other := testTools.ForTenant("globex", TenantOverrides{})
+	if _, err := other.UploadFiles(newUploadRequest(t, testUpload{"file", "pic.jpg", jpg}), root); err != nil {
+		t.Errorf("expected another tenant's quota to be separate, got %v", err)
+	}
+
+	full := testTools.ForTenant("acme", TenantOverrides{MaxDirBytes: 1})
+	if _, err := full.UploadFiles(newUploadRequest(t, testUpload{"file", "pic.jpg", jpg}), root); !errors.Is(err, ErrDirQuotaExceeded) {
+		t.Errorf("expected ErrDirQuotaExceeded, got %v", err)
+	}
+}
+
+func TestTenantDirName(t *testing.T) {
+	var tests = []struct {
+		id       string
+		expected string
+	}{
+		{id: "acme", expected: "acme"},
+		{id: "acme-2", expected: "acme-2"},
+		{id: "Acme", expected: "_41cme"},
+		{id: "a_b", expected: "a_5Fb"},
+		{id: "a/b", expected: "a_2Fb"},
+		{id: "..", expected: "_2E_2E"},
+		{id: "../globex", expected: "_2E_2E_2Fglobex"},
+		{id: "", expected: "_"},
+		{id: "nul\x00", expected: "nul_00"},
+	}
+
+	for _, e := range tests {
+		if got := TenantDirName(e.id); got != e.expected {
+			t.Errorf("%q: expected %q, got %q", e.id, e.expected, got)
+		}
+	}
+
+	long := TenantDirName(strings.Repeat("x", 500))
+	if len(long) > maxTenantDirName || !strings.HasPrefix(long, "_h") {
+		t.Errorf("expected a long ID to be hashed, got %q", long)
+	}
+
+	// IDs that would collide if they were simply cleaned up must still get their own directories
+	seen := make(map[string]string)
+	for _, id := range []string{"a/b", "a_b", "a.b", "A_B", "a b", "a-b", "", "_", "..", "."} {
+		name := TenantDirName(id)
+		if other, ok := seen[name]; ok {
+			t.Errorf("%q and %q share the directory %q", id, other, name)
+		}
+		seen[name] = id
+		if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			t.Errorf("%q: unsafe directory name %q", id, name)
+		}
+	}
+}
+
+func TestTools_ForTenantMaliciousID(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	var testTools Tools
+	base := t.TempDir()
+	root := filepath.Join(base, "uploads")
+
+	for _, id := range []string{"../escaped", "/etc", `..\..\windows`, "a/../../b"} {
+		tenant := testTools.ForTenant(id, TenantOverrides{})
+		if _, err := tenant.UploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), root); err != nil {
+			t.Fatalf("%q: %v", id, err)
+		}
+
+		if dir := tenant.TenantDir(root); filepath.Dir(dir) != root {
+			t.Errorf("%q: tenant directory %s is not directly inside %s", id, dir, root)
+		}
+	}
+
+	entries, _ := os.ReadDir(base)
+	if len(entries) != 1 || entries[0].Name() != "uploads" {
+		t.Errorf("expected nothing written outside the upload root, got %v", entries)
+	}
+}
+
+func TestTools_ForTenantEvents(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	var testTools Tools
+	root := t.TempDir()
+	tenant := testTools.ForTenant("acme", TenantOverrides{})
+
+	var uploadTenant, downloadTenant string
+	callback := WithUploadCallback(func(r *http.Request, files []*UploadedFile) error {
+		uploadTenant, _ = TenantFromContext(r.Context())
+		return nil
+	})
+	audit := WithDownloadAuditHook(func(r *http.Request, file string, err error) {
+		downloadTenant, _ = TenantFromContext(r.Context())
+	})
+
+	tenant.UploadHandler(root, callback, WithUploadRename(false))(httptest.NewRecorder(), newUploadRequest(t, testUpload{"file", "img.png", png}))
+	tenant.DownloadHandler(root, audit)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/download?file=img.png", nil))
+
+	if uploadTenant != "acme" || downloadTenant != "acme" {
+		t.Errorf("expected upload and download events to carry the tenant, got %q and %q", uploadTenant, downloadTenant)
+	}
+	if _, ok := TenantFromContext(httptest.NewRequest(http.MethodGet, "/", nil).Context()); ok {
+		t.Error("expected no tenant on a plain request")
+	}
+}
+
+func TestTools_ForTenantEntryPoints(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	server := newFileServer(t, png)
+	testTools := Tools{AllowPrivateURLs: true, Logger: log.New(io.Discard, "", 0)}
+	tenant := testTools.ForTenant("acme", TenantOverrides{})
+
+	single := func(f *UploadedFile, err error) ([]*UploadedFile, error) {
+		return []*UploadedFile{f}, err
+	}
+	uploads := map[string]func(root string) ([]*UploadedFile, error){
+		"UploadFiles": func(root string) ([]*UploadedFile, error) {
+			return tenant.UploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), root)
+		},
+		"UploadFilesContext": func(root string) ([]*UploadedFile, error) {
+			return tenant.UploadFilesContext(context.Background(), newUploadRequest(t, testUpload{"file", "img.png", png}), root)
+		},
+		"UploadOneFile": func(root string) ([]*UploadedFile, error) {
+			return single(tenant.UploadOneFile(newUploadRequest(t, testUpload{"file", "img.png", png}), root))
+		},
+		"StreamUploadFiles": func(root string) ([]*UploadedFile, error) {
+			return tenant.StreamUploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}), root)
+		},
+		"UploadBase64File": func(root string) ([]*UploadedFile, error) {
+			return single(tenant.UploadBase64File(base64.StdEncoding.EncodeToString(png), "img.png", root))
+		},
+		"UploadBase64FileContext": func(root string) ([]*UploadedFile, error) {
+			return single(tenant.UploadBase64FileContext(context.Background(), base64.StdEncoding.EncodeToString(png), "img.png", root))
+		},
+		"UploadFromURL": func(root string) ([]*UploadedFile, error) {
+			return single(tenant.UploadFromURL(context.Background(), server.URL+"/img.png", root))
+		},
+		"UploadRawBody": func(root string) ([]*UploadedFile, error) {
+			return single(tenant.UploadRawBody(httptest.NewRequest(http.MethodPut, "/", bytes.NewReader(png)), root, "img.png"))
+		},
+		"CompleteChunkedUpload": func(root string) ([]*UploadedFile, error) {
+			id, err := tenant.StartChunkedUpload(root, "img.png")
+			if err != nil {
+				return nil, err
+			}
+			if _, err := tenant.AppendChunk(root, id, 0, bytes.NewReader(png)); err != nil {
+				return nil, err
+			}
+			if n, err := tenant.ChunkedUploadOffset(root, id); err != nil || n != int64(len(png)) {
+				t.Errorf("expected the chunked upload to be %d bytes, got %d and %v", len(png), n, err)
+			}
+			return single(tenant.CompleteChunkedUpload(context.Background(), root, id, root))
+		},
+	}
+
+	for name, upload := range uploads {
+		root := t.TempDir()
+		files, err := upload(root)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(tenant.TenantDir(root), files[0].NewFileName)); err != nil {
+			t.Errorf("%s: expected the file in the tenant's directory: %v", name, err)
+		}
+		for _, f := range remainingFiles(t, root) {
+			if !strings.HasPrefix(f, "acme/") {
+				t.Errorf("%s: expected nothing outside the tenant's directory, got %s", name, f)
+			}
+		}
+	}
+
+	// the helpers that read or clean up a directory only see the tenant's files
+	root := t.TempDir()
+	_ = os.MkdirAll(tenant.TenantDir(root), 0755)
+	_ = os.WriteFile(filepath.Join(root, "shared.png"), png, 0644)
+	_ = os.WriteFile(filepath.Join(tenant.TenantDir(root), "own.png"), png, 0644)
+	old := time.Now().Add(-time.Hour)
+	_ = os.Chtimes(filepath.Join(root, "shared.png"), old, old)
+	_ = os.Chtimes(filepath.Join(tenant.TenantDir(root), "own.png"), old, old)
+
+	if names, err := tenant.OldFiles(root, time.Minute, ""); err != nil || len(names) != 1 || filepath.Base(names[0]) != "own.png" {
+		t.Errorf("expected OldFiles to list own.png alone, got %v and %v", names, err)
+	}
+	_, assetURL := tenant.StaticAssets(root, "/static")
+	h := tenant.StaticAssetHandler(root, "/static")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/static/shared.png", nil))
+	if rr.Code != http.StatusNotFound || assetURL("shared.png") != "/static/shared.png" {
+		t.Errorf("expected the shared file not to be served, got %d", rr.Code)
+	}
+
+	results := make(chan RetentionResult, 1)
+	stop, err := tenant.StartRetentionWorker(context.Background(), []RetentionRule{{Dir: root, MaxAge: time.Minute}},
+		WithRetentionInterval(time.Hour), WithRetentionMetrics(func(r RetentionResult) { results <- r }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := <-results
+	stop()
+	if result.Rule.Dir != tenant.TenantDir(root) || result.Deleted != 1 {
+		t.Errorf("expected the retention rule to clean the tenant's directory, got %+v", result)
+	}
+	if n, err := tenant.CleanOldFiles(root, time.Minute, ""); err != nil || n != 0 {
+		t.Errorf("expected nothing left for CleanOldFiles, got %d and %v", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "shared.png")); err != nil {
+		t.Errorf("expected the shared file to be kept: %v", err)
+	}
+}
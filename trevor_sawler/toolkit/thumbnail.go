@@ -0,0 +1,224 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+)
+
+// ThumbnailSpec describes a copy of an uploaded image scaled down to fit Width by Height, a zero side being free,
+// saved next to it with Suffix before its extension, e.g. photo_thumb.jpg
+type ThumbnailSpec struct {
+	Width, Height int
+	Suffix        string
+}
+
+// thumbnailJPEGQuality is the quality JPEG thumbnails are encoded at
+const thumbnailJPEGQuality = 85
+
+// errThumbnailAborted stops the decoding of an image whose upload failed
+var errThumbnailAborted = errors.New("upload aborted")
+
+// makesThumbnails reports whether thumbnails are made of an uploaded file of type fileType
+func (t *Tools) makesThumbnails(fileType string) bool {
+	if len(t.Thumbnails) == 0 {
+		return false
+	}
+	switch fileType {
+	case "image/png", "image/jpeg", "image/gif":
+		return true
+	}
+	return false
+}
+
+// variantName returns the name under which the variant of the file stored as name with suffix is saved
+func variantName(name, suffix string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + suffix + ext
+}
+
+// imageDecoder decodes an image from the bytes written to it, so that an uploaded file is decoded as it is saved.
+// A nil *imageDecoder does nothing
+type imageDecoder struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	img  image.Image
+	err  error
+}
+
+func newImageDecoder() *imageDecoder {
+	pr, pw := io.Pipe()
+	d := &imageDecoder{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(d.done)
+		d.img, _, d.err = image.Decode(pr)
+		io.Copy(io.Discard, pr)
+	}()
+	return d
+}
+
+func (d *imageDecoder) Write(p []byte) (int, error) {
+	return d.pw.Write(p)
+}
+
+// image waits for the decoding to finish, once everything has been written, and returns the image
+func (d *imageDecoder) image() (image.Image, error) {
+	d.pw.Close()
+	<-d.done
+	return d.img, d.err
+}
+
+// close stops the decoding, if it hasn't finished, and waits for it
+func (d *imageDecoder) close() {
+	if d == nil {
+		return
+	}
+	d.pw.CloseWithError(errThumbnailAborted)
+	<-d.done
+}
+
+// saveThumbnails saves a copy of the image decoded by d for each of Thumbnails, and records them in
+// uploadedFile.Variants
+func (t *Tools) saveThumbnails(ctx context.Context, store FileStore, uploadedFile *UploadedFile, d *imageDecoder) error {
+	img, err := d.image()
+	if err != nil {
+		err = fmt.Errorf("could not make thumbnails of uploaded file %q: %w", uploadedFile.OriginalFileName, err)
+		if t.StrictThumbnails {
+			return err
+		}
+		t.logger().Printf("upload: %s", err)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, spec := range t.Thumbnails {
+		name := variantName(uploadedFile.StoredPath, spec.Suffix)
+
+		buf.Reset()
+		err := encodeImage(&buf, uploadedFile.ContentType, resizeImage(img, spec.Width, spec.Height))
+		if err == nil {
+			_, err = store.Save(ctx, name, &buf)
+		}
+		if err != nil {
+			err = fmt.Errorf("could not save thumbnail %q of uploaded file %q: %w", path.Base(name), uploadedFile.OriginalFileName, err)
+			if t.StrictThumbnails {
+				removeVariants(store, uploadedFile)
+				return err
+			}
+			t.logger().Printf("upload: %s", err)
+			continue
+		}
+
+		if uploadedFile.Variants == nil {
+			uploadedFile.Variants = make(map[string]string, len(t.Thumbnails))
+		}
+		uploadedFile.Variants[spec.Suffix] = name
+	}
+	return nil
+}
+
+// findThumbnails records in uploadedFile.Variants the thumbnails already saved for a deduplicated file
+func (t *Tools) findThumbnails(ctx context.Context, store FileStore, uploadedFile *UploadedFile) {
+	for _, spec := range t.Thumbnails {
+		name := variantName(uploadedFile.StoredPath, spec.Suffix)
+		if exists, err := store.Exists(ctx, name); err != nil || !exists {
+			continue
+		}
+		if uploadedFile.Variants == nil {
+			uploadedFile.Variants = make(map[string]string, len(t.Thumbnails))
+		}
+		uploadedFile.Variants[spec.Suffix] = name
+	}
+}
+
+// removeVariants removes the thumbnails saved for uploadedFile. Like undoUpload, it doesn't use the upload's context,
+// which may have been cancelled
+func removeVariants(store FileStore, uploadedFile *UploadedFile) {
+	for _, name := range uploadedFile.Variants {
+		store.Remove(context.Background(), name)
+	}
+	uploadedFile.Variants = nil
+}
+
+// encodeImage writes img to w in the format of contentType
+func encodeImage(w io.Writer, contentType string, img image.Image) error {
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	case "image/gif":
+		return gif.Encode(w, img, nil)
+	default:
+		return png.Encode(w, img)
+	}
+}
+
+// resizeImage scales img down, keeping its aspect ratio, to fit within width by height pixels
+func resizeImage(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	srcW, srcH := b.Dx(), b.Dy()
+	if srcW == 0 || srcH == 0 {
+		return img
+	}
+
+	dstW, dstH := srcW, srcH
+	if width > 0 && dstW > width {
+		dstW, dstH = width, srcH*width/srcW
+	}
+	if height > 0 && dstH > height {
+		dstW, dstH = srcW*height/srcH, height
+	}
+	if dstW == srcW && dstH == srcH {
+		return img
+	}
+	if dstW < 1 {
+		dstW = 1
+	}
+	if dstH < 1 {
+		dstH = 1
+	}
+
+	src := image.NewRGBA(image.Rect(0, 0, srcW, srcH))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < dstH; y++ {
+		y0, y1 := y*srcH/dstH, (y+1)*srcH/dstH
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dstW; x++ {
+			x0, x1 := x*srcW/dstW, (x+1)*srcW/dstW
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+			}
+
+			count := (y1 - y0) * (x1 - x0)
+			p := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+			for i := range p {
+				p[i] = uint8(sum[i] / count)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,205 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// thumbFailingStore is a MemoryStore that can't save thumbnails
+type thumbFailingStore struct {
+	MemoryStore
+}
+
+func (s *thumbFailingStore) Save(ctx context.Context, name string, r io.Reader) (int64, error) {
+	if strings.Contains(name, "_thumb") {
+		return 0, errors.New("no room for thumbnails")
+	}
+	return s.MemoryStore.Save(ctx, name, r)
+}
+
+func TestTools_UploadFilesThumbnails(t *testing.T) {
+	img := readTestFile(t, "img.png") // 640x426
+	pic := readTestFile(t, "pic.jpg")
+	specs := []ThumbnailSpec{{Width: 100, Suffix: "_thumb"}, {Height: 50, Suffix: "_small"}, {Width: 1000, Height: 1000, Suffix: "_big"}}
+
+	var tests = []struct {
+		name     string
+		filename string
+		content  []byte
+		format   string
+		sizes    map[string]image.Point
+	}{
+		{name: "png", filename: "img.png", content: img, format: "png", sizes: map[string]image.Point{"_thumb": {100, 66}, "_small": {75, 50}, "_big": {640, 426}}},
+		{name: "jpeg", filename: "pic.jpg", content: pic, format: "jpeg"},
+		{name: "not an image", filename: "notes.txt", content: []byte("just some text")},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			testTools := Tools{Thumbnails: specs}
+			dir := t.TempDir()
+			req := newUploadRequest(t, testUpload{field: "file", filename: e.filename, content: e.content})
+			uploadedFiles, err := upload(&testTools, req, dir, false)
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+
+			f := uploadedFiles[0]
+			if saved, _ := os.ReadFile(filepath.Join(dir, f.StoredPath)); !bytes.Equal(saved, e.content) {
+				t.Errorf("%s, %s: expected the original to be saved as it was", e.name, name)
+			}
+			if e.format == "" {
+				if f.Variants != nil {
+					t.Errorf("%s, %s: expected no thumbnails, got %v", e.name, name, f.Variants)
+				}
+				continue
+			}
+
+			if len(f.Variants) != len(specs) {
+				t.Errorf("%s, %s: expected %d thumbnails, got %v", e.name, name, len(specs), f.Variants)
+			}
+			for _, spec := range specs {
+				stored := f.Variants[spec.Suffix]
+				if expected := strings.TrimSuffix(e.filename, filepath.Ext(e.filename)) + spec.Suffix + filepath.Ext(e.filename); stored != expected {
+					t.Errorf("%s, %s: expected thumbnail %s, got %q", e.name, name, expected, stored)
+					continue
+				}
+				file, err := os.Open(filepath.Join(dir, stored))
+				if err != nil {
+					t.Errorf("%s, %s: %s", e.name, name, err)
+					continue
+				}
+				cfg, format, err := image.DecodeConfig(file)
+				file.Close()
+				if err != nil || format != e.format {
+					t.Errorf("%s, %s: expected %s to be a %s image, got %s, %v", e.name, name, stored, e.format, format, err)
+					continue
+				}
+				if size, ok := e.sizes[spec.Suffix]; ok && (cfg.Width != size.X || cfg.Height != size.Y) {
+					t.Errorf("%s, %s: expected %s to be %dx%d, got %dx%d", e.name, name, stored, size.X, size.Y, cfg.Width, cfg.Height)
+				}
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesThumbnailErrors(t *testing.T) {
+	corrupt := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0xff}, 64)...)
+	specs := []ThumbnailSpec{{Width: 100, Suffix: "_thumb"}}
+
+	for _, strict := range []bool{false, true} {
+		for name, upload := range uploadFuncs {
+			var logged bytes.Buffer
+			testTools := Tools{Thumbnails: specs, StrictThumbnails: strict, Logger: log.New(&logged, "", 0)}
+			dir := t.TempDir()
+			req := newUploadRequest(t, testUpload{field: "file", filename: "bad.png", content: corrupt})
+			uploadedFiles, err := upload(&testTools, req, dir)
+
+			if strict {
+				if err == nil || !strings.Contains(err.Error(), "bad.png") {
+					t.Errorf("%s, strict: expected an error naming the file, got %v", name, err)
+				}
+				if names := remainingFiles(t, dir); names != nil {
+					t.Errorf("%s, strict: expected nothing to be left, got %v", name, names)
+				}
+				continue
+			}
+
+			if err != nil {
+				t.Errorf("%s: expected the upload to succeed without its thumbnail, got %s", name, err)
+				continue
+			}
+			if uploadedFiles[0].Variants != nil {
+				t.Errorf("%s: expected no thumbnails, got %v", name, uploadedFiles[0].Variants)
+			}
+			if !strings.Contains(logged.String(), "could not make thumbnails") {
+				t.Errorf("%s: expected the failure to be logged, got %q", name, logged.String())
+			}
+		}
+	}
+
+	// a thumbnail the store can't save fails a strict upload, and the original is removed
+	img := readTestFile(t, "img.png")
+	testTools := Tools{Thumbnails: []ThumbnailSpec{{Width: 50, Suffix: "_small"}, {Width: 100, Suffix: "_thumb"}}, StrictThumbnails: true}
+	var store thumbFailingStore
+	_, err := testTools.UploadFilesTo(newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: img}), &store)
+	if err == nil || !strings.Contains(err.Error(), "no room for thumbnails") {
+		t.Errorf("expected the store's error, got %v", err)
+	}
+	if names := store.Names(); len(names) != 0 {
+		t.Errorf("expected nothing to be left in the store, got %v", names)
+	}
+}
+
+func TestTools_UploadFilesThumbnailsRollback(t *testing.T) {
+	testTools := Tools{AllowedFileTypes: []string{"image/png"}, Thumbnails: []ThumbnailSpec{{Width: 100, Suffix: "_thumb"}}}
+
+	var store MemoryStore
+	req := newUploadRequest(t,
+		testUpload{field: "file", filename: "img.png", content: readTestFile(t, "img.png")},
+		testUpload{field: "file", filename: "notes.txt", content: []byte("not an image")},
+	)
+	if _, err := testTools.UploadFilesTo(req, &store); !errors.Is(err, ErrFileTypeNotPermitted) {
+		t.Errorf("expected ErrFileTypeNotPermitted, got %v", err)
+	}
+	if names := store.Names(); len(names) != 0 {
+		t.Errorf("expected the image and its thumbnail to be removed, got %v", names)
+	}
+}
+
+func TestTools_UploadFilesThumbnailsDeduplicated(t *testing.T) {
+	testTools := Tools{NamingStrategy: NamingContentHash, Thumbnails: []ThumbnailSpec{{Width: 100, Suffix: "_thumb"}}}
+	img := readTestFile(t, "img.png")
+
+	var store MemoryStore
+	var variants []map[string]string
+	for i := 0; i < 2; i++ {
+		uploadedFiles, err := testTools.UploadFilesTo(newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: img}), &store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		variants = append(variants, uploadedFiles[0].Variants)
+	}
+
+	if variants[0] == nil || !reflect.DeepEqual(variants[0], variants[1]) {
+		t.Errorf("expected the deduplicated upload to report the same thumbnails, got %v and %v", variants[0], variants[1])
+	}
+	if names := store.Names(); len(names) != 2 {
+		t.Errorf("expected the image and one thumbnail to be stored, got %v", names)
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 2; y++ {
+			src.Set(x, y, color.RGBA{R: uint8(x * 60), A: 255})
+		}
+	}
+
+	if resized := resizeImage(src, 10, 10); resized != image.Image(src) {
+		t.Error("expected an image that fits to be returned as it is")
+	}
+
+	resized := resizeImage(src, 2, 0)
+	if b := resized.Bounds(); b.Dx() != 2 || b.Dy() != 1 {
+		t.Fatalf("expected a 2x1 image, got %dx%d", b.Dx(), b.Dy())
+	}
+	// each pixel of the result averages two columns of the source
+	for x, expected := range []uint8{30, 150} {
+		if r, _, _, _ := resized.At(x, 0).RGBA(); uint8(r>>8) != expected {
+			t.Errorf("pixel %d: expected red %d, got %d", x, expected, r>>8)
+		}
+	}
+}
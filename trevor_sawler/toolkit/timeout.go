@@ -0,0 +1,144 @@
+package toolkit
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Timeout is middleware that cancels the next handler's context after d, and sends a 504 if the handler has not
+// started its response by then. Later writes return http.ErrHandlerTimeout
+func (t *Tools) Timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{w: w, header: make(http.Header)}
+			done := make(chan struct{})
+			panicChan := make(chan interface{}, 1)
+
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicChan <- p
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case p := <-panicChan:
+				panic(p)
+			case <-done:
+				tw.finish()
+				return
+			case <-ctx.Done():
+			}
+
+			// select picks at random between ready cases, so a handler that returned right at the deadline may
+			// still be reported as timed out; it gets no 504 once it is known to have finished
+			select {
+			case p := <-panicChan:
+				panic(p)
+			case <-done:
+				tw.finish()
+				return
+			default:
+			}
+
+			tw.mu.Lock()
+			if tw.wroteHeader {
+				// the handler is already streaming its response, so the best we can do is wait for it to notice
+				// the cancelled context and return
+				tw.mu.Unlock()
+				select {
+				case p := <-panicChan:
+					panic(p)
+				case <-done:
+				}
+				return
+			}
+			tw.timedOut = true
+			tw.mu.Unlock()
+
+			_ = t.LocalizedErrorJSON(w, r, newMessageError("request.timed_out"), http.StatusGatewayTimeout)
+		})
+	}
+}
+
+// timeoutWriter guards the real http.ResponseWriter so that a handler running past its deadline cannot write
+// to it concurrently with, or after, the timeout response
+type timeoutWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.writeHeaderLocked(status)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.w.Write(b)
+}
+
+// Flush implements http.Flusher, so that streaming handlers keep working behind the middleware
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.timedOut {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	if f, ok := tw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// finish sends the headers of a handler that returned without writing, with the implicit 200
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+}
+
+// writeHeaderLocked copies the handler's headers to the real writer and sends the status. tw.mu must be held
+func (tw *timeoutWriter) writeHeaderLocked(status int) {
+	dst := tw.w.Header()
+	for key, value := range tw.header {
+		dst[key] = value
+	}
+	tw.wroteHeader = true
+	tw.w.WriteHeader(status)
+}
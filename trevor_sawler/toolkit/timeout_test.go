@@ -0,0 +1,145 @@
+package toolkit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTools_TimeoutExpired(t *testing.T) {
+	var testTools Tools
+
+	lateWrite := make(chan error, 1)
+	h := testTools.Timeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("X-Late", "yes")
+		_, err := w.Write([]byte("too late"))
+		lateWrite <- err
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504 but got %d", rr.Code)
+	}
+
+	var payload JSONResponse
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Error("could not decode timeout response:", err)
+	}
+	if !payload.Error {
+		t.Error("error set to false in JSON, and it should be true")
+	}
+
+	if err := <-lateWrite; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("expected late write to fail with ErrHandlerTimeout, got %v", err)
+	}
+
+	if rr.Header().Get("X-Late") != "" {
+		t.Error("late header leaked into the response")
+	}
+}
+
+func TestTools_TimeoutContextCancelled(t *testing.T) {
+	var testTools Tools
+
+	// the handler is still cleaning up when the timeout response is sent, so it can't be taken for one that finished
+	aborted := make(chan error, 1)
+	release := make(chan struct{})
+	h := testTools.Timeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			aborted <- r.Context().Err()
+		case <-time.After(time.Second):
+			aborted <- nil
+		}
+		<-release
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	close(release)
+
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504 but got %d", rr.Code)
+	}
+
+	if err := <-aborted; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected handler to see deadline exceeded, got %v", err)
+	}
+}
+
+func TestTools_TimeoutInTime(t *testing.T) {
+	var testTools Tools
+
+	h := testTools.Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "yes")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("done"))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusCreated {
+		t.Errorf("expected status 201 but got %d", rr.Code)
+	}
+	if rr.Body.String() != "done" {
+		t.Errorf("wrong body returned: %s", rr.Body.String())
+	}
+	if rr.Header().Get("X-Handler") != "yes" {
+		t.Error("handler header missing from response")
+	}
+}
+
+func TestTools_TimeoutHeadersOnly(t *testing.T) {
+	var testTools Tools
+
+	// a handler that only sets headers relies on the implicit 200
+	h := testTools.Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/next")
+		w.Header().Add("Set-Cookie", "session=abc")
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	res := rr.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status 200 but got %d", res.StatusCode)
+	}
+	if res.Header.Get("Location") != "/next" || res.Header.Get("Set-Cookie") != "session=abc" {
+		t.Errorf("expected the handler's headers in the response, got %v", res.Header)
+	}
+}
+
+func TestTools_TimeoutStreaming(t *testing.T) {
+	var testTools Tools
+
+	h := testTools.Timeout(20 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("first;"))
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+
+		_, _ = w.Write([]byte("last"))
+	}))
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 but got %d", rr.Code)
+	}
+	if !rr.Flushed {
+		t.Error("expected response to be flushed")
+	}
+	if rr.Body.String() != "first;last" {
+		t.Errorf("wrong body returned: %s", rr.Body.String())
+	}
+}
@@ -2,35 +2,480 @@ package toolkit
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const randomStringSource = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_+"
 
 // Tools is the type used to instantiate this module. Any variable of this type will have access
-// to all the methods with the reciever *Tools
+// to all the methods with the reciever *Tools, which are safe for concurrent use
 type Tools struct {
-	MaxFileSize        int
-	AllowedFileTypes   []string
-	MaxJSONSize        int
-	AllowUnknownFields bool
+	MaxFileSize             int
+	MaxFilePerSize          int64
+	SizeLimitsByType        map[string]int64
+	MinFileSize             int64
+	MaxFiles                int
+	MaxTotalUploadSize      int64
+	MultipartMemoryLimit    int64
+	TempDir                 string
+	AllowedFileTypes        []string
+	DetectContentTypeFunc   func(head []byte, filename string) string
+	SniffLength             int
+	DeniedFileTypes         []string
+	SVGPolicy               SVGPolicy
+	AllowedFileExtensions   []string
+	CompoundExtensions      []string
+	AllowHiddenFiles        bool
+	MaxFileNameLength       int
+	RejectExtensionMismatch bool
+	AllowedFormFields       []string
+	MaxImageWidth           int
+	MaxImageHeight          int
+	MaxImagePixels          int64
+	NormalizeImageFormat    string
+	NormalizeJPEGQuality    int
+	Thumbnails              []ThumbnailSpec
+	StrictThumbnails        bool
+	ZipLimits               *ZipLimits
+	ExtractArchives         bool
+	Scanner                 Scanner
+	ScanFailOpen            bool
+	WriteMetadata           bool
+	MetadataFunc            func(uploadedFile *UploadedFile) map[string]interface{}
+	StrictFormFields        bool
+	MaxDirBytes             int64
+	FileMode                os.FileMode
+	DirMode                 os.FileMode
+	CheckFreeSpace          bool
+	FreeSpaceHeadroom       float64
+	ComputeChecksum         bool
+	Deduplicate             bool
+	ExistsByHash            func(hash string) (string, bool)
+	NamingStrategy          NamingStrategy
+	SubdirStrategy          SubdirStrategy
+	RenameFunc              func(original string) string
+	KeepPartialUploads      bool
+	ContinueOnError         bool
+	BeforeSave              func(ctx context.Context, uploadedFile *UploadedFile) error
+	AfterSave               func(ctx context.Context, uploadedFile *UploadedFile) error
+	TakeFirstFile           bool
+	ExistsPolicy            ExistsPolicy
+	OnProgress              func(filename string, bytesWritten, totalBytes int64)
+	Concurrency             int
+	URLUploadTimeout        time.Duration
+	AllowPrivateURLs        bool
+	MaxJSONSize             int
+	AllowUnknownFields      bool
+	ValidateJSON            bool
+	SanitizeJSON            bool
+	HTTPClient              *http.Client
+	HealthCheckTimeout      time.Duration
+	HealthCacheTTL          time.Duration
+	ETagMaxBufferSize       int
+	Logger                  *log.Logger
+	RandomStringSource      string
+	Translator              Translator
+	SlugMaxInputLength      int
+	CursorSecret            []byte
+	Templates               *TemplateSet
+	CookieKeys              [][]byte
+	EncryptionKeys          [][]byte
 }
 
-// RandomString returns a string of random characters of length n, using randomStringSource
-// as the source for the string
+// ErrFileTooBig is returned by UploadFiles when the upload exceeds MaxFileSize. It is also matched, via errors.Is,
+// by the *FileTooBigError for a single file over MaxFilePerSize
+var ErrFileTooBig error = newMessageError("upload.too_big")
+
+// FileTooBigError is returned by UploadFiles when one of the uploaded files is larger than MaxFilePerSize, or than the
+// limit SizeLimitsByType sets for its type. ContentType is that type, and is empty when the limit is MaxFilePerSize
+type FileTooBigError struct {
+	FileName    string
+	ContentType string
+	Limit       int64
+}
+
+// Error implements the error interface
+func (e *FileTooBigError) Error() string {
+	key, args := e.messageKey()
+	return englishMessage(key, args...)
+}
+
+// Is reports whether target is ErrFileTooBig
+func (e *FileTooBigError) Is(target error) bool {
+	return target == ErrFileTooBig
+}
+
+func (e *FileTooBigError) messageKey() (string, []interface{}) {
+	if e.ContentType != "" {
+		return "upload.type_too_big", []interface{}{e.FileName, e.ContentType, e.Limit}
+	}
+	return "upload.file_too_big", []interface{}{e.FileName, e.Limit}
+}
+
+// ErrFileTooSmall is matched, via errors.Is, by the *FileTooSmallError returned by UploadFiles for a file smaller
+// than MinFileSize
+var ErrFileTooSmall = errors.New("uploaded file is too small")
+
+// FileTooSmallError is returned by UploadFiles when one of the uploaded files is smaller than MinFileSize, or empty
+type FileTooSmallError struct {
+	FileName string
+	Limit    int64
+}
+
+// Error implements the error interface
+func (e *FileTooSmallError) Error() string {
+	key, args := e.messageKey()
+	return englishMessage(key, args...)
+}
+
+// Is reports whether target is ErrFileTooSmall
+func (e *FileTooSmallError) Is(target error) bool {
+	return target == ErrFileTooSmall
+}
+
+func (e *FileTooSmallError) messageKey() (string, []interface{}) {
+	if e.Limit <= 1 {
+		return "upload.file_empty", []interface{}{e.FileName}
+	}
+	return "upload.file_too_small", []interface{}{e.FileName, e.Limit}
+}
+
+// ErrTooManyFiles is matched, via errors.Is, by the *TooManyFilesError returned by UploadFiles when a request holds
+// more than MaxFiles files
+var ErrTooManyFiles = errors.New("too many files uploaded")
+
+// TooManyFilesError is returned by UploadFiles when a request holds more files than MaxFiles
+type TooManyFilesError struct {
+	Limit int
+}
+
+// Error implements the error interface
+func (e *TooManyFilesError) Error() string {
+	return englishMessage("upload.too_many_files", e.Limit)
+}
+
+// Is reports whether target is ErrTooManyFiles
+func (e *TooManyFilesError) Is(target error) bool {
+	return target == ErrTooManyFiles
+}
+
+func (e *TooManyFilesError) messageKey() (string, []interface{}) {
+	return "upload.too_many_files", []interface{}{e.Limit}
+}
+
+// ErrMultipleFiles is matched, via errors.Is, by the *MultipleFilesError returned by UploadOneFile for a request
+// holding more than one file
+var ErrMultipleFiles = errors.New("more than one file uploaded")
+
+// MultipleFilesError is returned by UploadOneFile when a request holds Count files rather than one, unless
+// TakeFirstFile is set. It also matches ErrTooManyFiles, which UploadOneFile returned for such a request before
+type MultipleFilesError struct {
+	Count int
+}
+
+// Error implements the error interface
+func (e *MultipleFilesError) Error() string {
+	return englishMessage("upload.multiple_files", e.Count)
+}
+
+// Is reports whether target is ErrMultipleFiles or ErrTooManyFiles
+func (e *MultipleFilesError) Is(target error) bool {
+	return target == ErrMultipleFiles || target == ErrTooManyFiles
+}
+
+func (e *MultipleFilesError) messageKey() (string, []interface{}) {
+	return "upload.multiple_files", []interface{}{e.Count}
+}
+
+// ErrRequestTooLarge is matched, via errors.Is, by the *RequestTooLargeError returned by UploadFiles when the files
+// of a request come to more than MaxTotalUploadSize bytes
+var ErrRequestTooLarge = errors.New("uploaded files are too large in all")
+
+// RequestTooLargeError is returned by UploadFiles and StreamUploadFiles when the files of a request come to more than
+// Limit bytes, as MaxTotalUploadSize sets. Handlers usually answer it with 413 Request Entity Too Large
+type RequestTooLargeError struct {
+	Limit int64
+}
+
+// Error implements the error interface
+func (e *RequestTooLargeError) Error() string {
+	return englishMessage("upload.request_too_big", e.Limit)
+}
+
+// Is reports whether target is ErrRequestTooLarge
+func (e *RequestTooLargeError) Is(target error) bool {
+	return target == ErrRequestTooLarge
+}
+
+func (e *RequestTooLargeError) messageKey() (string, []interface{}) {
+	return "upload.request_too_big", []interface{}{e.Limit}
+}
+
+// ErrDirQuotaExceeded is returned by UploadFiles when the upload would take the upload directory over MaxDirBytes
+var ErrDirQuotaExceeded error = newMessageError("upload.quota_exceeded")
+
+// ErrInsufficientStorage is matched, via errors.Is, by the *InsufficientStorageError returned by UploadFiles when
+// CheckFreeSpace is set and the upload directory's file system hasn't enough space free for the upload
+var ErrInsufficientStorage = errors.New("not enough free disk space for the upload")
+
+// InsufficientStorageError is returned when CheckFreeSpace is set and the upload directory has fewer than Required
+// bytes free
+type InsufficientStorageError struct {
+	Required  uint64
+	Available uint64
+}
+
+// Error implements the error interface
+func (e *InsufficientStorageError) Error() string {
+	return englishMessage("upload.no_disk_space")
+}
+
+// Is reports whether target is ErrInsufficientStorage
+func (e *InsufficientStorageError) Is(target error) bool {
+	return target == ErrInsufficientStorage
+}
+
+func (e *InsufficientStorageError) messageKey() (string, []interface{}) {
+	return "upload.no_disk_space", nil
+}
+
+// ErrFileTypeNotPermitted is matched, via errors.Is, by the *FileTypeError returned by UploadFiles when a file's
+// detected type matches no entry of AllowedFileTypes, or one of DeniedFileTypes
+var ErrFileTypeNotPermitted error = newMessageError("upload.type_not_permitted")
+
+// FileTypeError is returned by UploadFiles when the detected type of one of the uploaded files is not in
+// AllowedFileTypes, or is in DeniedFileTypes
+type FileTypeError struct {
+	FileName    string
+	ContentType string
+}
+
+// Error implements the error interface
+func (e *FileTypeError) Error() string {
+	return englishMessage("upload.file_type_denied", e.FileName, e.ContentType)
+}
+
+// Is reports whether target is ErrFileTypeNotPermitted
+func (e *FileTypeError) Is(target error) bool {
+	return target == ErrFileTypeNotPermitted
+}
+
+func (e *FileTypeError) messageKey() (string, []interface{}) {
+	return "upload.file_type_denied", []interface{}{e.FileName, e.ContentType}
+}
+
+// ErrFormFieldNotPermitted is matched, via errors.Is, by the *FormFieldError returned by UploadFiles when
+// StrictFormFields is set and a file is posted under a form field that is not in AllowedFormFields
+var ErrFormFieldNotPermitted = errors.New("file uploaded under a form field that is not permitted")
+
+// FormFieldError is returned by UploadFiles when StrictFormFields is set and a file is posted under a form field that
+// is not in AllowedFormFields
+type FormFieldError struct {
+	Field string
+}
+
+// Error implements the error interface
+func (e *FormFieldError) Error() string {
+	return englishMessage("upload.field_not_permitted", e.Field)
+}
+
+// Is reports whether target is ErrFormFieldNotPermitted
+func (e *FormFieldError) Is(target error) bool {
+	return target == ErrFormFieldNotPermitted
+}
+
+func (e *FormFieldError) messageKey() (string, []interface{}) {
+	return "upload.field_not_permitted", []interface{}{e.Field}
+}
+
+// ErrFileExists is matched, via errors.Is, by the *FileExistsError returned by UploadFiles when ExistsPolicy is
+// ExistsError and a file of the same name is already saved
+var ErrFileExists = errors.New("uploaded file already exists")
+
+// FileExistsError is returned by UploadFiles when a file can't be saved because its name is taken: always with
+// ExistsError, and with ExistsAutoRename when every name tried is taken
+type FileExistsError struct {
+	FileName string
+}
+
+// Error implements the error interface
+func (e *FileExistsError) Error() string {
+	return englishMessage("upload.file_exists", e.FileName)
+}
+
+// Is reports whether target is ErrFileExists
+func (e *FileExistsError) Is(target error) bool {
+	return target == ErrFileExists
+}
+
+func (e *FileExistsError) messageKey() (string, []interface{}) {
+	return "upload.file_exists", []interface{}{e.FileName}
+}
+
+// UploadFileError is the failure of one file of an upload with ContinueOnError
+type UploadFileError struct {
+	FileName string
+	Err      error
+}
+
+// Error implements the error interface
+func (e *UploadFileError) Error() string {
+	return fmt.Sprintf("%s: %s", e.FileName, e.Err)
+}
+
+// Unwrap returns the error the file failed with
+func (e *UploadFileError) Unwrap() error {
+	return e.Err
+}
+
+// ErrNoFiles is returned by UploadFiles and UploadOneFile when the request holds no files, so that an upload never
+// succeeds with no files saved
+var ErrNoFiles error = newMessageError("upload.no_files")
+
+// ErrFileExtensionNotPermitted is matched, via errors.Is, by the *FileExtensionNotPermittedError returned by
+// UploadFiles when a file's name has an extension that is not in AllowedFileExtensions
+var ErrFileExtensionNotPermitted error = newMessageError("upload.ext_not_permitted")
+
+// FileExtensionNotPermittedError is returned when an uploaded file's extension is not in AllowedFileExtensions
+type FileExtensionNotPermittedError struct {
+	FileName  string
+	Extension string
+}
+
+// Error implements the error interface
+func (e *FileExtensionNotPermittedError) Error() string {
+	key, args := e.messageKey()
+	return englishMessage(key, args...)
+}
+
+// Is reports whether target is ErrFileExtensionNotPermitted
+func (e *FileExtensionNotPermittedError) Is(target error) bool {
+	return target == ErrFileExtensionNotPermitted
+}
+
+func (e *FileExtensionNotPermittedError) messageKey() (string, []interface{}) {
+	if e.Extension == "" {
+		return "upload.file_no_ext", []interface{}{e.FileName}
+	}
+	return "upload.file_ext_denied", []interface{}{e.FileName, e.Extension}
+}
+
+// ErrInvalidFileName is returned by UploadFiles when a file is to be saved under its original name, and that name
+// can't be made safe to use in the upload directory
+var ErrInvalidFileName error = newMessageError("upload.invalid_name")
+
+// ErrInvalidJSON is matched, via errors.Is, by every ReadJSON error caused by a malformed body
+var ErrInvalidJSON = errors.New("invalid JSON body")
+
+// ErrJSONTooLarge is matched, via errors.Is, by the ReadJSON error for a body larger than MaxJSONSize
+var ErrJSONTooLarge = errors.New("JSON body is too large")
+
+// ErrEmptyString is returned by Slugify when it is given an empty string
+var ErrEmptyString = errors.New("empty string not permitted")
+
+// ErrEmptySlug is returned by Slugify when nothing is left of the input once disallowed characters are removed
+var ErrEmptySlug = errors.New("after removing characters, slug is zero length")
+
+// ErrInputTooLong is matched, via errors.Is, by the *InputTooLongError Slugify returns for input longer than
+// SlugMaxInputLength
+var ErrInputTooLong = errors.New("input is too long")
+
+// InputTooLongError is returned by Slugify when its input has more than Max runes
+type InputTooLongError struct {
+	Max int
+}
+
+// Error implements the error interface
+func (e *InputTooLongError) Error() string {
+	return fmt.Sprintf("input must not be longer than %d characters", e.Max)
+}
+
+// Is reports whether target is ErrInputTooLong
+func (e *InputTooLongError) Is(target error) bool {
+	return target == ErrInputTooLong
+}
+
+// JSONError describes why ReadJSON could not decode a request body. errors.Is matches Kind, and errors.As reaches
+// Err
+type JSONError struct {
+	Kind    error
+	Message string
+	Key     string
+	Args    []interface{}
+	Err     error
+}
+
+// newJSONError returns a JSONError whose Message is the English text for key
+func newJSONError(kind, err error, key string, args ...interface{}) *JSONError {
+	return &JSONError{Kind: kind, Message: englishMessage(key, args...), Key: key, Args: args, Err: err}
+}
+
+// Error implements the error interface
+func (e *JSONError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the underlying error
+func (e *JSONError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of this error
+func (e *JSONError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *JSONError) messageKey() (string, []interface{}) {
+	return e.Key, e.Args
+}
+
+// errDiskFreeUnsupported is returned by diskFree on platforms where free space cannot be determined
+var errDiskFreeUnsupported = errors.New("disk free space is not supported on this platform")
+
+// logger returns the configured Logger, or the standard logger if none has been set
+func (t *Tools) logger() *log.Logger {
+	if t.Logger != nil {
+		return t.Logger
+	}
+	return log.Default()
+}
+
+// httpClient returns the configured HTTPClient, or a default client if none has been set
+func (t *Tools) httpClient() *http.Client {
+	if t.HTTPClient != nil {
+		return t.HTTPClient
+	}
+	return &http.Client{}
+}
+
+// RandomString returns a string of random characters of length n, using RandomStringSource
+// (or randomStringSource, if that is not set) as the source for the string
 func (t *Tools) RandomString(n int) string {
+	source := randomStringSource
+	if t.RandomStringSource != "" {
+		source = t.RandomStringSource
+	}
+
 	// Create a slice of runes (Unicode characters) of length n
-	s, r := make([]rune, n), []rune(randomStringSource)
+	s, r := make([]rune, n), []rune(source)
 
 	// Loop through each position in the slice of runes
 	for i := range s {
@@ -52,154 +497,643 @@ func (t *Tools) RandomString(n int) string {
 
 // UploadedFile is a struct used to save information about an uploaded file
 type UploadedFile struct {
-	NewFileName      string
+	// NewFileName is the name the file was saved under
+	NewFileName string
+	// StoredPath is where the file was saved, relative to the upload directory and with forward slashes, such as
+	// 2024/05/01/img.png with SubdirDate; it is NewFileName when SubdirStrategy is SubdirNone
+	StoredPath string
+	// SavedPath is the cleaned, absolute path of the file on disk, and is empty for a store other than a DiskStore
+	SavedPath string
+	// MetadataPath is the StoredPath of the sidecar written with WriteMetadata, and is empty when none was
+	MetadataPath     string
 	OriginalFileName string
-	FileSize         int64
+	// FieldName is the form field the file was posted under, and is empty for a file that wasn't posted in a
+	// multipart form, such as one from UploadFromURL
+	FieldName string
+	FileSize  int64
+	// ContentType is the type detected from the first bytes of the file, by DetectContentTypeFunc when it is set
+	// or else by http.DetectContentType, whether or not AllowedFileTypes is set
+	ContentType string
+	// SHA256 is the hex encoded SHA-256 hash of the file, set when something needed it
+	SHA256 string
+	// Deduplicated reports that a file with the same content was already stored, under its hash or where
+	// ExistsByHash said, so nothing was written. Such a file is never removed, even when the upload fails
+	Deduplicated bool
+	// Variants maps the Suffix of each of Thumbnails to the StoredPath of the thumbnail saved for an image, and is
+	// nil when none was
+	Variants map[string]string
+	// ExtractedFiles holds the StoredPath of each file extracted from a ZIP archive with ExtractArchives
+	ExtractedFiles []string
+	// Duration is how long the file took to save, thumbnails and checks included
+	Duration time.Duration
+	// BytesPerSecond is FileSize over Duration
+	BytesPerSecond float64
 }
 
 // UploadOneFile is just a convenience method that calls UploadFiles, but expects only one file to
-// be in the upload.
+// be in the upload. More than one gets a *MultipleFilesError, unless TakeFirstFile is set
 func (t *Tools) UploadOneFile(r *http.Request, uploadDir string, rename ...bool) (*UploadedFile, error) {
 	renameFile := true
 	if len(rename) > 0 {
 		renameFile = rename[0]
 	}
 
-	files, err := t.UploadFiles(r, uploadDir, renameFile)
+	files, err := t.uploadFiles(r.Context(), r, uploadDir, renameFile, true, nil)
 	if err != nil {
 		return nil, err
 	}
+	// uploadFiles returns ErrNoFiles for a request without files, but a handler must never panic on files[0]
+	if len(files) == 0 {
+		return nil, ErrNoFiles
+	}
 
 	return files[0], nil
 }
 
 // UploadFiles uploads one or more file to a specified directory, and gives the files a random name.
 // It returns a slice containing the newly named files, the original file names, the size of the files,
-// and potentially an error. If the optional last parameter is set to true, then we will not rename
+// and potentially an error. If the option WithRename(false) is passed, then we will not rename
 // the files, but will use the original file names.
 // UploadFiles handles the process of uploading files via HTTP Request
-func (t *Tools) UploadFiles(r *http.Request, uploadDir string, rename ...bool) ([]*UploadedFile, error) {
-	// Determine whether to rename the uploaded files or not
-	renameFile := true
-	if len(rename) > 0 {
-		renameFile = rename[0]
+func (t *Tools) UploadFiles(r *http.Request, uploadDir string, opts ...UploadOption) ([]*UploadedFile, error) {
+	return t.UploadFilesContext(r.Context(), r, uploadDir, opts...)
+}
+
+// UploadFilesContext is like UploadFiles, but stops when ctx is done, removing what it has written, and returns
+// ctx.Err()
+func (t *Tools) UploadFilesContext(ctx context.Context, r *http.Request, uploadDir string, opts ...UploadOption) ([]*UploadedFile, error) {
+	call, cfg := t.withOptions(opts)
+	return call.uploadFiles(ctx, r, uploadDir, cfg.rename, false, cfg.fields)
+}
+
+// uploadFiles does the work of UploadFilesContext, and of UploadOneFile when single is set
+func (t *Tools) uploadFiles(ctx context.Context, r *http.Request, uploadDir string, renameFile bool, single bool, fields []string) ([]*UploadedFile, error) {
+	err := t.CreateDirIfNotExist(uploadDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not create upload directory: %w", err)
 	}
 
+	// When the directory has a quota, refuse straight away if it is already full
+	if err := t.checkDirQuota(uploadDir, false); err != nil {
+		return nil, err
+	}
+	// With CheckFreeSpace, refuse an upload the disk hasn't room for before any of it is read
+	if err := t.checkFreeSpace(r.ContentLength, uploadDir); err != nil {
+		return nil, err
+	}
+
+	// Save the files to the upload directory
+	store := t.diskStore(uploadDir)
+	uploadedFiles, err := t.uploadFilesTo(ctx, r, store, renameFile, single, fields)
+	if err != nil && !t.partialUpload(uploadedFiles, err) {
+		return uploadedFiles, err
+	}
+
+	// Uploads running at the same time may each fit on their own, so the quota is checked again now that the
+	// files are on disk, and this upload is undone if together they went over
+	if err := t.checkDirQuota(uploadDir, true); err != nil {
+		return t.undoUpload(store, uploadedFiles, err)
+	}
+	// Return the slice containing information about uploaded files, and about those that failed with ContinueOnError,
+	// once AfterSave has been called for them
+	return t.afterSaveAll(ctx, store, uploadedFiles, err)
+}
+
+// uploadFilesTo does the work of UploadFilesTo. When single is set, it saves one file, as UploadOneFile does, rather
+// than up to MaxFiles, and when fields is set, only the files posted under them, as WithFields does
+func (t *Tools) uploadFilesTo(ctx context.Context, r *http.Request, store FileStore, renameFile bool, single bool, fields []string) ([]*UploadedFile, error) {
 	// Initialize a slice to hold information about the uploaded files
 	var uploadedFiles []*UploadedFile
 
-	// If MaxFileSize is not set, default to 1GB
-	if t.MaxFileSize == 0 {
-		t.MaxFileSize = 1024 * 1024 * 1024
+	// If MaxFileSize is not set, default to 1GB. The default is kept local so that a shared Tools is never written to
+	maxFileSize := defaultMaxFileSize
+	if t.MaxFileSize != 0 {
+		maxFileSize = t.MaxFileSize
 	}
 
-	err := t.CreateDirIfNotExist(uploadDir)
+	// Parse the multipart form data from the HTTP Request, reading only the files under permitted fields
+	limit := formFileLimit{max: t.MaxFiles, accept: func(field string) bool {
+		if len(fields) > 0 && !formFieldAllowed(field, fields) {
+			return false
+		}
+		return t.StrictFormFields || formFieldAllowed(field, t.AllowedFormFields)
+	}}
+	if single {
+		limit.max, limit.count = 1, true
+	}
+	form, err := t.parseUploadForm(r, int64(maxFileSize), limit)
 	if err != nil {
 		return nil, err
 	}
+	defer form.release()
 
-	// Parse the multipart form data from the HTTP Request
-	err = r.ParseMultipartForm(int64(t.MaxFileSize))
-	if err != nil {
-		// Return an error if the uploaded file exceeds the maximum allowed size
-		return nil, errors.New("the uploaded file is too big")
+	// Gather the files posted under the permitted form fields, in the order they were posted. A form the caller
+	// parsed first has every file, so the fields are checked again
+	var fileHeaders []formFile
+	for _, file := range form.files {
+		if !limit.accept(file.field) {
+			continue
+		}
+		if !formFieldAllowed(file.field, t.AllowedFormFields) {
+			return nil, &FormFieldError{Field: file.field}
+		}
+		fileHeaders = append(fileHeaders, file)
+	}
+
+	// Refuse a request with too many files before any of them is written
+	switch count := len(fileHeaders) + form.skipped; {
+	case single && count > 1 && t.TakeFirstFile:
+		fileHeaders = fileHeaders[:1]
+	case single && count > 1:
+		return nil, &MultipleFilesError{Count: count}
+	case !single && t.MaxFiles > 0 && count > t.MaxFiles:
+		return nil, &TooManyFilesError{Limit: t.MaxFiles}
+	}
+	// The multipart reader has measured every file, so a request whose files come to more than MaxTotalUploadSize is
+	// refused before any of them is written too
+	if t.MaxTotalUploadSize > 0 {
+		var total int64
+		for _, file := range fileHeaders {
+			total += file.size
+		}
+		if total > t.MaxTotalUploadSize {
+			return nil, &RequestTooLargeError{Limit: t.MaxTotalUploadSize}
+		}
 	}
 
-	// Iterate through each file in the multipart form data
-	for _, fHeaders := range r.MultipartForm.File {
-		for _, hdr := range fHeaders {
+	// With ContinueOnError, the files that fail are collected here and the rest are saved all the same
+	var failures MultiError
+	if t.Concurrency > 1 && len(fileHeaders) > 1 {
+		// With Concurrency, several files are saved at once
+		uploadedFiles, failures, err = t.saveUploadedFilesConcurrently(ctx, fileHeaders, store, renameFile)
+		if err != nil {
+			return uploadedFiles, err
+		}
+	} else {
+		// Iterate through each file in the multipart form data
+		for _, file := range fileHeaders {
+			if err := ctx.Err(); err != nil {
+				return t.undoUpload(store, uploadedFiles, err)
+			}
+
 			// Process each file individually
-			uploadedFiles, err = func(uploadedFiles []*UploadedFile) ([]*UploadedFile, error) {
-				var uploadedFile UploadedFile
+			uploadedFile, err := t.saveUploadedFile(ctx, file, store, renameFile)
+			if err != nil && t.ContinueOnError {
+				failures = append(failures, &UploadFileError{FileName: file.name, Err: err})
+				continue
+			}
+			if err != nil {
+				return t.undoUpload(store, uploadedFiles, err)
+			}
 
-				// Open the uploaded file for reading
-				infile, err := hdr.Open()
-				if err != nil {
-					return nil, err
-				}
-				defer infile.Close()
+			// Append information about the uploaded file to the slice
+			uploadedFiles = append(uploadedFiles, uploadedFile)
+		}
+	}
 
-				// Read the first 512 bytes of the file to determine its type
-				buff := make([]byte, 512)
-				_, err = infile.Read(buff)
-				if err != nil {
-					return nil, err
-				}
+	if len(uploadedFiles) == 0 {
+		if len(failures) > 0 {
+			return nil, failures
+		}
+		return nil, ErrNoFiles
+	}
 
-				// Check if the file type is permitted based on AllowedFileTypes
-				allowed := false
-				fileType := http.DetectContentType(buff)
-
-				if len(t.AllowedFileTypes) > 0 {
-					for _, x := range t.AllowedFileTypes {
-						if strings.EqualFold(fileType, x) {
-							allowed = true
-						}
-					}
-				} else {
-					allowed = true
-				}
+	// The metadata sidecars are only written once every file has been saved
+	if err := t.writeMetadata(ctx, store, uploadedFiles, form.values); err != nil {
+		return t.undoUpload(store, uploadedFiles, err)
+	}
 
-				// If the file type is not permitted, return an error
-				if !allowed {
-					return nil, errors.New("the uploaded file type is not permitted")
-				}
+	if len(failures) > 0 {
+		return uploadedFiles, failures
+	}
+	return uploadedFiles, nil
+}
 
-				// Reset file read pointer to the beginning
-				_, err = infile.Seek(0, 0)
-				if err != nil {
-					return nil, err
-				}
+// checkDirQuota returns ErrDirQuotaExceeded when uploadDir holds more than MaxDirBytes, or, before an upload has
+// written anything, when it is already full
+func (t *Tools) checkDirQuota(uploadDir string, written bool) error {
+	if t.MaxDirBytes <= 0 {
+		return nil
+	}
 
-				// Determine the new file name
-				if renameFile {
-					uploadedFile.NewFileName = fmt.Sprintf("%s%s", t.RandomString(25), filepath.Ext(hdr.Filename))
-				} else {
-					uploadedFile.NewFileName = hdr.Filename
-				}
+	used, err := dirSize(uploadDir)
+	if err != nil {
+		return fmt.Errorf("could not measure upload directory: %w", err)
+	}
+	if used > t.MaxDirBytes || (!written && used == t.MaxDirBytes) {
+		return ErrDirQuotaExceeded
+	}
+	return nil
+}
+
+// checkFreeSpace returns an *InsufficientStorageError when CheckFreeSpace is set and uploadDir has less than size,
+// or MaxFileSize, plus FreeSpaceHeadroom free
+func (t *Tools) checkFreeSpace(size int64, uploadDir string) error {
+	if !t.CheckFreeSpace {
+		return nil
+	}
+
+	needed := int64(defaultMaxFileSize)
+	if t.MaxFileSize != 0 {
+		needed = int64(t.MaxFileSize)
+	}
+	if size > 0 && size < needed {
+		needed = size
+	}
+
+	free, err := diskFree(uploadDir)
+	if errors.Is(err, errDiskFreeUnsupported) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not check free disk space: %w", err)
+	}
+
+	required := float64(needed) * (1 + t.FreeSpaceHeadroom)
+	if float64(free) < required {
+		e := &InsufficientStorageError{Required: math.MaxUint64, Available: free}
+		if required < math.MaxUint64 {
+			e.Required = uint64(math.Ceil(required))
+		}
+		return e
+	}
+	return nil
+}
+
+// uploadBufferSize is the size of the pooled buffers used to copy uploaded files to disk
+const uploadBufferSize = 128 * 1024
+
+// uploadBufferPool holds *[]byte buffers of uploadBufferSize, shared by every upload so that copying a file doesn't
+// allocate. The first 512 bytes of a buffer double as the space used to sniff the file's content type
+var uploadBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, uploadBufferSize)
+		return &buf
+	},
+}
+
+// sniffLen is the number of bytes http.DetectContentType looks at
+const sniffLen = 512
+
+// sniffLength returns the number of bytes of an uploaded file read to detect its type, at most uploadBufferSize
+func (t *Tools) sniffLength() int {
+	switch {
+	case t.SniffLength > uploadBufferSize:
+		return uploadBufferSize
+	case t.SniffLength > 0:
+		return t.SniffLength
+	default:
+		return sniffLen
+	}
+}
+
+// detectContentType returns the type of the uploaded file filename, whose first bytes are head, by
+// DetectContentTypeFunc or else http.DetectContentType
+func (t *Tools) detectContentType(head []byte, filename string) string {
+	var fileType string
+	if t.DetectContentTypeFunc != nil {
+		fileType = t.DetectContentTypeFunc(head, filename)
+	}
+	if fileType == "" {
+		fileType = http.DetectContentType(head)
+	}
+
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+	return t.uploadContentType(fileType, head)
+}
+
+// fileTypeAllowed reports whether fileType matches one of allowed, which may hold wildcards such as "image/*"
+func fileTypeAllowed(fileType string, allowed []string) bool {
+	return len(allowed) == 0 || fileTypeMatches(fileType, allowed)
+}
 
-				// Store the original file name
-				uploadedFile.OriginalFileName = hdr.Filename
+// fileTypeDenied reports whether the detected fileType matches one of the entries of denied, which are given as for
+// fileTypeAllowed. An empty list denies nothing
+func fileTypeDenied(fileType string, denied []string) bool {
+	return len(denied) > 0 && fileTypeMatches(fileType, denied)
+}
+
+// fileSizeLimit returns the most bytes a file of fileType may hold, by SizeLimitsByType or MaxFilePerSize, and the
+// error for a file that holds more
+func (t *Tools) fileSizeLimit(filename, fileType string) (int64, error) {
+	mediaType := fileType
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = strings.TrimSpace(mediaType[:i])
+	}
 
-				// Create a new file in the upload directory
-				var outfile *os.File
-				if outfile, err = os.Create(filepath.Join(uploadDir, uploadedFile.NewFileName)); err != nil {
-					return nil, err
+	if len(t.SizeLimitsByType) > 0 {
+		wildcard := "*/*"
+		if i := strings.IndexByte(mediaType, '/'); i >= 0 {
+			wildcard = mediaType[:i] + "/*"
+		}
+		for _, key := range []string{mediaType, wildcard, "*/*"} {
+			for pattern, limit := range t.SizeLimitsByType {
+				if strings.EqualFold(pattern, key) {
+					return limit, &FileTooBigError{FileName: filename, ContentType: mediaType, Limit: limit}
 				}
-				defer outfile.Close()
+			}
+		}
+	}
+	return t.MaxFilePerSize, &FileTooBigError{FileName: filename, Limit: t.MaxFilePerSize}
+}
+
+// fileTypeMatches reports whether fileType matches one of patterns, ignoring case and any parameters of fileType
+func fileTypeMatches(fileType string, patterns []string) bool {
+	// DetectContentType adds parameters to some types, as in "text/plain; charset=utf-8"
+	mediaType := fileType
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = strings.TrimSpace(mediaType[:i])
+	}
+
+	for _, x := range patterns {
+		switch {
+		case x == "*/*":
+			return true
+		case strings.HasSuffix(x, "/*"):
+			prefix := x[:len(x)-1]
+			if len(mediaType) > len(prefix) && strings.EqualFold(mediaType[:len(prefix)], prefix) {
+				return true
+			}
+		case strings.EqualFold(fileType, x), strings.EqualFold(mediaType, x):
+			return true
+		}
+	}
+	return false
+}
+
+// fileExtensionAllowed reports whether the extension of filename, which may be one of compound, is one of allowed
+func fileExtensionAllowed(filename string, allowed, compound []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
 
-				// Copy the content of the uploaded file to the newly created file
-				fileSize, err := io.Copy(outfile, infile)
-				if err != nil {
-					return nil, err
+	ext := strings.TrimPrefix(fileExt(filename, compound), ".")
+	if ext == "" {
+		return false
+	}
+	for _, x := range allowed {
+		if strings.EqualFold(ext, strings.TrimPrefix(x, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFileExtension returns a *FileExtensionNotPermittedError when the extension of filename is not in
+// AllowedFileExtensions
+func (t *Tools) checkFileExtension(filename string) error {
+	if fileExtensionAllowed(filename, t.AllowedFileExtensions, t.compoundExtensions()) {
+		return nil
+	}
+	return &FileExtensionNotPermittedError{FileName: filename, Extension: fileExt(filename, t.compoundExtensions())}
+}
+
+// minFileSize returns the smallest size an uploaded file may have: MinFileSize, or 1 byte when that is not set, so
+// that empty files are always refused
+func (t *Tools) minFileSize() int64 {
+	if t.MinFileSize > 1 {
+		return t.MinFileSize
+	}
+	return 1
+}
+
+// formFieldAllowed reports whether files may be posted under the form field named field. An empty list allows every
+// field
+func formFieldAllowed(field string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	for _, name := range allowed {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// saveUploadedFilesConcurrently saves fileHeaders to store, Concurrency at a time, in order. ContinueOnError failures
+// are returned apart from the error
+func (t *Tools) saveUploadedFilesConcurrently(ctx context.Context, fileHeaders []formFile, store FileStore, renameFile bool) ([]*UploadedFile, MultiError, error) {
+	workers := t.Concurrency
+	if workers > len(fileHeaders) {
+		workers = len(fileHeaders)
+	}
+
+	// Each worker writes only to the entries of the files it saves, so the results need no lock
+	results := make([]*UploadedFile, len(fileHeaders))
+	errs := make([]error, len(fileHeaders))
+	var failed int32
+
+	rs := &requestStore{FileStore: store}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i], errs[i] = t.saveUploadedFile(ctx, fileHeaders[i], rs, renameFile)
+				if errs[i] != nil && !t.ContinueOnError {
+					atomic.StoreInt32(&failed, 1)
 				}
-				uploadedFile.FileSize = fileSize
+			}
+		}()
+	}
+
+	for i := range fileHeaders {
+		if atomic.LoadInt32(&failed) != 0 || ctx.Err() != nil {
+			break
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	var uploadedFiles []*UploadedFile
+	var failures MultiError
+	for i, uploadedFile := range results {
+		if errs[i] != nil && t.ContinueOnError {
+			failures = append(failures, &UploadFileError{FileName: fileHeaders[i].name, Err: errs[i]})
+		} else if errs[i] != nil {
+			failures = append(failures, errs[i])
+		} else if uploadedFile != nil {
+			uploadedFiles = append(uploadedFiles, uploadedFile)
+		}
+	}
+
+	var err error
+	switch {
+	case ctx.Err() != nil:
+		// every file still being saved fails with the same error, which is reported once
+		uploadedFiles, err = t.undoUpload(store, uploadedFiles, ctx.Err())
+		return uploadedFiles, nil, err
+	case t.ContinueOnError:
+		return uploadedFiles, failures, nil
+	case len(failures) == 1:
+		uploadedFiles, err = t.undoUpload(store, uploadedFiles, failures[0])
+	case len(failures) > 1:
+		uploadedFiles, err = t.undoUpload(store, uploadedFiles, failures)
+	}
+	return uploadedFiles, nil, err
+}
 
-				// Append information about the uploaded file to the slice
-				uploadedFiles = append(uploadedFiles, &uploadedFile)
+// partialUpload reports whether err is the MultiError of the files that failed when the others, uploadedFiles, were
+// saved with ContinueOnError
+func (t *Tools) partialUpload(uploadedFiles []*UploadedFile, err error) bool {
+	_, failures := err.(MultiError)
+	return t.ContinueOnError && failures && len(uploadedFiles) > 0
+}
 
-				return uploadedFiles, nil
-			}(uploadedFiles)
+// saveUploadedFile checks the type of one uploaded file and saves it to store
+func (t *Tools) saveUploadedFile(ctx context.Context, file formFile, store FileStore, renameFile bool) (*UploadedFile, error) {
+	var uploadedFile UploadedFile
+	filename := file.name
 
-			// Check for any errors during file processing
-			if err != nil {
-				return uploadedFiles, err
+	// Check the extension first, as it doesn't need the file to be read
+	if err := t.checkFileExtension(filename); err != nil {
+		return nil, err
+	}
+
+	// Open the uploaded file for reading
+	infile, err := file.open()
+	if err != nil {
+		return nil, fmt.Errorf("could not open uploaded file %q: %w", filename, err)
+	}
+	defer infile.Close()
+
+	bufp := uploadBufferPool.Get().(*[]byte)
+	defer uploadBufferPool.Put(bufp)
+	buf := *bufp
+
+	// Empty files, and files below MinFileSize, are refused before they are read
+	if minSize := t.minFileSize(); file.size < minSize {
+		return nil, &FileTooSmallError{FileName: filename, Limit: minSize}
+	}
+
+	// Read the first sniffLength bytes of the file, or all of it when it is shorter, to determine its type. Only the
+	// bytes actually read are sniffed, as the rest of the pooled buffer holds whatever was last copied through it
+	sniff := buf[:t.sniffLength()]
+	n, err := io.ReadFull(infile, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("could not read uploaded file %q: %w", filename, err)
+	}
+
+	// Check if the file type is permitted based on AllowedFileTypes
+	fileType := t.detectContentType(sniff[:n], filename)
+	if t.svgRefused(fileType) || !fileTypeAllowed(fileType, t.AllowedFileTypes) || fileTypeDenied(fileType, t.DeniedFileTypes) {
+		return nil, &FileTypeError{FileName: filename, ContentType: fileType}
+	}
+	if err := t.checkExtensionMatches(filename, fileType); err != nil {
+		return nil, err
+	}
+
+	// The size of the part is known from the form, so a file that is too big is refused before it is written
+	if limit, tooBig := t.fileSizeLimit(filename, fileType); limit > 0 && file.size > limit {
+		return nil, tooBig
+	}
+
+	// Refuse an image that is too large from its header, before the rest of it is copied
+	if t.checksImageSize() {
+		if _, err := infile.Seek(0, 0); err != nil {
+			return nil, fmt.Errorf("could not read uploaded file %q: %w", filename, err)
+		}
+		if err := t.checkImageSize(filename, fileType, infile); err != nil {
+			if errors.Is(err, ErrInvalidImage) || errors.Is(err, ErrImageTooLarge) {
+				return nil, err
 			}
+			return nil, fmt.Errorf("could not read uploaded file %q: %w", filename, err)
 		}
 	}
-	// Return the slice containing information about uploaded files
-	return uploadedFiles, nil
+
+	// Reset file read pointer to the beginning
+	_, err = infile.Seek(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("could not read uploaded file %q: %w", filename, err)
+	}
+
+	// Store the original file name, the form field and the detected type
+	uploadedFile.OriginalFileName = filename
+	uploadedFile.FieldName = file.field
+	uploadedFile.ContentType = fileType
+
+	// An SVG image is saved sanitized under SVGSanitize
+	var src io.Reader = infile
+	size := file.size
+	if t.sanitizesSVG(fileType) {
+		clean, err := t.sanitizeUploadedSVG(filename, fileType, infile)
+		if err != nil {
+			if errors.Is(err, ErrInvalidImage) || errors.Is(err, ErrFileTooBig) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("could not read uploaded file %q: %w", filename, err)
+		}
+		src, size = clean, int64(clean.Len())
+	}
+
+	// An image is saved converted to NormalizeImageFormat, when that is set
+	if t.normalizesImage(fileType) {
+		converted, err := t.normalizeUploadedImage(filename, fileType, src)
+		if err != nil {
+			if errors.Is(err, ErrInvalidImage) || errors.Is(err, ErrAnimatedImage) || errors.Is(err, ErrFileTooBig) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("could not read uploaded file %q: %w", filename, err)
+		}
+		src, size = converted, int64(converted.Len())
+		uploadedFile.ContentType = normalizedImageTypes[t.NormalizeImageFormat]
+	}
+
+	// Save the file under its new name. Its size has been checked against the header, which the multipart reader
+	// fills in as it reads the part, so only the limit for its type is checked again while it is copied
+	if err := t.saveToStore(ctx, store, src, size, &uploadedFile, renameFile, 0); err != nil {
+		return nil, err
+	}
+
+	return &uploadedFile, nil
 }
 
-// CreateDirIfNotExist creates a directory, and all necessary parents, if it does not exist
-func (t *Tools) CreateDirIfNotExist(path string) error {
-	// Define file mode (permissions for the directory)
-	const mode = 0755
+// uploadMaxMemory is the most of a multipart form held in memory; larger files are spooled to temporary files
+const uploadMaxMemory = 32 << 20
+
+// parseUploadForm reads the multipart form of r with readUploadForm, reporting a body over maxSize as ErrFileTooBig
+func (t *Tools) parseUploadForm(r *http.Request, maxSize int64, limit formFileLimit) (*uploadForm, error) {
+	if r.MultipartForm != nil {
+		// ParseMultipartForm returns an error for a form that was read with r.MultipartReader, as by an earlier upload
+		if err := r.ParseMultipartForm(0); err != nil {
+			return nil, &messageError{key: "upload.malformed_form", err: err}
+		}
+		return newUploadForm(r.MultipartForm), nil
+	}
+	r.Body = http.MaxBytesReader(nil, r.Body, maxSize)
+
+	form, err := t.readUploadForm(r, t.multipartMemoryLimit(maxSize), limit)
+	if err == nil {
+		return form, nil
+	}
+
+	var tooMany *TooManyFilesError
+	if errors.As(err, &tooMany) {
+		return nil, err
+	}
+	var maxBytesError *http.MaxBytesError
+	if errors.Is(err, multipart.ErrMessageTooLarge) || errors.As(err, &maxBytesError) {
+		// Return an error if the uploaded file exceeds the maximum allowed size
+		return nil, ErrFileTooBig
+	}
+
+	return nil, &messageError{key: "upload.malformed_form", err: err}
+}
 
+// CreateDirIfNotExist creates a directory, and all necessary parents, if it does not exist. The directory is given
+// the permissions DirMode, or 0755 less the umask when that is zero
+func (t *Tools) CreateDirIfNotExist(path string) error {
 	// Check if the directory already exists or not
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		// If the directory does not exist, create it along with any necessary parent directories
-		err := os.MkdirAll(path, mode)
+		err := makeDir(path, t.DirMode)
 		if err != nil {
 			return err
 		}
@@ -208,20 +1142,107 @@ func (t *Tools) CreateDirIfNotExist(path string) error {
 	return nil
 }
 
+// makeDir creates the directory path, and all necessary parents, with 0755 less the umask, and then gives path
+// itself the permissions mode, whatever the umask, when that is not zero
+func makeDir(path string, mode os.FileMode) error {
+	// Define file mode (permissions for the directory)
+	const defaultMode = 0755
+
+	if err := os.MkdirAll(path, defaultMode); err != nil {
+		return err
+	}
+	if mode != 0 {
+		return os.Chmod(path, mode)
+	}
+	return nil
+}
+
+// WriteFileAtomic writes data to the file at path through a temporary file that replaces it, so readers never see
+// it part written
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// dirSize returns the total size of the regular files in dir and its subdirectories
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// exceedsRunes reports whether s has more than n runes, without counting past n+1
+func exceedsRunes(s string, n int) bool {
+	if len(s) <= n {
+		return false
+	}
+
+	count := 0
+	for range s {
+		count++
+		if count > n {
+			return true
+		}
+	}
+	return false
+}
+
+// slugDisallowed matches any characters that are not lowercase letters or digits. It is compiled once, and a
+// *regexp.Regexp is safe for concurrent use
+var slugDisallowed = regexp.MustCompile(`[^a-z\d]+`)
+
 // Slugify is a (very) simple means of creating a slug from a string
 // Takes a string 's' and converts it into a slug, which is a URL-friendly version of the string
 func (t *Tools) Slugify(s string) (string, error) {
 	if s == "" {
-		return "", errors.New("empty string not permitted")
+		return "", ErrEmptyString
 	}
 
-	// Defining a regular expression pattern to match any characters that are not lowercase letters or digits.
-	var re = regexp.MustCompile(`[^a-z\d]+`)
+	maxLength := defaultSlugMaxInputLength
+	if t.SlugMaxInputLength != 0 {
+		maxLength = t.SlugMaxInputLength
+	}
+	if exceedsRunes(s, maxLength) {
+		return "", &InputTooLongError{Max: maxLength}
+	}
 
 	// Convert the input string to lowercase and replace any characters that do not match the pattern with a hyphen ("-").
-	slug := strings.Trim(re.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	slug := strings.Trim(slugDisallowed.ReplaceAllString(strings.ToLower(s), "-"), "-")
 	if len(slug) == 0 {
-		return "", errors.New("after removing characters, slug is zero length")
+		return "", ErrEmptySlug
 	}
 
 	// If all checks pass, return the slug, which is the URL-friendly version of the input string, and nil (indicating no error).
@@ -251,9 +1272,10 @@ type JSONResponse struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
-// ReadJSON tries to read the body of a request and converts from json into a go data variable
+// ReadJSON tries to read the body of a request and converts from json into a go data variable,
+// then applies SanitizeJSON and ValidateJSON when they are set
 func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
-	maxBytes := 1024 * 1024 // 1 MB
+	maxBytes := defaultMaxJSONSize
 	if t.MaxJSONSize != 0 {
 		maxBytes = t.MaxJSONSize
 	}
@@ -268,42 +1290,59 @@ func (t *Tools) ReadJSON(w http.ResponseWriter, r *http.Request, data interface{
 
 	err := dec.Decode(data)
 	if err != nil {
-		var syntaxError *json.SyntaxError
-		var unmarshalTypeError *json.UnmarshalTypeError
-		var invalidUnmarshalError *json.InvalidUnmarshalError
+		return jsonDecodeError(err, maxBytes)
+	}
 
-		switch {
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly formed JSON (at character %d)", syntaxError.Offset)
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly formed JSON")
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
-		case errors.Is(err, io.EOF):
-			return errors.New("body must not be empty")
-		case strings.HasPrefix(err.Error(), "json: unknown field"):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-		case err.Error() == "http: request body too large":
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytes)
-		case errors.As(err, &invalidUnmarshalError):
-			return fmt.Errorf("error unmarshaling JSON: %s", err.Error())
-		default:
+	err = dec.Decode(&struct{}{}) // decode more JSON from that file
+	if err != io.EOF {
+		return newJSONError(ErrInvalidJSON, err, "json.multiple_values")
+	}
+
+	if t.SanitizeJSON {
+		if err := t.sanitizeStruct(data); err != nil {
 			return err
 		}
 	}
 
-	err = dec.Decode(&struct{}{}) // decode more JSON from that file
-	if err != io.EOF {
-		return errors.New("body must contain only one JSON value")
+	if t.ValidateJSON {
+		return t.ValidateStruct(data)
 	}
 
 	return nil
 }
 
+// jsonDecodeError turns an error from decoding a JSON value of at most maxBytes into the error returned by ReadJSON
+func jsonDecodeError(err error, maxBytes int) error {
+	var syntaxError *json.SyntaxError
+	var unmarshalTypeError *json.UnmarshalTypeError
+	var invalidUnmarshalError *json.InvalidUnmarshalError
+
+	var maxBytesError *http.MaxBytesError
+
+	switch {
+	case errors.As(err, &syntaxError):
+		return newJSONError(ErrInvalidJSON, err, "json.badly_formed_at", syntaxError.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return newJSONError(ErrInvalidJSON, err, "json.badly_formed")
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field != "" {
+			return newJSONError(ErrInvalidJSON, err, "json.wrong_type_field", unmarshalTypeError.Field)
+		}
+		return newJSONError(ErrInvalidJSON, err, "json.wrong_type_at", unmarshalTypeError.Offset)
+	case errors.Is(err, io.EOF):
+		return newJSONError(ErrInvalidJSON, err, "json.empty_body")
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		fieldName := strings.Trim(strings.TrimSpace(strings.TrimPrefix(err.Error(), "json: unknown field")), `"`)
+		return newJSONError(ErrInvalidJSON, err, "json.unknown_field", fieldName)
+	case errors.As(err, &maxBytesError):
+		return newJSONError(ErrJSONTooLarge, err, "json.body_too_large", maxBytes)
+	case errors.As(err, &invalidUnmarshalError):
+		return fmt.Errorf("error unmarshaling JSON: %w", err)
+	default:
+		return err
+	}
+}
+
 // WriteJSON takes a response status code and arbitrary data and write json to the client
 func (t *Tools) WriteJSON(w http.ResponseWriter, status int, data interface{}, headers ...http.Header) error {
 	out, err := json.Marshal(data)
@@ -342,16 +1381,16 @@ func (t *Tools) ErrorJSON(w http.ResponseWriter, err error, status ...int) error
 }
 
 // PushJSONToRemote posts arbitrary data to some URL as JSON, and returns the response, status code and error (if any)
-// The final parameter, client, is optional. If none is specified, we use the standard http.Client
+// The final parameter, client, is optional. If none is specified, we use Tools.HTTPClient
 func (t *Tools) PushJSONToRemote(uri string, data interface{}, client ...*http.Client) (*http.Response, int, error) {
 	// create json
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, fmt.Errorf("could not encode JSON: %w", err)
 	}
 
 	// check for custom http client
-	httpClient := &http.Client{}
+	httpClient := t.httpClient()
 	if len(client) > 0 {
 		httpClient = client[0]
 	}
@@ -359,17 +1398,17 @@ func (t *Tools) PushJSONToRemote(uri string, data interface{}, client ...*http.C
 	// build the request and set the header
 	request, err := http.NewRequest("POST", uri, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, fmt.Errorf("could not create request: %w", err)
 	}
 	request.Header.Set("Content-Type", "application/json")
 
 	// call the remote URI
 	response, err := httpClient.Do(request)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, fmt.Errorf("could not push JSON to remote: %w", err)
 	}
 	defer response.Body.Close()
 
 	// send response back
 	return response, response.StatusCode, nil
-}
\ No newline at end of file
+}
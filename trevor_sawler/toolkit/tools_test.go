@@ -2,6 +2,7 @@ package toolkit
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,6 +13,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -127,7 +133,7 @@ func TestTools_UploadFiles(t *testing.T) {
 		var testTools Tools
 		testTools.AllowedFileTypes = e.allowedTypes
 
-		uploadedFiles, err := testTools.UploadFiles(request, "./testdata/uploads/", e.renameFile)
+		uploadedFiles, err := testTools.UploadFiles(request, "./testdata/uploads/", WithRename(e.renameFile))
 		if err != nil && !e.errorExpected {
 			t.Error(err)
 		}
@@ -198,6 +204,47 @@ func TestTools_UploadOneFile(t *testing.T) {
 	_ = os.Remove(fmt.Sprintf("./testdata/uploads/%s", uploadedFiles.NewFileName))
 }
 
+func TestTools_UploadOneFileNoFiles(t *testing.T) {
+	fileless := func(fields ...testUpload) func() *http.Request {
+		return func() *http.Request { return newUploadRequest(t, fields...) }
+	}
+
+	var tests = []struct {
+		name    string
+		request func() *http.Request
+	}{
+		{name: "text fields only", request: fileless(testUpload{"title", "", []byte("holiday")}, testUpload{"tags", "", []byte("beach")})},
+		{name: "empty form", request: fileless()},
+	}
+
+	for _, e := range tests {
+		for _, testTools := range []Tools{{}, {TakeFirstFile: true}, {ContinueOnError: true}} {
+			dir := t.TempDir()
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				file, err := testTools.UploadOneFile(r, dir)
+				if err != nil {
+					_ = testTools.ErrorJSON(w, err, uploadErrorStatus(err))
+					return
+				}
+				_ = testTools.WriteJSON(w, http.StatusCreated, file)
+			}
+
+			rr := httptest.NewRecorder()
+			handler(rr, e.request())
+			if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), englishMessage("upload.no_files")) {
+				t.Errorf("%s: expected a 400 for no files, got %d %s", e.name, rr.Code, rr.Body.String())
+			}
+
+			if _, err := testTools.UploadOneFile(e.request(), dir); !errors.Is(err, ErrNoFiles) {
+				t.Errorf("%s: expected %v, got %v", e.name, ErrNoFiles, err)
+			}
+			if files, err := testTools.UploadFiles(e.request(), dir); files != nil || !errors.Is(err, ErrNoFiles) {
+				t.Errorf("%s: expected UploadFiles to return no files and %v, got %v and %v", e.name, ErrNoFiles, files, err)
+			}
+		}
+	}
+}
+
 func TestTools_CreateDirIfNotExist(t *testing.T) {
 	var testTool Tools
 
@@ -392,3 +439,1050 @@ func TestTools_ErrorJSON(t *testing.T) {
 		t.Errorf("wrong status code returned; expected 503, but got %d", rr.Code)
 	}
 }
+
+var fileTypeAllowedTests = []struct {
+	name     string
+	fileType string
+	allowed  []string
+	expected bool
+}{
+	{name: "empty list", fileType: "application/pdf", expected: true},
+	{name: "exact", fileType: "image/png", allowed: []string{"image/png"}, expected: true},
+	{name: "exact is case-insensitive", fileType: "image/png", allowed: []string{"IMAGE/PNG"}, expected: true},
+	{name: "wildcard", fileType: "image/webp", allowed: []string{"image/*"}, expected: true},
+	{name: "wildcard is case-insensitive", fileType: "image/gif", allowed: []string{"Image/*"}, expected: true},
+	{name: "wildcard with parameters", fileType: "text/plain; charset=utf-8", allowed: []string{"text/*"}, expected: true},
+	{name: "exact with parameters", fileType: "text/plain; charset=utf-8", allowed: []string{"text/plain"}, expected: true},
+	{name: "wildcard does not match other types", fileType: "application/pdf", allowed: []string{"image/*"}, expected: false},
+	{name: "wildcard needs a whole type", fileType: "imagex/png", allowed: []string{"image/*"}, expected: false},
+	{name: "mixed", fileType: "application/pdf", allowed: []string{"image/*", "application/pdf"}, expected: true},
+	{name: "any", fileType: "application/octet-stream", allowed: []string{"image/png", "*/*"}, expected: true},
+}
+
+func TestFileTypeAllowed(t *testing.T) {
+	for _, e := range fileTypeAllowedTests {
+		if got := fileTypeAllowed(e.fileType, e.allowed); got != e.expected {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTools_UploadFilesWildcardTypes(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	pdf := []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	var tests = []struct {
+		name          string
+		allowedTypes  []string
+		upload        testUpload
+		errorExpected bool
+	}{
+		{name: "png with wildcard", allowedTypes: []string{"image/*"}, upload: testUpload{"file", "img.png", png}},
+		{name: "jpeg with wildcard and exact", allowedTypes: []string{"application/pdf", "image/*"}, upload: testUpload{"file", "pic.jpg", jpg}},
+		{name: "pdf with exact and wildcard", allowedTypes: []string{"application/pdf", "image/*"}, upload: testUpload{"file", "doc.pdf", pdf}},
+		{name: "pdf with image wildcard", allowedTypes: []string{"image/*"}, upload: testUpload{"file", "doc.pdf", pdf}, errorExpected: true},
+		{name: "pdf with any", allowedTypes: []string{"*/*"}, upload: testUpload{"file", "doc.pdf", pdf}},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{AllowedFileTypes: e.allowedTypes}
+		_, err := testTools.UploadFiles(newUploadRequest(t, e.upload), t.TempDir())
+		if e.errorExpected && !errors.Is(err, ErrFileTypeNotPermitted) {
+			t.Errorf("%s: expected ErrFileTypeNotPermitted, got %v", e.name, err)
+		}
+		if !e.errorExpected && err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+	}
+}
+
+var fileTypeDeniedTests = []struct {
+	name     string
+	fileType string
+	denied   []string
+	expected bool
+}{
+	{name: "empty list", fileType: "text/html; charset=utf-8", expected: false},
+	{name: "exact with parameters", fileType: "text/html; charset=utf-8", denied: []string{"text/html"}, expected: true},
+	{name: "exact is case-insensitive", fileType: "application/x-msdownload", denied: []string{"Application/X-MSDownload"}, expected: true},
+	{name: "wildcard", fileType: "text/plain; charset=utf-8", denied: []string{"text/*"}, expected: true},
+	{name: "other type", fileType: "image/png", denied: []string{"text/html", "application/x-msdownload"}, expected: false},
+}
+
+func TestFileTypeDenied(t *testing.T) {
+	for _, e := range fileTypeDeniedTests {
+		if got := fileTypeDenied(e.fileType, e.denied); got != e.expected {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTools_UploadFilesDeniedTypes(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	html := []byte("<!DOCTYPE html><html><body>hello</body></html>")
+	exe := append([]byte("MZ"), make([]byte, 64)...)
+
+	var tests = []struct {
+		name          string
+		allowedTypes  []string
+		deniedTypes   []string
+		upload        testUpload
+		errorExpected bool
+	}{
+		{name: "html denied", deniedTypes: []string{"text/html", "application/x-msdownload"}, upload: testUpload{"file", "page.html", html}, errorExpected: true},
+		// DetectContentType has no signature for executables, so they are refused as unrecognised binary files
+		{name: "executable denied", deniedTypes: []string{"text/html", "application/octet-stream"}, upload: testUpload{"file", "setup.exe", exe}, errorExpected: true},
+		{name: "png not denied", deniedTypes: []string{"text/html", "application/x-msdownload"}, upload: testUpload{"file", "img.png", png}},
+		{name: "deny wins over allow", allowedTypes: []string{"image/*"}, deniedTypes: []string{"image/png"}, upload: testUpload{"file", "img.png", png}, errorExpected: true},
+		{name: "deny wins over any", allowedTypes: []string{"*/*"}, deniedTypes: []string{"text/*"}, upload: testUpload{"file", "page.html", html}, errorExpected: true},
+		{name: "allowed and not denied", allowedTypes: []string{"image/*"}, deniedTypes: []string{"image/gif"}, upload: testUpload{"file", "img.png", png}},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			testTools := Tools{AllowedFileTypes: e.allowedTypes, DeniedFileTypes: e.deniedTypes}
+			_, err := upload(&testTools, newUploadRequest(t, e.upload), t.TempDir())
+			if e.errorExpected && !errors.Is(err, ErrFileTypeNotPermitted) {
+				t.Errorf("%s, %s: expected ErrFileTypeNotPermitted, got %v", e.name, name, err)
+			}
+			if !e.errorExpected && err != nil {
+				t.Errorf("%s, %s: unexpected error %v", e.name, name, err)
+			}
+		}
+	}
+}
+
+var fileExtensionAllowedTests = []struct {
+	name     string
+	filename string
+	allowed  []string
+	compound []string
+	expected bool
+}{
+	{name: "empty list", filename: "data.bin", expected: true},
+	{name: "without dot", filename: "report.csv", allowed: []string{"csv"}, expected: true},
+	{name: "with dot", filename: "report.csv", allowed: []string{".csv"}, expected: true},
+	{name: "case-insensitive", filename: "REPORT.CSV", allowed: []string{".Csv"}, expected: true},
+	{name: "last extension only", filename: "report.csv.exe", allowed: []string{"csv"}, expected: false},
+	{name: "not allowed", filename: "notes.txt", allowed: []string{"csv"}, expected: false},
+	{name: "no extension", filename: "Makefile", allowed: []string{"csv"}, expected: false},
+	{name: "compound", filename: "Backup.TAR.GZ", allowed: []string{".tar.gz"}, compound: defaultCompoundExtensions, expected: true},
+	{name: "outer layer of compound", filename: "backup.tar.gz", allowed: []string{"gz"}, compound: defaultCompoundExtensions, expected: false},
+	{name: "no compound extensions", filename: "backup.tar.gz", allowed: []string{"gz"}, expected: true},
+}
+
+func TestFileExtensionAllowed(t *testing.T) {
+	for _, e := range fileExtensionAllowedTests {
+		if got := fileExtensionAllowed(e.filename, e.allowed, e.compound); got != e.expected {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestTools_UploadFilesExtensions(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	csv := []byte("id,name\n1,bob\n")
+
+	var tests = []struct {
+		name         string
+		allowedTypes []string
+		allowedExts  []string
+		upload       testUpload
+		expected     error
+	}{
+		{name: "extension only", allowedExts: []string{"csv"}, upload: testUpload{"file", "people.csv", csv}},
+		{name: "extension only, wrong extension", allowedExts: []string{"csv"}, upload: testUpload{"file", "people.txt", csv}, expected: ErrFileExtensionNotPermitted},
+		{name: "type only", allowedTypes: []string{"image/png"}, upload: testUpload{"file", "img.whatever", png}},
+		{name: "both pass", allowedTypes: []string{"image/*"}, allowedExts: []string{".png"}, upload: testUpload{"file", "img.PNG", png}},
+		{name: "both, wrong extension", allowedTypes: []string{"image/*"}, allowedExts: []string{".jpg"}, upload: testUpload{"file", "img.png", png}, expected: ErrFileExtensionNotPermitted},
+		{name: "both, wrong type", allowedTypes: []string{"image/*"}, allowedExts: []string{"csv"}, upload: testUpload{"file", "people.csv", csv}, expected: ErrFileTypeNotPermitted},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{AllowedFileTypes: e.allowedTypes, AllowedFileExtensions: e.allowedExts}
+		_, err := testTools.UploadFiles(newUploadRequest(t, e.upload), t.TempDir())
+		if e.expected == nil && err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if e.expected != nil && !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+		}
+	}
+
+	// clients are told which check failed
+	testTools := Tools{AllowedFileExtensions: []string{"csv"}}
+	rr := httptest.NewRecorder()
+	testTools.UploadHandler(t.TempDir())(rr, newUploadRequest(t, testUpload{"file", "img.png", png}))
+	if rr.Code != http.StatusUnsupportedMediaType || !strings.Contains(rr.Body.String(), `has the extension \".png\", which is not permitted`) {
+		t.Errorf("expected a 415 naming the extension, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// with several files, each refused file is named
+	testTools.ContinueOnError = true
+	_, err := testTools.UploadFiles(newUploadRequest(t, testUpload{"file", "people.csv", csv}, testUpload{"file", "img.png", png}, testUpload{"file", "README", csv}), t.TempDir())
+	var refused []FileExtensionNotPermittedError
+	if multi, ok := err.(MultiError); ok {
+		for _, err := range multi {
+			var extErr *FileExtensionNotPermittedError
+			if errors.As(err, &extErr) {
+				refused = append(refused, *extErr)
+			}
+		}
+	}
+	expected := []FileExtensionNotPermittedError{{FileName: "img.png", Extension: ".png"}, {FileName: "README"}}
+	if !reflect.DeepEqual(refused, expected) {
+		t.Errorf("expected %+v to be refused, got %+v from %v", expected, refused, err)
+	}
+}
+
+func TestTools_UploadFilesMaxFilePerSize(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	small, big := testUpload{"file", "img.png", png}, testUpload{"file", "pic.jpg", jpg}
+	if len(png) >= len(jpg) {
+		small, big = big, small
+	}
+	between := int64(len(small.content)+len(big.content)) / 2
+
+	var tests = []struct {
+		name        string
+		maxFileSize int
+		maxPerFile  int64
+		uploads     []testUpload
+		tooBigFile  string
+		tooBig      bool
+	}{
+		{name: "under both limits", maxPerFile: int64(len(big.content)), uploads: []testUpload{small, big}},
+		{name: "one file over", maxPerFile: between, uploads: []testUpload{small, big}, tooBig: true, tooBigFile: big.filename},
+		{name: "files fit, request does not", maxFileSize: len(small.content) + len(big.content)/2, maxPerFile: int64(len(big.content)), uploads: []testUpload{small, big}, tooBig: true},
+		{name: "no per file limit", uploads: []testUpload{small, big}},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{MaxFileSize: e.maxFileSize, MaxFilePerSize: e.maxPerFile}
+		dir := t.TempDir()
+		_, err := testTools.UploadFiles(newUploadRequest(t, e.uploads...), dir)
+
+		if !e.tooBig {
+			if err != nil {
+				t.Errorf("%s: unexpected error %v", e.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrFileTooBig) {
+			t.Errorf("%s: expected ErrFileTooBig, got %v", e.name, err)
+			continue
+		}
+
+		var fileErr *FileTooBigError
+		if e.tooBigFile == "" {
+			if errors.As(err, &fileErr) {
+				t.Errorf("%s: expected the request limit to apply, got %v", e.name, err)
+			}
+			continue
+		}
+		if !errors.As(err, &fileErr) || fileErr.FileName != e.tooBigFile || fileErr.Limit != e.maxPerFile {
+			t.Errorf("%s: expected a FileTooBigError for %s, got %v", e.name, e.tooBigFile, err)
+		}
+		if !strings.Contains(err.Error(), e.tooBigFile) {
+			t.Errorf("%s: expected the message to name the file, got %q", e.name, err)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) > 1 {
+			t.Errorf("%s: expected the file over the limit not to be saved", e.name)
+		}
+	}
+
+	// a part longer than its header says is still stopped while copying
+	testTools := Tools{MaxFilePerSize: int64(len(small.content))}
+	req := newUploadRequest(t, big)
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	req.MultipartForm.File["file"][0].Size = 1
+	dir := t.TempDir()
+	var fileErr *FileTooBigError
+	if _, err := testTools.UploadFiles(req, dir); !errors.As(err, &fileErr) {
+		t.Errorf("expected a FileTooBigError while copying, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected the partial file to be removed, got %d files", len(entries))
+	}
+
+	// the handler responds with 413 and the file name
+	rr := httptest.NewRecorder()
+	(&Tools{MaxFilePerSize: between}).UploadHandler(t.TempDir())(rr, newUploadRequest(t, big))
+	if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), big.filename) {
+		t.Errorf("expected a 413 naming the file, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTools_UploadFilesSizeLimitsByType(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	text := []byte("a text file of more than ten bytes")
+
+	testTools := Tools{
+		MaxFilePerSize:   10,
+		SizeLimitsByType: map[string]int64{"image/png": int64(len(png)) - 1, "image/*": int64(len(jpg))},
+	}
+
+	var tests = []struct {
+		name        string
+		upload      testUpload
+		contentType string
+		limit       int64
+	}{
+		{name: "wildcard over the global limit", upload: testUpload{"file", "pic.jpg", jpg}},
+		{name: "exact type before wildcard", upload: testUpload{"file", "img.png", png}, contentType: "image/png", limit: int64(len(png)) - 1},
+		{name: "no entry", upload: testUpload{"file", "notes.txt", text}, limit: 10},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			_, err := upload(&testTools, newUploadRequest(t, e.upload), dir)
+			if e.limit == 0 {
+				if err != nil {
+					t.Errorf("%s, %s: unexpected error %v", e.name, name, err)
+				}
+				continue
+			}
+
+			var fileErr *FileTooBigError
+			if !errors.As(err, &fileErr) || !errors.Is(err, ErrFileTooBig) || fileErr.ContentType != e.contentType || fileErr.Limit != e.limit {
+				t.Errorf("%s, %s: expected a FileTooBigError for %q over %d, got %#v", e.name, name, e.contentType, e.limit, err)
+				continue
+			}
+			if e.contentType != "" && (!strings.Contains(err.Error(), e.contentType) || !strings.Contains(err.Error(), fmt.Sprint(e.limit))) {
+				t.Errorf("%s, %s: expected the message to name the type and the limit, got %q", e.name, name, err)
+			}
+			if remaining := remainingFiles(t, dir); remaining != nil {
+				t.Errorf("%s, %s: expected nothing to be saved, got %v", e.name, name, remaining)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesMaxTotalUploadSize(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	size := int64(len(png))
+	uploads := []testUpload{{"file", "one.png", png}, {"file", "two.png", png}, {"file", "three.png", png}}
+
+	var tests = []struct {
+		name       string
+		tools      Tools
+		expected   error
+		tooBigFile bool
+	}{
+		{name: "under both limits", tools: Tools{MaxFilePerSize: size, MaxTotalUploadSize: 3 * size}},
+		{name: "each file fits, the request does not", tools: Tools{MaxFilePerSize: size, MaxTotalUploadSize: 2*size + size/2}, expected: ErrRequestTooLarge},
+		{name: "the request fits, a file does not", tools: Tools{MaxFilePerSize: size - 1, MaxTotalUploadSize: 3 * size}, expected: ErrFileTooBig, tooBigFile: true},
+		{name: "continue on error", tools: Tools{MaxTotalUploadSize: 2*size + size/2, ContinueOnError: true}, expected: ErrRequestTooLarge},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			files, err := upload(&e.tools, newUploadRequest(t, uploads...), dir)
+			if e.expected == nil {
+				if err != nil || len(files) != len(uploads) {
+					t.Errorf("%s, %s: expected every file to be saved, got %d and %v", e.name, name, len(files), err)
+				}
+				continue
+			}
+
+			if !errors.Is(err, e.expected) {
+				t.Errorf("%s, %s: expected %v, got %v", e.name, name, e.expected, err)
+			}
+			var fileErr *FileTooBigError
+			if errors.As(err, &fileErr) != e.tooBigFile || errors.Is(err, ErrRequestTooLarge) == e.tooBigFile {
+				t.Errorf("%s, %s: expected the other limit not to be reported, got %v", e.name, name, err)
+			}
+			if files != nil || remainingFiles(t, dir) != nil {
+				t.Errorf("%s, %s: expected the files written for the request to be removed, got %v and %v", e.name, name, files, remainingFiles(t, dir))
+			}
+		}
+	}
+
+	// the handler responds with 413
+	rr := httptest.NewRecorder()
+	(&Tools{MaxTotalUploadSize: size}).UploadHandler(t.TempDir())(rr, newUploadRequest(t, uploads...))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a 413, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestTools_UploadFilesMaxFiles(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	one := testUpload{"file", "img.png", png}
+	uploads := []testUpload{one, one, {"other", "img.png", png}}
+
+	var tests = []struct {
+		name     string
+		maxFiles int
+		expected bool
+	}{
+		{name: "unlimited", maxFiles: 0, expected: true},
+		{name: "at the limit", maxFiles: 3, expected: true},
+		{name: "over the limit", maxFiles: 2, expected: false},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{MaxFiles: e.maxFiles}
+		dir := t.TempDir()
+		files, err := testTools.UploadFiles(newUploadRequest(t, uploads...), dir)
+
+		if e.expected {
+			if err != nil || len(files) != len(uploads) {
+				t.Errorf("%s: expected %d files, got %d and %v", e.name, len(uploads), len(files), err)
+			}
+			continue
+		}
+
+		var tooMany *TooManyFilesError
+		if !errors.Is(err, ErrTooManyFiles) || !errors.As(err, &tooMany) || tooMany.Limit != e.maxFiles {
+			t.Errorf("%s: expected a TooManyFilesError with limit %d, got %v", e.name, e.maxFiles, err)
+		}
+		if expected := fmt.Sprintf("too many files uploaded (the limit is %d)", e.maxFiles); fmt.Sprint(err) != expected {
+			t.Errorf("%s: expected the message %q, got %q", e.name, expected, err)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("%s: expected nothing to be left in the upload directory, got %d files", e.name, len(entries))
+		}
+	}
+
+	// UploadOneFile accepts a single file, whatever MaxFiles is
+	var testTools Tools
+	dir := t.TempDir()
+	if _, err := testTools.UploadOneFile(newUploadRequest(t, one, one), dir); !errors.Is(err, ErrTooManyFiles) {
+		t.Errorf("expected ErrTooManyFiles from UploadOneFile, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected UploadOneFile to write nothing, got %d files", len(entries))
+	}
+	if _, err := testTools.UploadOneFile(newUploadRequest(t, one), dir); err != nil {
+		t.Errorf("expected one file to be accepted, got %v", err)
+	}
+
+	// several files are refused with a MultipleFilesError, or with TakeFirstFile the one posted first is saved
+	uploads3 := []testUpload{{"b", "first.png", png}, {"a", "second.png", png}, {"b", "third.png", png}}
+	var multiple *MultipleFilesError
+	dir = t.TempDir()
+	if _, err := testTools.UploadOneFile(newUploadRequest(t, uploads3...), dir); !errors.As(err, &multiple) || !errors.Is(err, ErrMultipleFiles) || multiple.Count != 3 {
+		t.Errorf("expected a MultipleFilesError for 3 files, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected UploadOneFile to write nothing, got %d files", len(entries))
+	}
+	testTools.TakeFirstFile = true
+	file, err := testTools.UploadOneFile(newUploadRequest(t, uploads3...), dir, false)
+	if err != nil || file.OriginalFileName != "first.png" {
+		t.Errorf("expected first.png to be saved, got %v, %v", file, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected the other files to be ignored, got %d files", len(entries))
+	}
+
+	rr := httptest.NewRecorder()
+	(&Tools{MaxFiles: 1}).UploadHandler(t.TempDir())(rr, newUploadRequest(t, one, one))
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a 413 from the handler, got %d", rr.Code)
+	}
+}
+
+func TestTools_UploadFilesCleanupOnError(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	testTools := Tools{AllowedFileTypes: []string{"image/png"}}
+	dir := t.TempDir()
+
+	// the png is saved before the jpeg is refused, and must not be left behind
+	_, err := testTools.UploadFiles(newUploadRequest(t, testUpload{"file", "img.png", png}, testUpload{"file", "pic.jpg", jpg}), dir)
+	if !errors.Is(err, ErrFileTypeNotPermitted) {
+		t.Fatalf("expected ErrFileTypeNotPermitted, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected a failed upload to leave nothing behind, got %d files", len(entries))
+	}
+}
+
+// cancelAfterContext reports itself cancelled once Err has been called more than after times, so that a test can
+// stop an upload part way through a file
+type cancelAfterContext struct {
+	context.Context
+	calls int
+	after int
+}
+
+func (c *cancelAfterContext) Err() error {
+	c.calls++
+	if c.calls > c.after {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestTools_UploadFilesContext(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	big := make([]byte, 4*uploadBufferSize)
+	copy(big, png)
+	uploads := []testUpload{{"file", "img.png", png}, {"file", "big.png", big}}
+
+	var testTools Tools
+
+	// cancelled before anything is written
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := testTools.UploadFilesContext(ctx, newUploadRequest(t, uploads...), dir); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected nothing to be written, got %d files", len(entries))
+	}
+
+	// cancelled while the second file is being copied: the first file and the partial second are removed
+	for after := 2; after < 6; after++ {
+		dir = t.TempDir()
+		ctx := &cancelAfterContext{Context: context.Background(), after: after}
+		ctx.Context, cancel = context.WithCancel(context.Background())
+		_, err := testTools.UploadFilesContext(ctx, newUploadRequest(t, uploads...), dir)
+		cancel()
+		if err != context.Canceled {
+			t.Errorf("after %d checks: expected context.Canceled, got %v", after, err)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("after %d checks: expected partial files to be removed, got %d files", after, len(entries))
+		}
+	}
+
+	// UploadFiles uses the request's context
+	dir = t.TempDir()
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	req := newUploadRequest(t, uploads...).WithContext(ctx)
+	if _, err := testTools.UploadFiles(req, dir); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected UploadFiles to stop with the request's context, got %v", err)
+	}
+
+	files, err := testTools.UploadFilesContext(context.Background(), newUploadRequest(t, uploads...), t.TempDir())
+	if err != nil || len(files) != 2 {
+		t.Errorf("expected both files with a live context, got %d and %v", len(files), err)
+	}
+}
+
+func TestTools_UploadFilesContentType(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	expected := map[string]string{
+		"img.png":   "image/png",
+		"pic.jpg": "image/jpeg",
+	}
+
+	for _, allowed := range [][]string{nil, {"image/*"}} {
+		testTools := Tools{AllowedFileTypes: allowed}
+		req := newUploadRequest(t, testUpload{"file", "img.png", png}, testUpload{"file", "pic.jpg", jpg})
+		files, err := testTools.UploadFiles(req, t.TempDir(), WithRename(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files {
+			if f.ContentType != expected[f.OriginalFileName] {
+				t.Errorf("allowed %v: expected %s to be %q, got %q", allowed, f.OriginalFileName, expected[f.OriginalFileName], f.ContentType)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesDetectContentType(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	// a DICOM file has a 128 byte preamble before its magic bytes, and the proprietary format has them past byte 512
+	dicom := append(append(make([]byte, 128), "DICM"...), bytes.Repeat([]byte{0x01}, 64)...)
+	acme := append(append(bytes.Repeat([]byte{0x02}, 600), "ACME"...), bytes.Repeat([]byte{0x03}, 64)...)
+
+	var seen []string
+	detect := func(head []byte, filename string) string {
+		seen = append(seen, filename)
+		switch {
+		case len(head) >= 132 && string(head[128:132]) == "DICM":
+			return "application/dicom"
+		case bytes.Contains(head, []byte("ACME")):
+			return "application/x-acme"
+		}
+		return ""
+	}
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		filename string
+		content  []byte
+		expected string
+		refused  bool
+	}{
+		{name: "dicom", tools: Tools{DetectContentTypeFunc: detect, AllowedFileTypes: []string{"application/dicom"}}, filename: "scan.dcm", content: dicom, expected: "application/dicom"},
+		{name: "magic past the default sniff length", tools: Tools{DetectContentTypeFunc: detect}, filename: "data.acme", content: acme, expected: "application/octet-stream"},
+		{name: "longer sniff length", tools: Tools{DetectContentTypeFunc: detect, SniffLength: 1024}, filename: "data.acme", content: acme, expected: "application/x-acme"},
+		{name: "sniff length over the buffer", tools: Tools{DetectContentTypeFunc: detect, SniffLength: 2 * uploadBufferSize}, filename: "data.acme", content: acme, expected: "application/x-acme"},
+		{name: "built-in sniffer when unknown", tools: Tools{DetectContentTypeFunc: detect}, filename: "img.png", content: png, expected: "image/png"},
+		{name: "denied", tools: Tools{DetectContentTypeFunc: detect, DeniedFileTypes: []string{"application/dicom"}}, filename: "scan.dcm", content: dicom, refused: true},
+		{name: "not allowed", tools: Tools{DetectContentTypeFunc: detect, AllowedFileTypes: []string{"image/*"}}, filename: "scan.dcm", content: dicom, refused: true},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			seen = nil
+			files, err := upload(&e.tools, newUploadRequest(t, testUpload{"file", e.filename, e.content}), t.TempDir())
+			if e.refused {
+				if !errors.Is(err, ErrFileTypeNotPermitted) {
+					t.Errorf("%s, %s: expected ErrFileTypeNotPermitted, got %v", e.name, name, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+			if files[0].ContentType != e.expected {
+				t.Errorf("%s, %s: expected the type %s, got %s", e.name, name, e.expected, files[0].ContentType)
+			}
+			if !reflect.DeepEqual(seen, []string{e.filename}) {
+				t.Errorf("%s, %s: expected the sniffer to be called with %s, got %v", e.name, name, e.filename, seen)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesChecksum(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+
+	// sha256sum testdata/img.png testdata/pic.jpg
+	expected := map[string]string{
+		"img.png": "80babf09b223f1049a5b8216fb5de60ae6dd956e6872e76f838a1c57a5e34147",
+		"pic.jpg": "63b7b0c8bf0ecac6f033efbecbf64e749e3f50af9af26b3ad0dc8aee8711f5a4",
+	}
+
+	for _, compute := range []bool{false, true} {
+		testTools := Tools{ComputeChecksum: compute}
+		for name, upload := range uploadFuncs {
+			req := newUploadRequest(t, testUpload{"file", "img.png", png}, testUpload{"file", "pic.jpg", jpg})
+			files, err := upload(&testTools, req, t.TempDir(), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(files) != 2 {
+				t.Fatalf("%s: expected 2 files, got %d", name, len(files))
+			}
+			for _, f := range files {
+				want := ""
+				if compute {
+					want = expected[f.OriginalFileName]
+				}
+				if f.SHA256 != want {
+					t.Errorf("%s with ComputeChecksum %v: expected %s to hash to %q, got %q", name, compute, f.OriginalFileName, want, f.SHA256)
+				}
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesKeepPartialUploads(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+
+	for _, keep := range []bool{false, true} {
+		testTools := Tools{AllowedFileTypes: []string{"image/png"}, KeepPartialUploads: keep}
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+
+			// the second file fails after the first has been saved
+			req := newUploadRequest(t, testUpload{"file", "img.png", png}, testUpload{"file", "pic.jpg", jpg})
+			files, err := upload(&testTools, req, dir, false)
+			if !errors.Is(err, ErrFileTypeNotPermitted) {
+				t.Errorf("%s, keep %v: expected %v, got %v", name, keep, ErrFileTypeNotPermitted, err)
+			}
+
+			remaining := remainingFiles(t, dir)
+			if !keep {
+				if files != nil || len(remaining) != 0 {
+					t.Errorf("%s: expected the saved file to be removed, got %v and %v", name, files, remaining)
+				}
+				continue
+			}
+			if len(files) != 1 || files[0].NewFileName != "img.png" || len(remaining) != 1 || remaining[0] != "img.png" {
+				t.Errorf("%s: expected the saved file to be kept and returned, got %v and %v", name, files, remaining)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesContinueOnError(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+
+	var tests = []struct {
+		name     string
+		uploads  []testUpload
+		expected []string
+		failed   []string
+	}{
+		{
+			name:     "some fail",
+			uploads:  []testUpload{{"file", "img.png", png}, {"file", "notes.txt", []byte("just some text")}, {"file", "pic.jpg", jpg}, {"file", "copy.png", png}},
+			expected: []string{"copy.png", "img.png"},
+			failed:   []string{"notes.txt", "pic.jpg"},
+		},
+		{
+			name:    "all fail",
+			uploads: []testUpload{{"file", "notes.txt", []byte("just some text")}, {"file", "pic.jpg", jpg}},
+			failed:  []string{"notes.txt", "pic.jpg"},
+		},
+	}
+
+	for _, e := range tests {
+		for _, concurrency := range []int{0, 2} {
+			testTools := Tools{AllowedFileTypes: []string{"image/png"}, ContinueOnError: true, Concurrency: concurrency}
+			for name, upload := range uploadFuncs {
+				dir := t.TempDir()
+				files, err := upload(&testTools, newUploadRequest(t, e.uploads...), dir, false)
+
+				var failures MultiError
+				if !errors.As(err, &failures) || !errors.Is(err, ErrFileTypeNotPermitted) {
+					t.Errorf("%s, %s, concurrency %d: expected a MultiError of refused types, got %v", e.name, name, concurrency, err)
+					continue
+				}
+				var failed []string
+				for _, failure := range failures {
+					var fileErr *UploadFileError
+					if !errors.As(failure, &fileErr) {
+						t.Errorf("%s, %s, concurrency %d: expected an *UploadFileError, got %v", e.name, name, concurrency, failure)
+						continue
+					}
+					failed = append(failed, fileErr.FileName)
+				}
+				if !reflect.DeepEqual(failed, e.failed) {
+					t.Errorf("%s, %s, concurrency %d: expected %v to fail, got %v", e.name, name, concurrency, e.failed, failed)
+				}
+
+				var saved []string
+				for _, f := range files {
+					saved = append(saved, f.NewFileName)
+				}
+				sort.Strings(saved)
+				if !reflect.DeepEqual(saved, e.expected) || !reflect.DeepEqual(remainingFiles(t, dir), e.expected) {
+					t.Errorf("%s, %s, concurrency %d: expected %v to be saved, got %v and %v", e.name, name, concurrency, e.expected, saved, remainingFiles(t, dir))
+				}
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesSentinelErrors(t *testing.T) {
+	jpg := readTestFile(t, "pic.jpg")
+
+	for name, upload := range uploadFuncs {
+		testTools := Tools{AllowedFileTypes: []string{"image/png"}}
+		_, err := upload(&testTools, newUploadRequest(t, testUpload{"file", "pic.jpg", jpg}), t.TempDir())
+
+		var typeErr *FileTypeError
+		if !errors.As(err, &typeErr) || !errors.Is(err, ErrFileTypeNotPermitted) {
+			t.Fatalf("%s: expected a *FileTypeError, got %v", name, err)
+		}
+		if typeErr.FileName != "pic.jpg" || typeErr.ContentType != "image/jpeg" {
+			t.Errorf("%s: unexpected error fields %+v", name, typeErr)
+		}
+		if !strings.Contains(err.Error(), `"pic.jpg"`) || !strings.Contains(err.Error(), "image/jpeg") {
+			t.Errorf("%s: expected the message to name the file and its type, got %q", name, err.Error())
+		}
+
+		// a form with no files
+		req := newUploadRequest(t, testUpload{"title", "", []byte("holiday")})
+		if _, err := upload(&testTools, req, t.TempDir()); !errors.Is(err, ErrNoFiles) {
+			t.Errorf("%s: expected %v, got %v", name, ErrNoFiles, err)
+		}
+	}
+}
+
+func TestTools_UploadFilesAllowedFormFields(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	uploads := []testUpload{{"avatar", "img.png", png}, {"attachments", "pic.jpg", jpg}, {"smuggled", "other.png", png}}
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		expected []string
+		err      error
+	}{
+		{name: "every field", tools: Tools{}, expected: []string{"img.png", "other.png", "pic.jpg"}},
+		{name: "ignore others", tools: Tools{AllowedFormFields: []string{"avatar", "attachments"}}, expected: []string{"img.png", "pic.jpg"}},
+		{name: "strict", tools: Tools{AllowedFormFields: []string{"avatar", "attachments"}, StrictFormFields: true}, err: ErrFormFieldNotPermitted},
+		{name: "ignored files don't count", tools: Tools{AllowedFormFields: []string{"avatar"}, MaxFiles: 1}, expected: []string{"img.png"}},
+		{name: "nothing allowed", tools: Tools{AllowedFormFields: []string{"document"}}, err: ErrNoFiles},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			files, err := upload(&e.tools, newUploadRequest(t, uploads...), dir, false)
+			if !errors.Is(err, e.err) {
+				t.Errorf("%s, %s: expected error %v, got %v", e.name, name, e.err, err)
+				continue
+			}
+
+			var saved []string
+			for _, f := range files {
+				saved = append(saved, f.NewFileName)
+			}
+			sort.Strings(saved)
+			if !reflect.DeepEqual(saved, e.expected) || !reflect.DeepEqual(remainingFiles(t, dir), e.expected) {
+				t.Errorf("%s, %s: expected %v to be saved, got %v and %v on disk", e.name, name, e.expected, saved, remainingFiles(t, dir))
+			}
+		}
+	}
+
+	var fieldErr *FormFieldError
+	strict := Tools{AllowedFormFields: []string{"avatar", "attachments"}, StrictFormFields: true}
+	if _, err := strict.UploadFiles(newUploadRequest(t, uploads...), t.TempDir()); !errors.As(err, &fieldErr) || fieldErr.Field != "smuggled" {
+		t.Errorf("expected a *FormFieldError for the smuggled field, got %v", err)
+	}
+}
+
+func TestTools_UploadFilesFieldName(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+	uploads := []testUpload{{"avatar", "img.png", png}, {"attachments", "pic.jpg", jpg}, {"attachments", "notes.txt", []byte("some notes")}}
+	expected := map[string]string{"img.png": "avatar", "pic.jpg": "attachments", "notes.txt": "attachments"}
+
+	var tests = []struct {
+		name  string
+		tools Tools
+	}{
+		{name: "one at a time", tools: Tools{}},
+		{name: "concurrently", tools: Tools{Concurrency: 3}},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			files, err := upload(&e.tools, newUploadRequest(t, uploads...), t.TempDir())
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				continue
+			}
+
+			fields := make(map[string]string)
+			for _, f := range files {
+				fields[f.OriginalFileName] = f.FieldName
+			}
+			if !reflect.DeepEqual(fields, expected) {
+				t.Errorf("%s, %s: expected fields %v, got %v", e.name, name, expected, fields)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesMinFileSize(t *testing.T) {
+	// PNG-headed files of a given size
+	pngOfSize := func(n int) []byte {
+		b := make([]byte, n)
+		copy(b, "\x89PNG\r\n\x1a\n")
+		return b
+	}
+
+	var tests = []struct {
+		name     string
+		minSize  int64
+		content  []byte
+		expected string
+	}{
+		{name: "empty", content: nil, expected: `the uploaded file "img.png" is empty`},
+		{name: "one byte", content: []byte("x")},
+		{name: "below minimum", minSize: 100, content: pngOfSize(99), expected: `the uploaded file "img.png" is smaller than 100 bytes`},
+		{name: "at minimum", minSize: 100, content: pngOfSize(100)},
+		{name: "below minimum, past sniffing", minSize: 1000, content: pngOfSize(999), expected: `the uploaded file "img.png" is smaller than 1000 bytes`},
+		{name: "above minimum, past sniffing", minSize: 1000, content: pngOfSize(1001)},
+	}
+
+	for _, e := range tests {
+		testTools := Tools{MinFileSize: e.minSize}
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			files, err := upload(&testTools, newUploadRequest(t, testUpload{"file", "img.png", e.content}), dir)
+
+			if e.expected == "" {
+				if err != nil || len(files) != 1 || files[0].FileSize != int64(len(e.content)) {
+					t.Errorf("%s, %s: expected the file to be saved, got %v", e.name, name, err)
+				}
+				continue
+			}
+
+			var tooSmall *FileTooSmallError
+			if !errors.As(err, &tooSmall) || !errors.Is(err, ErrFileTooSmall) {
+				t.Errorf("%s, %s: expected a *FileTooSmallError, got %v", e.name, name, err)
+				continue
+			}
+			if err.Error() != e.expected {
+				t.Errorf("%s, %s: expected %q, got %q", e.name, name, e.expected, err.Error())
+			}
+			if got := remainingFiles(t, dir); len(got) != 0 {
+				t.Errorf("%s, %s: expected nothing to be saved, got %v", e.name, name, got)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesSmallFiles(t *testing.T) {
+	png512 := make([]byte, 512)
+	copy(png512, "\x89PNG\r\n\x1a\n")
+	text512 := bytes.Repeat([]byte("a"), 512)
+
+	var tests = []struct {
+		name        string
+		content     []byte
+		contentType string
+	}{
+		{name: "10 byte text", content: []byte("hello text"), contentType: "text/plain; charset=utf-8"},
+		{name: "10 byte png", content: []byte("\x89PNG\r\n\x1a\n\x00\x00"), contentType: "image/png"},
+		{name: "512 byte png", content: png512, contentType: "image/png"},
+		{name: "512 byte text", content: text512, contentType: "text/plain; charset=utf-8"},
+	}
+
+	// a large upload first leaves its bytes in the pooled buffers, which must not affect the sniffing of small files
+	stale := bytes.Repeat([]byte{0xff}, 2*uploadBufferSize)
+	copy(stale, "\x89PNG\r\n\x1a\n")
+
+	var testTools Tools
+	for name, upload := range uploadFuncs {
+		for _, e := range tests {
+			if _, err := upload(&testTools, newUploadRequest(t, testUpload{"file", "stale.png", stale}), t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+
+			dir := t.TempDir()
+			files, err := upload(&testTools, newUploadRequest(t, testUpload{"file", "small", e.content}), dir, false)
+			if err != nil {
+				t.Errorf("%s, %s: unexpected error %v", e.name, name, err)
+				continue
+			}
+			if files[0].ContentType != e.contentType || files[0].FileSize != int64(len(e.content)) {
+				t.Errorf("%s, %s: expected %s of %d bytes, got %s of %d bytes", e.name, name, e.contentType, len(e.content), files[0].ContentType, files[0].FileSize)
+			}
+			if saved, _ := os.ReadFile(filepath.Join(dir, "small")); !bytes.Equal(saved, e.content) {
+				t.Errorf("%s, %s: saved contents do not match the upload", e.name, name)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesFreeSpace(t *testing.T) {
+	if _, err := diskFree(t.TempDir()); errors.Is(err, errDiskFreeUnsupported) {
+		t.Skip("free disk space can't be determined on this platform")
+	}
+	png := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		name          string
+		tools         Tools
+		unknownLength bool
+		errorExpected bool
+	}{
+		{name: "not checked", tools: Tools{MaxFileSize: 1 << 62}, unknownLength: true},
+		{name: "room to spare", tools: Tools{CheckFreeSpace: true, FreeSpaceHeadroom: 0.1}},
+		{name: "headroom too big", tools: Tools{CheckFreeSpace: true, FreeSpaceHeadroom: 1e18}, errorExpected: true},
+		{name: "known length below max", tools: Tools{CheckFreeSpace: true, MaxFileSize: 1 << 62}},
+		{name: "unknown length, max too big", tools: Tools{CheckFreeSpace: true, MaxFileSize: 1 << 62}, unknownLength: true, errorExpected: true},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			req := newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: png})
+			if e.unknownLength {
+				req.ContentLength = -1
+			}
+
+			_, err := upload(&e.tools, req, dir)
+			if e.errorExpected {
+				var storageErr *InsufficientStorageError
+				if !errors.As(err, &storageErr) || !errors.Is(err, ErrInsufficientStorage) {
+					t.Errorf("%s, %s: expected an *InsufficientStorageError, got %v", e.name, name, err)
+				} else if storageErr.Required <= storageErr.Available {
+					t.Errorf("%s, %s: expected more to be required than is available, got %+v", e.name, name, storageErr)
+				}
+				if got := remainingFiles(t, dir); got != nil {
+					t.Errorf("%s, %s: expected nothing to be saved, got %v", e.name, name, got)
+				}
+			} else if err != nil {
+				t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+			}
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	err := &InsufficientStorageError{Required: 10, Available: 5}
+	http.Error(rr, err.Error(), uploadErrorStatus(err))
+	if rr.Code != http.StatusInsufficientStorage {
+		t.Errorf("expected status %d, got %d", http.StatusInsufficientStorage, rr.Code)
+	}
+}
+
+func TestTools_UploadFilesFileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file permissions are not supported on Windows")
+	}
+	png := readTestFile(t, "img.png")
+
+	// without a FileMode, uploads get the mode os.Create gives a file
+	created, err := os.Create(filepath.Join(t.TempDir(), "created"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Close()
+	info, err := os.Stat(created.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultMode := info.Mode().Perm()
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		fileMode os.FileMode
+		dirMode  os.FileMode
+	}{
+		{name: "default", fileMode: defaultMode},
+		{name: "0640", tools: Tools{FileMode: 0640, DirMode: 0750}, fileMode: 0640, dirMode: 0750},
+		{name: "wider than the umask", tools: Tools{FileMode: 0666, DirMode: 0777}, fileMode: 0666, dirMode: 0777},
+		{name: "setgid directory", tools: Tools{FileMode: 0640, DirMode: os.ModeSetgid | 0750}, fileMode: 0640, dirMode: os.ModeSetgid | 0750},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := filepath.Join(t.TempDir(), "uploads")
+			files, err := upload(&e.tools, newUploadRequest(t, testUpload{field: "file", filename: "img.png", content: png}), dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := os.Stat(filepath.Join(dir, files[0].NewFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != e.fileMode {
+				t.Errorf("%s, %s: expected file mode %s, got %s", e.name, name, e.fileMode, info.Mode().Perm())
+			}
+
+			if e.dirMode != 0 {
+				info, err := os.Stat(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := info.Mode() &^ os.ModeDir; got != e.dirMode {
+					t.Errorf("%s, %s: expected directory mode %s, got %s", e.name, name, e.dirMode, got)
+				}
+			}
+		}
+	}
+}
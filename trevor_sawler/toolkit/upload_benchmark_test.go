@@ -0,0 +1,70 @@
+package toolkit
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+)
+
+// uploadBenchmarkBody builds a multipart body holding one PNG-headed file of the given size, and its content type
+func uploadBenchmarkBody(b *testing.B, size int) ([]byte, string) {
+	b.Helper()
+
+	content := make([]byte, size)
+	copy(content, "\x89PNG\r\n\x1a\n")
+
+	body, contentType := newUploadBody(b, testUpload{"file", "img.png", content})
+	return body.Bytes(), contentType
+}
+
+// benchmarkUploadFiles uploads a file of size bytes with upload from 64 concurrent goroutines. With UploadFiles,
+// parts larger than uploadMaxMemory are spooled to disk by the multipart reader rather than held in memory
+func benchmarkUploadFiles(b *testing.B, size int, upload uploadFunc) {
+	body, contentType := uploadBenchmarkBody(b, size)
+	var testTools Tools
+	uploadDir := b.TempDir()
+
+	b.SetBytes(int64(size))
+	b.SetParallelism((64 + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			req := httptest.NewRequest("POST", "/upload", bytes.NewReader(body))
+			req.Header.Set("Content-Type", contentType)
+
+			files, err := upload(&testTools, req, uploadDir)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if req.MultipartForm != nil {
+				_ = req.MultipartForm.RemoveAll()
+			}
+			for _, f := range files {
+				_ = os.Remove(uploadDir + "/" + f.NewFileName)
+			}
+		}
+	})
+}
+
+func BenchmarkTools_UploadFiles1MB(b *testing.B) {
+	benchmarkUploadFiles(b, 1<<20, uploadFilesRename)
+}
+
+func BenchmarkTools_UploadFiles100MB(b *testing.B) {
+	benchmarkUploadFiles(b, 100<<20, uploadFilesRename)
+}
+
+// The streaming benchmarks should allocate about the same per upload whatever the size of the file
+
+func BenchmarkTools_StreamUploadFiles1MB(b *testing.B) {
+	benchmarkUploadFiles(b, 1<<20, (*Tools).StreamUploadFiles)
+}
+
+func BenchmarkTools_StreamUploadFiles100MB(b *testing.B) {
+	benchmarkUploadFiles(b, 100<<20, (*Tools).StreamUploadFiles)
+}
@@ -0,0 +1,112 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// UploadHandlerOption configures the handler returned by UploadHandler
+type UploadHandlerOption func(*uploadHandlerConfig)
+
+type uploadHandlerConfig struct {
+	rename   bool
+	fields   []string
+	callback func(r *http.Request, files []*UploadedFile) error
+}
+
+// WithUploadRename sets whether uploaded files are given random names (the default) or keep their original names
+func WithUploadRename(rename bool) UploadHandlerOption {
+	return func(c *uploadHandlerConfig) { c.rename = rename }
+}
+
+// WithUploadFields restricts the upload to files posted under the given form field names. Files under any other
+// field name are ignored
+func WithUploadFields(names ...string) UploadHandlerOption {
+	return func(c *uploadHandlerConfig) { c.fields = names }
+}
+
+// WithUploadCallback registers a function that is called after the files have been saved, for example to record
+// them in a database. If it returns an error, the client receives a 500 response
+func WithUploadCallback(fn func(r *http.Request, files []*UploadedFile) error) UploadHandlerOption {
+	return func(c *uploadHandlerConfig) { c.callback = fn }
+}
+
+// UploadHandler returns a handler that saves POSTed multipart uploads to uploadDir with UploadFiles and responds as
+// WriteUploadResponse does
+func (t *Tools) UploadHandler(uploadDir string, opts ...UploadHandlerOption) http.HandlerFunc {
+	cfg := uploadHandlerConfig{rename: true}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return t.AllowMethods(func(w http.ResponseWriter, r *http.Request) {
+		files, err := t.UploadFiles(r, uploadDir, WithRename(cfg.rename), WithFields(cfg.fields...))
+		if err != nil && !t.partialUpload(files, err) {
+			_ = t.LocalizedErrorJSON(w, r, err, uploadErrorStatus(err))
+			return
+		}
+
+		if cfg.callback != nil {
+			if err := cfg.callback(r, files); err != nil {
+				_ = t.LocalizedErrorJSON(w, r, err, http.StatusInternalServerError)
+				return
+			}
+		}
+
+		_ = t.writeUploadResponse(w, r, files, err)
+	}, http.MethodPost)
+}
+
+// WriteUploadResponse writes the result of an upload as a 201 JSONResponse of files, or err via ErrorJSON with the
+// status that suits it, such as 413 for a file too big
+func (t *Tools) WriteUploadResponse(w http.ResponseWriter, files []*UploadedFile, err error) error {
+	return t.writeUploadResponse(w, nil, files, err)
+}
+
+// writeUploadResponse is WriteUploadResponse, with error messages in the language the client of r prefers. r may be
+// nil, for the English messages of ErrorJSON
+func (t *Tools) writeUploadResponse(w http.ResponseWriter, r *http.Request, files []*UploadedFile, err error) error {
+	if err != nil && !t.partialUpload(files, err) {
+		return t.LocalizedErrorJSON(w, r, err, uploadErrorStatus(err))
+	}
+
+	message := fmt.Sprintf("%d file(s) uploaded", len(files))
+	if failures, ok := err.(MultiError); ok {
+		reasons := make([]string, len(failures))
+		for i, failure := range failures {
+			reasons[i] = t.LocalizeError(r, failure)
+		}
+		message += fmt.Sprintf(", %d failed: %s", len(failures), strings.Join(reasons, "; "))
+	}
+
+	payload := JSONResponse{
+		Error:   false,
+		Message: message,
+		Data:    files,
+	}
+
+	return t.WriteJSON(w, http.StatusCreated, payload)
+}
+
+// uploadErrorStatus maps an error from UploadFiles to a HTTP status code
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrFileTooBig), errors.Is(err, ErrDirQuotaExceeded), errors.Is(err, ErrTooManyFiles), errors.Is(err, ErrImageTooLarge),
+		errors.Is(err, ErrZipTooManyEntries), errors.Is(err, ErrZipTooLarge), errors.Is(err, ErrZipCompressionRatio), errors.Is(err, ErrRequestTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrFileTypeNotPermitted), errors.Is(err, ErrFileExtensionNotPermitted), errors.Is(err, ErrExtensionMismatch), errors.Is(err, ErrAnimatedImage):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrFileExists):
+		return http.StatusConflict
+	case errors.Is(err, ErrInfected):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, ErrScannerUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrInsufficientStorage):
+		return http.StatusInsufficientStorage
+	default:
+		return http.StatusBadRequest
+	}
+}
@@ -0,0 +1,223 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTools_UploadHandler(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	jpg := readTestFile(t, "pic.jpg")
+
+	var uploadHandlerTests = []struct {
+		name           string
+		request        func() *http.Request
+		opts           []UploadHandlerOption
+		expectedStatus int
+		expectedFiles  int
+	}{
+		{
+			name: "success",
+			request: func() *http.Request {
+				return newUploadRequest(t, testUpload{"file", "img.png", png}, testUpload{"file", "pic.jpg", jpg})
+			},
+			expectedStatus: http.StatusCreated,
+			expectedFiles:  2,
+		},
+		{
+			name: "type not permitted",
+			request: func() *http.Request {
+				return newUploadRequest(t, testUpload{"file", "notes.txt", []byte("just some text")})
+			},
+			expectedStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "too big",
+			request: func() *http.Request {
+				req := newUploadRequest(t, testUpload{"file", "img.png", png})
+				req.Body = http.MaxBytesReader(httptest.NewRecorder(), req.Body, 100)
+				return req
+			},
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "not multipart",
+			request: func() *http.Request {
+				return httptest.NewRequest("POST", "/upload", bytes.NewBufferString("hello"))
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "wrong method",
+			request: func() *http.Request {
+				return httptest.NewRequest("GET", "/upload", nil)
+			},
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name: "restricted fields",
+			request: func() *http.Request {
+				return newUploadRequest(t, testUpload{"avatar", "img.png", png}, testUpload{"other", "pic.jpg", jpg})
+			},
+			opts:           []UploadHandlerOption{WithUploadFields("avatar")},
+			expectedStatus: http.StatusCreated,
+			expectedFiles:  1,
+		},
+		{
+			name: "callback error",
+			request: func() *http.Request {
+				return newUploadRequest(t, testUpload{"file", "img.png", png})
+			},
+			opts: []UploadHandlerOption{WithUploadCallback(func(r *http.Request, files []*UploadedFile) error {
+				return errors.New("database unavailable")
+			})},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	testTools := Tools{AllowedFileTypes: []string{"image/png", "image/jpeg"}}
+
+	for _, e := range uploadHandlerTests {
+		dir := t.TempDir()
+
+		rr := httptest.NewRecorder()
+		testTools.UploadHandler(dir, e.opts...)(rr, e.request())
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expectedStatus, rr.Code, rr.Body.String())
+			continue
+		}
+
+		var payload struct {
+			Error   bool            `json:"error"`
+			Message string          `json:"message"`
+			Data    []*UploadedFile `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+			t.Errorf("%s: could not decode response: %s", e.name, err)
+			continue
+		}
+
+		if e.expectedStatus != http.StatusCreated {
+			if !payload.Error {
+				t.Errorf("%s: error set to false in JSON, and it should be true", e.name)
+			}
+			continue
+		}
+
+		if len(payload.Data) != e.expectedFiles {
+			t.Errorf("%s: expected %d files but got %d", e.name, e.expectedFiles, len(payload.Data))
+		}
+		for _, f := range payload.Data {
+			if _, err := os.Stat(filepath.Join(dir, f.NewFileName)); err != nil {
+				t.Errorf("%s: expected file to exist: %s", e.name, err)
+			}
+		}
+	}
+}
+
+func TestTools_UploadHandlerContinueOnError(t *testing.T) {
+	testTools := Tools{AllowedFileTypes: []string{"image/png"}, ContinueOnError: true}
+	dir := t.TempDir()
+
+	req := newUploadRequest(t, testUpload{"file", "img.png", readTestFile(t, "img.png")}, testUpload{"file", "notes.txt", []byte("just some text")})
+	rr := httptest.NewRecorder()
+	testTools.UploadHandler(dir)(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status %d but got %d: %s", http.StatusCreated, rr.Code, rr.Body.String())
+	}
+	var payload JSONResponse
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Error || !strings.Contains(payload.Message, "1 file(s) uploaded, 1 failed") || !strings.Contains(payload.Message, "notes.txt") {
+		t.Errorf("expected the message to report the failed file, got %+v", payload)
+	}
+	if remaining := remainingFiles(t, dir); len(remaining) != 1 {
+		t.Errorf("expected the good file to be kept, got %v", remaining)
+	}
+
+	// a request whose files all fail is still refused
+	req = newUploadRequest(t, testUpload{"file", "notes.txt", []byte("just some text")})
+	rr = httptest.NewRecorder()
+	testTools.UploadHandler(dir)(rr, req)
+	if rr.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected status %d but got %d", http.StatusUnsupportedMediaType, rr.Code)
+	}
+}
+
+func TestTools_WriteUploadResponse(t *testing.T) {
+	png := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		uploads  []testUpload
+		expected int
+	}{
+		{name: "saved", tools: Tools{ComputeChecksum: true}, uploads: []testUpload{{"file", "img.png", png}}, expected: http.StatusCreated},
+		{name: "too big", tools: Tools{MaxFilePerSize: 10}, uploads: []testUpload{{"file", "img.png", png}}, expected: http.StatusRequestEntityTooLarge},
+		{name: "type not permitted", tools: Tools{AllowedFileTypes: []string{"image/jpeg"}}, uploads: []testUpload{{"file", "img.png", png}}, expected: http.StatusUnsupportedMediaType},
+		{name: "no files", expected: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		files, err := e.tools.UploadFiles(newUploadRequest(t, e.uploads...), t.TempDir())
+		rr := httptest.NewRecorder()
+		if err := e.tools.WriteUploadResponse(rr, files, err); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != e.expected {
+			t.Errorf("%s: expected status %d but got %d: %s", e.name, e.expected, rr.Code, rr.Body.String())
+			continue
+		}
+
+		var payload struct {
+			Error   bool            `json:"error"`
+			Message string          `json:"message"`
+			Data    []*UploadedFile `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+			t.Fatal(err)
+		}
+		if payload.Error != (e.expected != http.StatusCreated) {
+			t.Errorf("%s: unexpected payload %+v", e.name, payload)
+		}
+		if e.expected == http.StatusCreated {
+			if len(payload.Data) != 1 || payload.Data[0].ContentType != "image/png" || payload.Data[0].FileSize != int64(len(png)) || payload.Data[0].SHA256 != files[0].SHA256 {
+				t.Errorf("%s: expected the saved file in the response, got %+v", e.name, payload.Data)
+			}
+			if payload.Message != "1 file(s) uploaded" {
+				t.Errorf("%s: unexpected message %q", e.name, payload.Message)
+			}
+		}
+	}
+}
+
+func TestTools_UploadHandlerCallback(t *testing.T) {
+	var testTools Tools
+
+	var saved []*UploadedFile
+	h := testTools.UploadHandler(t.TempDir(), WithUploadRename(false), WithUploadCallback(func(r *http.Request, files []*UploadedFile) error {
+		saved = files
+		return nil
+	}))
+
+	rr := httptest.NewRecorder()
+	h(rr, newUploadRequest(t, testUpload{"file", "img.png", readTestFile(t, "img.png")}))
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("expected status 201 but got %d", rr.Code)
+	}
+	if len(saved) != 1 || saved[0].NewFileName != "img.png" {
+		t.Errorf("expected callback to receive the original file name, got %+v", saved)
+	}
+}
@@ -0,0 +1,132 @@
+package toolkit
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"  // register the GIF decoder with image.DecodeConfig
+	_ "image/jpeg" // register the JPEG decoder with image.DecodeConfig
+	_ "image/png"  // register the PNG decoder with image.DecodeConfig
+	"io"
+)
+
+// ErrImageTooLarge is matched, via errors.Is, by the *ImageTooLargeError returned by UploadFiles for an image wider
+// than MaxImageWidth, taller than MaxImageHeight or with more pixels than MaxImagePixels
+var ErrImageTooLarge = errors.New("uploaded image is too large")
+
+// ImageTooLargeError is returned by UploadFiles when an uploaded image has dimensions over MaxImageWidth,
+// MaxImageHeight or MaxImagePixels
+type ImageTooLargeError struct {
+	FileName      string
+	Width, Height int
+}
+
+// Error implements the error interface
+func (e *ImageTooLargeError) Error() string {
+	return englishMessage("upload.image_too_large", e.FileName, e.Width, e.Height)
+}
+
+// Is reports whether target is ErrImageTooLarge
+func (e *ImageTooLargeError) Is(target error) bool {
+	return target == ErrImageTooLarge
+}
+
+func (e *ImageTooLargeError) messageKey() (string, []interface{}) {
+	return "upload.image_too_large", []interface{}{e.FileName, e.Width, e.Height}
+}
+
+// ErrInvalidImage is matched, via errors.Is, by the *InvalidImageError returned by UploadFiles for an image whose
+// dimensions can't be read
+var ErrInvalidImage = errors.New("uploaded image is not valid")
+
+// InvalidImageError is returned by UploadFiles when an image's dimensions are to be checked, and its header can't be
+// decoded. Err is the error from image.DecodeConfig
+type InvalidImageError struct {
+	FileName string
+	Err      error
+}
+
+// Error implements the error interface
+func (e *InvalidImageError) Error() string {
+	return englishMessage("upload.image_invalid", e.FileName)
+}
+
+// Is reports whether target is ErrInvalidImage
+func (e *InvalidImageError) Is(target error) bool {
+	return target == ErrInvalidImage
+}
+
+// Unwrap returns the error from image.DecodeConfig
+func (e *InvalidImageError) Unwrap() error {
+	return e.Err
+}
+
+func (e *InvalidImageError) messageKey() (string, []interface{}) {
+	return "upload.image_invalid", []interface{}{e.FileName}
+}
+
+// imageHeaderLimit is the most bytes of an uploaded image read to find its dimensions. A JPEG may hold metadata
+// before the frame header that has them, but not this much
+const imageHeaderLimit = 1 << 20
+
+// checksImageSize reports whether any of MaxImageWidth, MaxImageHeight and MaxImagePixels is set
+func (t *Tools) checksImageSize() bool {
+	return t.MaxImageWidth > 0 || t.MaxImageHeight > 0 || t.MaxImagePixels > 0
+}
+
+// checkImageSize refuses a PNG, JPEG or GIF image read from r whose dimensions are over the limits, from its header
+func (t *Tools) checkImageSize(filename, fileType string, r io.Reader) error {
+	if !t.checksImageSize() {
+		return nil
+	}
+	switch fileType {
+	case "image/png", "image/jpeg", "image/gif":
+	default:
+		return nil
+	}
+
+	header := &headerReader{r: io.LimitReader(r, imageHeaderLimit)}
+	cfg, _, err := image.DecodeConfig(header)
+	if header.err != nil {
+		// the file couldn't be read, which says nothing about the image
+		return header.err
+	}
+	if err != nil {
+		return &InvalidImageError{FileName: filename, Err: err}
+	}
+
+	if (t.MaxImageWidth > 0 && cfg.Width > t.MaxImageWidth) ||
+		(t.MaxImageHeight > 0 && cfg.Height > t.MaxImageHeight) ||
+		(t.MaxImagePixels > 0 && int64(cfg.Width)*int64(cfg.Height) > t.MaxImagePixels) {
+		return &ImageTooLargeError{FileName: filename, Width: cfg.Width, Height: cfg.Height}
+	}
+	return nil
+}
+
+// checkStreamedImageSize is checkImageSize for a file that can only be read once. It returns a reader that gives the
+// whole of the file read from r, the bytes read to decode its header included
+func (t *Tools) checkStreamedImageSize(filename, fileType string, r io.Reader) (io.Reader, error) {
+	if !t.checksImageSize() {
+		return r, nil
+	}
+
+	var header bytes.Buffer
+	if err := t.checkImageSize(filename, fileType, io.TeeReader(r, &header)); err != nil {
+		return nil, err
+	}
+	return io.MultiReader(&header, r), nil
+}
+
+// headerReader reads the header of an image from r, and keeps any error other than io.EOF that reading it gives
+type headerReader struct {
+	r   io.Reader
+	err error
+}
+
+func (h *headerReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	if err != nil && err != io.EOF {
+		h.err = err
+	}
+	return n, err
+}
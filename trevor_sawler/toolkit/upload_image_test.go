@@ -0,0 +1,91 @@
+package toolkit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+// pngHeader returns the signature and IHDR chunk of a PNG image of the given dimensions, which is all that
+// image.DecodeConfig reads of it
+func pngHeader(width, height uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+
+	chunk := make([]byte, 0, 17)
+	chunk = append(chunk, "IHDR"...)
+	chunk = binary.BigEndian.AppendUint32(chunk, width)
+	chunk = binary.BigEndian.AppendUint32(chunk, height)
+	chunk = append(chunk, 8, 2, 0, 0, 0) // 8-bit RGB, no interlacing
+
+	_ = binary.Write(&buf, binary.BigEndian, uint32(13))
+	buf.Write(chunk)
+	_ = binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestTools_UploadFilesImageSize(t *testing.T) {
+	img := readTestFile(t, "img.png") // 640x426
+	pic := readTestFile(t, "pic.jpg")
+
+	// a PNG whose header claims a huge image, with none of the pixels that would make it one
+	huge := append(pngHeader(50000, 50000), bytes.Repeat([]byte{0}, 64)...)
+	corrupt := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0xff}, 64)...)
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		filename string
+		content  []byte
+		expected error
+	}{
+		{name: "no limits", filename: "img.png", content: img},
+		{name: "under width and height", tools: Tools{MaxImageWidth: 640, MaxImageHeight: 426}, filename: "img.png", content: img},
+		{name: "over width", tools: Tools{MaxImageWidth: 639}, filename: "img.png", content: img, expected: ErrImageTooLarge},
+		{name: "over height", tools: Tools{MaxImageHeight: 425}, filename: "img.png", content: img, expected: ErrImageTooLarge},
+		{name: "under pixels", tools: Tools{MaxImagePixels: 640 * 426}, filename: "img.png", content: img},
+		{name: "over pixels", tools: Tools{MaxImagePixels: 640*426 - 1}, filename: "img.png", content: img, expected: ErrImageTooLarge},
+		{name: "jpeg over width", tools: Tools{MaxImageWidth: 10}, filename: "pic.jpg", content: pic, expected: ErrImageTooLarge},
+		{name: "huge header", tools: Tools{MaxImagePixels: 40_000_000}, filename: "huge.png", content: huge, expected: ErrImageTooLarge},
+		{name: "corrupt header", tools: Tools{MaxImageWidth: 1000}, filename: "bad.png", content: corrupt, expected: ErrInvalidImage},
+		{name: "not an image", tools: Tools{MaxImageWidth: 1}, filename: "notes.txt", content: []byte("just some text")},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			dir := t.TempDir()
+			req := newUploadRequest(t, testUpload{field: "file", filename: e.filename, content: e.content})
+			uploadedFiles, err := upload(&e.tools, req, dir)
+
+			if e.expected == nil {
+				if err != nil {
+					t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+					continue
+				}
+				if uploadedFiles[0].FileSize != int64(len(e.content)) {
+					t.Errorf("%s, %s: expected all %d bytes to be saved, got %d", e.name, name, len(e.content), uploadedFiles[0].FileSize)
+				}
+				continue
+			}
+
+			if !errors.Is(err, e.expected) {
+				t.Errorf("%s, %s: expected %v, got %v", e.name, name, e.expected, err)
+			}
+			if names := remainingFiles(t, dir); names != nil {
+				t.Errorf("%s, %s: expected nothing to be saved, got %v", e.name, name, names)
+			}
+		}
+	}
+}
+
+func TestImageTooLargeError(t *testing.T) {
+	err := error(&ImageTooLargeError{FileName: "big.png", Width: 4000, Height: 3000})
+	if err.Error() != `the uploaded image "big.png" is 4000x3000 pixels, which is larger than permitted` {
+		t.Errorf("unexpected message %q", err)
+	}
+	if status := uploadErrorStatus(err); status != 413 {
+		t.Errorf("expected status 413, got %d", status)
+	}
+}
@@ -0,0 +1,56 @@
+package toolkit
+
+// UploadOption configures a single call of UploadFiles or UploadFilesContext
+type UploadOption func(*uploadConfig)
+
+type uploadConfig struct {
+	rename   bool
+	fields   []string
+	naming   *NamingStrategy
+	progress func(filename string, bytesWritten, totalBytes int64)
+}
+
+// WithRename sets whether uploaded files are given random names (the default), in place of the rename flag
+// UploadFiles used to take
+func WithRename(rename bool) UploadOption {
+	return func(c *uploadConfig) { c.rename = rename }
+}
+
+// WithFields restricts the upload to files posted under the given form field names. Files under any other field
+// name are ignored, as they are by the handler of WithUploadFields
+func WithFields(names ...string) UploadOption {
+	return func(c *uploadConfig) { c.fields = names }
+}
+
+// WithNaming sets the NamingStrategy of this upload, in place of that of the Tools
+func WithNaming(strategy NamingStrategy) UploadOption {
+	return func(c *uploadConfig) { c.naming = &strategy }
+}
+
+// WithProgress sets the function called as the files of this upload are saved, in place of OnProgress
+func WithProgress(fn func(filename string, bytesWritten, totalBytes int64)) UploadOption {
+	return func(c *uploadConfig) { c.progress = fn }
+}
+
+// withOptions resolves opts once, into a copy of t made for this call when one of them sets a field of it, so a Tools
+// shared between requests is never changed by them
+func (t *Tools) withOptions(opts []UploadOption) (*Tools, uploadConfig) {
+	cfg := uploadConfig{rename: true}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	call := t
+	if cfg.naming != nil || cfg.progress != nil {
+		copied := *t
+		if cfg.naming != nil {
+			copied.NamingStrategy = *cfg.naming
+		}
+		if cfg.progress != nil {
+			copied.OnProgress = cfg.progress
+		}
+		call = &copied
+	}
+
+	return call, cfg
+}
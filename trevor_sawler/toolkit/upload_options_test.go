@@ -0,0 +1,60 @@
+package toolkit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTools_UploadFilesOptions(t *testing.T) {
+	png := readTestFile(t, "img.png")
+	uploads := []testUpload{{"avatar", "My Photo.png", png}, {"other", "other.png", png}}
+
+	var progressed []string
+	var tests = []struct {
+		name     string
+		opts     []UploadOption
+		tempDir  bool
+		expected []string
+	}{
+		{name: "defaults", expected: []string{"", ""}},
+		{name: "keep names", opts: []UploadOption{WithRename(false)}, expected: []string{"My Photo.png", "other.png"}},
+		{name: "fields", opts: []UploadOption{WithRename(false), WithFields("avatar")}, expected: []string{"My Photo.png"}},
+		{name: "fields with a temp dir", opts: []UploadOption{WithRename(false), WithFields("avatar")}, tempDir: true, expected: []string{"My Photo.png"}},
+		{name: "naming", opts: []UploadOption{WithNaming(NamingSlug)}, expected: []string{"my-photo.png", "other.png"}},
+		{name: "progress", opts: []UploadOption{WithRename(false), WithProgress(func(filename string, _, _ int64) {
+			progressed = append(progressed, filename)
+		})}, expected: []string{"My Photo.png", "other.png"}},
+	}
+
+	testTools := Tools{}
+	for _, e := range tests {
+		call := testTools
+		if e.tempDir {
+			call.TempDir = t.TempDir()
+		}
+		files, err := call.UploadFiles(newUploadRequest(t, uploads...), t.TempDir(), e.opts...)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+		var names []string
+		for _, f := range files {
+			if f.NewFileName != f.OriginalFileName && len(f.NewFileName) == 29 {
+				names = append(names, "")
+			} else {
+				names = append(names, f.NewFileName)
+			}
+		}
+		if !reflect.DeepEqual(names, e.expected) {
+			t.Errorf("%s: expected the names %q, got %q", e.name, e.expected, names)
+		}
+	}
+
+	if len(progressed) != 2 {
+		t.Errorf("expected progress to be reported for both files, got %v", progressed)
+	}
+	// the options only applied to their own call
+	if testTools.NamingStrategy != NamingRename || testTools.OnProgress != nil {
+		t.Errorf("expected the Tools not to be changed, got %+v", testTools)
+	}
+}
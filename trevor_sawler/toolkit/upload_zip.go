@@ -0,0 +1,327 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// zipContentType is the type http.DetectContentType gives a ZIP archive, and the archives based on it, such as
+// .docx and .jar files
+const zipContentType = "application/zip"
+
+// ZipLimits are the limits an uploaded ZIP archive is checked against, from its central directory, before it is
+// saved. A zero limit is not checked
+type ZipLimits struct {
+	// MaxEntries is the most files and directories the archive may hold
+	MaxEntries int
+	// MaxUncompressedSize is the most bytes its entries may add up to once extracted
+	MaxUncompressedSize int64
+	// MaxCompressionRatio is the most times any one entry may be smaller compressed than extracted
+	MaxCompressionRatio float64
+}
+
+// ErrInvalidZip is matched, via errors.Is, by every *ZipError
+var ErrInvalidZip = errors.New("uploaded ZIP archive is not permitted")
+
+var (
+	// ErrZipTooManyEntries is the Reason of a *ZipError for an archive with more than MaxEntries entries
+	ErrZipTooManyEntries = errors.New("ZIP archive has too many entries")
+	// ErrZipTooLarge is the Reason of a *ZipError for an archive whose entries add up to more than
+	// MaxUncompressedSize
+	ErrZipTooLarge = errors.New("ZIP archive is too large uncompressed")
+	// ErrZipCompressionRatio is the Reason of a *ZipError for an archive with an entry compressed more than
+	// MaxCompressionRatio times, as in a zip bomb
+	ErrZipCompressionRatio = errors.New("ZIP archive entry is compressed too much")
+	// ErrZipUnsafePath is the Reason of a *ZipError for an archive with an entry that would be extracted outside the
+	// directory it is extracted to, as ../../etc/passwd or /etc/passwd would
+	ErrZipUnsafePath = errors.New("ZIP archive entry has an unsafe path")
+)
+
+// ZipError is returned when an uploaded ZIP archive fails ZipLimits or can't be read. Reason is the error, such as
+// ErrZipTooLarge, and Entry the entry at fault, if any
+type ZipError struct {
+	FileName string
+	Entry    string
+	Reason   error
+}
+
+// Error implements the error interface
+func (e *ZipError) Error() string {
+	key, args := e.messageKey()
+	return englishMessage(key, args...)
+}
+
+// Is reports whether target is ErrInvalidZip
+func (e *ZipError) Is(target error) bool {
+	return target == ErrInvalidZip
+}
+
+// Unwrap returns the Reason
+func (e *ZipError) Unwrap() error {
+	return e.Reason
+}
+
+func (e *ZipError) messageKey() (string, []interface{}) {
+	switch e.Reason {
+	case ErrZipTooManyEntries:
+		return "upload.zip_entries", []interface{}{e.FileName}
+	case ErrZipTooLarge:
+		return "upload.zip_too_large", []interface{}{e.FileName}
+	case ErrZipCompressionRatio:
+		return "upload.zip_ratio", []interface{}{e.Entry, e.FileName}
+	case ErrZipUnsafePath:
+		return "upload.zip_unsafe_path", []interface{}{e.Entry, e.FileName}
+	}
+	// an entry that archive/zip can read, but that fails the checks an uploaded file goes through, is refused
+	if e.Entry != "" && !errors.Is(e.Reason, zip.ErrFormat) && !errors.Is(e.Reason, zip.ErrAlgorithm) && !errors.Is(e.Reason, zip.ErrChecksum) {
+		return "upload.zip_entry_refused", []interface{}{e.Entry, e.FileName}
+	}
+	return "upload.zip_invalid", []interface{}{e.FileName}
+}
+
+// checksZip reports whether an uploaded file of type fileType is checked against ZipLimits, or extracted
+func (t *Tools) checksZip(fileType string) bool {
+	return (t.ZipLimits != nil || t.ExtractArchives) && fileType == zipContentType
+}
+
+// zipLimits returns ZipLimits, or no limits when it isn't set
+func (t *Tools) zipLimits() ZipLimits {
+	if t.ZipLimits == nil {
+		return ZipLimits{}
+	}
+	return *t.ZipLimits
+}
+
+// checkZip walks the central directory of the ZIP archive filename, of size bytes read from r, and returns a
+// *ZipError when it breaks limits, has an entry with an unsafe path, or isn't a ZIP archive
+func checkZip(filename string, r io.ReaderAt, size int64, limits ZipLimits) (*zip.Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, &ZipError{FileName: filename, Reason: err}
+	}
+	if limits.MaxEntries > 0 && len(zr.File) > limits.MaxEntries {
+		return nil, &ZipError{FileName: filename, Reason: ErrZipTooManyEntries}
+	}
+
+	var total uint64
+	for _, f := range zr.File {
+		if unsafeZipPath(f.Name) {
+			return nil, &ZipError{FileName: filename, Entry: f.Name, Reason: ErrZipUnsafePath}
+		}
+
+		// the sum is checked before it is added to, so that it can't overflow
+		if limits.MaxUncompressedSize > 0 && f.UncompressedSize64 > uint64(limits.MaxUncompressedSize)-total {
+			return nil, &ZipError{FileName: filename, Entry: f.Name, Reason: ErrZipTooLarge}
+		}
+		total += f.UncompressedSize64
+
+		// an entry of some bytes that takes none compressed has no ratio, and is refused along with the rest
+		if limits.MaxCompressionRatio > 0 && f.UncompressedSize64 > 0 &&
+			(f.CompressedSize64 == 0 || float64(f.UncompressedSize64)/float64(f.CompressedSize64) > limits.MaxCompressionRatio) {
+			return nil, &ZipError{FileName: filename, Entry: f.Name, Reason: ErrZipCompressionRatio}
+		}
+	}
+	return zr, nil
+}
+
+// unsafeZipPath reports whether an entry called name is absolute, has a colon or climbs out with "..", taking
+// backslashes as separators
+func unsafeZipPath(name string) bool {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if path.IsAbs(name) || strings.Contains(name, ":") {
+		return true
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// extractZip saves each file of zr, checked as an upload, under the directory named after uploadedFile, up to limit
+// bytes in all. When anything fails, the files already extracted are removed
+func (t *Tools) extractZip(ctx context.Context, store FileStore, uploadedFile *UploadedFile, zr *zip.Reader, limit int64) error {
+	if limit == 0 {
+		limit = defaultMaxFileSize
+	}
+	filename := uploadedFile.OriginalFileName
+	dir := extractDir(uploadedFile.StoredPath)
+
+	fail := func(err error) error {
+		removeExtracted(store, uploadedFile)
+		return err
+	}
+
+	var total int64
+	seen := make(map[string]bool, len(zr.File))
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		name, err := t.extractedName(f.Name)
+		if err != nil {
+			return fail(&ZipError{FileName: filename, Entry: f.Name, Reason: err})
+		}
+		name = dir + "/" + name
+
+		rc, err := f.Open()
+		if err != nil {
+			return fail(&ZipError{FileName: filename, Entry: f.Name, Reason: err})
+		}
+		src := &zipEntryReader{r: rc, remaining: limit - total}
+		n, err := t.saveExtractedFile(ctx, store, filename, f.Name, name, src)
+		rc.Close()
+		if src.err != nil {
+			return fail(&ZipError{FileName: filename, Entry: f.Name, Reason: src.err})
+		}
+		if err != nil {
+			return fail(err)
+		}
+		total += n
+
+		// an archive may hold the same name twice, when the later file replaces the earlier
+		if !seen[name] {
+			seen[name] = true
+			uploadedFile.ExtractedFiles = append(uploadedFile.ExtractedFiles, name)
+		}
+	}
+	return nil
+}
+
+// extractDir returns the directory the archive stored as name is extracted to: name without its extension, or with
+// "_files" added when it has none
+func extractDir(name string) string {
+	if ext := path.Ext(name); ext != "" {
+		return strings.TrimSuffix(name, ext)
+	}
+	return name + "_files"
+}
+
+// extractedName returns the safe path, relative to the extraction directory, of the entry called name, or
+// ErrZipUnsafePath when it would land outside it
+func (t *Tools) extractedName(name string) (string, error) {
+	if unsafeZipPath(name) {
+		return "", ErrZipUnsafePath
+	}
+
+	var elems []string
+	for _, elem := range strings.Split(strings.ReplaceAll(name, `\`, "/"), "/") {
+		if elem == "" || elem == "." {
+			continue
+		}
+		safe, err := safeFileName(elem)
+		safe, err = t.refuseHiddenName(name, safe, err)
+		if err == nil {
+			safe, err = t.shortenFileName(name, safe)
+		}
+		if err != nil {
+			return "", err
+		}
+		elems = append(elems, safe)
+	}
+	stored := strings.Join(elems, "/")
+	if !storedPath(stored) {
+		return "", ErrZipUnsafePath
+	}
+	if err := t.checkFileExtension(elems[len(elems)-1]); err != nil {
+		return "", err
+	}
+	return stored, nil
+}
+
+// saveExtractedFile saves the archive entry read from r to store as name once it passes the checks of an uploaded
+// file. An entry that fails them gets a *ZipError
+func (t *Tools) saveExtractedFile(ctx context.Context, store FileStore, filename, entry, name string, r io.Reader) (int64, error) {
+	refuse := func(err error) error {
+		return &ZipError{FileName: filename, Entry: entry, Reason: err}
+	}
+	extractErr := func(err error) error {
+		return fmt.Errorf("could not extract %q from uploaded file %q: %w", entry, filename, err)
+	}
+
+	head := make([]byte, t.sniffLength())
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, extractErr(err)
+	}
+	head = head[:n]
+
+	base := path.Base(name)
+	fileType := t.detectContentType(head, base)
+	if t.svgRefused(fileType) || !fileTypeAllowed(fileType, t.AllowedFileTypes) || fileTypeDenied(fileType, t.DeniedFileTypes) {
+		return 0, refuse(&FileTypeError{FileName: entry, ContentType: fileType})
+	}
+	if err := t.checkExtensionMatches(base, fileType); err != nil {
+		return 0, refuse(err)
+	}
+
+	src := io.MultiReader(bytes.NewReader(head), r)
+	if t.sanitizesSVG(fileType) {
+		clean, err := t.sanitizeUploadedSVG(entry, fileType, src)
+		if err != nil {
+			if errors.Is(err, ErrInvalidImage) || errors.Is(err, ErrFileTooBig) {
+				return 0, refuse(err)
+			}
+			return 0, extractErr(err)
+		}
+		src = clean
+	}
+
+	// The entry is scanned from a copy in TempDir before it is saved, so that one the scanner rejects is never stored
+	// under its name
+	if scan := t.newUploadScan(ctx); scan != nil {
+		defer scan.stop()
+		again, _, release, err := rereadable(src, io.TeeReader(src, scan), t.TempDir)
+		if err != nil {
+			if scanErr := t.scanResult(entry, scan.stop()); scanErr != nil {
+				return 0, refuse(scanErr)
+			}
+			return 0, extractErr(err)
+		}
+		defer release()
+		if err := t.scanResult(entry, scan.wait()); err != nil {
+			return 0, refuse(err)
+		}
+		src = again
+	}
+	saved, err := store.Save(ctx, name, src)
+	if err != nil {
+		return 0, extractErr(err)
+	}
+	return saved, nil
+}
+
+// removeExtracted removes the files extracted from an uploaded archive
+func removeExtracted(store FileStore, uploadedFile *UploadedFile) {
+	for _, name := range uploadedFile.ExtractedFiles {
+		store.Remove(context.Background(), name)
+	}
+	uploadedFile.ExtractedFiles = nil
+}
+
+// zipEntryReader reads an entry of an archive as it is extracted, failing with ErrZipTooLarge once more than
+// remaining bytes have been read. It keeps any error reading the entry, to tell it from an error of the store
+type zipEntryReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (z *zipEntryReader) Read(p []byte) (int, error) {
+	n, err := z.r.Read(p)
+	z.remaining -= int64(n)
+	if z.remaining < 0 {
+		err = ErrZipTooLarge
+	}
+	if err != nil && err != io.EOF {
+		z.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,298 @@
+package toolkit
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// zipEntry is a file of a ZIP archive made by makeZip
+type zipEntry struct {
+	name    string
+	content []byte
+}
+
+// makeZip returns a ZIP archive of entries, deflated
+func makeZip(t *testing.T, entries ...zipEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTools_UploadFilesZip(t *testing.T) {
+	text := []byte("some text that is not very compressible: 1a2b3c4d5e6f")
+	limits := ZipLimits{MaxEntries: 3, MaxUncompressedSize: 1 << 20, MaxCompressionRatio: 100}
+
+	var tests = []struct {
+		name    string
+		limits  *ZipLimits
+		archive []byte
+		reason  error
+		entry   string
+	}{
+		{name: "valid", limits: &limits, archive: makeZip(t, zipEntry{"a.txt", text}, zipEntry{"docs/b.txt", text})},
+		{name: "not checked", archive: makeZip(t, zipEntry{"../evil.txt", text})},
+		{name: "no limits", limits: &ZipLimits{}, archive: makeZip(t, zipEntry{"a.txt", bytes.Repeat([]byte{0}, 2<<20)})},
+		{name: "parent directory", limits: &limits, archive: makeZip(t, zipEntry{"a.txt", text}, zipEntry{"docs/../../evil.txt", text}), reason: ErrZipUnsafePath, entry: "docs/../../evil.txt"},
+		{name: "backslashes", limits: &limits, archive: makeZip(t, zipEntry{`..\evil.txt`, text}), reason: ErrZipUnsafePath, entry: `..\evil.txt`},
+		{name: "absolute path", limits: &limits, archive: makeZip(t, zipEntry{"/etc/cron.d/evil", text}), reason: ErrZipUnsafePath, entry: "/etc/cron.d/evil"},
+		{name: "drive letter", limits: &limits, archive: makeZip(t, zipEntry{"C:/evil.txt", text}), reason: ErrZipUnsafePath, entry: "C:/evil.txt"},
+		{name: "too many entries", limits: &limits, archive: makeZip(t, zipEntry{"a", text}, zipEntry{"b", text}, zipEntry{"c", text}, zipEntry{"d", text}), reason: ErrZipTooManyEntries},
+		{name: "too large", limits: &ZipLimits{MaxUncompressedSize: 100}, archive: makeZip(t, zipEntry{"a.txt", text}, zipEntry{"b.txt", text}), reason: ErrZipTooLarge, entry: "b.txt"},
+		{name: "zip bomb", limits: &limits, archive: makeZip(t, zipEntry{"a.txt", text}, zipEntry{"zeros", bytes.Repeat([]byte{0}, 512<<10)}), reason: ErrZipCompressionRatio, entry: "zeros"},
+		{name: "truncated", limits: &limits, archive: makeZip(t, zipEntry{"a.txt", text})[:40], reason: zip.ErrFormat},
+	}
+
+	for _, e := range tests {
+		for name, upload := range uploadFuncs {
+			testTools := Tools{ZipLimits: e.limits}
+			dir := t.TempDir()
+			req := newUploadRequest(t, testUpload{field: "file", filename: "archive.zip", content: e.archive})
+			files, err := upload(&testTools, req, dir)
+
+			if e.reason == nil {
+				if err != nil {
+					t.Errorf("%s, %s: unexpected error: %s", e.name, name, err)
+				} else if files[0].ContentType != "application/zip" {
+					t.Errorf("%s, %s: expected application/zip, got %s", e.name, name, files[0].ContentType)
+				}
+				continue
+			}
+
+			var zipErr *ZipError
+			if !errors.As(err, &zipErr) || !errors.Is(err, ErrInvalidZip) || !errors.Is(err, e.reason) {
+				t.Errorf("%s, %s: expected a *ZipError for %v, got %v", e.name, name, e.reason, err)
+				continue
+			}
+			if zipErr.FileName != "archive.zip" || zipErr.Entry != e.entry {
+				t.Errorf("%s, %s: expected the error to name archive.zip and %q, got %q and %q", e.name, name, e.entry, zipErr.FileName, zipErr.Entry)
+			}
+			if names := remainingFiles(t, dir); names != nil {
+				t.Errorf("%s, %s: expected the archive not to be saved, got %v", e.name, name, names)
+			}
+		}
+	}
+}
+
+func TestTools_UploadFilesToZip(t *testing.T) {
+	bomb := makeZip(t, zipEntry{"zeros", bytes.Repeat([]byte{0}, 1<<20)})
+	testTools := Tools{ZipLimits: &ZipLimits{MaxCompressionRatio: 100}}
+
+	// an archive is checked before it reaches any store, so a store that fails every Save never sees the bomb
+	memory := &MemoryStore{}
+	stores := map[string]FileStore{"MemoryStore": memory, "other store": struct{ FileStore }{memory}}
+	for name, store := range stores {
+		req := newUploadRequest(t, testUpload{field: "file", filename: "bomb.zip", content: bomb})
+		if _, err := testTools.UploadFilesTo(req, store); !errors.Is(err, ErrZipCompressionRatio) {
+			t.Errorf("%s: expected ErrZipCompressionRatio, got %v", name, err)
+		}
+		if names := memory.Names(); len(names) != 0 {
+			t.Errorf("%s: expected the archive not to be saved, got %v", name, names)
+		}
+
+		req = newUploadRequest(t, testUpload{field: "file", filename: "ok.zip", content: makeZip(t, zipEntry{"a.txt", []byte("hello")})})
+		files, err := testTools.UploadFilesTo(req, store)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		memory.Remove(req.Context(), files[0].StoredPath)
+	}
+
+	failing := &failingStore{err: errors.New("the archive was saved")}
+	req := newUploadRequest(t, testUpload{field: "file", filename: "bomb.zip", content: bomb})
+	if _, err := testTools.UploadFilesTo(req, failing); !errors.Is(err, ErrZipCompressionRatio) {
+		t.Errorf("expected ErrZipCompressionRatio before the archive was saved, got %v", err)
+	}
+}
+
+func TestZipError(t *testing.T) {
+	var tests = []struct {
+		err     *ZipError
+		message string
+		status  int
+	}{
+		{err: &ZipError{FileName: "a.zip", Entry: "../x", Reason: ErrZipUnsafePath}, message: `the entry "../x" of the uploaded archive "a.zip" would be extracted outside its directory`, status: http.StatusBadRequest},
+		{err: &ZipError{FileName: "a.zip", Entry: "x", Reason: ErrZipCompressionRatio}, message: `the entry "x" of the uploaded archive "a.zip" is compressed more than permitted`, status: http.StatusRequestEntityTooLarge},
+		{err: &ZipError{FileName: "a.zip", Reason: ErrZipTooManyEntries}, message: `the uploaded archive "a.zip" has more entries than permitted`, status: http.StatusRequestEntityTooLarge},
+		{err: &ZipError{FileName: "a.zip", Reason: zip.ErrFormat}, message: `the uploaded file "a.zip" is not a valid ZIP archive`, status: http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		if e.err.Error() != e.message {
+			t.Errorf("%v: unexpected message %q", e.err.Reason, e.err.Error())
+		}
+		if status := uploadErrorStatus(e.err); status != e.status {
+			t.Errorf("%v: expected status %d, got %d", e.err.Reason, e.status, status)
+		}
+	}
+}
+
+func TestTools_UploadFilesExtractArchives(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range []zipEntry{{"a.txt", []byte("alpha")}, {"docs/b.txt", []byte("bravo")}, {"./docs//c.txt", []byte("charlie")}, {"empty/", nil}} {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(e.content)
+	}
+	link := &zip.FileHeader{Name: "link"}
+	link.SetMode(os.ModeSymlink | 0777)
+	w, err := zw.CreateHeader(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("/etc/passwd"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := buf.Bytes()
+
+	// an entry holding more than its header declares fails as it is extracted, after the entries before it
+	var lyingBuf bytes.Buffer
+	zw = zip.NewWriter(&lyingBuf)
+	w, _ = zw.Create("a.txt")
+	w.Write([]byte("alpha"))
+	w, err = zw.CreateRaw(&zip.FileHeader{Name: "b.txt", Method: zip.Store, CompressedSize64: 10, UncompressedSize64: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("0123456789"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lying := lyingBuf.Bytes()
+
+	for name, upload := range uploadFuncs {
+		testTools := Tools{ExtractArchives: true}
+		dir := t.TempDir()
+		req := newUploadRequest(t, testUpload{field: "file", filename: "archive.zip", content: archive})
+		files, err := upload(&testTools, req, dir, false)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", name, err)
+			continue
+		}
+		expected := []string{"archive/a.txt", "archive/docs/b.txt", "archive/docs/c.txt"}
+		if !reflect.DeepEqual(files[0].ExtractedFiles, expected) {
+			t.Errorf("%s: expected %v to be extracted, got %v", name, expected, files[0].ExtractedFiles)
+		}
+		if names := remainingFiles(t, dir); !reflect.DeepEqual(names, append([]string{"archive.zip"}, expected...)) {
+			t.Errorf("%s: unexpected files %v", name, names)
+		}
+		if content, _ := os.ReadFile(filepath.Join(dir, "archive", "docs", "c.txt")); string(content) != "charlie" {
+			t.Errorf("%s: expected c.txt to hold charlie, got %q", name, content)
+		}
+
+		dir = t.TempDir()
+		req = newUploadRequest(t, testUpload{field: "file", filename: "lying.zip", content: lying})
+		if _, err := upload(&testTools, req, dir); !errors.Is(err, ErrInvalidZip) || !errors.Is(err, zip.ErrFormat) {
+			t.Errorf("%s: expected a *ZipError for zip.ErrFormat, got %v", name, err)
+		}
+		if names := remainingFiles(t, dir); names != nil {
+			t.Errorf("%s: expected the archive and its files to be removed, got %v", name, names)
+		}
+
+		// the files extracted from an archive are removed with it when a later file of the upload fails
+		testTools.AllowedFileTypes = []string{"application/zip", "text/plain"}
+		dir = t.TempDir()
+		req = newUploadRequest(t,
+			testUpload{field: "file", filename: "archive.zip", content: archive},
+			testUpload{field: "file", filename: "img.png", content: readTestFile(t, "img.png")},
+		)
+		if _, err := upload(&testTools, req, dir); !errors.Is(err, ErrFileTypeNotPermitted) {
+			t.Errorf("%s: expected ErrFileTypeNotPermitted, got %v", name, err)
+		}
+		if names := remainingFiles(t, dir); names != nil {
+			t.Errorf("%s: expected the archive and its files to be removed, got %v", name, names)
+		}
+	}
+}
+
+// prefixScanner is a Scanner that rejects the files that begin with it, which an archive, beginning with PK, never does
+type prefixScanner string
+
+func (s prefixScanner) Scan(ctx context.Context, r io.Reader) error {
+	head := make([]byte, len(s))
+	n, _ := io.ReadFull(r, head)
+	if string(head[:n]) == string(s) {
+		return ErrInfected
+	}
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+func TestTools_UploadFilesExtractArchivesChecks(t *testing.T) {
+	png := readTestFile(t, "img.png")
+
+	var tests = []struct {
+		name     string
+		tools    Tools
+		entries  []zipEntry
+		expected error
+	}{
+		{name: "hidden file", entries: []zipEntry{{"a.txt", []byte("alpha")}, {".htaccess", []byte("Options +ExecCGI")}}, expected: ErrHiddenFile},
+		{name: "hidden directory", entries: []zipEntry{{".git/config", []byte("[core]")}}, expected: ErrHiddenFile},
+		{name: "hidden allowed", tools: Tools{AllowHiddenFiles: true}, entries: []zipEntry{{".htaccess", []byte("Options +ExecCGI")}}},
+		{name: "type not allowed", tools: Tools{AllowedFileTypes: []string{"application/zip", "image/png"}},
+			entries: []zipEntry{{"img.png", png}, {"shell.php", []byte("<?php system($_GET['c']); ?>")}}, expected: ErrFileTypeNotPermitted},
+		{name: "type denied", tools: Tools{DeniedFileTypes: []string{"text/html"}}, entries: []zipEntry{{"page.html", []byte("<html><body>hi</body></html>")}}, expected: ErrFileTypeNotPermitted},
+		{name: "extension not allowed", tools: Tools{AllowedFileExtensions: []string{"zip", "png"}}, entries: []zipEntry{{"img.png", png}, {"shell.php", png}}, expected: ErrFileExtensionNotPermitted},
+		{name: "svg refused", tools: Tools{SVGPolicy: SVGReject}, entries: []zipEntry{{"logo.svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)}}, expected: ErrFileTypeNotPermitted},
+		{name: "infected", tools: Tools{Scanner: prefixScanner("virus")}, entries: []zipEntry{{"a.txt", []byte("alpha")}, {"virus.txt", []byte("virus!")}}, expected: ErrInfected},
+		{name: "name too long", tools: Tools{MaxFileNameLength: 8}, entries: []zipEntry{{"a.verylongextension", []byte("alpha")}}, expected: ErrFileNameTooLong},
+		{name: "allowed", tools: Tools{AllowedFileTypes: []string{"application/zip", "image/png"}, AllowedFileExtensions: []string{"zip", "png"}}, entries: []zipEntry{{"img.png", png}}},
+	}
+
+	for _, e := range tests {
+		e.tools.ExtractArchives = true
+		dir := t.TempDir()
+		req := newUploadRequest(t, testUpload{field: "file", filename: "archive.zip", content: makeZip(t, e.entries...)})
+		_, err := e.tools.UploadFiles(req, dir, WithRename(false))
+		if e.expected == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %s", e.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, e.expected) || !errors.Is(err, ErrInvalidZip) {
+			t.Errorf("%s: expected a *ZipError for %v, got %v", e.name, e.expected, err)
+		}
+		if names := remainingFiles(t, dir); names != nil {
+			t.Errorf("%s: expected the archive and its files to be removed, got %v", e.name, names)
+		}
+	}
+
+	// the name of an entry is made safe as the name of an uploaded file is
+	testTools := Tools{ExtractArchives: true}
+	dir := t.TempDir()
+	req := newUploadRequest(t, testUpload{field: "file", filename: "archive.zip", content: makeZip(t, zipEntry{"docs/CON.txt", []byte("alpha")})})
+	files, err := testTools.UploadFiles(req, dir, WithRename(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"archive/docs/_CON.txt"}; !reflect.DeepEqual(files[0].ExtractedFiles, expected) {
+		t.Errorf("expected %v to be extracted, got %v", expected, files[0].ExtractedFiles)
+	}
+}
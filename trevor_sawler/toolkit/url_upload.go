@@ -0,0 +1,178 @@
+package toolkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"syscall"
+	"time"
+)
+
+// defaultURLUploadTimeout is how long UploadFromURL may take when URLUploadTimeout is not set
+const defaultURLUploadTimeout = 30 * time.Second
+
+// ErrInvalidURL is returned by UploadFromURL for a URL that can't be parsed, or that isn't http or https
+var ErrInvalidURL error = newMessageError("upload.invalid_url")
+
+// ErrForbiddenURL is returned by UploadFromURL when the URL, or a redirect it leads to, points at a loopback,
+// private, link-local or otherwise internal address, and AllowPrivateURLs is not set
+var ErrForbiddenURL error = newMessageError("upload.url_forbidden")
+
+// URLFetchError is returned by UploadFromURL when the server answers with a status other than 200 OK
+type URLFetchError struct {
+	URL        string
+	StatusCode int
+}
+
+// Error implements the error interface
+func (e *URLFetchError) Error() string {
+	return englishMessage("upload.url_fetch_failed", e.URL, e.StatusCode)
+}
+
+func (e *URLFetchError) messageKey() (string, []interface{}) {
+	return "upload.url_fetch_failed", []interface{}{e.URL, e.StatusCode}
+}
+
+// publicAddress reports whether UploadFromURL may connect to address, given as host:port with host an IP address,
+// when AllowPrivateURLs is not set. It is a variable so that tests can stand in for a public server
+var publicAddress = func(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598, which IsPrivate doesn't cover
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// UploadFromURL fetches the file at rawURL and saves it to uploadDir, as StreamUploadFiles does. Unless
+// AllowPrivateURLs is set, internal addresses are refused with ErrForbiddenURL
+func (t *Tools) UploadFromURL(ctx context.Context, rawURL string, uploadDir string, rename ...bool) (*UploadedFile, error) {
+	renameFile := true
+	if len(rename) > 0 {
+		renameFile = rename[0]
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	timeout := t.URLUploadTimeout
+	if timeout == 0 {
+		timeout = defaultURLUploadTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	maxFileSize := int64(defaultMaxFileSize)
+	if t.MaxFileSize != 0 {
+		maxFileSize = int64(t.MaxFileSize)
+	}
+
+	client, err := t.urlUploadClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrForbiddenURL) {
+			return nil, ErrForbiddenURL
+		}
+		return nil, fmt.Errorf("could not fetch %s: %w", u.Redacted(), err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &URLFetchError{URL: u.Redacted(), StatusCode: resp.StatusCode}
+	}
+	if resp.ContentLength > maxFileSize {
+		return nil, ErrFileTooBig
+	}
+
+	filename := urlFileName(resp)
+	src := &maxSizeReader{r: resp.Body, max: maxFileSize}
+	return t.uploadStreamToDir(ctx, uploadDir, resp.ContentLength, filename, src, renameFile, func(err error) error {
+		if errors.Is(err, ErrFileTooBig) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("could not fetch %s: %w", u.Redacted(), err)
+	})
+}
+
+// urlUploadClient returns the client UploadFromURL fetches with: HTTPClient, or a default client, with its transport
+// made to refuse internal addresses unless AllowPrivateURLs is set
+func (t *Tools) urlUploadClient() (*http.Client, error) {
+	client := t.httpClient()
+	if t.AllowPrivateURLs {
+		return client, nil
+	}
+
+	var transport *http.Transport
+	switch rt := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = rt.Clone()
+	default:
+		return nil, errors.New("UploadFromURL can only refuse private addresses with an *http.Transport; set AllowPrivateURLs to use another")
+	}
+
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if !publicAddress(address) {
+				return ErrForbiddenURL
+			}
+			return nil
+		},
+	}
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	transport.DialTLSContext = nil
+
+	guarded := *client
+	guarded.Transport = transport
+	return &guarded, nil
+}
+
+// urlFileName returns the name of the file fetched in resp: the filename of its Content-Disposition header, or the
+// last element of the path it was fetched from, or "download" when that is empty too
+func urlFileName(resp *http.Response) string {
+	if name := contentDispositionFileName(resp.Header); name != "" {
+		return name
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "/" && name != "." {
+		return name
+	}
+	return "download"
+}
+
+// contentDispositionFileName returns the last element of the filename of the Content-Disposition header in header,
+// or an empty string when it has none
+func contentDispositionFileName(header http.Header) string {
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		if name := path.Base(params["filename"]); params["filename"] != "" && name != "/" && name != "." {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,184 @@
+package toolkit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFileServer returns a test server for UploadFromURL
+func newFileServer(t *testing.T, img []byte) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(img)
+	})
+	mux.HandleFunc("/attachment", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="../notes.txt"`)
+		w.Write([]byte("hello world"))
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		// no Content-Length, so the size is only found out as the body is read
+		chunk := bytes.Repeat([]byte("x"), 1024)
+		for i := 0; i < 1024; i++ {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTools_UploadFromURL(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	server := newFileServer(t, img)
+
+	var tests = []struct {
+		name         string
+		tools        Tools
+		path         string
+		rename       bool
+		expectedName string
+		expected     error
+	}{
+		{name: "image", path: "/img.png", rename: false, expectedName: "img.png"},
+		{name: "renamed", path: "/img.png", rename: true},
+		{name: "content disposition", path: "/attachment", rename: false, expectedName: "notes.txt"},
+		{name: "not found", path: "/missing.png", expected: &URLFetchError{}},
+		{name: "over MaxFileSize", tools: Tools{MaxFileSize: 1000}, path: "/img.png", expected: ErrFileTooBig},
+		{name: "streamed over MaxFileSize", tools: Tools{MaxFileSize: 100 << 10}, path: "/stream", expected: ErrFileTooBig},
+		{name: "type not allowed", tools: Tools{AllowedFileTypes: []string{"image/jpeg"}}, path: "/img.png", expected: ErrFileTypeNotPermitted},
+		{name: "timeout", tools: Tools{URLUploadTimeout: 50 * time.Millisecond}, path: "/slow", expected: context.DeadlineExceeded},
+	}
+
+	for _, e := range tests {
+		e.tools.AllowPrivateURLs = true
+		dir := t.TempDir()
+		uploadedFile, err := e.tools.UploadFromURL(context.Background(), server.URL+e.path, dir, e.rename)
+
+		if e.expected != nil {
+			var fetchErr *URLFetchError
+			if errors.As(e.expected, &fetchErr) {
+				if !errors.As(err, &fetchErr) || fetchErr.StatusCode != http.StatusNotFound {
+					t.Errorf("%s: expected a 404 *URLFetchError, got %v", e.name, err)
+				}
+			} else if !errors.Is(err, e.expected) {
+				t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+			}
+			if names := remainingFiles(t, dir); names != nil {
+				t.Errorf("%s: expected nothing to be saved, got %v", e.name, names)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", e.name, err)
+			continue
+		}
+
+		if e.expectedName != "" && uploadedFile.NewFileName != e.expectedName {
+			t.Errorf("%s: expected the file to be saved as %s, got %s", e.name, e.expectedName, uploadedFile.NewFileName)
+		}
+		if e.rename && (uploadedFile.NewFileName == "img.png" || filepath.Ext(uploadedFile.NewFileName) != ".png") {
+			t.Errorf("%s: expected a random name, got %s", e.name, uploadedFile.NewFileName)
+		}
+		if _, err := os.Stat(filepath.Join(dir, uploadedFile.NewFileName)); err != nil {
+			t.Errorf("%s: expected the file to be saved: %s", e.name, err)
+		}
+	}
+
+	// the fetched image is saved as it was served
+	var testTools = Tools{AllowPrivateURLs: true}
+	dir := t.TempDir()
+	uploadedFile, err := testTools.UploadFromURL(context.Background(), server.URL+"/img.png", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := os.ReadFile(filepath.Join(dir, uploadedFile.NewFileName)); !bytes.Equal(saved, img) || uploadedFile.ContentType != "image/png" {
+		t.Error("expected the image to be saved as it was served")
+	}
+}
+
+func TestTools_UploadFromURLInvalid(t *testing.T) {
+	var testTools Tools
+	for _, rawURL := range []string{"ftp://example.com/a.png", "://nothing", "http://", "/relative.png", "file:///etc/passwd"} {
+		if _, err := testTools.UploadFromURL(context.Background(), rawURL, t.TempDir()); !errors.Is(err, ErrInvalidURL) {
+			t.Errorf("%q: expected ErrInvalidURL, got %v", rawURL, err)
+		}
+	}
+}
+
+func TestTools_UploadFromURLPrivateAddresses(t *testing.T) {
+	img := readTestFile(t, "img.png")
+	server := newFileServer(t, img)
+
+	var testTools Tools
+	dir := t.TempDir()
+
+	// the test server listens on a loopback address, which is refused by default
+	if _, err := testTools.UploadFromURL(context.Background(), server.URL+"/img.png", dir); !errors.Is(err, ErrForbiddenURL) {
+		t.Errorf("expected ErrForbiddenURL for a loopback address, got %v", err)
+	}
+
+	// a redirect to an internal address is refused too. The first server stands in for a public one
+	redirector := httptest.NewServer(http.RedirectHandler(server.URL+"/img.png", http.StatusFound))
+	defer redirector.Close()
+	defer func(original func(string) bool) { publicAddress = original }(publicAddress)
+	publicAddress = func(address string) bool {
+		return address == redirector.Listener.Addr().String()
+	}
+	if _, err := testTools.UploadFromURL(context.Background(), redirector.URL, dir); !errors.Is(err, ErrForbiddenURL) {
+		t.Errorf("expected ErrForbiddenURL for a redirect to a loopback address, got %v", err)
+	}
+	if names := remainingFiles(t, dir); names != nil {
+		t.Errorf("expected nothing to be saved, got %v", names)
+	}
+
+	// a transport other than *http.Transport can't be guarded
+	testTools.HTTPClient = &http.Client{Transport: RoundTripFunc(func(r *http.Request) *http.Response {
+		t.Error("expected no request to be made")
+		return nil
+	})}
+	if _, err := testTools.UploadFromURL(context.Background(), redirector.URL, dir); err == nil || !strings.Contains(err.Error(), "AllowPrivateURLs") {
+		t.Errorf("expected an error asking for AllowPrivateURLs, got %v", err)
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	for address, expected := range map[string]bool{
+		"93.184.216.34:80":         true,
+		"[2606:2800:220:1::]:443":  true,
+		"127.0.0.1:80":             false,
+		"10.1.2.3:80":              false,
+		"172.16.0.1:80":            false,
+		"192.168.1.1:80":           false,
+		"169.254.169.254:80":       false,
+		"100.64.0.1:80":            false,
+		"0.0.0.0:80":               false,
+		"[::1]:80":                 false,
+		"[fe80::1]:80":             false,
+		"[fd00::1]:80":             false,
+		"[::ffff:127.0.0.1]:80":    false,
+		"example.com:80":           false,
+		net.JoinHostPort("", "80"): false,
+	} {
+		if got := publicAddress(address); got != expected {
+			t.Errorf("%s: expected %t, got %t", address, expected, got)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package toolkit
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// structRule is one parsed rule from a validate tag, such as "max=120"
+type structRule struct {
+	name  string
+	param string
+	num   float64
+	list  []string
+}
+
+// structField describes one field of a struct type that needs validating
+type structField struct {
+	index int
+	name  string
+	rules []structRule
+}
+
+// structFieldCache maps a reflect.Type to its []structField, or the error from parsing its tags, so that the
+// tags of each type are only parsed once
+var structFieldCache sync.Map
+
+type cachedStructFields struct {
+	fields []structField
+	err    error
+}
+
+// ValidateStruct checks the struct v against the required, min, max, email, url and oneof rules of its `validate`
+// tags, and returns failures as ValidationErrors keyed by JSON path, e.g. "items.2.price"
+func (t *Tools) ValidateStruct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return fmt.Errorf("ValidateStruct requires a struct, got a nil %T", v)
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("ValidateStruct requires a struct, got %T", v)
+	}
+
+	errs := make(ValidationErrors)
+	if err := validateStructValue("", rv, errs); err != nil {
+		return err
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateStructValue validates every field of the struct rv, recording failures under prefix
+func validateStructValue(prefix string, rv reflect.Value, errs ValidationErrors) error {
+	fields, err := structFieldsFor(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		fv := rv.Field(f.index)
+		path := f.name
+		if prefix != "" {
+			path = prefix + "." + f.name
+		}
+
+		for _, rule := range f.rules {
+			if msg := checkStructRule(rule, fv); msg != "" {
+				errs.add(path, msg)
+				break
+			}
+		}
+
+		if err := validateNested(path, fv, errs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateNested descends into struct, pointer to struct, and slice or array of struct values
+func validateNested(path string, fv reflect.Value, errs ValidationErrors) error {
+	for fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return nil
+		}
+		fv = fv.Elem()
+	}
+
+	switch fv.Kind() {
+	case reflect.Struct:
+		return validateStructValue(path, fv, errs)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < fv.Len(); i++ {
+			if err := validateNested(path+"."+strconv.Itoa(i), fv.Index(i), errs); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// add records msg for field unless it already has a failure
+func (v ValidationErrors) add(field, msg string) {
+	if _, exists := v[field]; !exists {
+		v[field] = msg
+	}
+}
+
+// structFieldsFor returns the cached fields of typ, parsing its tags the first time it is seen
+func structFieldsFor(typ reflect.Type) ([]structField, error) {
+	if cached, ok := structFieldCache.Load(typ); ok {
+		c := cached.(cachedStructFields)
+		return c.fields, c.err
+	}
+
+	fields, err := parseStructFields(typ)
+	structFieldCache.Store(typ, cachedStructFields{fields: fields, err: err})
+
+	return fields, err
+}
+
+// parseStructFields collects the exported fields of typ that have rules or may contain nested structs
+func parseStructFields(typ reflect.Type) ([]structField, error) {
+	var fields []structField
+
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		name := sf.Name
+		if tag := sf.Tag.Get("json"); tag != "" {
+			jsonName := strings.Split(tag, ",")[0]
+			if jsonName == "-" {
+				continue
+			}
+			if jsonName != "" {
+				name = jsonName
+			}
+		}
+
+		rules, err := parseStructRules(sf.Tag.Get("validate"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid validate tag on %s.%s: %w", typ.Name(), sf.Name, err)
+		}
+
+		fields = append(fields, structField{index: i, name: name, rules: rules})
+	}
+
+	return fields, nil
+}
+
+// parseStructRules parses a tag like "required,max=120,email"
+func parseStructRules(tag string) ([]structRule, error) {
+	if tag == "" {
+		return nil, nil
+	}
+
+	var rules []structRule
+	for _, part := range strings.Split(tag, ",") {
+		name, param := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, param = part[:i], part[i+1:]
+		}
+
+		rule := structRule{name: strings.TrimSpace(name), param: param}
+
+		switch rule.name {
+		case "required", "email", "url":
+		case "min", "max":
+			n, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s needs a numeric parameter, got %q", rule.name, param)
+			}
+			rule.num = n
+		case "oneof":
+			rule.list = strings.Fields(param)
+			if len(rule.list) == 0 {
+				return nil, fmt.Errorf("rule oneof needs at least one value")
+			}
+		default:
+			return nil, fmt.Errorf("unknown rule %q", rule.name)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// checkStructRule applies rule to fv, returning a failure message or an empty string
+func checkStructRule(rule structRule, fv reflect.Value) string {
+	if rule.name == "required" {
+		if isEmptyValue(fv) {
+			return "this field is required"
+		}
+		return ""
+	}
+
+	for fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return ""
+		}
+		fv = fv.Elem()
+	}
+
+	if fv.Kind() == reflect.String && fv.String() == "" {
+		return ""
+	}
+
+	switch rule.name {
+	case "min", "max":
+		n, isLength, ok := measure(fv)
+		if !ok {
+			return ""
+		}
+		if rule.name == "min" && n < rule.num {
+			if isLength {
+				return fmt.Sprintf("must be at least %s long", formatLength(rule.num, fv))
+			}
+			return fmt.Sprintf("must be at least %s", strconv.FormatFloat(rule.num, 'f', -1, 64))
+		}
+		if rule.name == "max" && n > rule.num {
+			if isLength {
+				return fmt.Sprintf("must not be more than %s long", formatLength(rule.num, fv))
+			}
+			return fmt.Sprintf("must not be more than %s", strconv.FormatFloat(rule.num, 'f', -1, 64))
+		}
+	case "email":
+		if fv.Kind() == reflect.String && (len(fv.String()) > 254 || !emailRegex.MatchString(fv.String())) {
+			return "must be a valid email address"
+		}
+	case "url":
+		if fv.Kind() == reflect.String {
+			u, err := url.ParseRequestURI(fv.String())
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return "must be a valid URL"
+			}
+		}
+	case "oneof":
+		s := fmt.Sprint(fv.Interface())
+		for _, allowed := range rule.list {
+			if s == allowed {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of: %s", strings.Join(rule.list, ", "))
+	}
+
+	return ""
+}
+
+// measure returns the number min and max compare against: the length of a string (in runes), slice or map, or
+// the value of a number
+func measure(fv reflect.Value) (float64, bool, bool) {
+	switch fv.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(fv.String())), true, true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return float64(fv.Len()), true, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), false, true
+	}
+	return 0, false, false
+}
+
+// formatLength describes a length limit in characters for strings and items for everything else
+func formatLength(n float64, fv reflect.Value) string {
+	unit := "items"
+	if fv.Kind() == reflect.String {
+		unit = "characters"
+	}
+	return strconv.FormatFloat(n, 'f', -1, 64) + " " + unit
+}
+
+// isEmptyValue reports whether fv counts as missing for the required rule
+func isEmptyValue(fv reflect.Value) bool {
+	switch fv.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(fv.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return fv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return fv.IsNil()
+	}
+	return fv.IsZero()
+}
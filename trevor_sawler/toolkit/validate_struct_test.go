@@ -0,0 +1,150 @@
+package toolkit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testLineItem struct {
+	SKU   string  `json:"sku" validate:"required"`
+	Price float64 `json:"price" validate:"min=0.01"`
+}
+
+type testAddress struct {
+	City string `json:"city" validate:"required,max=20"`
+}
+
+type testOrder struct {
+	Email    string         `json:"email" validate:"required,email"`
+	Name     string         `json:"name" validate:"max=10"`
+	Website  string         `json:"website" validate:"url"`
+	Status   string         `json:"status" validate:"oneof=new paid shipped"`
+	Quantity int            `json:"quantity" validate:"min=1,max=5"`
+	Tags     []string       `json:"tags" validate:"max=2"`
+	Address  *testAddress   `json:"address"`
+	Items    []testLineItem `json:"items" validate:"required"`
+	Secret   string         `json:"-" validate:"required"`
+	internal string
+}
+
+func validTestOrder() testOrder {
+	return testOrder{
+		Email:    "me@here.com",
+		Status:   "new",
+		Quantity: 1,
+		Items:    []testLineItem{{SKU: "a", Price: 1}},
+	}
+}
+
+var validateStructTests = []struct {
+	name     string
+	modify   func(o *testOrder)
+	expected map[string]string
+}{
+	{name: "valid", modify: func(o *testOrder) {}},
+	{name: "missing email", modify: func(o *testOrder) { o.Email = "" }, expected: map[string]string{"email": "this field is required"}},
+	{name: "bad email", modify: func(o *testOrder) { o.Email = "nope" }, expected: map[string]string{"email": "must be a valid email address"}},
+	{name: "name too long", modify: func(o *testOrder) { o.Name = "abcdefghijk" }, expected: map[string]string{"name": "must not be more than 10 characters long"}},
+	{name: "name counts runes", modify: func(o *testOrder) { o.Name = "日本語日本語日本語日" }},
+	{name: "bad url", modify: func(o *testOrder) { o.Website = "ftp://example.com" }, expected: map[string]string{"website": "must be a valid URL"}},
+	{name: "good url", modify: func(o *testOrder) { o.Website = "https://example.com/a" }},
+	{name: "bad status", modify: func(o *testOrder) { o.Status = "lost" }, expected: map[string]string{"status": "must be one of: new, paid, shipped"}},
+	{name: "empty status skipped", modify: func(o *testOrder) { o.Status = "" }},
+	{name: "quantity too low", modify: func(o *testOrder) { o.Quantity = 0 }, expected: map[string]string{"quantity": "must be at least 1"}},
+	{name: "quantity too high", modify: func(o *testOrder) { o.Quantity = 6 }, expected: map[string]string{"quantity": "must not be more than 5"}},
+	{name: "too many tags", modify: func(o *testOrder) { o.Tags = []string{"a", "b", "c"} }, expected: map[string]string{"tags": "must not be more than 2 items long"}},
+	{name: "nested pointer", modify: func(o *testOrder) { o.Address = &testAddress{} }, expected: map[string]string{"address.city": "this field is required"}},
+	{name: "no items", modify: func(o *testOrder) { o.Items = nil }, expected: map[string]string{"items": "this field is required"}},
+	{
+		name: "slice element",
+		modify: func(o *testOrder) {
+			o.Items = append(o.Items, testLineItem{SKU: "b", Price: 1}, testLineItem{Price: 0})
+		},
+		expected: map[string]string{"items.2.sku": "this field is required", "items.2.price": "must be at least 0.01"},
+	},
+}
+
+func TestTools_ValidateStruct(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range validateStructTests {
+		o := validTestOrder()
+		e.modify(&o)
+
+		err := testTools.ValidateStruct(&o)
+		if len(e.expected) == 0 {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %s", e.name, err)
+			}
+			continue
+		}
+
+		var errs ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("%s: expected ValidationErrors, got %v", e.name, err)
+			continue
+		}
+
+		if len(errs) != len(e.expected) {
+			t.Errorf("%s: expected %d errors, got %v", e.name, len(e.expected), errs)
+		}
+		for field, msg := range e.expected {
+			if errs[field] != msg {
+				t.Errorf("%s: expected %q for %s, got %q", e.name, msg, field, errs[field])
+			}
+		}
+	}
+}
+
+func TestTools_ValidateStructInvalidInput(t *testing.T) {
+	var testTools Tools
+
+	if err := testTools.ValidateStruct("not a struct"); err == nil {
+		t.Error("expected error for non-struct value")
+	}
+
+	var nilOrder *testOrder
+	if err := testTools.ValidateStruct(nilOrder); err == nil {
+		t.Error("expected error for nil pointer")
+	}
+
+	type badTag struct {
+		Name string `validate:"shiny"`
+	}
+	err := testTools.ValidateStruct(badTag{})
+	if err == nil || !strings.Contains(err.Error(), "unknown rule") {
+		t.Errorf("expected unknown rule error, got %v", err)
+	}
+
+	var errs ValidationErrors
+	if errors.As(err, &errs) {
+		t.Error("bad tags should not be reported as ValidationErrors")
+	}
+}
+
+func TestTools_ReadJSONValidate(t *testing.T) {
+	testTools := Tools{ValidateJSON: true}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"bad","status":"new","quantity":1,"items":[{"sku":"a","price":0}]}`))
+	rr := httptest.NewRecorder()
+
+	var o testOrder
+	err := testTools.ReadJSON(rr, req, &o)
+
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected ValidationErrors, got %v", err)
+	}
+	if errs["email"] == "" || errs["items.0.price"] == "" {
+		t.Errorf("expected email and items.0.price errors, got %v", errs)
+	}
+
+	testTools.ValidateJSON = false
+	req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"email":"bad"}`))
+	if err := testTools.ReadJSON(rr, req, &o); err != nil {
+		t.Errorf("expected no error without ValidateJSON, got %s", err)
+	}
+}
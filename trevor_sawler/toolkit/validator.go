@@ -0,0 +1,135 @@
+package toolkit
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// emailRegex is the pattern browsers use to validate <input type="email">
+var emailRegex = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+
+// ValidationErrors maps a field name to the message describing what is wrong with it
+type ValidationErrors map[string]string
+
+// Error implements the error interface, listing every field in a stable order
+func (v ValidationErrors) Error() string {
+	fields := make([]string, 0, len(v))
+	for field := range v {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	msgs := make([]string, 0, len(fields))
+	for _, field := range fields {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", field, v[field]))
+	}
+
+	return "validation failed: " + strings.Join(msgs, "; ")
+}
+
+// Validator collects validation failures, keyed by field name, keeping the first for each field. Rules other than
+// Required treat an empty value as valid
+type Validator struct {
+	Errors ValidationErrors
+}
+
+// NewValidator returns an empty Validator
+func (t *Tools) NewValidator() *Validator {
+	return &Validator{Errors: make(ValidationErrors)}
+}
+
+// Valid reports whether no failures have been recorded
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError records message for field, unless field already has a failure
+func (v *Validator) AddError(field, message string) {
+	if v.Errors == nil {
+		v.Errors = make(ValidationErrors)
+	}
+	if _, exists := v.Errors[field]; !exists {
+		v.Errors[field] = message
+	}
+}
+
+// Check records message for field when ok is false
+func (v *Validator) Check(ok bool, field, message string) {
+	if !ok {
+		v.AddError(field, message)
+	}
+}
+
+// Required checks that value is not empty or only whitespace
+func (v *Validator) Required(field, value string) {
+	v.Check(strings.TrimSpace(value) != "", field, "this field is required")
+}
+
+// MinLength checks that value has at least n characters. Characters are counted as unicode code points, not bytes
+func (v *Validator) MinLength(field, value string, n int) {
+	if value == "" {
+		return
+	}
+	v.Check(utf8.RuneCountInString(value) >= n, field, fmt.Sprintf("must be at least %d characters long", n))
+}
+
+// MaxLength checks that value has at most n characters. Characters are counted as unicode code points, not bytes
+func (v *Validator) MaxLength(field, value string, n int) {
+	v.Check(utf8.RuneCountInString(value) <= n, field, fmt.Sprintf("must not be more than %d characters long", n))
+}
+
+// Email checks that value looks like an email address
+func (v *Validator) Email(field, value string) {
+	if value == "" {
+		return
+	}
+	v.Check(len(value) <= 254 && emailRegex.MatchString(value), field, "must be a valid email address")
+}
+
+// OneOf checks that value is one of the permitted values
+func (v *Validator) OneOf(field, value string, permitted ...string) {
+	if value == "" {
+		return
+	}
+	for _, p := range permitted {
+		if value == p {
+			return
+		}
+	}
+	v.AddError(field, fmt.Sprintf("must be one of: %s", strings.Join(permitted, ", ")))
+}
+
+// Matches checks that value matches re
+func (v *Validator) Matches(field, value string, re *regexp.Regexp) {
+	if value == "" {
+		return
+	}
+	v.Check(re.MatchString(value), field, "is not in the correct format")
+}
+
+// Range checks that min <= value <= max
+func (v *Validator) Range(field string, value, min, max int) {
+	v.Check(value >= min && value <= max, field, fmt.Sprintf("must be between %d and %d", min, max))
+}
+
+// ValidationErrorJSON sends errs as a JSON error response, with the field messages in the data member. The status
+// defaults to 422 Unprocessable Entity
+func (t *Tools) ValidationErrorJSON(w http.ResponseWriter, errs ValidationErrors, status ...int) error {
+	statusCode := http.StatusUnprocessableEntity
+
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+
+	payload := JSONResponse{
+		Error:   true,
+		Message: "validation failed",
+		Data:    errs,
+	}
+
+	return t.WriteJSON(w, statusCode, payload)
+}
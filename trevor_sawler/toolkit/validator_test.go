@@ -0,0 +1,138 @@
+package toolkit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var validatorTests = []struct {
+	name  string
+	check func(v *Validator)
+	valid bool
+}{
+	{name: "required present", check: func(v *Validator) { v.Required("f", "x") }, valid: true},
+	{name: "required empty", check: func(v *Validator) { v.Required("f", "") }, valid: false},
+	{name: "required whitespace", check: func(v *Validator) { v.Required("f", " \t\n") }, valid: false},
+	{name: "min length ok", check: func(v *Validator) { v.MinLength("f", "abc", 3) }, valid: true},
+	{name: "min length short", check: func(v *Validator) { v.MinLength("f", "ab", 3) }, valid: false},
+	{name: "min length empty skipped", check: func(v *Validator) { v.MinLength("f", "", 3) }, valid: true},
+	{name: "min length unicode", check: func(v *Validator) { v.MinLength("f", "日本", 3) }, valid: false},
+	{name: "max length ok", check: func(v *Validator) { v.MaxLength("f", "abc", 3) }, valid: true},
+	{name: "max length long", check: func(v *Validator) { v.MaxLength("f", "abcd", 3) }, valid: false},
+	{name: "max length counts runes not bytes", check: func(v *Validator) { v.MaxLength("f", "日本語", 3) }, valid: true},
+	{name: "max length emoji", check: func(v *Validator) { v.MaxLength("f", "😀😀😀😀", 3) }, valid: false},
+	{name: "one of ok", check: func(v *Validator) { v.OneOf("f", "b", "a", "b") }, valid: true},
+	{name: "one of bad", check: func(v *Validator) { v.OneOf("f", "c", "a", "b") }, valid: false},
+	{name: "one of case sensitive", check: func(v *Validator) { v.OneOf("f", "A", "a", "b") }, valid: false},
+	{name: "matches ok", check: func(v *Validator) { v.Matches("f", "AB-123", regexp.MustCompile(`^[A-Z]{2}-\d+$`)) }, valid: true},
+	{name: "matches bad", check: func(v *Validator) { v.Matches("f", "ab-123", regexp.MustCompile(`^[A-Z]{2}-\d+$`)) }, valid: false},
+	{name: "range inside", check: func(v *Validator) { v.Range("f", 5, 1, 10) }, valid: true},
+	{name: "range at bounds", check: func(v *Validator) { v.Range("f", 1, 1, 1) }, valid: true},
+	{name: "range below", check: func(v *Validator) { v.Range("f", 0, 1, 10) }, valid: false},
+	{name: "range above", check: func(v *Validator) { v.Range("f", 11, 1, 10) }, valid: false},
+	{name: "check false", check: func(v *Validator) { v.Check(false, "f", "nope") }, valid: false},
+}
+
+func TestValidator_Rules(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range validatorTests {
+		v := testTools.NewValidator()
+		e.check(v)
+
+		if v.Valid() != e.valid {
+			t.Errorf("%s: expected valid to be %t, errors: %v", e.name, e.valid, v.Errors)
+		}
+	}
+}
+
+var emailTests = []struct {
+	email string
+	valid bool
+}{
+	{"me@here.com", true},
+	{"first.last@example.co.uk", true},
+	{"user+tag@example.com", true},
+	{"o'brien@example.ie", true},
+	{"x@localhost", true},
+	{"123@456.io", true},
+	{"a_b-c@sub-domain.example.org", true},
+	{"", true},
+	{"plainaddress", false},
+	{"@example.com", false},
+	{"user@", false},
+	{"user@@example.com", false},
+	{"user@example..com", false},
+	{"user@-example.com", false},
+	{"user@example-.com", false},
+	{"user name@example.com", false},
+	{"user@exa mple.com", false},
+	{"user@example.com\n", false},
+	{"<script>@example.com", false},
+	{strings.Repeat("a", 250) + "@b.com", false},
+}
+
+func TestValidator_Email(t *testing.T) {
+	var testTools Tools
+
+	for _, e := range emailTests {
+		v := testTools.NewValidator()
+		v.Email("email", e.email)
+
+		if v.Valid() != e.valid {
+			t.Errorf("%q: expected valid to be %t", e.email, e.valid)
+		}
+	}
+}
+
+func TestValidator_FirstErrorWins(t *testing.T) {
+	var testTools Tools
+
+	v := testTools.NewValidator()
+	v.Required("name", "")
+	v.MaxLength("name", "", 0)
+	v.Check(false, "name", "second message")
+	v.Range("age", 200, 0, 150)
+
+	if v.Errors["name"] != "this field is required" {
+		t.Errorf("expected first message to be kept, got %q", v.Errors["name"])
+	}
+	if v.Errors["age"] != "must be between 0 and 150" {
+		t.Errorf("unexpected message for age: %q", v.Errors["age"])
+	}
+	if v.Errors.Error() != "validation failed: age: must be between 0 and 150; name: this field is required" {
+		t.Errorf("unexpected error string: %s", v.Errors.Error())
+	}
+}
+
+func TestTools_ValidationErrorJSON(t *testing.T) {
+	var testTools Tools
+
+	v := testTools.NewValidator()
+	v.Required("name", "")
+	v.Email("email", "nope")
+
+	rr := httptest.NewRecorder()
+	if err := testTools.ValidationErrorJSON(rr, v.Errors); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 but got %d", rr.Code)
+	}
+
+	var payload struct {
+		Error bool              `json:"error"`
+		Data  map[string]string `json:"data"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if !payload.Error || payload.Data["name"] == "" || payload.Data["email"] == "" {
+		t.Errorf("unexpected payload: %+v", payload)
+	}
+}